@@ -91,6 +91,24 @@ var SetNoEcho TermiosOperation = func(t *unix.Termios) {
 	t.Lflag &^= unix.ECHO
 }
 
+// Turn output post-processing back on, so that the terminal driver translates
+// \n into \r\n.
+var SetPostProcessOutput TermiosOperation = func(t *unix.Termios) {
+	t.Oflag |= unix.OPOST | unix.ONLCR
+}
+
+// Turn software flow control (IXON) on or off. When on, ctrl+s pauses output
+// and ctrl+q resumes it, instead of them being sent to the application.
+func SetFlowControl(enabled bool) TermiosOperation {
+	return func(t *unix.Termios) {
+		if enabled {
+			t.Iflag |= unix.IXON
+		} else {
+			t.Iflag &^= unix.IXON
+		}
+	}
+}
+
 var SetReadPassword TermiosOperation = func(t *unix.Termios) {
 	t.Lflag &^= unix.ECHO
 	t.Lflag |= unix.ISIG
@@ -0,0 +1,86 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+var _ = fmt.Print
+
+// Maps the fraction of the duration of an animation that has elapsed, from 0
+// to 1, to the fraction of the change in value, 0 at the start and 1 at the
+// end
+type EasingFunc func(t float64) float64
+
+func EaseLinear(t float64) float64    { return t }
+func EaseInQuad(t float64) float64    { return t * t }
+func EaseOutQuad(t float64) float64   { return t * (2 - t) }
+func EaseInCubic(t float64) float64   { return t * t * t }
+func EaseOutCubic(t float64) float64  { return 1 - math.Pow(1-t, 3) }
+func EaseInOutSine(t float64) float64 { return (1 - math.Cos(math.Pi*t)) / 2 }
+
+func EaseInOutQuad(t float64) float64 {
+	if t < 0.5 {
+		return 2 * t * t
+	}
+	return 1 - math.Pow(-2*t+2, 2)/2
+}
+
+func EaseInOutCubic(t float64) float64 {
+	if t < 0.5 {
+		return 4 * t * t * t
+	}
+	return 1 - math.Pow(-2*t+2, 3)/2
+}
+
+const default_animation_fps = 60
+
+// the clock used for animations, replaced in tests
+var animation_now = time.Now
+
+// Animate a value from from to to over duration. onUpdate is called once per
+// frame with the value for the time that has actually elapsed, so animations
+// finish on time even if frames are delayed, and finally with exactly to,
+// after which onDone, if not nil, is called. Frames are produced at the rate
+// set by SetMaxFPS() or 60 per second if that is unlimited. A nil easing
+// means EaseLinear. Returns the id of the timer driving the animation, which
+// can be used to cancel it with RemoveTimer(), in which case onDone is not
+// called, or zero if the loop is not running.
+func (self *Loop) Animate(from, to float64, duration time.Duration, easing EasingFunc, onUpdate func(value float64) error, onDone func() error) IdType {
+	if easing == nil {
+		easing = EaseLinear
+	}
+	fps := self.render.max_fps
+	if fps <= 0 {
+		fps = default_animation_fps
+	}
+	interval := time.Second / time.Duration(fps)
+	start := animation_now()
+	id, err := self.AddTimer(interval, true, func(id IdType) error {
+		t := 1.
+		if duration > 0 {
+			t = math.Min(1, float64(animation_now().Sub(start))/float64(duration))
+		}
+		value := to
+		if t < 1 {
+			value = from + (to-from)*easing(t)
+		}
+		if err := onUpdate(value); err != nil {
+			return err
+		}
+		if t >= 1 {
+			self.RemoveTimer(id)
+			if onDone != nil {
+				return onDone()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0
+	}
+	return id
+}
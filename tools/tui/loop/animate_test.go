@@ -0,0 +1,51 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestAnimate(t *testing.T) {
+	lp, _ := New()
+	if lp.Animate(0, 1, time.Second, nil, func(float64) error { return nil }, nil) != 0 {
+		t.Fatalf("Animation started without a running loop")
+	}
+	lp.timers = make([]*timer, 0, 1)
+	now := time.Now()
+	animation_now = func() time.Time { return now }
+	defer func() { animation_now = time.Now }()
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		if err := lp.dispatch_timers(now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var values []float64
+	done := false
+	id := lp.Animate(10, 20, time.Second, EaseInQuad, func(v float64) error { values = append(values, v); return nil }, func() error { done = true; return nil })
+	if id == 0 {
+		t.Fatalf("Animation not started")
+	}
+	advance(500 * time.Millisecond)
+	// a delayed frame uses the elapsed time, not the number of frames
+	advance(400 * time.Millisecond)
+	advance(400 * time.Millisecond)
+	if diff := cmp.Diff([]float64{12.5, 18.1, 20}, values, cmp.Comparer(func(a, b float64) bool { return a-b < 1e-9 && b-a < 1e-9 })); diff != "" {
+		t.Fatalf("Unexpected values:\n%s", diff)
+	}
+	if !done || len(lp.timers) != 0 {
+		t.Fatalf("Animation not finished")
+	}
+	for _, e := range []EasingFunc{EaseLinear, EaseInQuad, EaseOutQuad, EaseInOutQuad, EaseInCubic, EaseOutCubic, EaseInOutCubic, EaseInOutSine} {
+		if e(0) != 0 || e(1) != 1 {
+			t.Fatalf("Easing function does not map 0 to 0 and 1 to 1")
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var _ = fmt.Print
+
+// Query the terminal for its answerback message by sending ENQ. The
+// answerback is text, not an escape code, so it is identified as whatever
+// text the terminal sends before its response to a DA1 query sent after the
+// ENQ. Returns an empty string and no error if the terminal has no answerback
+// message or does not respond within timeout, in which case text received
+// until the terminal does respond is discarded, so that a late answerback is
+// not mistaken for typed text.
+func (self *Loop) QueryAnswerback(timeout time.Duration) (string, error) {
+	if self.query.wait_for_responses == nil {
+		return "", fmt.Errorf("Cannot query the terminal before starting the run loop")
+	}
+	if self.is_dumb_terminal || !self.RespondsToQueries() {
+		return "", nil
+	}
+	var answerback strings.Builder
+	self.query.rune_filter = func(ch rune) bool {
+		answerback.WriteRune(ch)
+		return true
+	}
+	_, err := self.send_query("\x05"+DA1_QUERY, timeout, func(EscapeCodeType, []byte) bool { return false }, true)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			self.query.rune_filter = func(rune) bool { return true }
+			self.query.discard_runes_until_da1 = true
+			return "", nil
+		}
+		self.query.rune_filter = nil
+		return "", err
+	}
+	self.query.rune_filter = nil
+	return answerback.String(), nil
+}
@@ -0,0 +1,76 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+var _ = fmt.Print
+
+func TestQueryAnswerback(t *testing.T) {
+	lp := new_test_loop()
+	if _, err := lp.QueryAnswerback(time.Second); err == nil {
+		t.Fatalf("Querying the answerback before the loop runs did not fail")
+	}
+	responds := true
+	lp.query.responds = &responds
+	// the terminal input received while waiting
+	reply := ""
+	lp.query.wait_for_responses = func(timeout time.Duration, done func() bool) error {
+		if err := lp.query_parser.Parse([]byte(reply)); err != nil {
+			return err
+		}
+		if done() {
+			return nil
+		}
+		return os.ErrDeadlineExceeded
+	}
+	var typed string
+	lp.OnText = func(text string, from_key_event, in_bracketed_paste bool) error {
+		typed += text
+		return nil
+	}
+	typed_later := func(input string) {
+		t.Helper()
+		if err := lp.escape_code_parser.Parse([]byte(input)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reply = "my term\x1b[?62c"
+	if ans, err := lp.QueryAnswerback(time.Second); err != nil || ans != "my term" {
+		t.Fatalf("Unexpected answerback: %#v %v", ans, err)
+	}
+	if q := lp.output(); q != "\x05\x1b[c" {
+		t.Fatalf("Unexpected query: %#v", q)
+	}
+	typed_later("x")
+	if typed != "x" {
+		t.Fatalf("Text typed after the query not delivered: %#v", typed)
+	}
+
+	reply = "\x1b[?62c"
+	if ans, err := lp.QueryAnswerback(time.Second); err != nil || ans != "" {
+		t.Fatalf("Unexpected empty answerback: %#v %v", ans, err)
+	}
+
+	// a late answerback is discarded along with the late DA1 response
+	reply, typed = "", ""
+	if ans, err := lp.QueryAnswerback(time.Second); err != nil || ans != "" {
+		t.Fatalf("Unexpected answerback on timeout: %#v %v", ans, err)
+	}
+	typed_later("late\x1b[?62cy")
+	if typed != "y" {
+		t.Fatalf("Late answerback not discarded: %#v", typed)
+	}
+
+	lp.output()
+	lp.is_dumb_terminal = true
+	if ans, err := lp.QueryAnswerback(time.Second); err != nil || ans != "" || lp.output() != "" {
+		t.Fatalf("Dumb terminal queried for its answerback")
+	}
+}
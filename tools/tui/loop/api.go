@@ -36,6 +36,59 @@ const (
 	PM
 )
 
+// How line endings in pasted text are delivered to OnText
+type PasteNewlines uint8
+
+const (
+	PASTE_NEWLINES_AS_IS PasteNewlines = iota
+	// Convert \r\n and \r to \n
+	PASTE_NEWLINES_LF
+	// Convert \r\n and \n to \r
+	PASTE_NEWLINES_CR
+)
+
+// How bracketed paste content is delivered to OnText
+type PasteDelivery uint8
+
+const (
+	// Hold the pasted text until the paste ends and deliver it in a single
+	// call to OnText, so that newlines in the pasted text cannot cause
+	// individual lines to be acted upon before the application has seen the
+	// whole paste. Very large pastes are delivered in pieces, see
+	// SetMaxPasteBuffer().
+	PASTE_AS_BLOCK PasteDelivery = iota
+	// Deliver pasted text to OnText as it arrives
+	PASTE_INCREMENTALLY
+)
+
+// What to do with a paste delivered as a block, see PASTE_AS_BLOCK, when it
+// is too large to hold in memory, see SetMaxPasteBuffer()
+type OversizedPasteAction uint8
+
+const (
+	// Deliver the text held so far and the rest of the paste as it arrives,
+	// as for PASTE_INCREMENTALLY
+	STREAM_OVERSIZED_PASTE OversizedPasteAction = iota
+	// Discard the paste, the end of the paste is still reported
+	ABORT_OVERSIZED_PASTE
+)
+
+const default_max_paste_buffer = 64 * 1024 * 1024
+
+// The paste settings and the state of the paste being received
+type paste_state struct {
+	newlines                        PasteNewlines
+	delivery                        PasteDelivery
+	normalize_text                  bool
+	fallback_encoding               string
+	text_encoding, current_encoding string
+	dispatch_replies                bool
+	max_buffer                      int
+	oversized_action                OversizedPasteAction
+	started, oversized, after_cr    bool
+	buffer                          strings.Builder
+}
+
 type timer struct {
 	interval time.Duration
 	deadline time.Time
@@ -52,8 +105,8 @@ type Loop struct {
 	controlling_term                       *tty.Term
 	terminal_options                       TerminalStateOptions
 	screen_size                            ScreenSize
-	escape_code_parser                     wcswidth.EscapeCodeParser
-	keep_going                             bool
+	escape_code_parser, query_parser       wcswidth.EscapeCodeParser
+	keep_going, running                    bool
 	death_signal                           unix.Signal
 	exit_code                              int
 	timers, timers_temp                    []*timer
@@ -64,7 +117,43 @@ type Loop struct {
 	on_SIGTSTP                             func() error
 	style_cache                            map[string]func(...any) string
 	style_ctx                              style.Context
-	atomic_update_active                   bool
+	atomic_update_active, is_dumb_terminal bool
+	fd_watcher                             *fd_watcher
+	exit_cleanup_requested                 bool
+	exit_cleanup                           *exit_cleanup
+	pending_scrollback                     []string
+	attention_requested                    bool
+	title_stack                            []saved_title
+	status_line                            *StatusLine
+	distinguish_keypad_enter               bool
+	typed_after_cr                         bool
+	write_chunker                          write_chunker
+	focused                                bool
+	presented                              *Screen
+	sgr_optimizer                          *sgr_optimizer
+	stripped_sequences                     []string
+	epilogue_on_death_signal               bool
+	clipboard_read                         *clipboard_read
+	prompt_marks                           []PromptMark
+	prompt_mark_history                    int
+	diagnostics                            *diagnostics
+	plain_text_mirror                      *plain_text_mirror
+	debounced, throttled                   map[string]*rate_limited_call
+	active_pulses                          map[IdType]*pulse
+	paste                                  paste_state
+	query                                  query_state
+	detected                               detected_capabilities
+	modes                                  mode_state
+	cursors                                cursor_state
+	tab_stops                              tab_stops_state
+	mouse                                  mouse_state
+	resize                                 resize_state
+	render                                 render_state
+	backlog                                write_backlog
+	interactive                            interactive_state
+	hyperlinks                             hyperlink_state
+	replay                                 replay_state
+	nested                                 nested_loops
 
 	// Suspend the loop restoring terminal state. Call the return resume function to restore the loop
 	Suspend func() (func() error, error)
@@ -82,6 +171,18 @@ type Loop struct {
 	// Called when a key event happens
 	OnKeyEvent func(event *KeyEvent) error
 
+	// Called for presses and repeats of keys that nothing else used, giving a
+	// single place for default behavior such as beeping. Key events are
+	// dispatched in the order: the shortcuts of the top view and then those
+	// of the ViewManager, if any, OnKeyEvent (the OnKeyEvent of the top view
+	// with a ViewManager), the built-in handling of ctrl+c and ctrl+z, and
+	// delivery of the text of the key to OnText. The first of these to set
+	// event.Handled or receive the text stops the dispatch, and if none of
+	// them do, this is called. Enter, which has no text of its own with the
+	// kitty keyboard protocol, is also reported here, rather than delivered
+	// to OnText as a carriage return, in terminals that do not use it.
+	OnUnhandledKey func(event *KeyEvent) error
+
 	// Called when a mouse event happens
 	OnMouseEvent func(event *MouseEvent) error
 
@@ -89,12 +190,56 @@ type Loop struct {
 	// Called with an empty string when bracketed paste ends
 	OnText func(text string, from_key_event bool, in_bracketed_paste bool) error
 
+	// Like OnText except that key is the key event the text is from, or nil
+	// if it is not from a key event, letting applications see the modifiers,
+	// for example, to distinguish alt+key from composed input. When set, it
+	// is called instead of OnText.
+	OnTextWithKey func(text string, key *KeyEvent, in_bracketed_paste bool) error
+
+	// Called when a paste delivered as a block exceeds the size set by
+	// SetMaxPasteBuffer(), with the number of bytes held so far. It is called
+	// before any of the text is delivered to OnText, or, with
+	// ABORT_OVERSIZED_PASTE, discarded, once per paste.
+	OnOversizedPaste func(buffered int) error
+
 	// Called when the terminal is resized
 	OnResize func(old_size ScreenSize, new_size ScreenSize) error
 
+	// Called for every resize of the terminal, before OnResize and without
+	// any of the debouncing set by SetResizeDebounce(). Use it for cheap
+	// bookkeeping that must track the current size, deferring expensive
+	// re-layout to OnResize. ScreenSize() returns new_size when it is called.
+	OnResizeImmediate func(new_size ScreenSize)
+
 	// Called when writing is done
 	OnWriteComplete func(msg_id IdType) error
 
+	// Called when the amount of data queued for writing to the terminal but
+	// not yet written rises above the high-water mark set with
+	// SetWriteBacklogLimits(). Called once per crossing, not again till
+	// OnWriteBacklogCleared has been called.
+	OnWriteBacklog func(pending_bytes int) error
+
+	// Called when the amount of queued data falls back below the low-water
+	// mark after OnWriteBacklog was called
+	OnWriteBacklogCleared func() error
+
+	// Called when the terminal window gains or loses focus, requires
+	// FocusTracking(). Also called once, after OnInitialize, with the initial
+	// focus state, which is assumed to be focused. Terminals that report the
+	// focus state as soon as focus tracking is turned on then call it again
+	// with the actual state, when that report arrives.
+	OnFocusEvent func(focused bool) error
+
+	// Called with the colors reported by the terminal in response to
+	// QueryColorTable() or to OSC 21 color table queries sent directly,
+	// keyed by palette index
+	OnColorTableResponse func(colors map[int]Color) error
+
+	// Called with the contents of the clipboard, in response to
+	// RequestClipboardText() or to OSC 52 queries sent directly
+	OnClipboardResponse func(resp ClipboardResponse) error
+
 	// Called when a response to an rc command is received
 	OnRCResponse func(data []byte) error
 
@@ -109,6 +254,10 @@ type Loop struct {
 
 	// Called when main loop is woken up
 	OnWakeup func() error
+
+	// Called to render the screen after RequestRedraw(), at most once per
+	// iteration of the loop, subject to the limit set by SetMaxFPS()
+	OnRender func() error
 }
 
 func New(options ...func(self *Loop)) (*Loop, error) {
@@ -172,6 +321,37 @@ func (self *Loop) NoMouseTracking() *Loop {
 	return self
 }
 
+// Have the terminal report when its window gains or loses focus, see
+// OnFocusEvent and IsFocused()
+func (self *Loop) FocusTracking() *Loop {
+	self.terminal_options.focus_tracking = true
+	return self
+}
+
+func FocusTracking(self *Loop) {
+	self.terminal_options.focus_tracking = true
+}
+
+// Turn software flow control (IXON) on or off, it is off by default. When
+// it is off, ctrl+s and ctrl+q are delivered as key events. When it is on,
+// the terminal driver uses them to pause and resume output instead, which
+// makes the application appear to hang till ctrl+q is pressed. Can be called
+// before or while the loop is running, the original setting is restored when
+// the loop exits.
+func (self *Loop) SetFlowControl(enabled bool) {
+	self.terminal_options.flow_control = enabled
+	if self.controlling_term != nil {
+		_ = self.controlling_term.ApplyOperations(tty.TCSANOW, tty.SetFlowControl(enabled))
+	}
+}
+
+// Whether the terminal window currently has focus, as reported by the
+// terminal. Assumed to be true when the terminal does not report focus, or
+// FocusTracking() is not used.
+func (self *Loop) IsFocused() bool {
+	return self.focused
+}
+
 func (self *Loop) NoRestoreColors() *Loop {
 	self.terminal_options.restore_colors = false
 	return self
@@ -181,6 +361,156 @@ func NoRestoreColors(self *Loop) {
 	self.terminal_options.restore_colors = false
 }
 
+// Hide the cursor while the screen is being updated, that is, during
+// OnRender and between StartAtomicUpdate() and EndAtomicUpdate(), showing it
+// again, at its final position, when the update is done. This prevents the
+// cursor from flickering across the screen during redraws. A cursor hidden
+// with SetCursorVisible(false) stays hidden.
+func (self *Loop) HideCursorDuringUpdates() *Loop {
+	self.cursors.hide_during_updates = true
+	return self
+}
+
+func HideCursorDuringUpdates(self *Loop) {
+	self.cursors.hide_during_updates = true
+}
+
+func (self *Loop) hide_cursor_for_update() {
+	if !self.cursors.hide_during_updates {
+		return
+	}
+	self.cursors.hide_depth++
+	if self.cursors.hide_depth == 1 && !self.cursors.hidden {
+		self.queue_tracked_write(DECTCEM.EscapeCodeToReset())
+	}
+}
+
+func (self *Loop) show_cursor_after_update() {
+	if self.cursors.hide_depth < 1 {
+		return
+	}
+	self.cursors.hide_depth--
+	if self.cursors.hide_depth == 0 && !self.cursors.hidden {
+		self.queue_tracked_write(DECTCEM.EscapeCodeToSet())
+	}
+}
+
+// Disable the heuristic that detects queries sent to the terminal being
+// echoed back, causing them to fail immediately with ErrQueryEchoed
+func (self *Loop) NoEchoDetection() *Loop {
+	self.query.no_echo_detection = true
+	return self
+}
+
+func NoEchoDetection(self *Loop) {
+	self.query.no_echo_detection = true
+}
+
+// Report the Enter key on the keypad as KP_ENTER instead of ENTER. Note
+// that Enter is reported as a key event regardless of how the terminal sends
+// it. In particular, \r, \n and \r\n when typed rather than pasted are all
+// reported as a press of ENTER, and if the key event is not handled, as text.
+// \r\n is a single press only when both arrive in the same read, otherwise,
+// they are two presses, such as Enter followed by ctrl+j.
+// Since legacy terminals send the same byte for ctrl+m as for Enter, ctrl+m
+// is reported as ENTER unless the kitty keyboard protocol is in use, in which
+// case it is reported as ctrl+m. Similarly, ctrl+j is reported as ENTER in
+// legacy terminals.
+func (self *Loop) DistinguishKeypadEnter() *Loop {
+	self.distinguish_keypad_enter = true
+	return self
+}
+
+func DistinguishKeypadEnter(self *Loop) {
+	self.distinguish_keypad_enter = true
+}
+
+// Normalize line endings in bracketed paste content before it is delivered to
+// OnText, all of \r\n, \r and \n are converted to the same line ending. Text
+// that is typed rather than pasted is not affected.
+func (self *Loop) NormalizePastedNewlines(which PasteNewlines) *Loop {
+	self.paste.newlines = which
+	return self
+}
+
+func NormalizePastedNewlines(self *Loop, which PasteNewlines) {
+	self.paste.newlines = which
+}
+
+// Control how bracketed paste content is delivered to OnText. The default,
+// PASTE_AS_BLOCK, delivers the whole paste in a single call once it ends,
+// letting applications such as REPLs decide whether to act on a multi-line
+// paste, instead of acting on each line as it arrives.
+func (self *Loop) SetPasteDelivery(which PasteDelivery) *Loop {
+	self.paste.delivery = which
+	return self
+}
+
+func SetPasteDelivery(self *Loop, which PasteDelivery) {
+	self.paste.delivery = which
+}
+
+// Set the maximum number of bytes of pasted text held in memory when
+// delivering pastes as a block, see SetPasteDelivery(). Larger pastes are
+// handled as specified by SetOversizedPasteAction(), after calling
+// OnOversizedPaste. This protects against running out of memory when very
+// large amounts of text are pasted. Zero means the default of 64MB and
+// negative values mean no limit.
+func (self *Loop) SetMaxPasteBuffer(n int) *Loop {
+	self.paste.max_buffer = n
+	return self
+}
+
+func SetMaxPasteBuffer(self *Loop, n int) {
+	self.paste.max_buffer = n
+}
+
+// Control what happens to pastes that exceed the size set by
+// SetMaxPasteBuffer(). The default, STREAM_OVERSIZED_PASTE, delivers them
+// in pieces, as they arrive.
+func (self *Loop) SetOversizedPasteAction(which OversizedPasteAction) *Loop {
+	self.paste.oversized_action = which
+	return self
+}
+
+func SetOversizedPasteAction(self *Loop, which OversizedPasteAction) {
+	self.paste.oversized_action = which
+}
+
+// Handle replies from the terminal that arrive in the middle of a bracketed
+// paste, such as focus events and responses to queries, while the paste
+// continues, instead of treating them as pasted text. Key and mouse events
+// inside a paste are always treated as pasted text, since terminals pass on
+// any escape codes in the pasted text.
+func (self *Loop) DispatchRepliesDuringPaste() *Loop {
+	self.paste.dispatch_replies = true
+	self.escape_code_parser.DispatchEscapeCodesInBracketedPaste = true
+	self.query_parser.DispatchEscapeCodesInBracketedPaste = true
+	return self
+}
+
+func DispatchRepliesDuringPaste(self *Loop) {
+	self.DispatchRepliesDuringPaste()
+}
+
+// The escape codes written to the terminal, in a single write, to set it up
+// when the loop starts or resumes after being suspended
+func (self *Loop) SetupSequence() string {
+	if self.is_dumb_terminal {
+		return ""
+	}
+	return self.terminal_options.SetStateEscapeCodes()
+}
+
+// The escape codes written to the terminal, in a single write, to restore
+// its state when the loop exits or is suspended
+func (self *Loop) TeardownSequence() string {
+	if self.is_dumb_terminal {
+		return ""
+	}
+	return self.terminal_options.ResetStateEscapeCodes()
+}
+
 func (self *Loop) DeathSignalName() string {
 	if self.death_signal != SIGNULL {
 		return self.death_signal.String()
@@ -196,6 +526,21 @@ func (self *Loop) ScreenSize() (ScreenSize, error) {
 	return self.screen_size, err
 }
 
+// Returns true if the loop is running in a dumb terminal, as indicated by
+// the TERM environment variable being set to dumb. Terminals that merely do
+// not answer queries are not treated as dumb, as they generally still handle
+// escape codes that change their state, use RespondsToQueries() to detect
+// them. In dumb terminals no
+// terminal state is changed: there is no alternate screen, mouse tracking,
+// bracketed paste or keyboard protocol. All escape codes are stripped from
+// output, so cursor movement, styling, etc. are silently dropped, leaving
+// plain, line at a time output. Queries to the terminal are not sent and fail
+// immediately. Input is still read a character at a time, but only keys that
+// send plain bytes are recognized, arriving via OnText.
+func (self *Loop) IsDumbTerminal() bool {
+	return self.is_dumb_terminal
+}
+
 func (self *Loop) KillIfSignalled() {
 	if self.death_signal != SIGNULL {
 		kill_self(self.death_signal)
@@ -250,9 +595,42 @@ func (self *Loop) DebugPrintln(args ...any) {
 }
 
 func (self *Loop) Run() (err error) {
+	return self.run_with_epilogue(self.run, nil)
+}
+
+// Like Run() except that epilogue is called after the loop quits and the
+// terminal is restored, that is, the alternate screen has been exited, modes
+// reset and the tty is back in its normal mode, but before this function
+// returns. Useful for printing final output, such as a summary, to the main
+// screen with plain writes to os.Stdout, without it getting mixed up with the
+// escape codes that restore the terminal. epilogue is not called if the loop
+// panics or fails with an error or, unless EpilogueOnDeathSignal() is used, if
+// it quits because of a signal such as SIGINT or SIGTERM.
+func (self *Loop) RunWithEpilogue(epilogue func()) (err error) {
+	return self.run_with_epilogue(self.run, epilogue)
+}
+
+// Call the epilogue passed to RunWithEpilogue() even when the loop quits
+// because of a signal such as SIGINT or SIGTERM
+func (self *Loop) EpilogueOnDeathSignal() *Loop {
+	self.epilogue_on_death_signal = true
+	return self
+}
+
+func EpilogueOnDeathSignal(self *Loop) {
+	self.epilogue_on_death_signal = true
+}
+
+func (self *Loop) should_run_epilogue() bool {
+	return self.death_signal == SIGNULL || self.epilogue_on_death_signal
+}
+
+func (self *Loop) run_with_epilogue(run func() error, epilogue func()) (err error) {
+	var panic_stack []byte
 	defer func() {
 		if r := recover(); r != nil {
-			stack := utils.Splitlines(string(debug.Stack()))
+			panic_stack = debug.Stack()
+			stack := utils.Splitlines(string(panic_stack))
 			err = fmt.Errorf("Paniced: %s", r)
 			fmt.Fprintf(os.Stderr, "\r\nPaniced with error: %s\r\nStacktrace:\r\n", r)
 			for _, line := range stack {
@@ -268,8 +646,12 @@ func (self *Loop) Run() (err error) {
 				}
 			}
 		}
+		self.write_diagnostic_report(err, panic_stack)
+		if err == nil && epilogue != nil && self.should_run_epilogue() {
+			epilogue()
+		}
 	}()
-	return self.run()
+	return run()
 }
 
 func (self *Loop) WakeupMainThread() bool {
@@ -281,7 +663,17 @@ func (self *Loop) WakeupMainThread() bool {
 	}
 }
 
+// Queue data to be written to the terminal. As the text and escape codes in
+// data can move the cursor and change the text attributes, the loop no longer
+// knows the cursor position and attributes afterwards, till they are next set
+// with, for example, MoveCursorTo() and SetSGR().
 func (self *Loop) QueueWriteString(data string) IdType {
+	self.cursors.current.x, self.cursors.current.y, self.cursors.current.sgr_known = 0, 0, false
+	return self.queue_tracked_write(data)
+}
+
+// Queue data whose effect on the cursor is tracked by the caller
+func (self *Loop) queue_tracked_write(data string) IdType {
 	self.write_msg_id_counter++
 	msg := write_msg{str: data, bytes: nil, id: self.write_msg_id_counter}
 	self.add_write_to_pending_queue(&msg)
@@ -291,6 +683,7 @@ func (self *Loop) QueueWriteString(data string) IdType {
 // This is dangerous as it is upto the calling code
 // to ensure the data in the underlying array does not change
 func (self *Loop) UnsafeQueueWriteBytes(data []byte) IdType {
+	self.cursors.current.x, self.cursors.current.y, self.cursors.current.sgr_known = 0, 0, false
 	self.write_msg_id_counter++
 	msg := write_msg{bytes: data, id: self.write_msg_id_counter}
 	self.add_write_to_pending_queue(&msg)
@@ -311,11 +704,43 @@ func (self *Loop) Beep() {
 	self.QueueWriteString("\a")
 }
 
+// Whether the terminal supports attention requests, detected from the
+// environment, falling back to XTVERSION for when the environment is not
+// passed through, for example, over SSH
+func (self *Loop) supports_attention_requests() bool {
+	return os.Getenv("TERM_PROGRAM") == "iTerm.app" || self.terminal_version_has_prefix("iTerm2 ")
+}
+
+func attention_request(on bool) string {
+	if on {
+		return "\x1b]1337;RequestAttention=yes\a"
+	}
+	return "\x1b]1337;RequestAttention=no\a"
+}
+
+// Ask the terminal to have its window demand attention, for example by
+// bouncing its dock icon or flashing its taskbar entry, without sounding the
+// bell. The request is cleared automatically when the loop quits and, if the
+// application has turned on focus reporting, when the window gains focus. Does
+// nothing in terminals that do not support it, currently only iTerm2 does.
+// When not detectable from the environment, support is detected from the
+// terminal version, which is queried the first time it is needed.
+func (self *Loop) SetAttention(on bool) {
+	if self.is_dumb_terminal || on == self.attention_requested || !self.supports_attention_requests() {
+		return
+	}
+	self.attention_requested = on
+	self.QueueWriteString(attention_request(on))
+}
+
 func (self *Loop) StartAtomicUpdate() {
 	if self.atomic_update_active {
-		self.EndAtomicUpdate()
+		// end the current update, keeping the cursor hidden
+		self.queue_tracked_write(PENDING_UPDATE.EscapeCodeToReset())
+	} else {
+		self.hide_cursor_for_update()
 	}
-	self.QueueWriteString(PENDING_UPDATE.EscapeCodeToSet())
+	self.queue_tracked_write(PENDING_UPDATE.EscapeCodeToSet())
 	self.atomic_update_active = true
 }
 
@@ -323,20 +748,27 @@ func (self *Loop) IsAtomicUpdateActive() bool { return self.atomic_update_active
 
 func (self *Loop) EndAtomicUpdate() {
 	if self.atomic_update_active {
-		self.QueueWriteString(PENDING_UPDATE.EscapeCodeToReset())
+		self.queue_tracked_write(PENDING_UPDATE.EscapeCodeToReset())
 		self.atomic_update_active = false
+		self.show_cursor_after_update()
 	}
 }
 
 func (self *Loop) SetCursorShape(shape CursorShapes, blink bool) {
-	self.QueueWriteString(CursorShape(shape, blink))
+	self.queue_tracked_write(CursorShape(shape, blink))
+	self.cursors.current.style.shape, self.cursors.current.style.blink, self.cursors.current.style.shape_known = shape, blink, true
 }
 
 func (self *Loop) SetCursorVisible(visible bool) {
+	self.cursors.hidden = !visible
+	if self.cursors.hide_depth > 0 {
+		// applied when the update ends
+		return
+	}
 	if visible {
-		self.QueueWriteString(DECTCEM.EscapeCodeToSet())
+		self.queue_tracked_write(DECTCEM.EscapeCodeToSet())
 	} else {
-		self.QueueWriteString(DECTCEM.EscapeCodeToReset())
+		self.queue_tracked_write(DECTCEM.EscapeCodeToReset())
 	}
 }
 
@@ -344,30 +776,77 @@ const MoveCursorToTemplate = "\x1b[%d;%dH"
 
 func (self *Loop) MoveCursorTo(x, y int) { // 1, 1 is top left
 	if x > 0 && y > 0 {
-		self.QueueWriteString(fmt.Sprintf(MoveCursorToTemplate, y, x))
+		self.queue_tracked_write(fmt.Sprintf(MoveCursorToTemplate, self.terminal_row(y), x))
+		self.cursors.current.x, self.cursors.current.y = x, y
 	}
 }
 
 func (self *Loop) MoveCursorHorizontally(amt int) {
 	if amt != 0 {
+		delta := amt
 		suffix := "C"
 		if amt < 0 {
 			suffix = "D"
 			amt *= -1
 		}
-		self.QueueWriteString(fmt.Sprintf("\x1b[%d%s", amt, suffix))
+		self.queue_tracked_write(fmt.Sprintf("\x1b[%d%s", amt, suffix))
+		if self.cursors.current.x > 0 {
+			self.cursors.current.x = utils.Max(1, self.cursors.current.x+delta)
+		}
 	}
 }
 
 func (self *Loop) MoveCursorVertically(amt int) {
 	if amt != 0 {
+		delta := amt
 		suffix := "B"
 		if amt < 0 {
 			suffix = "A"
 			amt *= -1
 		}
-		self.QueueWriteString(fmt.Sprintf("\x1b[%d%s", amt, suffix))
+		self.queue_tracked_write(fmt.Sprintf("\x1b[%d%s", amt, suffix))
+		if self.cursors.current.y > 0 {
+			self.cursors.current.y = utils.Max(1, self.cursors.current.y+delta)
+		}
+	}
+}
+
+// Move the cursor to the specified column (1-based) of the current row,
+// clamped to the screen width
+func (self *Loop) MoveToColumn(col int) {
+	col = utils.Max(1, col)
+	if sz, err := self.ScreenSize(); err == nil && sz.WidthCells > 0 {
+		col = utils.Min(col, int(sz.WidthCells))
+	}
+	self.queue_tracked_write(fmt.Sprintf("\x1b[%dG", col))
+	self.cursors.current.x = col
+}
+
+// Move the cursor to the specified row (1-based) in the current column,
+// clamped to the screen height
+func (self *Loop) MoveToRow(row int) {
+	row = utils.Max(1, row)
+	if sz, err := self.ScreenSize(); err == nil && sz.HeightCells > 0 {
+		row = utils.Min(row, int(sz.HeightCells))
+	}
+	self.queue_tracked_write(fmt.Sprintf("\x1b[%dd", self.terminal_row(row)))
+	self.cursors.current.y = row
+}
+
+// Move the cursor by dx columns and dy rows, negative values move left and
+// up. When the cursor position is known, the movement is clamped to the
+// screen.
+func (self *Loop) MoveBy(dx, dy int) {
+	if sz, err := self.ScreenSize(); err == nil {
+		if self.cursors.current.x > 0 && sz.WidthCells > 0 {
+			dx = utils.Max(1-self.cursors.current.x, utils.Min(dx, int(sz.WidthCells)-self.cursors.current.x))
+		}
+		if self.cursors.current.y > 0 && sz.HeightCells > 0 {
+			dy = utils.Max(1-self.cursors.current.y, utils.Min(dy, int(sz.HeightCells)-self.cursors.current.y))
+		}
 	}
+	self.MoveCursorVertically(dy)
+	self.MoveCursorHorizontally(dx)
 }
 
 func (self *Loop) ClearToEndOfScreen() {
@@ -379,11 +858,11 @@ func (self *Loop) ClearToEndOfLine() {
 }
 
 func (self *Loop) StartBracketedPaste() {
-	self.QueueWriteString(BRACKETED_PASTE.EscapeCodeToSet())
+	self.SetBracketedPaste(true)
 }
 
 func (self *Loop) EndBracketedPaste() {
-	self.QueueWriteString(BRACKETED_PASTE.EscapeCodeToReset())
+	self.SetBracketedPaste(false)
 }
 
 func (self *Loop) AllowLineWrapping(allow bool) {
@@ -401,7 +880,9 @@ func (self *Loop) SetWindowTitle(title string) {
 }
 
 func (self *Loop) ClearScreen() {
-	self.QueueWriteString("\x1b[H\x1b[2J")
+	self.queue_tracked_write("\x1b[H\x1b[2J")
+	self.cursors.current.x, self.cursors.current.y = 1, 1
+	self.invalidate_presented()
 }
 
 func (self *Loop) SendOverlayReady() {
@@ -0,0 +1,179 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sys/unix"
+)
+
+var _ = fmt.Print
+
+func TestSetAttention(t *testing.T) {
+	t.Setenv("TERM_PROGRAM", "")
+	on, off := attention_request(true), attention_request(false)
+	for _, x := range []struct {
+		version  string
+		expected string
+	}{
+		{"iTerm2 3.4.19", on + off},
+		{"kitty(0.28.1)", ""},
+		{"", ""},
+	} {
+		lp := new_test_loop()
+		queried := false
+		lp.answer_queries(func(output string) []string {
+			queried = true
+			if x.version == "" {
+				return nil
+			}
+			return []string{"\x1bP>|" + x.version + "\x1b\\"}
+		})
+		// cleared without being requested sends nothing, nor queries
+		lp.SetAttention(false)
+		if queried || lp.output() != "" {
+			t.Fatalf("Clearing an attention request that was not made had an effect")
+		}
+		lp.SetAttention(true)
+		lp.SetAttention(true)
+		lp.SetAttention(false)
+		if q := lp.output(); q != "\x1b[>q\x1b[c"+x.expected {
+			t.Fatalf("Unexpected output for %#v: %#v", x.version, q)
+		}
+	}
+	// detected from the environment without querying
+	t.Setenv("TERM_PROGRAM", "iTerm.app")
+	lp := new_test_loop()
+	lp.SetAttention(true)
+	if q := lp.output(); q != on {
+		t.Fatalf("Unexpected output: %#v", q)
+	}
+}
+
+func TestEpilogue(t *testing.T) {
+	for _, x := range []struct {
+		death_signal unix.Signal
+		options      []func(*Loop)
+		expected     bool
+	}{
+		{SIGNULL, nil, true},
+		{unix.SIGINT, nil, false},
+		{unix.SIGTERM, nil, false},
+		{unix.SIGTERM, []func(*Loop){EpilogueOnDeathSignal}, true},
+	} {
+		lp := new_test_loop(x.options...)
+		lp.death_signal = x.death_signal
+		if actual := lp.should_run_epilogue(); actual != x.expected {
+			t.Fatalf("Unexpected epilogue decision for signal %s with %d options: %v", x.death_signal, len(x.options), actual)
+		}
+	}
+	if lp := new_test_loop().EpilogueOnDeathSignal(); !lp.epilogue_on_death_signal {
+		t.Fatalf("The EpilogueOnDeathSignal() method did not take effect")
+	}
+
+	// the epilogue output comes after the loop has restored the terminal
+	// and not at all if the loop fails
+	devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devnull.Close()
+	// silence the report of the panic
+	stderr := os.Stderr
+	os.Stderr = devnull
+	defer func() { os.Stderr = stderr }()
+	run := func(how string) (written []string) {
+		lp := new_test_loop(NoAlternateScreen)
+		_ = lp.run_with_epilogue(func() error {
+			written = append(written, "work")
+			switch how {
+			case "panic":
+				panic("failed")
+			case "error":
+				return fmt.Errorf("failed")
+			}
+			written = append(written, lp.TeardownSequence())
+			return nil
+		}, func() { written = append(written, "epilogue") })
+		return
+	}
+	teardown := new_test_loop(NoAlternateScreen).TeardownSequence()
+	if diff := cmp.Diff([]string{"work", teardown, "epilogue"}, run("")); diff != "" {
+		t.Fatalf("Unexpected output order:\n%s", diff)
+	}
+	for _, how := range []string{"panic", "error"} {
+		if diff := cmp.Diff([]string{"work"}, run(how)); diff != "" {
+			t.Fatalf("Unexpected output on %s:\n%s", how, diff)
+		}
+	}
+}
+
+func TestSetupSequence(t *testing.T) {
+	lp, _ := New(FullKeyboardProtocol, func(lp *Loop) { lp.MouseTrackingMode(BUTTONS_ONLY_MOUSE_TRACKING) })
+	setup := lp.SetupSequence()
+	index := func(q string) int {
+		ans := strings.Index(setup, q)
+		if ans < 0 {
+			t.Fatalf("%#v not found in setup sequence: %#v", q, setup)
+		}
+		return ans
+	}
+	order := []string{S7C1T, SAVE_CURSOR, SAVE_PRIVATE_MODE_VALUES, SAVE_COLORS, BRACKETED_PASTE.EscapeCodeToReset(),
+		ALTERNATE_SCREEN.EscapeCodeToSet(), CLEAR_SCREEN, fmt.Sprintf("\x1b[>%du", FULL_KEYBOARD_PROTOCOL),
+		MOUSE_SGR_MODE.EscapeCodeToSet(), MOUSE_SGR_PIXEL_MODE.EscapeCodeToSet(), MOUSE_BUTTON_TRACKING.EscapeCodeToSet()}
+	for i := 1; i < len(order); i++ {
+		if index(order[i-1]) > index(order[i]) {
+			t.Fatalf("%#v comes after %#v in setup sequence: %#v", order[i-1], order[i], setup)
+		}
+	}
+	if setup != lp.SetupSequence() {
+		t.Fatalf("Setup sequence is not deterministic")
+	}
+	teardown := lp.TeardownSequence()
+	if !strings.HasPrefix(teardown, "\x1b[<u") || !strings.Contains(teardown, ALTERNATE_SCREEN.EscapeCodeToReset()) {
+		t.Fatalf("Unexpected teardown sequence: %#v", teardown)
+	}
+	lp.is_dumb_terminal = true
+	if lp.SetupSequence() != "" || lp.TeardownSequence() != "" {
+		t.Fatalf("Dumb terminals must not be sent setup or teardown sequences")
+	}
+}
+
+func TestHideCursorDuringUpdates(t *testing.T) {
+	lp := new_test_loop(HideCursorDuringUpdates)
+	output := func() string {
+		return strings.NewReplacer("\x1b[?25l", "<hide>", "\x1b[?25h", "<show>", "\x1b[?2026h", "<start>", "\x1b[?2026l", "<end>").Replace(lp.output())
+	}
+	check := func(expected string) {
+		t.Helper()
+		if actual := output(); actual != expected {
+			t.Fatalf("Unexpected output: %#v != %#v", actual, expected)
+		}
+	}
+	lp.OnRender = func() error {
+		lp.StartAtomicUpdate()
+		lp.QueueWriteString("a")
+		lp.StartAtomicUpdate()
+		lp.QueueWriteString("b")
+		lp.EndAtomicUpdate()
+		return nil
+	}
+	lp.render.redraw_requested = true
+	_ = lp.render_if_needed(time.Now())
+	check("<hide><start>a<end><start>b<end><show>")
+	lp.SetCursorVisible(false)
+	check("<hide>")
+	lp.StartAtomicUpdate()
+	lp.EndAtomicUpdate()
+	check("<start><end>")
+	lp.StartAtomicUpdate()
+	lp.SetCursorVisible(true)
+	lp.EndAtomicUpdate()
+	check("<start><end><show>")
+}
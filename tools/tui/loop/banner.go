@@ -0,0 +1,192 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"kitty/tools/utils"
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+// How the pixels of the glyphs in a banner are drawn, see DrawBanner()
+type BannerFont uint8
+
+const (
+	// Each pixel is two full block characters, so that the letters have
+	// roughly the proportions of their bitmaps
+	BANNER_BLOCK BannerFont = iota
+	// Two rows of pixels per line using half block characters, for smaller
+	// banners
+	BANNER_HALF_BLOCK
+	// Each pixel is a #, for terminals or fonts without block characters
+	BANNER_ASCII
+)
+
+const banner_glyph_height = 5
+
+// Glyphs are bitmaps five pixels tall where # is a set pixel
+var banner_glyphs = map[rune][banner_glyph_height]string{
+	'A':  {" # ", "# #", "###", "# #", "# #"},
+	'B':  {"## ", "# #", "## ", "# #", "## "},
+	'C':  {" ##", "#  ", "#  ", "#  ", " ##"},
+	'D':  {"## ", "# #", "# #", "# #", "## "},
+	'E':  {"###", "#  ", "## ", "#  ", "###"},
+	'F':  {"###", "#  ", "## ", "#  ", "#  "},
+	'G':  {" ##", "#  ", "# #", "# #", " ##"},
+	'H':  {"# #", "# #", "###", "# #", "# #"},
+	'I':  {"###", " # ", " # ", " # ", "###"},
+	'J':  {"  #", "  #", "  #", "# #", " # "},
+	'K':  {"# #", "# #", "## ", "# #", "# #"},
+	'L':  {"#  ", "#  ", "#  ", "#  ", "###"},
+	'M':  {"#   #", "## ##", "# # #", "#   #", "#   #"},
+	'N':  {"#  #", "## #", "# ##", "#  #", "#  #"},
+	'O':  {" # ", "# #", "# #", "# #", " # "},
+	'P':  {"## ", "# #", "## ", "#  ", "#  "},
+	'Q':  {" # ", "# #", "# #", "## ", " ##"},
+	'R':  {"## ", "# #", "## ", "# #", "# #"},
+	'S':  {" ##", "#  ", " # ", "  #", "## "},
+	'T':  {"###", " # ", " # ", " # ", " # "},
+	'U':  {"# #", "# #", "# #", "# #", "###"},
+	'V':  {"# #", "# #", "# #", "# #", " # "},
+	'W':  {"#   #", "#   #", "# # #", "## ##", "#   #"},
+	'X':  {"# #", "# #", " # ", "# #", "# #"},
+	'Y':  {"# #", "# #", " # ", " # ", " # "},
+	'Z':  {"###", "  #", " # ", "#  ", "###"},
+	'0':  {"###", "# #", "# #", "# #", "###"},
+	'1':  {" # ", "## ", " # ", " # ", "###"},
+	'2':  {"## ", "  #", " # ", "#  ", "###"},
+	'3':  {"## ", "  #", " # ", "  #", "## "},
+	'4':  {"# #", "# #", "###", "  #", "  #"},
+	'5':  {"###", "#  ", "## ", "  #", "## "},
+	'6':  {" ##", "#  ", "###", "# #", "###"},
+	'7':  {"###", "  #", " # ", " # ", " # "},
+	'8':  {"###", "# #", "###", "# #", "###"},
+	'9':  {"###", "# #", "###", "  #", "## "},
+	' ':  {"  ", "  ", "  ", "  ", "  "},
+	'.':  {" ", " ", " ", " ", "#"},
+	',':  {" ", " ", " ", "#", "#"},
+	'!':  {"#", "#", "#", " ", "#"},
+	'?':  {"## ", "  #", " # ", "   ", " # "},
+	'-':  {"   ", "   ", "###", "   ", "   "},
+	'+':  {"   ", " # ", "###", " # ", "   "},
+	':':  {" ", "#", " ", "#", " "},
+	'\'': {"#", "#", " ", " ", " "},
+	'/':  {"  #", "  #", " # ", "#  ", "#  "},
+}
+
+// Used for characters without a glyph
+var banner_placeholder_glyph = [banner_glyph_height]string{"# #", " # ", "# #", " # ", "# #"}
+
+// The rows of pixels of a line of text, glyphs are separated by a column of
+// blank pixels
+func banner_bitmap(text string) (rows [banner_glyph_height]string) {
+	var sb [banner_glyph_height]strings.Builder
+	for i, ch := range []rune(text) {
+		g, found := banner_glyphs[unicode.ToUpper(ch)]
+		if !found {
+			g = banner_placeholder_glyph
+		}
+		for y := range sb {
+			if i > 0 {
+				sb[y].WriteByte(' ')
+			}
+			sb[y].WriteString(g[y])
+		}
+	}
+	for y := range rows {
+		rows[y] = sb[y].String()
+	}
+	return
+}
+
+// Render text as lines of large letters in the specified font, lines of
+// text, separated by newlines, are separated by a blank line
+func render_banner(text string, font BannerFont) (lines []string) {
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		rows := banner_bitmap(line)
+		switch font {
+		case BANNER_HALF_BLOCK:
+			for y := 0; y < len(rows); y += 2 {
+				var sb strings.Builder
+				for x := range rows[y] {
+					top := rows[y][x] == '#'
+					bottom := y+1 < len(rows) && rows[y+1][x] == '#'
+					switch {
+					case top && bottom:
+						sb.WriteString("█")
+					case top:
+						sb.WriteString("▀")
+					case bottom:
+						sb.WriteString("▄")
+					default:
+						sb.WriteByte(' ')
+					}
+				}
+				lines = append(lines, sb.String())
+			}
+		case BANNER_ASCII:
+			lines = append(lines, rows[:]...)
+		default:
+			for _, row := range rows {
+				row = strings.ReplaceAll(row, " ", "  ")
+				lines = append(lines, strings.ReplaceAll(row, "#", "██"))
+			}
+		}
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return
+}
+
+// The number of cells text rendered with DrawBanner() occupies, useful for
+// centering it
+func BannerSize(text string, font BannerFont) (width, height int) {
+	lines := render_banner(text, font)
+	for _, line := range lines {
+		width = utils.Max(width, wcswidth.Stringwidth(line))
+	}
+	return width, len(lines)
+}
+
+// Draw text in large letters made of block characters, or of # with
+// BANNER_ASCII, with the top left corner at the specified row and column
+// (1-based). Only letters, digits and some punctuation have glyphs, letters
+// are drawn in upper case and other characters are drawn as a checkered
+// placeholder. Lines of text, separated by newlines, are drawn one below the
+// other. The parts of the banner that do not fit on screen are clipped.
+// Returns the number of cells the whole banner occupies, see BannerSize().
+func (self *Loop) DrawBanner(text string, font BannerFont, top, left int) (width, height int) {
+	lines := render_banner(text, font)
+	screen_width, screen_height := -1, -1
+	if sz, err := self.ScreenSize(); err == nil && sz.WidthCells > 0 {
+		screen_width, screen_height = int(sz.WidthCells), int(sz.HeightCells)
+	}
+	for i, line := range lines {
+		w := wcswidth.Stringwidth(line)
+		width = utils.Max(width, w)
+		y, x := top+i, left
+		if w <= 1-x || y < 1 || (screen_height > 0 && y > screen_height) {
+			continue
+		}
+		if x < 1 {
+			line, x = wcswidth.ScrollWindow(line, 1-x, w-(1-x)), 1
+		}
+		if screen_width > 0 {
+			if line = wcswidth.TruncateToVisualLength(line, screen_width-x+1); line == "" {
+				continue
+			}
+		}
+		self.MoveCursorTo(x, y)
+		self.QueueWriteString(line)
+	}
+	return width, len(lines)
+}
@@ -0,0 +1,44 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestBanner(t *testing.T) {
+	for ch, g := range banner_glyphs {
+		for _, row := range g {
+			if len(row) != len(g[0]) || strings.Trim(row, "# ") != "" {
+				t.Fatalf("Invalid glyph for %q: %#v", ch, g)
+			}
+		}
+	}
+	if diff := cmp.Diff([]string{
+		"# # ###", "# #  #", "###  #", "# #  #", "# # ###",
+	}, render_banner("Hi", BANNER_ASCII)); diff != "" {
+		t.Fatalf("Unexpected ASCII banner:\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"█ █ ▀█▀", "█▀█  █", "▀ ▀ ▀▀▀", "", "▀▄▀", "▀▄▀", "▀ ▀"}, render_banner("hi\n~", BANNER_HALF_BLOCK)); diff != "" {
+		t.Fatalf("Unexpected half block banner:\n%s", diff)
+	}
+	if w, h := BannerSize("HI", BANNER_BLOCK); w != 14 || h != 5 {
+		t.Fatalf("Unexpected size of block banner: %dx%d", w, h)
+	}
+
+	lp := new_test_loop()
+	lp.set_screen_size(10, 4)
+	if w, h := lp.DrawBanner("HI", BANNER_BLOCK, 2, -1); w != 14 || h != 5 {
+		t.Fatalf("Unexpected size of drawn banner: %dx%d", w, h)
+	}
+	expected := "<move 2,1>  ██  ████<move 3,1>  ██    ██<move 4,1>████    ██"
+	if diff := cmp.Diff(expected, lp.normalized_output()); diff != "" {
+		t.Fatalf("Banner not clipped to the screen:\n%s", diff)
+	}
+}
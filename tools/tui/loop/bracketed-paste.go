@@ -0,0 +1,101 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var _ = fmt.Print
+
+// Turn bracketed paste mode on or off. It is off when the loop starts.
+func (self *Loop) SetBracketedPaste(on bool) {
+	if on {
+		self.QueueWriteString(BRACKETED_PASTE.EscapeCodeToSet())
+	} else {
+		self.QueueWriteString(BRACKETED_PASTE.EscapeCodeToReset())
+	}
+	self.modes.bracketed_paste = on
+}
+
+// Whether bracketed paste mode is on, as set by SetBracketedPaste(),
+// StartBracketedPaste(), EndBracketedPaste() and SetMode(). This is the state
+// the loop has requested, use QueryBracketedPaste() to ask the terminal.
+func (self *Loop) BracketedPasteEnabled() bool {
+	return self.modes.bracketed_paste
+}
+
+type ModeState int
+
+const (
+	MODE_NOT_RECOGNIZED ModeState = iota
+	MODE_SET
+	MODE_RESET
+	MODE_PERMANENTLY_SET
+	MODE_PERMANENTLY_RESET
+)
+
+func (self ModeState) IsSet() bool {
+	return self == MODE_SET || self == MODE_PERMANENTLY_SET
+}
+
+func decrqm_query(m Mode) string {
+	if m&private > 0 {
+		return fmt.Sprintf("\x1b[?%d$p", uint32(m&^private))
+	}
+	return fmt.Sprintf("\x1b[%d$p", uint32(m))
+}
+
+// Parse a DECRPM response of the form ?mode;state$y
+func parse_decrpm_response(m Mode, raw []byte) (state ModeState, ok bool) {
+	payload, found := strings.CutSuffix(string(raw), "$y")
+	if !found {
+		return
+	}
+	if m&private > 0 {
+		if payload, found = strings.CutPrefix(payload, "?"); !found {
+			return
+		}
+	}
+	mode, val, found := strings.Cut(payload, ";")
+	if !found || mode != strconv.FormatUint(uint64(m&^private), 10) {
+		return
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 0 || n > int(MODE_PERMANENTLY_RESET) {
+		return
+	}
+	return ModeState(n), true
+}
+
+// Query the terminal for the state of the specified mode using DECRQM. ok is
+// false if the terminal did not respond, note that terminals that respond
+// may still report the mode as not recognized.
+func (self *Loop) QueryMode(m Mode) (state ModeState, ok bool) {
+	ok, _ = self.query_terminal(decrqm_query(m), default_query_timeout, func(etype EscapeCodeType, raw []byte) bool {
+		if etype != CSI {
+			return false
+		}
+		s, found := parse_decrpm_response(m, raw)
+		if found {
+			state = s
+		}
+		return found
+	})
+	return
+}
+
+// Ask the terminal whether bracketed paste mode is on. ok is false if the
+// terminal does not support querying it, in which case use
+// BracketedPasteEnabled() instead. Updates the state reported by
+// BracketedPasteEnabled() to match the terminal.
+func (self *Loop) QueryBracketedPaste() (enabled bool, ok bool) {
+	state, ok := self.QueryMode(BRACKETED_PASTE)
+	if !ok || state == MODE_NOT_RECOGNIZED {
+		return self.modes.bracketed_paste, false
+	}
+	self.modes.bracketed_paste = state.IsSet()
+	return self.modes.bracketed_paste, true
+}
@@ -0,0 +1,41 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestBracketedPasteState(t *testing.T) {
+	lp := new_test_loop()
+	lp.StartBracketedPaste()
+	if !lp.BracketedPasteEnabled() {
+		t.Fatalf("Bracketed paste state not tracked")
+	}
+	lp.output()
+	lp.queue_setup_sequence()
+	if !strings.HasSuffix(lp.output(), BRACKETED_PASTE.EscapeCodeToSet()) {
+		t.Fatalf("Bracketed paste not restored by the setup sequence")
+	}
+	_ = lp.SetMode(BRACKETED_PASTE, false)
+	if lp.BracketedPasteEnabled() {
+		t.Fatalf("SetMode() did not update the bracketed paste state")
+	}
+	if q := decrqm_query(BRACKETED_PASTE); q != "\x1b[?2004$p" {
+		t.Fatalf("Unexpected DECRQM query: %#v", q)
+	}
+	for raw, expected := range map[string]ModeState{"?2004;1$y": MODE_SET, "?2004;2$y": MODE_RESET, "?2004;0$y": MODE_NOT_RECOGNIZED, "?2004;4$y": MODE_PERMANENTLY_RESET} {
+		if s, ok := parse_decrpm_response(BRACKETED_PASTE, []byte(raw)); !ok || s != expected {
+			t.Fatalf("Failed to parse DECRPM response %#v: %v %v", raw, s, ok)
+		}
+	}
+	for _, raw := range []string{"?2005;1$y", "2004;1$y", "?2004;9$y", "?2004;1y"} {
+		if _, ok := parse_decrpm_response(BRACKETED_PASTE, []byte(raw)); ok {
+			t.Fatalf("Parsed invalid DECRPM response %#v", raw)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+// What has been detected about the terminal, nil or zero till it is needed
+type detected_capabilities struct {
+	keyboard_flags   *int
+	terminal_version *string
+	scrollback       *ScrollbackCapabilities
+	title_stack      *bool
+	graphics         *bool
+	color_count      int
+}
+
+const TRUECOLOR_COLOR_COUNT = 1 << 24
+
+// Query the terminal for the values of the terminfo capabilities names using
+// XTGETTCAP, all in a single round trip. Capabilities the terminal does not
+// know are absent from the result. Boolean capabilities have an empty value.
+func (self *Loop) query_termcaps(names ...string) map[string]string {
+	ans := make(map[string]string, len(names))
+	hnames := make(map[string]string, len(names))
+	var q strings.Builder
+	for _, name := range names {
+		hname := hex.EncodeToString([]byte(name))
+		hnames[hname] = name
+		q.WriteString("\x1bP+q" + hname + "\x1b\\")
+	}
+	_, _ = self.query_terminal_batch(q.String(), default_query_timeout, func(etype EscapeCodeType, raw []byte) bool {
+		if etype != DCS || len(raw) < 3 || string(raw[1:3]) != "+r" {
+			return false
+		}
+		k, v, _ := strings.Cut(string(raw[3:]), "=")
+		name, found := hnames[strings.ToLower(k)]
+		if !found {
+			return false
+		}
+		if raw[0] == '1' {
+			if b, err := hex.DecodeString(v); err == nil {
+				ans[name] = string(b)
+			}
+		}
+		return true
+	})
+	return ans
+}
+
+func color_count_from_env() int {
+	switch strings.ToLower(os.Getenv("COLORTERM")) {
+	case "truecolor", "24bit":
+		return TRUECOLOR_COLOR_COUNT
+	}
+	if strings.Contains(os.Getenv("TERM"), "256color") {
+		return 256
+	}
+	return 8
+}
+
+// The number of colors the terminal supports, one of 8, 16, 256 or
+// TRUECOLOR_COLOR_COUNT. Detected by querying the terminal for its colors, RGB
+// and Tc terminfo capabilities, using the COLORTERM and TERM environment
+// variables as a fallback, whichever gives the higher count is used. The
+// result is cached.
+func (self *Loop) ColorCount() int {
+	if self.detected.color_count > 0 {
+		return self.detected.color_count
+	}
+	ans := color_count_from_env()
+	if self.is_dumb_terminal {
+		return ans
+	}
+	probed := 0
+	caps := self.query_termcaps("colors", "RGB", "Tc")
+	if v, ok := caps["colors"]; ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			probed = n
+		}
+	}
+	for _, name := range []string{"RGB", "Tc"} {
+		if _, ok := caps[name]; ok {
+			probed = TRUECOLOR_COLOR_COUNT
+		}
+	}
+	switch {
+	case probed >= TRUECOLOR_COLOR_COUNT:
+		probed = TRUECOLOR_COLOR_COUNT
+	case probed >= 256:
+		probed = 256
+	case probed >= 16:
+		probed = 16
+	default:
+		probed = 8
+	}
+	ans = utils.Max(ans, probed)
+	if self.query.wait_for_responses != nil {
+		self.detected.color_count = ans
+	}
+	return ans
+}
+
+// Query the terminal for its name and version using XTVERSION, for example,
+// kitty(0.28.1). The result is cached.
+func (self *Loop) terminal_version() string {
+	if self.detected.terminal_version != nil {
+		return *self.detected.terminal_version
+	}
+	ans := ""
+	found, err := self.query_terminal("\x1b[>q", default_query_timeout, func(etype EscapeCodeType, raw []byte) bool {
+		if etype == DCS && len(raw) > 1 && raw[0] == '>' && raw[1] == '|' {
+			ans = string(raw[2:])
+			return true
+		}
+		return false
+	})
+	if err == nil || found {
+		self.detected.terminal_version = &ans
+	}
+	return ans
+}
+
+// Whether the XTVERSION response of the terminal starts with any of prefixes
+func (self *Loop) terminal_version_has_prefix(prefixes ...string) bool {
+	v := self.terminal_version()
+	for _, q := range prefixes {
+		if strings.HasPrefix(v, q) {
+			return true
+		}
+	}
+	return false
+}
+
+// The name and version the terminal reports for itself in response to
+// XTVERSION, for example, kitty(0.28.1) or XTerm(389). ok is false if the
+// terminal does not respond to XTVERSION. The result is cached.
+func (self *Loop) TerminalName() (name string, ok bool) {
+	name = self.terminal_version()
+	return name, name != ""
+}
+
+// Whether the terminal is kitty, detected from the environment, falling back
+// to XTVERSION for when the environment is not passed through, for example,
+// over SSH
+func (self *Loop) is_kitty() bool {
+	if os.Getenv("TERM") == "xterm-kitty" || os.Getenv("KITTY_WINDOW_ID") != "" {
+		return true
+	}
+	return strings.HasPrefix(self.terminal_version(), "kitty(")
+}
+
+type ScrollbackCapabilities struct {
+	// Entering the alternate screen leaves the main screen and its scrollback
+	// untouched, so text written with PrintToScrollback() ends up in the
+	// scrollback
+	CleanAltScreen bool
+	// CSI 3J clears the scrollback
+	ClearScrollback bool
+	// The terminal was identified, when false the capabilities are the
+	// conservative defaults used for unknown terminals
+	Detected bool
+}
+
+// Scrollback related capabilities of terminals, keyed by the prefix of their
+// XTVERSION response
+var known_scrollback_capabilities = []struct {
+	prefix string
+	caps   ScrollbackCapabilities
+}{
+	{"kitty(", ScrollbackCapabilities{CleanAltScreen: true, ClearScrollback: true, Detected: true}},
+	{"XTerm(", ScrollbackCapabilities{CleanAltScreen: true, ClearScrollback: true, Detected: true}},
+	{"foot(", ScrollbackCapabilities{CleanAltScreen: true, ClearScrollback: true, Detected: true}},
+	{"WezTerm ", ScrollbackCapabilities{CleanAltScreen: true, ClearScrollback: true, Detected: true}},
+	// tmux keeps the alternate screen out of its history, but CSI 3J does not
+	// clear the history
+	{"tmux ", ScrollbackCapabilities{CleanAltScreen: true, Detected: true}},
+}
+
+// Whether switching to the alternate screen keeps the scrollback intact and
+// whether it can be cleared with CSI 3J. Terminals are identified with
+// XTVERSION, for terminals that cannot be identified both are assumed to be
+// false, in which case PrintToScrollback() and clearing the scrollback may not
+// work. The result is cached.
+func (self *Loop) ScrollbackCapabilities() ScrollbackCapabilities {
+	if self.detected.scrollback == nil {
+		ans := ScrollbackCapabilities{}
+		v := self.terminal_version()
+		if v == "" && self.is_kitty() {
+			v = "kitty("
+		}
+		for _, q := range known_scrollback_capabilities {
+			if strings.HasPrefix(v, q.prefix) {
+				ans = q.caps
+				break
+			}
+		}
+		if !ans.Detected && self.query.wait_for_responses == nil {
+			return ans // not running, so the terminal could not be queried
+		}
+		self.detected.scrollback = &ans
+	}
+	return *self.detected.scrollback
+}
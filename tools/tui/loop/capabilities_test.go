@@ -0,0 +1,158 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var _ = fmt.Print
+
+// Answer XTGETTCAP queries from caps, unknown capabilities are reported as
+// such
+func answer_termcap_queries(caps map[string]string) func(string) []string {
+	return func(output string) (ans []string) {
+		for _, q := range strings.Split(output, "\x1bP+q")[1:] {
+			hname, _, _ := strings.Cut(q, "\x1b\\")
+			name, _ := hex.DecodeString(hname)
+			if v, ok := caps[string(name)]; ok {
+				ans = append(ans, "\x1bP1+r"+hname+"="+hex.EncodeToString([]byte(v))+"\x1b\\")
+			} else {
+				ans = append(ans, "\x1bP0+r"+hname+"\x1b\\")
+			}
+		}
+		return
+	}
+}
+
+func TestColorCount(t *testing.T) {
+	for _, x := range []struct {
+		colorterm, term string
+		caps            map[string]string
+		expected        int
+	}{
+		{"", "xterm", map[string]string{"colors": "256"}, 256},
+		{"", "xterm", map[string]string{"colors": "88"}, 16},
+		{"", "xterm", map[string]string{"colors": "8"}, 8},
+		{"", "xterm", map[string]string{"colors": "256", "Tc": ""}, TRUECOLOR_COLOR_COUNT},
+		{"", "xterm", map[string]string{"colors": "16777216"}, TRUECOLOR_COLOR_COUNT},
+		{"", "xterm", map[string]string{"RGB": ""}, TRUECOLOR_COLOR_COUNT},
+		// the environment is used when the terminal does not know better
+		{"", "xterm-256color", nil, 256},
+		{"truecolor", "xterm", map[string]string{"colors": "256"}, TRUECOLOR_COLOR_COUNT},
+		{"24bit", "xterm", nil, TRUECOLOR_COLOR_COUNT},
+		{"", "xterm", nil, 8},
+	} {
+		t.Setenv("COLORTERM", x.colorterm)
+		t.Setenv("TERM", x.term)
+		lp := new_test_loop()
+		queries := 0
+		answer := answer_termcap_queries(x.caps)
+		lp.answer_queries(func(output string) []string {
+			queries++
+			return answer(output)
+		})
+		if actual := lp.ColorCount(); actual != x.expected {
+			t.Fatalf("Unexpected color count for %+v: %d", x, actual)
+		}
+		if queries != 1 {
+			t.Fatalf("Capabilities not queried in a single round trip: %d", queries)
+		}
+		// cached
+		before := queries
+		if lp.ColorCount(); queries != before {
+			t.Fatalf("Color count not cached")
+		}
+	}
+	// only the environment is used before the loop runs and in dumb terminals
+	t.Setenv("TERM", "xterm-256color")
+	lp := new_test_loop()
+	if c := lp.ColorCount(); c != 256 || lp.detected.color_count != 0 {
+		t.Fatalf("Unexpected color count before the loop runs: %d", c)
+	}
+	lp.answer_queries(func(string) []string {
+		t.Fatalf("A dumb terminal was queried")
+		return nil
+	})
+	lp.is_dumb_terminal = true
+	if c := lp.ColorCount(); c != 256 {
+		t.Fatalf("Unexpected color count for a dumb terminal: %d", c)
+	}
+}
+
+func TestScrollbackCapabilities(t *testing.T) {
+	t.Setenv("KITTY_WINDOW_ID", "")
+	full := ScrollbackCapabilities{CleanAltScreen: true, ClearScrollback: true, Detected: true}
+	for _, x := range []struct {
+		term, version string
+		expected      ScrollbackCapabilities
+	}{
+		{"xterm", "kitty(0.28.1)", full},
+		{"xterm", "XTerm(389)", full},
+		{"xterm", "tmux 3.3a", ScrollbackCapabilities{CleanAltScreen: true, Detected: true}},
+		{"xterm", "Unknown 1.0", ScrollbackCapabilities{}},
+		{"xterm", "", ScrollbackCapabilities{}},
+		// kitty is detected from the environment when XTVERSION is not answered
+		{"xterm-kitty", "", full},
+	} {
+		t.Setenv("TERM", x.term)
+		lp := new_test_loop()
+		queries := 0
+		lp.answer_queries(func(string) []string {
+			queries++
+			if x.version == "" {
+				return nil
+			}
+			return []string{"\x1bP>|" + x.version + "\x1b\\"}
+		})
+		if actual := lp.ScrollbackCapabilities(); actual != x.expected {
+			t.Fatalf("Unexpected capabilities for %+v: %+v", x, actual)
+		}
+		if lp.ScrollbackCapabilities(); queries != 1 {
+			t.Fatalf("Scrollback capabilities not cached for %+v", x)
+		}
+	}
+	// not cached before the loop runs, when the terminal cannot be queried
+	t.Setenv("TERM", "xterm")
+	lp := new_test_loop()
+	if lp.ScrollbackCapabilities() != (ScrollbackCapabilities{}) || lp.detected.scrollback != nil {
+		t.Fatalf("Scrollback capabilities cached before the loop runs")
+	}
+}
+
+func TestTerminalName(t *testing.T) {
+	lp := new_test_loop()
+	if name, ok := lp.TerminalName(); ok || name != "" {
+		t.Fatalf("Terminal name found before the loop runs: %#v", name)
+	}
+	for _, x := range []struct {
+		answers  []string
+		expected string
+	}{
+		{[]string{"\x1bP>|kitty(0.28.1)\x1b\\"}, "kitty(0.28.1)"},
+		// other replies to the query are ignored
+		{[]string{"\x1bP>0|x\x1b\\", "\x1bP>|XTerm(389)\x1b\\"}, "XTerm(389)"},
+		{nil, ""},
+	} {
+		lp = new_test_loop()
+		queries := 0
+		lp.answer_queries(func(output string) []string {
+			queries++
+			if output != "\x1b[>q\x1b[c" {
+				t.Fatalf("Unexpected query: %#v", output)
+			}
+			return x.answers
+		})
+		for i := 0; i < 2; i++ {
+			if name, ok := lp.TerminalName(); name != x.expected || ok != (x.expected != "") {
+				t.Fatalf("Unexpected terminal name for %#v: %#v %v", x.answers, name, ok)
+			}
+		}
+		if queries != 1 {
+			t.Fatalf("Terminal name not cached")
+		}
+	}
+}
@@ -0,0 +1,336 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+// The maximum time RunCapabilityReport() spends waiting for the terminal
+const capability_report_budget = 10 * time.Second
+
+type ProbeResult int
+
+const (
+	PROBE_PASS ProbeResult = iota
+	PROBE_FAIL
+	// The terminal did not answer in time, so support is unknown
+	PROBE_TIMEOUT
+)
+
+var probe_result_names = []string{"pass", "fail", "timeout"}
+
+func (self ProbeResult) String() string {
+	if int(self) < len(probe_result_names) {
+		return probe_result_names[self]
+	}
+	return strconv.Itoa(int(self))
+}
+
+func (self ProbeResult) MarshalText() ([]byte, error) {
+	return []byte(self.String()), nil
+}
+
+func (self *ProbeResult) UnmarshalText(text []byte) error {
+	for i, q := range probe_result_names {
+		if q == string(text) {
+			*self = ProbeResult(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown probe result: %#v", string(text))
+}
+
+// The result of probing the terminal for a single feature
+type FeatureProbe struct {
+	Name     string      `json:"name"`
+	Category string      `json:"category"`
+	Result   ProbeResult `json:"result"`
+	// Extra information from the response, such as the reported mode state
+	Detail string `json:"detail,omitempty"`
+}
+
+// What the terminal supports, as found by RunCapabilityReport()
+type CapabilityReport struct {
+	Terminal string         `json:"terminal"`
+	Duration time.Duration  `json:"duration"`
+	Features []FeatureProbe `json:"features"`
+}
+
+// The report as indented JSON, suitable for including in bug reports
+func (self CapabilityReport) JSON() string {
+	ans, _ := json.MarshalIndent(self, "", "  ")
+	return string(ans)
+}
+
+// One feature per line, in a human readable form
+func (self CapabilityReport) String() string {
+	var sb strings.Builder
+	terminal := self.Terminal
+	if terminal == "" {
+		terminal = "unknown"
+	}
+	fmt.Fprintf(&sb, "Terminal: %s\n", terminal)
+	for _, f := range self.Features {
+		fmt.Fprintf(&sb, "%s/%s: %s", f.Category, f.Name, f.Result)
+		if f.Detail != "" {
+			fmt.Fprintf(&sb, " (%s)", f.Detail)
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+type capability_probe struct {
+	name, query string
+	// Whether the escape code is the response to this probe and if so,
+	// whether the feature is supported
+	parse func(etype EscapeCodeType, raw []byte) (is_response, supported bool, detail string)
+}
+
+type capability_category struct {
+	name   string
+	probes []capability_probe
+}
+
+var mode_state_names = []string{"not recognized", "set", "reset", "permanently set", "permanently reset"}
+
+func mode_probe(name string, m Mode) capability_probe {
+	return capability_probe{name: name, query: decrqm_query(m), parse: func(etype EscapeCodeType, raw []byte) (bool, bool, string) {
+		if etype != CSI {
+			return false, false, ""
+		}
+		state, ok := parse_decrpm_response(m, raw)
+		if !ok {
+			return false, false, ""
+		}
+		return true, state != MODE_NOT_RECOGNIZED, mode_state_names[state]
+	}}
+}
+
+// Probe for an SGR attribute by setting it and reading it back with DECRQSS.
+// Responses to these all look alike, so they are matched in order.
+func sgr_probe(name, sgr string, accept func(reported []string) bool) capability_probe {
+	return capability_probe{name: name, query: "\x1b[0;" + sgr + "m\x1bP$qm\x1b\\\x1b[m", parse: func(etype EscapeCodeType, raw []byte) (bool, bool, string) {
+		if etype != DCS || len(raw) < 3 || string(raw[1:3]) != "$r" {
+			return false, false, ""
+		}
+		reported, _ := strings.CutSuffix(string(raw[3:]), "m")
+		return true, raw[0] == '1' && accept(strings.Split(reported, ";")), ""
+	}}
+}
+
+func sgr_has(param string) func([]string) bool {
+	return func(reported []string) bool {
+		for _, q := range reported {
+			if q == param {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Probe for a kitty keyboard protocol enhancement flag by pushing it and
+// reading back the active flags
+func keyboard_probe(name string, flag KeyboardStateBits) capability_probe {
+	return capability_probe{name: name, query: fmt.Sprintf("\x1b[>%du\x1b[?u\x1b[<u", flag), parse: func(etype EscapeCodeType, raw []byte) (bool, bool, string) {
+		if etype != CSI || len(raw) < 3 || raw[0] != '?' || raw[len(raw)-1] != 'u' {
+			return false, false, ""
+		}
+		n, err := strconv.Atoi(string(raw[1 : len(raw)-1]))
+		if err != nil {
+			return false, false, ""
+		}
+		return true, KeyboardStateBits(n)&flag != 0, ""
+	}}
+}
+
+// The features probed by RunCapabilityReport(), the probes in a category are
+// sent to the terminal together
+var capability_categories = []capability_category{
+	{"modes", []capability_probe{
+		mode_probe("cursor-keys", DECKM),
+		mode_probe("autowrap", DECAWM),
+		mode_probe("cursor-visibility", DECTCEM),
+		mode_probe("mouse-button-tracking", MOUSE_BUTTON_TRACKING),
+		mode_probe("mouse-motion-tracking", MOUSE_MOTION_TRACKING),
+		mode_probe("mouse-move-tracking", MOUSE_MOVE_TRACKING),
+		mode_probe("mouse-sgr", MOUSE_SGR_MODE),
+		mode_probe("mouse-sgr-pixel", MOUSE_SGR_PIXEL_MODE),
+		mode_probe("focus-tracking", FOCUS_TRACKING),
+		mode_probe("alternate-screen", ALTERNATE_SCREEN),
+		mode_probe("bracketed-paste", BRACKETED_PASTE),
+		mode_probe("synchronized-output", PENDING_UPDATE),
+	}},
+	{"protocols", []capability_probe{
+		{name: "xtversion", query: "\x1b[>q", parse: func(etype EscapeCodeType, raw []byte) (bool, bool, string) {
+			if etype == DCS && len(raw) > 1 && raw[0] == '>' && raw[1] == '|' {
+				return true, true, string(raw[2:])
+			}
+			return false, false, ""
+		}},
+		{name: "xtgettcap", query: "\x1bP+q544e\x1b\\", parse: func(etype EscapeCodeType, raw []byte) (bool, bool, string) {
+			if etype == DCS && len(raw) > 2 && string(raw[1:3]) == "+r" {
+				return true, raw[0] == '1', ""
+			}
+			return false, false, ""
+		}},
+		{name: "color-query", query: "\x1b]10;?\x1b\\", parse: func(etype EscapeCodeType, raw []byte) (bool, bool, string) {
+			if etype == OSC && strings.HasPrefix(string(raw), "10;") {
+				return true, true, ""
+			}
+			return false, false, ""
+		}},
+		{name: "kitty-keyboard", query: "\x1b[?u", parse: func(etype EscapeCodeType, raw []byte) (bool, bool, string) {
+			if etype == CSI && len(raw) > 1 && raw[0] == '?' && raw[len(raw)-1] == 'u' {
+				return true, true, "flags: " + string(raw[1:len(raw)-1])
+			}
+			return false, false, ""
+		}},
+	}},
+	{"sgr", []capability_probe{
+		sgr_probe("bold", "1", sgr_has("1")),
+		sgr_probe("dim", "2", sgr_has("2")),
+		sgr_probe("italic", "3", sgr_has("3")),
+		sgr_probe("underline", "4", sgr_has("4")),
+		sgr_probe("curly-underline", "4:3", sgr_has("4:3")),
+		sgr_probe("blink", "5", sgr_has("5")),
+		sgr_probe("reverse", "7", sgr_has("7")),
+		sgr_probe("strikethrough", "9", sgr_has("9")),
+		sgr_probe("overline", "53", sgr_has("53")),
+		sgr_probe("truecolor", "38:2:1:2:3", func(reported []string) bool {
+			for _, q := range reported {
+				if strings.HasPrefix(q, "38:2:") {
+					return true
+				}
+			}
+			// some terminals report the semicolon form
+			return strings.Contains(strings.Join(reported, ";"), "38;2;1;2;3")
+		}),
+	}},
+	{"graphics", []capability_probe{
+		{name: "kitty-graphics", query: "\x1b_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\x1b\\", parse: func(etype EscapeCodeType, raw []byte) (bool, bool, string) {
+			if etype == APC && strings.HasPrefix(string(raw), "Gi=31;") {
+				return true, string(raw) == "Gi=31;OK", ""
+			}
+			return false, false, ""
+		}},
+		// sixel support is advertised in the DA1 response
+		{name: "sixel", parse: func(etype EscapeCodeType, raw []byte) (bool, bool, string) {
+			if !is_da1_response(etype, raw) {
+				return false, false, ""
+			}
+			return true, sgr_has("4")(strings.Split(string(raw[1:len(raw)-1]), ";")), ""
+		}},
+	}},
+	{"keyboard", []capability_probe{
+		keyboard_probe("disambiguate-keys", DISAMBIGUATE_KEYS),
+		keyboard_probe("report-event-types", REPORT_KEY_EVENT_TYPES),
+		keyboard_probe("report-alternate-keys", REPORT_ALTERNATE_KEYS),
+		keyboard_probe("report-all-keys", REPORT_ALL_KEYS_AS_ESCAPE_CODES),
+		keyboard_probe("report-text", REPORT_TEXT_WITH_KEYS),
+	}},
+}
+
+type capability_batch struct {
+	probes   []capability_probe
+	results  []FeatureProbe
+	answered []bool
+}
+
+func new_capability_batch(c capability_category) *capability_batch {
+	ans := &capability_batch{probes: c.probes, results: make([]FeatureProbe, len(c.probes)), answered: make([]bool, len(c.probes))}
+	for i, p := range c.probes {
+		ans.results[i] = FeatureProbe{Name: p.name, Category: c.name, Result: PROBE_FAIL}
+	}
+	return ans
+}
+
+func (self *capability_batch) query() string {
+	var sb strings.Builder
+	for _, p := range self.probes {
+		sb.WriteString(p.query)
+	}
+	return sb.String()
+}
+
+// Assign a response to the first unanswered probe it is a response to
+func (self *capability_batch) handle_response(etype EscapeCodeType, raw []byte) bool {
+	for i, p := range self.probes {
+		if self.answered[i] {
+			continue
+		}
+		if is_response, supported, detail := p.parse(etype, raw); is_response {
+			self.answered[i] = true
+			if supported {
+				self.results[i].Result = PROBE_PASS
+			}
+			self.results[i].Detail = detail
+			return true
+		}
+	}
+	return false
+}
+
+// Probes that were not answered failed if the terminal answered the DA1
+// query that follows them, as terminals answer queries in order, otherwise
+// they timed out
+func (self *capability_batch) finish(da1_received bool, detail string) []FeatureProbe {
+	for i := range self.results {
+		if !self.answered[i] && !da1_received {
+			self.results[i].Result = PROBE_TIMEOUT
+			self.results[i].Detail = detail
+		}
+	}
+	return self.results
+}
+
+// Systematically probe the terminal for support of a documented list of
+// features: modes, protocols, SGR attributes, graphics and keyboard protocol
+// enhancements, reporting the result for each feature. Unlike the lazy
+// detection used elsewhere, this always queries the terminal. Probes are sent
+// in batches and the whole report takes at most ten seconds, features that
+// could not be probed in that time are reported as timed out. This is meant
+// for diagnostic tools, it changes terminal state such as the SGR attributes
+// and keyboard mode while probing, restoring them afterwards.
+func (self *Loop) RunCapabilityReport() (CapabilityReport, error) {
+	ans := CapabilityReport{}
+	start := time.Now()
+	deadline := start.Add(capability_report_budget)
+	can_query, err := self.can_query()
+	if err != nil {
+		return ans, err
+	}
+	for _, c := range capability_categories {
+		b := new_capability_batch(c)
+		da1_received, detail := false, "no response"
+		remaining := time.Until(deadline)
+		switch {
+		case !can_query:
+			detail = "the terminal does not respond to queries"
+		case remaining <= 0:
+			detail = "not probed, time budget exhausted"
+		default:
+			da1_received, err = self.query_terminal_batch(b.query(), utils.Min(default_query_timeout, remaining), b.handle_response)
+			if errors.Is(err, ErrQueryEchoed) {
+				return ans, err
+			}
+		}
+		ans.Features = append(ans.Features, b.finish(da1_received, detail)...)
+		if c.name == "protocols" && b.answered[0] {
+			ans.Terminal = b.results[0].Detail
+		}
+	}
+	ans.Duration = time.Since(start)
+	return ans, nil
+}
@@ -0,0 +1,84 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestCapabilityReport(t *testing.T) {
+	category := func(name string) capability_category {
+		for _, c := range capability_categories {
+			if c.name == name {
+				return c
+			}
+		}
+		t.Fatalf("No category named: %s", name)
+		return capability_category{}
+	}
+	results := func(b *capability_batch, da1_received bool) map[string]string {
+		ans := map[string]string{}
+		for _, r := range b.finish(da1_received, "no response") {
+			ans[r.Name] = r.Result.String()
+		}
+		return ans
+	}
+
+	b := new_capability_batch(category("sgr"))
+	for _, r := range []string{"1$r0;1m", "0$r", "1$r0;3m", "1$r0;4:3m", "1$r0;4:3m"} {
+		if !b.handle_response(DCS, []byte(r)) {
+			t.Fatalf("Response not consumed: %#v", r)
+		}
+	}
+	if b.handle_response(CSI, []byte("?62;4c")) {
+		t.Fatalf("DA1 response consumed by SGR probes")
+	}
+	expected := map[string]string{
+		"bold": "pass", "dim": "fail", "italic": "pass", "underline": "fail", "curly-underline": "pass",
+		"blink": "fail", "reverse": "fail", "strikethrough": "fail", "overline": "fail", "truecolor": "fail",
+	}
+	if diff := cmp.Diff(expected, results(b, true)); diff != "" {
+		t.Fatalf("Unexpected SGR probe results:\n%s", diff)
+	}
+
+	b = new_capability_batch(category("modes"))
+	b.handle_response(CSI, []byte("?2004;2$y"))
+	b.handle_response(CSI, []byte("?1016;0$y"))
+	r := results(b, false)
+	if r["bracketed-paste"] != "pass" || r["mouse-sgr-pixel"] != "fail" || r["autowrap"] != "timeout" {
+		t.Fatalf("Unexpected mode probe results: %v", r)
+	}
+	if b.results[10].Detail != "reset" {
+		t.Fatalf("Unexpected detail: %#v", b.results[10].Detail)
+	}
+
+	b = new_capability_batch(category("graphics"))
+	b.handle_response(APC, []byte("Gi=31;OK"))
+	b.handle_response(CSI, []byte("?62;4;22c"))
+	if diff := cmp.Diff(map[string]string{"kitty-graphics": "pass", "sixel": "pass"}, results(b, true)); diff != "" {
+		t.Fatalf("Unexpected graphics probe results:\n%s", diff)
+	}
+
+	b = new_capability_batch(category("keyboard"))
+	for _, r := range []string{"?1u", "?0u", "?4u"} {
+		b.handle_response(CSI, []byte(r))
+	}
+	if diff := cmp.Diff(map[string]string{"disambiguate-keys": "pass", "report-event-types": "fail", "report-alternate-keys": "pass", "report-all-keys": "fail", "report-text": "fail"}, results(b, true)); diff != "" {
+		t.Fatalf("Unexpected keyboard probe results:\n%s", diff)
+	}
+
+	report := CapabilityReport{Terminal: "kitty(0.31.0)", Features: b.results}
+	var q CapabilityReport
+	if err := json.Unmarshal([]byte(report.JSON()), &q); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(report, q); diff != "" {
+		t.Fatalf("Report changed on serialization:\n%s", diff)
+	}
+}
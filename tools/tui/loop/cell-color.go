@@ -0,0 +1,158 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+type ColorType uint8
+
+const (
+	COLOR_DEFAULT ColorType = iota
+	// One of the 256 indexed colors
+	COLOR_INDEXED
+	COLOR_RGB
+)
+
+type Color struct {
+	Type             ColorType
+	Index            uint8
+	Red, Green, Blue uint8
+}
+
+func (self Color) String() string {
+	switch self.Type {
+	case COLOR_INDEXED:
+		return strconv.Itoa(int(self.Index))
+	case COLOR_RGB:
+		return fmt.Sprintf("#%02x%02x%02x", self.Red, self.Green, self.Blue)
+	}
+	return "default"
+}
+
+// Parse an extended color, such as 5;n or 2;r;g;b, returning the number of
+// parameters consumed
+func parse_extended_color(params []string) (c Color, consumed int) {
+	num := func(i int) uint8 {
+		n, _ := strconv.Atoi(params[i])
+		return uint8(n)
+	}
+	if len(params) > 1 && params[0] == "5" {
+		return Color{Type: COLOR_INDEXED, Index: num(1)}, 2
+	}
+	if len(params) > 3 && params[0] == "2" {
+		if len(params) > 4 && params[1] == "" {
+			// the colon form can have an empty color space id
+			return Color{Type: COLOR_RGB, Red: num(2), Green: num(3), Blue: num(4)}, 5
+		}
+		return Color{Type: COLOR_RGB, Red: num(1), Green: num(2), Blue: num(3)}, 4
+	}
+	return Color{}, len(params)
+}
+
+func apply_sgr_colors(sgr string, fg, bg *Color) {
+	params := strings.FieldsFunc(sgr, func(r rune) bool { return r == ';' })
+	if len(params) == 0 {
+		*fg, *bg = Color{}, Color{}
+		return
+	}
+	for i := 0; i < len(params); i++ {
+		p := params[i]
+		base, sub, has_sub := strings.Cut(p, ":")
+		n, err := strconv.Atoi(base)
+		if err != nil {
+			continue
+		}
+		switch {
+		case n == 0:
+			*fg, *bg = Color{}, Color{}
+		case 30 <= n && n <= 37:
+			*fg = Color{Type: COLOR_INDEXED, Index: uint8(n - 30)}
+		case 90 <= n && n <= 97:
+			*fg = Color{Type: COLOR_INDEXED, Index: uint8(n - 90 + 8)}
+		case n == 39:
+			*fg = Color{}
+		case 40 <= n && n <= 47:
+			*bg = Color{Type: COLOR_INDEXED, Index: uint8(n - 40)}
+		case 100 <= n && n <= 107:
+			*bg = Color{Type: COLOR_INDEXED, Index: uint8(n - 100 + 8)}
+		case n == 49:
+			*bg = Color{}
+		case n == 38 || n == 48 || n == 58:
+			var c Color
+			if has_sub {
+				c, _ = parse_extended_color(strings.Split(sub, ":"))
+			} else {
+				var consumed int
+				c, consumed = parse_extended_color(params[i+1:])
+				i += consumed
+			}
+			switch n {
+			case 38:
+				*fg = c
+			case 48:
+				*bg = c
+			}
+		}
+	}
+}
+
+// Find the colors of the cell at the specified row and column (1-based) in
+// screen contents formatted with SGR codes
+func cell_colors_from_ansi(text string, row, col int) (fg, bg Color, found bool) {
+	x, y := 1, 1
+	p := wcswidth.EscapeCodeParser{}
+	p.HandleCSI = func(raw []byte) error {
+		if len(raw) > 0 && raw[len(raw)-1] == 'm' {
+			apply_sgr_colors(string(raw[:len(raw)-1]), &fg, &bg)
+		}
+		return nil
+	}
+	p.HandleRune = func(ch rune) error {
+		if ch == '\n' {
+			if y == row {
+				// trailing blank cells are not included in the text
+				fg, bg, found = Color{}, Color{}, true
+				return fmt.Errorf("found")
+			}
+			x, y = 1, y+1
+			return nil
+		}
+		w := wcswidth.Runewidth(ch)
+		if y == row && x <= col && col < x+w {
+			found = true
+			return fmt.Errorf("found")
+		}
+		x += w
+		return nil
+	}
+	_ = p.ParseString(text)
+	if !found {
+		// the last line may also be missing trailing blank cells
+		fg, bg, found = Color{}, Color{}, y == row
+	}
+	return
+}
+
+// Read the foreground and background colors of the cell at the specified row
+// and column (1-based, as for MoveCursorTo) from the terminal. This uses the
+// remote control get-text command, so it works only in kitty with remote
+// control enabled. Returns ErrNotKitty in other terminals.
+func (self *Loop) ReadCellColor(row, col int) (fg, bg Color, err error) {
+	data, err := self.send_rc_command("get-text", map[string]any{"match": "", "extent": "screen", "ansi": true, "self": true})
+	if err != nil {
+		return
+	}
+	fg, bg, found := cell_colors_from_ansi(data, row, col)
+	if !found {
+		err = fmt.Errorf("There is no cell at row: %d column: %d", row, col)
+	}
+	return
+}
@@ -0,0 +1,35 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestCellColorsFromANSI(t *testing.T) {
+	text := "ab\x1b[31;48;5;200mc\x1b[m一\x1b[38:2::1:2:3;44md\n\x1b[92;48;2;4;5;6mx\x1b[39my"
+	for _, x := range []struct {
+		row, col int
+		fg, bg   string
+		found    bool
+	}{
+		{1, 1, "default", "default", true},
+		{1, 3, "1", "200", true},
+		{1, 4, "default", "default", true},
+		{1, 5, "default", "default", true},
+		{1, 6, "#010203", "4", true},
+		{1, 20, "default", "default", true},
+		{2, 1, "10", "#040506", true},
+		{2, 2, "default", "#040506", true},
+		{2, 9, "default", "default", true},
+		{3, 1, "default", "default", false},
+	} {
+		fg, bg, found := cell_colors_from_ansi(text, x.row, x.col)
+		if found != x.found || fg.String() != x.fg || bg.String() != x.bg {
+			t.Fatalf("Unexpected colors at (%d, %d): fg: %s bg: %s found: %v", x.row, x.col, fg, bg, found)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+var _ = fmt.Print
+
+type ClipboardStatus int
+
+const (
+	// The terminal does not distinguish between an empty clipboard and
+	// access being denied, so an empty response could be either
+	CLIPBOARD_UNKNOWN ClipboardStatus = iota
+	CLIPBOARD_OK
+	CLIPBOARD_EMPTY
+	// Reading the clipboard was denied by the settings of the terminal or
+	// by the user
+	CLIPBOARD_DENIED
+	// The terminal has no access to the clipboard
+	CLIPBOARD_UNSUPPORTED
+)
+
+func (self ClipboardStatus) String() string {
+	switch self {
+	case CLIPBOARD_OK:
+		return "Ok"
+	case CLIPBOARD_EMPTY:
+		return "Empty"
+	case CLIPBOARD_DENIED:
+		return "Denied"
+	case CLIPBOARD_UNSUPPORTED:
+		return "Unsupported"
+	}
+	return "Unknown"
+}
+
+type ClipboardResponse struct {
+	Status ClipboardStatus
+	Text   string
+	// The response is for the primary selection rather than the clipboard
+	Primary bool
+}
+
+type clipboard_read struct {
+	primary bool
+	data    strings.Builder
+}
+
+// Ask the terminal for the text on the clipboard or the primary selection,
+// the response is delivered to OnClipboardResponse, possibly after the
+// terminal asks the user for permission. In kitty this uses the OSC 5522
+// clipboard protocol, which distinguishes between an empty clipboard and
+// access being denied. Other terminals are sent an OSC 52 query, for which
+// an empty response has status CLIPBOARD_UNKNOWN. Terminals that do not allow
+// reading the clipboard often do not respond at all.
+func (self *Loop) RequestClipboardText(primary bool) {
+	if self.is_kitty() {
+		loc := ""
+		if primary {
+			loc = ":loc=primary"
+		}
+		self.clipboard_read = &clipboard_read{primary: primary}
+		self.QueueWriteString("\x1b]5522;type=read" + loc + ";" + base64.StdEncoding.EncodeToString([]byte("text/plain")) + "\x1b\\")
+		return
+	}
+	dest := "c"
+	if primary {
+		dest = "p"
+	}
+	self.QueueWriteString("\x1b]52;" + dest + ";?\x1b\\")
+}
+
+func parse_osc52_response(raw string) (resp ClipboardResponse, ok bool) {
+	rest, found := strings.CutPrefix(raw, "52;")
+	if !found {
+		return
+	}
+	dest, payload, found := strings.Cut(rest, ";")
+	if !found || payload == "?" {
+		return
+	}
+	resp.Primary = dest == "p" || dest == "s"
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return
+	}
+	resp.Text = string(data)
+	if resp.Text != "" {
+		resp.Status = CLIPBOARD_OK
+	}
+	return resp, true
+}
+
+// Handle a response using the OSC 5522 protocol, returning whether the
+// response is complete
+func (self *clipboard_read) handle_response(raw string) (resp ClipboardResponse, done bool) {
+	metadata, payload, _ := strings.Cut(strings.TrimPrefix(raw, "5522;"), ";")
+	m := make(map[string]string)
+	for _, record := range strings.Split(metadata, ":") {
+		k, v, _ := strings.Cut(record, "=")
+		m[k] = v
+	}
+	if m["type"] != "read" {
+		return
+	}
+	resp.Primary = self.primary
+	switch m["status"] {
+	case "DATA":
+		if data, err := base64.StdEncoding.DecodeString(payload); err == nil {
+			self.data.Write(data)
+		}
+	case "DONE":
+		resp.Text = self.data.String()
+		resp.Status = CLIPBOARD_EMPTY
+		if resp.Text != "" {
+			resp.Status = CLIPBOARD_OK
+		}
+		return resp, true
+	case "EPERM":
+		resp.Status = CLIPBOARD_DENIED
+		return resp, true
+	case "ENOSYS":
+		resp.Status = CLIPBOARD_UNSUPPORTED
+		return resp, true
+	}
+	return
+}
+
+func (self *Loop) handle_clipboard_response(raw []byte) (handled bool, err error) {
+	text := string(raw)
+	if strings.HasPrefix(text, "5522;") {
+		if self.clipboard_read == nil {
+			return false, nil
+		}
+		resp, done := self.clipboard_read.handle_response(text)
+		if done {
+			self.clipboard_read = nil
+			if self.OnClipboardResponse != nil {
+				err = self.OnClipboardResponse(resp)
+			}
+		}
+		return true, err
+	}
+	if resp, ok := parse_osc52_response(text); ok && self.OnClipboardResponse != nil {
+		return true, self.OnClipboardResponse(resp)
+	}
+	return false, nil
+}
@@ -0,0 +1,59 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestClipboardResponses(t *testing.T) {
+	lp, _ := New()
+	var responses []ClipboardResponse
+	lp.OnClipboardResponse = func(r ClipboardResponse) error { responses = append(responses, r); return nil }
+	osc := func(x string) {
+		if err := lp.handle_osc([]byte(x)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(expected ClipboardResponse) {
+		t.Helper()
+		if len(responses) != 1 || responses[0] != expected {
+			t.Fatalf("Unexpected responses: %+v != %+v", responses, expected)
+		}
+		responses = nil
+	}
+	b64 := func(x string) string { return base64.StdEncoding.EncodeToString([]byte(x)) }
+
+	osc("52;c;" + b64("abc"))
+	check(ClipboardResponse{Status: CLIPBOARD_OK, Text: "abc"})
+	osc("52;p;")
+	check(ClipboardResponse{Status: CLIPBOARD_UNKNOWN, Primary: true})
+
+	// unsolicited OSC 5522 responses are ignored
+	osc("5522;type=read:status=EPERM")
+	if len(responses) != 0 {
+		t.Fatalf("Unsolicited response delivered")
+	}
+	lp.clipboard_read = &clipboard_read{}
+	osc("5522;type=read:status=OK")
+	osc("5522;type=read:status=DATA:mime=" + b64("text/plain") + ";" + b64("hello "))
+	osc("5522;type=read:status=DATA:mime=" + b64("text/plain") + ";" + b64("world"))
+	if len(responses) != 0 {
+		t.Fatalf("Response delivered before it was complete")
+	}
+	osc("5522;type=read:status=DONE")
+	check(ClipboardResponse{Status: CLIPBOARD_OK, Text: "hello world"})
+	for status, expected := range map[string]ClipboardStatus{"EPERM": CLIPBOARD_DENIED, "ENOSYS": CLIPBOARD_UNSUPPORTED} {
+		lp.clipboard_read = &clipboard_read{primary: true}
+		osc("5522;type=read:status=" + status)
+		check(ClipboardResponse{Status: expected, Primary: true})
+	}
+	lp.clipboard_read = &clipboard_read{}
+	osc("5522;type=read:status=OK")
+	osc("5522;type=read:status=DONE")
+	check(ClipboardResponse{Status: CLIPBOARD_EMPTY})
+}
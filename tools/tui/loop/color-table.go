@@ -0,0 +1,136 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var _ = fmt.Print
+
+// Parse an X11 color specification of the form rgb:r/g/b with one to four hex
+// digits per component
+func parse_x11_rgb(spec string) (c Color, ok bool) {
+	components, found := strings.CutPrefix(spec, "rgb:")
+	if !found {
+		return
+	}
+	parts := strings.Split(components, "/")
+	if len(parts) != 3 {
+		return
+	}
+	var vals [3]uint8
+	for i, p := range parts {
+		if len(p) < 1 || len(p) > 4 {
+			return
+		}
+		n, err := strconv.ParseUint(p, 16, 16)
+		if err != nil {
+			return
+		}
+		// scale to 8 bits
+		limit := uint64(1)<<(4*len(p)) - 1
+		vals[i] = uint8((n*255 + limit/2) / limit)
+	}
+	return Color{Type: COLOR_RGB, Red: vals[0], Green: vals[1], Blue: vals[2]}, true
+}
+
+// Parse a response to an OSC 21 query of the form
+// 21;index=rgb:r/g/b;index=...  Non-numeric keys, such as foreground, and
+// entries without a color are ignored.
+func parse_color_table_response(raw []byte) (ans map[int]Color, ok bool) {
+	rest, found := bytes.CutPrefix(raw, []byte("21;"))
+	if !found {
+		return nil, false
+	}
+	ans = make(map[int]Color)
+	for _, entry := range strings.Split(string(rest), ";") {
+		key, val, _ := strings.Cut(entry, "=")
+		idx, err := strconv.Atoi(key)
+		if err != nil || idx < 0 || idx > 255 {
+			continue
+		}
+		if c, ok := parse_x11_rgb(val); ok {
+			ans[idx] = c
+		}
+	}
+	return ans, true
+}
+
+// Parse a response to an OSC 4 query of the form 4;index;rgb:r/g/b
+func parse_osc4_response(raw []byte) (idx int, c Color, ok bool) {
+	parts := strings.Split(string(raw), ";")
+	if len(parts) != 3 || parts[0] != "4" {
+		return
+	}
+	idx, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return
+	}
+	c, ok = parse_x11_rgb(parts[2])
+	return
+}
+
+// kitty supports querying multiple colors at once with OSC 21 since 0.31
+func (self *Loop) supports_color_table_queries() bool {
+	v, found := strings.CutPrefix(self.terminal_version(), "kitty(")
+	if !found {
+		return false
+	}
+	parts := strings.SplitN(strings.TrimSuffix(v, ")"), ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, err1 := strconv.Atoi(parts[0])
+	minor, err2 := strconv.Atoi(parts[1])
+	return err1 == nil && err2 == nil && (major > 0 || minor >= 31)
+}
+
+// Query the terminal for the colors at the specified indices of its 256
+// color palette. The colors are reported via OnColorTableResponse once the
+// query completes. Uses a single OSC 21 query on terminals that support it,
+// such as kitty, falling back to an OSC 4 query per index. Colors the terminal
+// does not report, for example, because the query timed out, are omitted.
+func (self *Loop) QueryColorTable(indices []int) {
+	ans := make(map[int]Color, len(indices))
+	if self.supports_color_table_queries() {
+		var q strings.Builder
+		q.WriteString("\x1b]21")
+		for _, idx := range indices {
+			fmt.Fprintf(&q, ";%d=?", idx)
+		}
+		q.WriteString("\x1b\\")
+		_, _ = self.query_terminal(q.String(), default_query_timeout, func(etype EscapeCodeType, raw []byte) bool {
+			if etype != OSC {
+				return false
+			}
+			colors, ok := parse_color_table_response(raw)
+			for k, v := range colors {
+				ans[k] = v
+			}
+			return ok
+		})
+	} else {
+		for _, idx := range indices {
+			found, err := self.query_terminal(fmt.Sprintf("\x1b]4;%d;?\x1b\\", idx), default_query_timeout, func(etype EscapeCodeType, raw []byte) bool {
+				if etype != OSC {
+					return false
+				}
+				q, c, ok := parse_osc4_response(raw)
+				if ok && q == idx {
+					ans[q] = c
+				}
+				return ok && q == idx
+			})
+			if !found && err != nil {
+				break
+			}
+		}
+	}
+	if self.OnColorTableResponse != nil {
+		self.query.deferred_input = append(self.query.deferred_input, func() error { return self.OnColorTableResponse(ans) })
+	}
+}
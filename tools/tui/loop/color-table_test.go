@@ -0,0 +1,40 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestColorTableResponses(t *testing.T) {
+	for spec, expected := range map[string]string{
+		"rgb:ff/00/80":       "#ff0080",
+		"rgb:ffff/0000/8080": "#ff0080",
+		"rgb:f/0/8":          "#ff0088",
+		"rgb:ff/00":          "default",
+		"#ff0000":            "default",
+	} {
+		c, _ := parse_x11_rgb(spec)
+		if c.String() != expected {
+			t.Fatalf("Failed to parse %#v: %s", spec, c)
+		}
+	}
+	colors, ok := parse_color_table_response([]byte("21;0=rgb:ff/00/00;foreground=rgb:00/00/00;7=;255=rgb:01/02/03"))
+	if !ok {
+		t.Fatalf("Failed to parse OSC 21 response")
+	}
+	if diff := cmp.Diff(map[int]Color{0: {Type: COLOR_RGB, Red: 255}, 255: {Type: COLOR_RGB, Red: 1, Green: 2, Blue: 3}}, colors); diff != "" {
+		t.Fatalf("Unexpected colors:\n%s", diff)
+	}
+	if _, ok := parse_color_table_response([]byte("4;1;rgb:ff/00/00")); ok {
+		t.Fatalf("OSC 4 response parsed as OSC 21")
+	}
+	if idx, c, ok := parse_osc4_response([]byte("4;12;rgb:0000/ffff/0000")); !ok || idx != 12 || c.String() != "#00ff00" {
+		t.Fatalf("Failed to parse OSC 4 response: %d %s", idx, c)
+	}
+}
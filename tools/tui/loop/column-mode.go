@@ -0,0 +1,69 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+)
+
+var _ = fmt.Print
+
+type column_mode_state struct {
+	initial, wide bool
+}
+
+// Switch the terminal between 80 and 132 column mode, using DECCOLM. This
+// clears the screen, resets the scroll region, moves the cursor to the top left
+// corner and typically resizes the terminal, after which the screen size is
+// re-queried and OnResize is called. Does nothing if the terminal does not
+// support switching, which is common as it is often turned off by default,
+// for example, by DECNCSM or, in xterm, the allowColumnMode setting. The mode
+// the terminal was in is restored when the loop exits or is suspended.
+func (self *Loop) SetColumnMode(wide bool) {
+	if self.modes.column == nil {
+		state, ok := self.QueryMode(DECCOLM)
+		if !ok || (state != MODE_SET && state != MODE_RESET) {
+			return
+		}
+		self.modes.column = &column_mode_state{initial: state.IsSet(), wide: state.IsSet()}
+	}
+	if self.modes.column.wide == wide {
+		return
+	}
+	self.modes.column.wide = wide
+	if wide {
+		self.QueueWriteString(DECCOLM.EscapeCodeToSet())
+	} else {
+		self.QueueWriteString(DECCOLM.EscapeCodeToReset())
+	}
+	self.modes.scroll_region_top, self.modes.scroll_region_bottom = 0, 0
+	self.cursors.current.x, self.cursors.current.y = 0, 0
+	// wait for the terminal to process the change before re-querying the size
+	_, _ = self.query_terminal("", default_query_timeout, func(EscapeCodeType, []byte) bool { return false })
+	self.query.deferred_input = append(self.query.deferred_input, self.on_SIGWINCH)
+}
+
+// Whether the terminal is in 132 column mode, as set by SetColumnMode()
+func (self *Loop) ColumnMode() (wide bool) {
+	return self.modes.column != nil && self.modes.column.wide
+}
+
+func (self *Loop) column_mode_setup_codes() string {
+	if c := self.modes.column; c != nil && c.wide != c.initial {
+		if c.wide {
+			return DECCOLM.EscapeCodeToSet()
+		}
+		return DECCOLM.EscapeCodeToReset()
+	}
+	return ""
+}
+
+func (self *Loop) column_mode_teardown_codes() string {
+	if c := self.modes.column; c != nil && c.wide != c.initial {
+		if c.initial {
+			return DECCOLM.EscapeCodeToSet()
+		}
+		return DECCOLM.EscapeCodeToReset()
+	}
+	return ""
+}
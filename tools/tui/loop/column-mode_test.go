@@ -0,0 +1,54 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestColumnMode(t *testing.T) {
+	lp := new_test_loop()
+	var decrpm []string
+	lp.answer_queries(func(string) []string { return decrpm })
+	output := lp.normalized_output
+
+	// permanently reset, as when the terminal does not allow switching
+	decrpm = []string{"\x1b[?3;4$y"}
+	lp.SetColumnMode(true)
+	if lp.ColumnMode() || strings.Contains(output(), "<set DECCOLM>") || len(lp.query.deferred_input) > 0 {
+		t.Fatalf("Column mode changed though it is not supported")
+	}
+
+	decrpm = []string{"\x1b[?3;2$y"}
+	lp.SetScrollRegion(2, 10)
+	lp.SetColumnMode(true)
+	if out := output(); !strings.Contains(out, "<set DECCOLM>") {
+		t.Fatalf("Column mode not set: %s", out)
+	}
+	if !lp.ColumnMode() || len(lp.query.deferred_input) != 1 {
+		t.Fatalf("Column mode change not tracked or resize not scheduled")
+	}
+	if top, _ := lp.ScrollRegion(); top != 0 {
+		t.Fatalf("Scroll region not reset by column mode change")
+	}
+	lp.queue_teardown_sequence()
+	if out := output(); !strings.HasPrefix(out, "<reset DECCOLM>") {
+		t.Fatalf("Column mode not restored on teardown: %s", out)
+	}
+	lp.queue_setup_sequence()
+	if out := output(); !strings.HasPrefix(out, "<set DECCOLM>") {
+		t.Fatalf("Column mode not restored on resume: %s", out)
+	}
+	lp.SetColumnMode(false)
+	if out := output(); !strings.Contains(out, "<reset DECCOLM>") || lp.ColumnMode() {
+		t.Fatalf("Column mode not reset: %s", out)
+	}
+	lp.queue_teardown_sequence()
+	if out := output(); strings.Contains(out, "DECCOLM") {
+		t.Fatalf("Column mode changed on teardown though it is unchanged: %s", out)
+	}
+}
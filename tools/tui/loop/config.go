@@ -0,0 +1,89 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var _ = fmt.Print
+
+// A snapshot of the configuration of a loop, useful for debugging
+type LoopConfig struct {
+	Running, DumbTerminal          bool
+	AlternateScreen, RestoreColors bool
+	MouseTracking                  MouseTracking
+	KeyboardMode                   KeyboardStateBits
+	NoEchoDetection                bool
+	PasteNewlines                  PasteNewlines
+	PasteDelivery                  PasteDelivery
+	NormalizePastedText            bool
+	PasteFallbackEncoding          string
+	MaxPasteBuffer                 int
+	OversizedPasteAction           OversizedPasteAction
+	DispatchRepliesDuringPaste     bool
+	FocusTracking, FlowControl     bool
+	OriginMode                     bool
+	ScrollRegion                   [2]int
+	ExitCleanup                    bool
+	HideCursorDuringUpdates        bool
+	EpilogueOnDeathSignal          bool
+	ResizeDebounce                 time.Duration
+	PixelResizeDebounce            time.Duration
+	InteractiveBoost               time.Duration
+	MaxFPS                         int
+	WriteBacklogHigh               int
+	WriteBacklogLow                int
+	QueryTimeout                   time.Duration
+	NumTimers, NumWatchedFDs       int
+	// The names of the callbacks that are set, such as OnKeyEvent
+	CallbacksSet []string
+}
+
+// Return a snapshot of the current configuration of the loop
+func (self *Loop) Config() LoopConfig {
+	ans := LoopConfig{
+		Running: self.running, DumbTerminal: self.is_dumb_terminal,
+		AlternateScreen: self.terminal_options.alternate_screen, RestoreColors: self.terminal_options.restore_colors,
+		MouseTracking: self.terminal_options.mouse_tracking, KeyboardMode: self.terminal_options.kitty_keyboard_mode,
+		NoEchoDetection: self.query.no_echo_detection, PasteNewlines: self.paste.newlines, PasteDelivery: self.paste.delivery, MaxPasteBuffer: self.max_paste_buffer_size(), OriginMode: self.modes.origin, ScrollRegion: [2]int{self.modes.scroll_region_top, self.modes.scroll_region_bottom}, ExitCleanup: self.exit_cleanup_requested,
+		NormalizePastedText: self.paste.normalize_text, PasteFallbackEncoding: self.paste.fallback_encoding,
+		OversizedPasteAction: self.paste.oversized_action, DispatchRepliesDuringPaste: self.paste.dispatch_replies,
+		FocusTracking: self.terminal_options.focus_tracking, FlowControl: self.terminal_options.flow_control,
+		HideCursorDuringUpdates: self.cursors.hide_during_updates, EpilogueOnDeathSignal: self.epilogue_on_death_signal,
+		ResizeDebounce: self.resize.debounce, PixelResizeDebounce: self.resize.pixel_debounce, InteractiveBoost: self.interactive.boost,
+		MaxFPS: self.render.max_fps, WriteBacklogHigh: self.backlog.high, WriteBacklogLow: self.backlog.low,
+		QueryTimeout: default_query_timeout, NumTimers: len(self.timers),
+	}
+	if ans.WriteBacklogHigh <= 0 {
+		ans.WriteBacklogHigh = default_write_backlog_high
+	}
+	if ans.WriteBacklogLow <= 0 {
+		ans.WriteBacklogLow = default_write_backlog_low
+	}
+	if self.fd_watcher != nil {
+		ans.NumWatchedFDs = len(self.fd_watcher.watches)
+	}
+	v := reflect.ValueOf(self).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.IsExported() && f.Type.Kind() == reflect.Func && strings.HasPrefix(f.Name, "On") && !v.Field(i).IsNil() {
+			ans.CallbacksSet = append(ans.CallbacksSet, f.Name)
+		}
+	}
+	return ans
+}
+
+// One field per line, suitable for including in bug reports
+func (self LoopConfig) String() string {
+	var sb strings.Builder
+	v, t := reflect.ValueOf(self), reflect.TypeOf(self)
+	for i := 0; i < t.NumField(); i++ {
+		fmt.Fprintf(&sb, "%s: %v\n", t.Field(i).Name, v.Field(i).Interface())
+	}
+	return sb.String()
+}
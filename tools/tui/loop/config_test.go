@@ -0,0 +1,64 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestLoopConfig(t *testing.T) {
+	lp := new_test_loop(NoAlternateScreen, NoEchoDetection, HideCursorDuringUpdates, InstallExitCleanup)
+	lp.SetMaxFPS(30)
+	lp.SetWriteBacklogLimits(100, 0)
+	lp.OnKeyEvent = func(*KeyEvent) error { return nil }
+	lp.OnInitialize = func() (string, error) { return "", nil }
+	if err := lp.NormalizePastedText("latin1"); err != nil {
+		t.Fatal(err)
+	}
+	lp.SetOversizedPasteAction(ABORT_OVERSIZED_PASTE).DispatchRepliesDuringPaste().FocusTracking().EpilogueOnDeathSignal()
+	lp.SetFlowControl(true)
+	lp.SetResizeDebounce(time.Second)
+	lp.SetPixelResizeDebounce(2 * time.Second)
+	lp.SetInteractiveBoost(time.Millisecond)
+	c := lp.Config()
+	if c.Running || c.AlternateScreen || !c.RestoreColors || !c.NoEchoDetection || !c.HideCursorDuringUpdates || !c.ExitCleanup {
+		t.Fatalf("Unexpected config: %s", c)
+	}
+	if c.MaxFPS != 30 || c.WriteBacklogHigh != 100 || c.WriteBacklogLow != default_write_backlog_low || c.QueryTimeout != default_query_timeout {
+		t.Fatalf("Unexpected config: %s", c)
+	}
+	if !c.NormalizePastedText || c.PasteFallbackEncoding != "iso-8859-1" || c.OversizedPasteAction != ABORT_OVERSIZED_PASTE || !c.DispatchRepliesDuringPaste {
+		t.Fatalf("Unexpected paste config: %s", c)
+	}
+	if !c.FocusTracking || !c.FlowControl || !c.EpilogueOnDeathSignal || c.ResizeDebounce != time.Second || c.PixelResizeDebounce != 2*time.Second || c.InteractiveBoost != time.Millisecond {
+		t.Fatalf("Unexpected config: %s", c)
+	}
+	if diff := cmp.Diff([]string{"OnInitialize", "OnKeyEvent"}, c.CallbacksSet); diff != "" {
+		t.Fatalf("Unexpected callbacks:\n%s", diff)
+	}
+
+	lp.timers, lp.running = make([]*timer, 0, 1), true
+	if _, err := lp.AddTimer(time.Hour, false, func(IdType) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if c = lp.Config(); !c.Running || c.NumTimers != 1 || c.NumWatchedFDs != 0 {
+		t.Fatalf("Unexpected config of a running loop: %s", c)
+	}
+	s := c.String()
+	for _, line := range []string{"Running: true\n", "MaxFPS: 30\n", "CallbacksSet: [OnInitialize OnKeyEvent]\n"} {
+		if !strings.Contains(s, line) {
+			t.Fatalf("%#v not in config:\n%s", line, s)
+		}
+	}
+	if n := strings.Count(s, "\n"); n != reflect.TypeOf(c).NumField() {
+		t.Fatalf("Config is not one field per line:\n%s", s)
+	}
+}
@@ -0,0 +1,128 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var _ = fmt.Print
+
+var ErrQueryNotAnswered = errors.New("The terminal did not answer the query")
+
+// The shape and color of the cursor as last set or queried via the loop
+type cursor_style struct {
+	shape       CursorShapes
+	blink       bool
+	shape_known bool
+	color       Color
+	color_known bool
+}
+
+// Parse the DECSCUSR parameter, zero means the terminal default, a blinking
+// block
+func parse_decscusr(val string) (shape CursorShapes, blink bool, ok bool) {
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 0 || n > 6 {
+		return
+	}
+	if n == 0 {
+		return BLOCK_CURSOR, true, true
+	}
+	// odd values blink, the following even value is the steady version
+	return CursorShapes(n - (n+1)%2), n%2 == 1, true
+}
+
+// Query the terminal for the shape of the cursor and whether it blinks,
+// using DECRQSS for DECSCUSR. Useful to save the user's cursor style before
+// changing it with SetCursorShape(). Blocks till the terminal answers,
+// buffering other input received in the meantime. Returns
+// ErrQueryNotAnswered if the terminal does not support the query.
+func (self *Loop) QueryCursorStyle() (shape CursorShapes, blink bool, err error) {
+	ok := false
+	found, err := self.query_terminal("\x1bP$q q\x1b\\", default_query_timeout, func(etype EscapeCodeType, raw []byte) bool {
+		if etype != DCS || len(raw) < 3 || string(raw[1:3]) != "$r" {
+			return false
+		}
+		if raw[0] == '1' {
+			if val, is_decscusr := strings.CutSuffix(string(raw[3:]), " q"); is_decscusr {
+				shape, blink, ok = parse_decscusr(val)
+			}
+		}
+		return true
+	})
+	if !ok {
+		if err == nil || found {
+			err = ErrQueryNotAnswered
+		}
+		return
+	}
+	self.cursors.current.style.shape, self.cursors.current.style.blink, self.cursors.current.style.shape_known = shape, blink, true
+	return shape, blink, nil
+}
+
+// Query the terminal for the color of the cursor using OSC 12. A color of
+// type COLOR_DEFAULT means the cursor has no color of its own, for example,
+// in kitty, when it is drawn using the color of the text under it. Blocks
+// till the terminal answers, buffering other input received in the meantime.
+// Returns ErrQueryNotAnswered if the terminal does not support the query.
+func (self *Loop) QueryCursorColor() (c Color, err error) {
+	ok := false
+	found, err := self.query_terminal("\x1b]12;?\x1b\\", default_query_timeout, func(etype EscapeCodeType, raw []byte) bool {
+		if etype != OSC {
+			return false
+		}
+		val, is_cursor_color := strings.CutPrefix(string(raw), "12;")
+		if !is_cursor_color {
+			return false
+		}
+		ok = true
+		c, _ = parse_x11_rgb(val)
+		return true
+	})
+	if !ok {
+		if err == nil || found {
+			err = ErrQueryNotAnswered
+		}
+		return
+	}
+	self.cursors.current.style.color, self.cursors.current.style.color_known = c, true
+	return c, nil
+}
+
+func cursor_color_escape_code(c Color) string {
+	if c.Type != COLOR_RGB {
+		return "\x1b]112\x1b\\"
+	}
+	return fmt.Sprintf("\x1b]12;rgb:%02x/%02x/%02x\x1b\\", c.Red, c.Green, c.Blue)
+}
+
+// Set the color of the cursor. Only RGB colors are supported, any other type
+// of color resets the cursor to the terminal's default color.
+func (self *Loop) SetCursorColor(c Color) {
+	if c.Type != COLOR_RGB {
+		c = Color{}
+	}
+	self.queue_tracked_write(cursor_color_escape_code(c))
+	self.cursors.current.style.color, self.cursors.current.style.color_known = c, true
+}
+
+// Change the cursor shape and color back to what they were, those not known
+// are reset to the terminal defaults
+func (self *Loop) restore_cursor_style(s cursor_style) {
+	cur := &self.cursors.current.style
+	if s.shape_known != cur.shape_known || s.shape != cur.shape || s.blink != cur.blink {
+		if s.shape_known {
+			self.queue_tracked_write(CursorShape(s.shape, s.blink))
+		} else {
+			self.queue_tracked_write("\x1b[0 q")
+		}
+	}
+	if s.color_known != cur.color_known || s.color != cur.color {
+		self.queue_tracked_write(cursor_color_escape_code(s.color))
+	}
+	*cur = s
+}
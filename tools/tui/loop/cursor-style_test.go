@@ -0,0 +1,70 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestCursorStyle(t *testing.T) {
+	for val, expected := range map[string]struct {
+		shape CursorShapes
+		blink bool
+	}{"0": {BLOCK_CURSOR, true}, "1": {BLOCK_CURSOR, true}, "2": {BLOCK_CURSOR, false}, "4": {UNDERLINE_CURSOR, false}, "5": {BAR_CURSOR, true}, "6": {BAR_CURSOR, false}} {
+		shape, blink, ok := parse_decscusr(val)
+		if !ok || shape != expected.shape || blink != expected.blink {
+			t.Fatalf("Failed to parse DECSCUSR %s: %v %v %v", val, shape, blink, ok)
+		}
+	}
+
+	lp := new_test_loop()
+	var responses []string
+	lp.answer_queries(func(string) []string { return responses })
+	output := func() string { return lp.output() }
+
+	if _, _, err := lp.QueryCursorStyle(); err != ErrQueryNotAnswered {
+		t.Fatalf("Unanswered cursor style query did not fail: %v", err)
+	}
+	if _, err := lp.QueryCursorColor(); err != ErrQueryNotAnswered {
+		t.Fatalf("Unanswered cursor color query did not fail: %v", err)
+	}
+
+	responses = []string{"\x1bP1$r4 q\x1b\\", "\x1b]12;rgb:ffff/8080/0000\x1b\\"}
+	shape, blink, err := lp.QueryCursorStyle()
+	if err != nil || shape != UNDERLINE_CURSOR || blink {
+		t.Fatalf("Unexpected cursor style: %v %v %v", shape, blink, err)
+	}
+	c, err := lp.QueryCursorColor()
+	if err != nil || c.String() != "#ff8000" {
+		t.Fatalf("Unexpected cursor color: %s %v", c, err)
+	}
+	output()
+
+	lp.SaveCursor()
+	lp.SetCursorShape(BAR_CURSOR, true)
+	lp.SetCursorColor(Color{Type: COLOR_RGB, Red: 1, Green: 2, Blue: 3})
+	lp.SaveCursor()
+	lp.SetCursorColor(Color{})
+	output()
+	lp.RestoreCursor()
+	if out := output(); strings.Contains(out, " q") || !strings.Contains(out, "\x1b]12;rgb:01/02/03\x1b\\") {
+		t.Fatalf("Nested cursor style not restored: %q", out)
+	}
+	lp.RestoreCursor()
+	if out := output(); !strings.Contains(out, "\x1b[4 q") || !strings.Contains(out, "\x1b]12;rgb:ff/80/00\x1b\\") {
+		t.Fatalf("Queried cursor style not restored: %q", out)
+	}
+
+	lp = new_test_loop()
+	lp.SaveCursor()
+	lp.SetCursorShape(BAR_CURSOR, false)
+	output()
+	lp.RestoreCursor()
+	if out := output(); !strings.Contains(out, "\x1b[0 q") || strings.Contains(out, "]1") {
+		t.Fatalf("Unknown cursor style not restored to default: %q", out)
+	}
+}
@@ -0,0 +1,112 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+var _ = fmt.Print
+
+type cursor_state struct {
+	current                     logical_cursor
+	stack                       []saved_cursor
+	hide_during_updates, hidden bool
+	hide_depth                  int
+}
+
+type logical_cursor struct {
+	// 1-based, zero means the position is not known
+	x, y int
+	sgr  string
+	// false when the attributes may have been changed other than by
+	// SetSGR(), for instance, by escape codes in written text
+	sgr_known bool
+	style     cursor_style
+}
+
+type saved_cursor struct {
+	logical_cursor
+	uses_hardware_slot bool
+}
+
+// Set the SGR attributes for subsequently written text. sgr is the list of
+// semi-colon separated SGR parameters, an empty string resets all attributes.
+// Attributes set this way are tracked by the loop and restored by
+// RestoreCursor().
+func (self *Loop) SetSGR(sgr string) {
+	self.queue_tracked_write("\x1b[" + sgr + "m")
+	self.cursors.current.sgr = collapse_sgr(self.cursors.current.sgr, sgr)
+	self.cursors.current.sgr_known = true
+}
+
+// The SGR parameters for the attributes that result from applying sgr after
+// current, with each attribute appearing only once, so that setting
+// attributes repeatedly does not make them grow without limit. Parameters
+// whose effect is not known are kept, after the others.
+func collapse_sgr(current, sgr string) string {
+	var pen sgr_pen
+	var unknown []string
+	for _, q := range []string{current, sgr} {
+		params := strings.Split(q, ";")
+		for i := 0; i < len(params); i++ {
+			p := params[i]
+			if p == "38" || p == "48" || p == "58" {
+				_, consumed := parse_extended_color(params[i+1:])
+				p = strings.Join(params[i:i+1+consumed], ";")
+				i += consumed
+			}
+			known := true
+			pen.apply(p, &known)
+			if p == "" || p == "0" {
+				unknown = unknown[:0]
+			} else if !known && !slices.Contains(unknown, p) {
+				unknown = append(unknown, p)
+			}
+		}
+	}
+	return strings.Join(append(pen.params(), unknown...), ";")
+}
+
+// Save the cursor position, the attributes set with SetSGR() and the cursor
+// shape and color on a stack, to be restored by RestoreCursor(). Unlike SaveCursorPosition(), which uses
+// the single slot the terminal has, calls to this can be nested. It relies on
+// the loop knowing the cursor position and attributes, which it does after
+// the cursor is positioned via the loop's APIs, such as MoveCursorTo(), and
+// the attributes are set with SetSGR(), till text or raw escape codes are
+// written. If either is not known, the terminal's slot is used instead, so
+// nested saves made while they are not known overwrite each other.
+func (self *Loop) SaveCursor() {
+	s := saved_cursor{logical_cursor: self.cursors.current}
+	if s.x < 1 || s.y < 1 || !s.sgr_known {
+		s.uses_hardware_slot = true
+		self.queue_tracked_write("\x1b7")
+	}
+	self.cursors.stack = append(self.cursors.stack, s)
+}
+
+// Restore the cursor position, attributes, shape and color saved by the
+// matching call to SaveCursor(). A shape or color that was neither set nor
+// queried, see QueryCursorStyle() and QueryCursorColor(), before the save
+// is restored to the terminal default. Does nothing if there is nothing
+// saved.
+func (self *Loop) RestoreCursor() {
+	if len(self.cursors.stack) == 0 {
+		return
+	}
+	s := self.cursors.stack[len(self.cursors.stack)-1]
+	self.cursors.stack = self.cursors.stack[:len(self.cursors.stack)-1]
+	if s.uses_hardware_slot {
+		// the terminal restores the attributes along with the position
+		self.queue_tracked_write("\x1b8")
+		self.cursors.current.x, self.cursors.current.y = s.x, s.y
+	} else {
+		self.MoveCursorTo(s.x, s.y)
+		self.queue_tracked_write("\x1b[" + s.sgr + "m")
+	}
+	self.cursors.current.sgr, self.cursors.current.sgr_known = s.sgr, s.sgr_known
+	self.restore_cursor_style(s.style)
+}
@@ -0,0 +1,106 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestCursorMovement(t *testing.T) {
+	lp := new_test_loop()
+	lp.set_screen_size(20, 10)
+	test := func(f func(), expected string, x, y int) {
+		t.Helper()
+		lp.output()
+		f()
+		if diff := cmp.Diff(expected, lp.normalized_output()); diff != "" {
+			t.Fatalf("Unexpected output:\n%s", diff)
+		}
+		if lp.cursors.current.x != x || lp.cursors.current.y != y {
+			t.Fatalf("Tracked cursor position %d,%d != %d,%d", lp.cursors.current.x, lp.cursors.current.y, x, y)
+		}
+	}
+	test(func() { lp.MoveToColumn(5) }, "<CSI 5G>", 5, 0)
+	test(func() { lp.MoveToRow(3) }, "<CSI 3d>", 5, 3)
+	test(func() { lp.MoveToColumn(100) }, "<CSI 20G>", 20, 3)
+	test(func() { lp.MoveToRow(0) }, "<CSI 1d>", 20, 1)
+	test(func() { lp.MoveBy(-4, 2) }, "<down 2><left 4>", 16, 3)
+	test(func() { lp.MoveBy(10, -10) }, "<up 2><right 4>", 20, 1)
+	test(func() { lp.MoveBy(0, 0) }, "", 20, 1)
+
+	// rows are relative to the scroll region in origin mode
+	lp.SetScrollRegion(4, 8)
+	lp.SetOriginMode(true)
+	test(func() { lp.MoveToRow(6) }, "<CSI 3d>", lp.cursors.current.x, 6)
+}
+
+func TestSaveCursor(t *testing.T) {
+	lp := new_test_loop()
+	lp.set_screen_size(20, 10)
+	test := func(f func(), expected string) {
+		t.Helper()
+		lp.output()
+		f()
+		if diff := cmp.Diff(expected, lp.normalized_output()); diff != "" {
+			t.Fatalf("Unexpected output:\n%s", diff)
+		}
+	}
+	// a known position is restored by moving the cursor, nested saves work
+	lp.MoveCursorTo(3, 4)
+	lp.SetSGR("1")
+	test(lp.SaveCursor, "")
+	test(func() { lp.MoveCursorTo(1, 1); lp.SaveCursor(); lp.SetSGR("31") }, "<move 1,1><SGR 31>")
+	test(lp.RestoreCursor, "<move 1,1><SGR 1>")
+	test(lp.RestoreCursor, "<move 4,3><SGR 1>")
+	// the position is not known after writing text, so the terminal's slot is used
+	lp.QueueWriteString("abc")
+	test(lp.SaveCursor, "<ESC 7>")
+	test(func() { lp.MoveCursorTo(5, 5); lp.RestoreCursor() }, "<move 5,5><ESC 8>")
+	// nor is it after restoring from the terminal's slot
+	if lp.cursors.current.x != 0 || lp.cursors.current.y != 0 {
+		t.Fatalf("Cursor position known after restoring from the terminal: %d,%d", lp.cursors.current.x, lp.cursors.current.y)
+	}
+	test(func() { lp.SaveCursor(); lp.RestoreCursor() }, "<ESC 7><ESC 8>")
+
+	// attributes not set with SetSGR() are restored by the terminal, they
+	// are not reset by restoring the cursor
+	lp.MoveCursorTo(3, 4)
+	lp.QueueWriteString("\x1b[31m")
+	lp.MoveCursorTo(3, 4)
+	test(func() { lp.SaveCursor(); lp.MoveCursorTo(1, 1); lp.SetSGR(""); lp.RestoreCursor() }, "<ESC 7><move 1,1><SGR 0><ESC 8>")
+	if lp.cursors.current.x != 3 || lp.cursors.current.y != 4 || lp.cursors.current.sgr_known {
+		t.Fatalf("Unexpected cursor state after restoring from the terminal: %+v", lp.cursors.current)
+	}
+	// attributes set with SetSGR() survive saving and restoring
+	lp.SetSGR("1;31")
+	test(func() { lp.SaveCursor(); lp.SetSGR("0;32"); lp.RestoreCursor() }, "<SGR 0;32><move 4,3><SGR 1;31>")
+	if lp.cursors.current.sgr != "1;31" || !lp.cursors.current.sgr_known {
+		t.Fatalf("Attributes not restored: %+v", lp.cursors.current)
+	}
+}
+
+func TestCollapseSGR(t *testing.T) {
+	sgr := ""
+	for i := 0; i < 100; i++ {
+		sgr = collapse_sgr(sgr, "1;31")
+		sgr = collapse_sgr(sgr, "22;4:3;38;5;200;73")
+	}
+	if sgr != "4:3;38;5;200;73" {
+		t.Fatalf("SGR not collapsed: %#v", sgr)
+	}
+	for _, x := range [][3]string{
+		{"1;31", "0;32", "32"},
+		{"1", "", ""},
+		{"2;48;2;1;2;3", "41;92", "2;92;41"},
+		{"58:2::1:2:3", "9", "9;58;2;1;2;3"},
+	} {
+		if actual := collapse_sgr(x[0], x[1]); actual != x[2] {
+			t.Fatalf("collapse_sgr(%#v, %#v) = %#v != %#v", x[0], x[1], actual, x[2])
+		}
+	}
+}
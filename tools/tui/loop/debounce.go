@@ -0,0 +1,90 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"time"
+)
+
+var _ = fmt.Print
+
+type rate_limited_call struct {
+	timer_id IdType
+	pending  func() error
+}
+
+// Call f after delay has elapsed without any further calls to Debounce() with
+// the same key. Each call replaces the previously pending function for the key
+// and restarts the delay. f is called on the main goroutine. Must be called
+// while the loop is running, calls before that are ignored.
+func (self *Loop) Debounce(key string, delay time.Duration, f func() error) {
+	if self.timers == nil {
+		return
+	}
+	if self.debounced == nil {
+		self.debounced = make(map[string]*rate_limited_call)
+	}
+	c := self.debounced[key]
+	if c == nil {
+		c = &rate_limited_call{}
+		self.debounced[key] = c
+	} else {
+		self.remove_timer(c.timer_id)
+	}
+	c.pending = f
+	c.timer_id, _ = self.add_timer(delay, false, func(IdType) error {
+		delete(self.debounced, key)
+		return c.pending()
+	})
+}
+
+// Call f at most once every interval for the given key. The first call runs as
+// soon as possible, further calls made before it runs or during the interval
+// are coalesced and only the last of them is called when the interval ends. f
+// is called on the main goroutine. Must be called while the loop is running,
+// calls before that are ignored.
+func (self *Loop) Throttle(key string, interval time.Duration, f func() error) {
+	if self.timers == nil {
+		return
+	}
+	if self.throttled == nil {
+		self.throttled = make(map[string]*rate_limited_call)
+	}
+	c := self.throttled[key]
+	if c != nil {
+		c.pending = f
+		return
+	}
+	c = &rate_limited_call{pending: f}
+	self.throttled[key] = c
+	var fire TimerCallback
+	fire = func(IdType) error {
+		if c.pending == nil {
+			delete(self.throttled, key)
+			return nil
+		}
+		f := c.pending
+		c.pending = nil
+		c.timer_id, _ = self.add_timer(interval, false, fire)
+		return f()
+	}
+	c.timer_id, _ = self.add_timer(0, false, fire)
+}
+
+// Cancel the pending call for key made with Debounce(), if any
+func (self *Loop) cancel_debounce(key string) {
+	if c := self.debounced[key]; c != nil {
+		self.remove_timer(c.timer_id)
+		delete(self.debounced, key)
+	}
+}
+
+func (self *Loop) cancel_rate_limited_calls() {
+	for _, m := range []map[string]*rate_limited_call{self.debounced, self.throttled} {
+		for _, c := range m {
+			self.remove_timer(c.timer_id)
+		}
+	}
+	self.debounced, self.throttled = nil, nil
+}
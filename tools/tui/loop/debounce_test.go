@@ -0,0 +1,66 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var _ = fmt.Print
+
+func TestDebounceAndThrottle(t *testing.T) {
+	lp, _ := New()
+	lp.timers = make([]*timer, 0, 1)
+	now := time.Now()
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		if err := lp.dispatch_timers(now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var calls []string
+	record := func(x string) func() error {
+		return func() error { calls = append(calls, x); return nil }
+	}
+	check := func(expected ...string) {
+		t.Helper()
+		if fmt.Sprint(calls) != fmt.Sprint(expected) {
+			t.Fatalf("Expected calls: %v got: %v", expected, calls)
+		}
+		calls = nil
+	}
+
+	lp.Debounce("d", time.Second, record("1"))
+	lp.Debounce("d", time.Second, record("2"))
+	advance(500 * time.Millisecond)
+	check()
+	advance(time.Second)
+	check("2")
+	advance(time.Second)
+	check()
+
+	// timers are scheduled relative to the real clock
+	now = time.Now()
+	lp.Throttle("t", time.Second, record("1"))
+	lp.Throttle("t", time.Second, record("2"))
+	lp.Throttle("t", time.Second, record("3"))
+	advance(time.Millisecond)
+	check("3")
+	lp.Throttle("t", time.Second, record("4"))
+	advance(500 * time.Millisecond)
+	check()
+	advance(time.Second)
+	check("4")
+	advance(2 * time.Second)
+	check()
+	if len(lp.throttled) != 0 || len(lp.debounced) != 0 || len(lp.timers) != 0 {
+		t.Fatalf("Rate limited calls not cleaned up")
+	}
+
+	lp.Debounce("d", time.Second, record("x"))
+	lp.cancel_rate_limited_calls()
+	advance(2 * time.Second)
+	check()
+}
@@ -0,0 +1,138 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+const default_diagnostic_buffer_size = 4096
+
+type diagnostics struct {
+	writer        io.Writer
+	input, output *utils.RingBuffer[byte]
+}
+
+// Write a diagnostic report to w when Run() returns an error, including the
+// terminal name, capabilities and configuration of the loop and the last few
+// bytes of input and output, see SetDiagnosticBufferSize(). Clipboard
+// contents and pasted text are redacted, however typed text is included. Use
+// nil to turn off the report.
+func (self *Loop) SetDiagnosticWriter(w io.Writer) {
+	if w == nil {
+		self.diagnostics = nil
+		return
+	}
+	if self.diagnostics == nil {
+		self.diagnostics = &diagnostics{}
+		self.SetDiagnosticBufferSize(default_diagnostic_buffer_size)
+	}
+	self.diagnostics.writer = w
+}
+
+// The number of bytes of input and output to include in the diagnostic
+// report, see SetDiagnosticWriter(). Defaults to 4096.
+func (self *Loop) SetDiagnosticBufferSize(size int) {
+	if self.diagnostics != nil {
+		size = utils.Max(1, size)
+		self.diagnostics.input = utils.NewRingBuffer[byte](uint64(size))
+		self.diagnostics.output = utils.NewRingBuffer[byte](uint64(size))
+	}
+}
+
+func (self *Loop) clear_diagnostics() {
+	if self.diagnostics != nil {
+		self.diagnostics.input.Clear()
+		self.diagnostics.output.Clear()
+	}
+}
+
+func (self *Loop) record_input(data []byte) {
+	if self.diagnostics != nil {
+		self.diagnostics.input.WriteAllAndDiscardOld(data...)
+	}
+}
+
+func (self *Loop) record_output(data *write_msg) {
+	if self.diagnostics != nil {
+		if data.bytes != nil {
+			self.diagnostics.output.WriteAllAndDiscardOld(data.bytes...)
+		} else {
+			self.diagnostics.output.WriteAllAndDiscardOld([]byte(data.str)...)
+		}
+	}
+}
+
+// Clipboard escape codes and the contents of bracketed pastes, the end of the
+// pattern allows for codes cut off at the end of the captured data
+var sensitive_data_pattern = (&utils.Once[*regexp.Regexp]{Run: func() *regexp.Regexp {
+	return regexp.MustCompile(`(?s)\x1b\](?:52|5522);[^\x07\x1b]*|\x1b\[200~.*?(?:\x1b\[201~|$)`)
+}}).Get
+
+func redact_sensitive_data(data []byte) []byte {
+	return sensitive_data_pattern().ReplaceAllFunc(data, func(m []byte) []byte {
+		prefix := []byte("\x1b[200~")
+		if !bytes.HasPrefix(m, prefix) {
+			prefix = m[:bytes.IndexByte(m, ';')+1]
+		}
+		suffix := []byte{}
+		if bytes.HasSuffix(m, []byte("\x1b[201~")) {
+			suffix = []byte("\x1b[201~")
+		}
+		ans := append([]byte{}, prefix...)
+		ans = append(ans, fmt.Sprintf("<redacted %d bytes>", len(m)-len(prefix)-len(suffix))...)
+		return append(ans, suffix...)
+	})
+}
+
+func (self *Loop) diagnostic_report(run_err error, stack []byte) string {
+	var sb strings.Builder
+	p := func(format string, args ...any) { fmt.Fprintf(&sb, format, args...) }
+	p("Diagnostic report for abnormal termination at %s\n", time.Now().Format(time.RFC3339))
+	p("Error: %s\n", run_err)
+	// use only cached values as the terminal can no longer be queried
+	if self.detected.terminal_version != nil && *self.detected.terminal_version != "" {
+		p("Terminal: %s\n", *self.detected.terminal_version)
+	} else {
+		p("Terminal: unknown\n")
+	}
+	if self.detected.color_count > 0 {
+		p("ColorCount: %d\n", self.detected.color_count)
+	}
+	if self.detected.scrollback != nil {
+		p("ScrollbackCapabilities: %+v\n", *self.detected.scrollback)
+	}
+	if self.query.responds != nil {
+		p("RespondsToQueries: %v\n", *self.query.responds)
+	}
+	if len(self.stripped_sequences) > 0 {
+		p("StrippedSequences: %q\n", self.stripped_sequences)
+	}
+	p("\nConfiguration:\n%s", self.Config())
+	p("\nLast input:\n%q\n", redact_sensitive_data(self.diagnostics.input.ReadAll()))
+	p("\nLast output:\n%q\n", redact_sensitive_data(self.diagnostics.output.ReadAll()))
+	if len(stack) > 0 {
+		p("\nStack trace:\n%s", stack)
+	}
+	return sb.String()
+}
+
+func (self *Loop) write_diagnostic_report(run_err error, stack []byte) {
+	if self.diagnostics == nil || run_err == nil {
+		return
+	}
+	if stack == nil {
+		stack = debug.Stack()
+	}
+	_, _ = io.WriteString(self.diagnostics.writer, self.diagnostic_report(run_err, stack))
+}
@@ -0,0 +1,45 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestDiagnosticReport(t *testing.T) {
+	for input, expected := range map[string]string{
+		"a\x1b]52;c;c2VjcmV0\x1b\\b":         "a\x1b]52;<redacted 10 bytes>\x1b\\b",
+		"\x1b]52;c;c2Vj":                     "\x1b]52;<redacted 6 bytes>",
+		"x\x1b[200~secret\x1b[201~y":         "x\x1b[200~<redacted 6 bytes>\x1b[201~y",
+		"\x1b[200~secret":                    "\x1b[200~<redacted 6 bytes>",
+		"typed\x1b[A\x1b]5522;type=read\x07": "typed\x1b[A\x1b]5522;<redacted 9 bytes>\x07",
+	} {
+		if actual := string(redact_sensitive_data([]byte(input))); actual != expected {
+			t.Fatalf("Failed to redact %#v\nexpected: %#v\nactual:   %#v", input, expected, actual)
+		}
+	}
+
+	lp := new_test_loop()
+	var sb strings.Builder
+	lp.SetDiagnosticWriter(&sb)
+	lp.SetDiagnosticBufferSize(8)
+	lp.QueueWriteString("0123456789")
+	lp.output()
+	lp.record_input([]byte("abc"))
+	lp.write_diagnostic_report(nil, nil)
+	if sb.Len() != 0 {
+		t.Fatalf("Report written without an error")
+	}
+	lp.write_diagnostic_report(errors.New("oops"), []byte("the stack"))
+	report := sb.String()
+	for _, q := range []string{"Error: oops\n", "Terminal: unknown\n", "Last input:\n\"abc\"\n", "Last output:\n\"23456789\"\n", "Stack trace:\nthe stack"} {
+		if !strings.Contains(report, q) {
+			t.Fatalf("%#v not present in report:\n%s", q, report)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+
+	"kitty/tools/utils"
+	"kitty/tools/utils/style"
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+// Loops run inside the main loop, for dialogs
+type nested_loops struct {
+	run     func(done func() bool) error
+	parsers []*wcswidth.EscapeCodeParser
+}
+
+const default_dialog_max_width = 60
+
+type DialogOptions struct {
+	Title   string
+	Message string
+	// The labels of the buttons, defaults to a single OK button
+	Buttons []string
+	// The index of the initially selected button
+	DefaultButton int
+	// The maximum width of the dialog in cells, defaults to 60
+	MaxWidth int
+	// The Screen the application draws with, if any. It is invalidated when
+	// the dialog closes, so that the dialog is drawn over on the next Flush().
+	Screen *Screen
+}
+
+type dialog struct {
+	lp               *Loop
+	opts             DialogOptions
+	selected, result int
+	done             bool
+}
+
+// Show a modal dialog centered on the screen, with a title, a message
+// wrapped to the width of the dialog and a row of buttons. Tab and the arrow
+// keys move between the buttons, Enter or Space activates the selected button
+// and Esc cancels. Returns the index of the activated button, or -1 if the
+// dialog was cancelled or the loop was quit. Blocks until the dialog is
+// closed, while the loop keeps running, with key, text and mouse events going
+// to the dialog. OnRender and OnResize are still called, so the application
+// is drawn behind the dialog, which is drawn on top after every OnRender.
+// When the dialog closes, a redraw is requested to replace it with the
+// application, so OnRender must be able to redraw everything.
+func (self *Loop) Dialog(opts DialogOptions) (int, error) {
+	if self.nested.run == nil {
+		return -1, fmt.Errorf("Cannot show a dialog before starting the run loop")
+	}
+	if len(opts.Buttons) == 0 {
+		opts.Buttons = []string{"OK"}
+	}
+	if opts.MaxWidth < 1 {
+		opts.MaxWidth = default_dialog_max_width
+	}
+	d := &dialog{lp: self, opts: opts, result: -1, selected: utils.Max(0, utils.Min(opts.DefaultButton, len(opts.Buttons)-1))}
+	on_key_event, on_text, on_mouse_event, on_render, on_resize := self.OnKeyEvent, self.OnText, self.OnMouseEvent, self.OnRender, self.OnResize
+	on_text_with_key, on_unhandled_key := self.OnTextWithKey, self.OnUnhandledKey
+	cursor_was_hidden := self.cursors.hidden
+	defer func() {
+		self.OnKeyEvent, self.OnText, self.OnMouseEvent, self.OnRender, self.OnResize = on_key_event, on_text, on_mouse_event, on_render, on_resize
+		self.OnTextWithKey, self.OnUnhandledKey = on_text_with_key, on_unhandled_key
+		if opts.Screen != nil {
+			opts.Screen.Invalidate()
+		}
+		if !cursor_was_hidden {
+			self.SetCursorVisible(true)
+		}
+		self.RequestRedraw()
+	}()
+	self.OnKeyEvent = d.on_key_event
+	self.OnText = func(string, bool, bool) error { return nil }
+	self.OnTextWithKey, self.OnUnhandledKey = nil, nil
+	self.OnMouseEvent = nil
+	self.OnRender = func() error {
+		self.StartAtomicUpdate()
+		defer self.EndAtomicUpdate()
+		if on_render != nil {
+			if err := on_render(); err != nil {
+				return err
+			}
+		}
+		d.render()
+		return nil
+	}
+	self.OnResize = func(old_size, new_size ScreenSize) (err error) {
+		// the dialog is re-centered when redrawn
+		self.RequestRedraw()
+		if on_resize != nil {
+			err = on_resize(old_size, new_size)
+		}
+		return
+	}
+	self.SetCursorVisible(false)
+	self.RequestRedraw()
+	if err := self.nested.run(func() bool { return d.done }); err != nil {
+		return -1, err
+	}
+	return d.result, nil
+}
+
+func (self *dialog) on_key_event(ev *KeyEvent) error {
+	n := len(self.opts.Buttons)
+	switch {
+	case ev.MatchesPressOrRepeat("tab") || ev.MatchesPressOrRepeat("right"):
+		self.selected = (self.selected + 1) % n
+	case ev.MatchesPressOrRepeat("shift+tab") || ev.MatchesPressOrRepeat("left"):
+		self.selected = (self.selected + n - 1) % n
+	case ev.MatchesPressOrRepeat("enter") || ev.MatchesPressOrRepeat("space"):
+		self.result, self.done = self.selected, true
+	case ev.MatchesPressOrRepeat("esc"):
+		self.result, self.done = -1, true
+	default:
+		return nil
+	}
+	ev.Handled = true
+	self.lp.RequestRedraw()
+	return nil
+}
+
+// The buttons, with labels shortened to fit in width if needed
+func (self *dialog) button_labels(width int) []string {
+	const decoration = len("[  ]")
+	n := len(self.opts.Buttons)
+	total := 2 * (n - 1)
+	for _, b := range self.opts.Buttons {
+		total += wcswidth.Stringwidth(b) + decoration
+	}
+	max_label_width := -1
+	if total > width {
+		max_label_width = utils.Max(1, (width-2*(n-1))/n-decoration)
+	}
+	ans := make([]string, n)
+	for i, b := range self.opts.Buttons {
+		if max_label_width > -1 {
+			b = wcswidth.TruncateToVisualLength(b, max_label_width)
+		}
+		ans[i] = "[ " + b + " ]"
+	}
+	return ans
+}
+
+// Lay out the dialog for a screen of the specified size, returning the
+// position of its top left corner and its lines
+func (self *dialog) layout(screen_width, screen_height int) (left, top int, lines []string) {
+	// the border and padding on either side take two cells
+	max_inner := utils.Min(self.opts.MaxWidth, screen_width) - 4
+	max_message_lines := screen_height - 4
+	if max_inner < 1 || max_message_lines < 0 {
+		return
+	}
+	message := style.WrapStyledText(self.opts.Message, max_inner)
+	if len(message) > max_message_lines {
+		message = message[:max_message_lines]
+	}
+	buttons := self.button_labels(max_inner)
+	buttons_width := 2 * (len(buttons) - 1)
+	for _, b := range buttons {
+		buttons_width += wcswidth.Stringwidth(b)
+	}
+	inner := utils.Min(max_inner, utils.Max(buttons_width, wcswidth.Stringwidth(self.opts.Title)+2))
+	for i, line := range message {
+		message[i] = strings.TrimRight(line, " ")
+		inner = utils.Max(inner, wcswidth.Stringwidth(message[i]))
+	}
+	inner = utils.Min(inner, max_inner)
+
+	title := ""
+	if self.opts.Title != "" {
+		title = wcswidth.TruncateToVisualLength(self.opts.Title, inner-2)
+		title = " " + self.lp.SprintStyled("bold", title) + " "
+	}
+	lines = append(lines, "┌─"+title+strings.Repeat("─", inner+1-wcswidth.Stringwidth(title))+"┐")
+	for _, line := range message {
+		lines = append(lines, "│ "+fit_to_width(line, inner)+" │")
+	}
+	lines = append(lines, "│ "+strings.Repeat(" ", inner)+" │")
+	var row strings.Builder
+	for i, b := range buttons {
+		if i > 0 {
+			row.WriteString("  ")
+		}
+		if i == self.selected {
+			b = self.lp.SprintStyled("reverse", b)
+		}
+		row.WriteString(b)
+	}
+	pad := utils.Max(0, inner-buttons_width)
+	lines = append(lines, "│ "+strings.Repeat(" ", pad/2)+row.String()+strings.Repeat(" ", pad-pad/2)+" │")
+	lines = append(lines, "└"+strings.Repeat("─", inner+2)+"┘")
+	left = (screen_width-(inner+4))/2 + 1
+	top = (screen_height-len(lines))/2 + 1
+	return
+}
+
+func (self *dialog) render() {
+	sz, err := self.lp.ScreenSize()
+	if err != nil {
+		return
+	}
+	left, top, lines := self.layout(int(sz.WidthCells), int(sz.HeightCells))
+	for i, line := range lines {
+		self.lp.MoveCursorTo(left, top+i)
+		self.lp.QueueWriteString(line)
+	}
+}
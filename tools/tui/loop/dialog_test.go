@@ -0,0 +1,97 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+func TestDialog(t *testing.T) {
+	lp, _ := New()
+	if _, err := lp.Dialog(DialogOptions{}); err == nil {
+		t.Fatalf("Dialog shown without a running loop")
+	}
+	lp.style_ctx.AllowEscapeCodes = false
+	d := &dialog{lp: lp, opts: DialogOptions{Title: "Quit?", Message: "Unsaved changes will be lost", Buttons: []string{"Yes", "No"}, MaxWidth: 20}}
+	left, top, lines := d.layout(40, 20)
+	expected := []string{
+		"┌─ Quit? ─────────┐",
+		"│ Unsaved changes │",
+		"│ will be lost    │",
+		"│                 │",
+		"│ [ Yes ]  [ No ] │",
+		"└─────────────────┘",
+	}
+	if diff := cmp.Diff(expected, lines); diff != "" {
+		t.Fatalf("Unexpected dialog layout:\n%s", diff)
+	}
+	if left != 11 || top != 8 {
+		t.Fatalf("Dialog not centered: %d %d", left, top)
+	}
+	// labels are shortened to fit
+	d.opts.Buttons = []string{"Save changes", "Discard changes"}
+	_, _, lines = d.layout(18, 20)
+	for _, line := range lines {
+		if w := wcswidth.Stringwidth(line); w != 18 {
+			t.Fatalf("Line %#v has width %d", line, w)
+		}
+	}
+	press := func(key string) {
+		ps := ParseShortcut(key)
+		if err := d.on_key_event(&KeyEvent{Type: PRESS, Key: ps.KeyName, Mods: ps.Mods}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	press("left")
+	press("tab")
+	press("shift+tab")
+	if d.selected != 1 || d.done {
+		t.Fatalf("Unexpected selection: %d", d.selected)
+	}
+	press("enter")
+	if !d.done || d.result != 1 {
+		t.Fatalf("Button not activated")
+	}
+	d.done = false
+	press("esc")
+	if !d.done || d.result != -1 {
+		t.Fatalf("Dialog not cancelled")
+	}
+
+	// the callbacks of the application do not get input meant for the dialog
+	// and are restored afterwards
+	var app_input []string
+	lp.OnTextWithKey = func(text string, key *KeyEvent, in_bracketed_paste bool) error {
+		app_input = append(app_input, text)
+		return nil
+	}
+	lp.OnUnhandledKey = func(ev *KeyEvent) error {
+		app_input = append(app_input, ev.Key)
+		return nil
+	}
+	lp.nested.run = func(done func() bool) error {
+		if err := lp.dispatch_input_data([]byte("x\x1b[120u\x1b[P\x1b[9u\x1b[13u")); err != nil {
+			return err
+		}
+		if !done() {
+			return fmt.Errorf("Dialog not closed")
+		}
+		return nil
+	}
+	if result, err := lp.Dialog(DialogOptions{Buttons: []string{"Yes", "No"}}); err != nil || result != 1 {
+		t.Fatalf("Unexpected dialog result: %d %v", result, err)
+	}
+	if len(app_input) != 0 {
+		t.Fatalf("Input went to the application: %#v", app_input)
+	}
+	if err := lp.dispatch_input_data([]byte("y\x1b[P")); err != nil || fmt.Sprint(app_input) != "[y F1]" {
+		t.Fatalf("OnTextWithKey and OnUnhandledKey not restored: %v %v", app_input, err)
+	}
+}
@@ -0,0 +1,79 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+var _ = fmt.Print
+
+// The watchdog waits for a line on its stdin, if stdin is closed without the
+// line being received, the parent process died without restoring the
+// terminal. Terminal signals are ignored so that the watchdog survives the
+// death of the foreground process group and can still write to the tty after
+// the shell has taken it back.
+const exit_cleanup_script = `trap '' INT QUIT TSTP TTIN TTOU HUP
+read -r x
+if [ "$x" != done ]; then
+    { stty sane < "$2"; printf '%s' "$1" > "$2"; } 2> /dev/null
+fi`
+
+// The terminal restored by the watchdog, changed by the tests
+var exit_cleanup_tty = "/dev/tty"
+
+type exit_cleanup struct {
+	cmd  *exec.Cmd
+	pipe *os.File
+}
+
+// Restore the terminal even if the process exits without the loop shutting
+// down cleanly, for instance, because os.Exit() or log.Fatal() is called while
+// it is running. Go provides no way to run code when os.Exit() is called, so
+// this works by starting a small watchdog process that writes the
+// TeardownSequence() and restores the tty to a sane mode if this process exits
+// without telling it the loop finished normally. This is best-effort: any
+// state set by the application itself via OnFinalize is not restored, the
+// tty is restored to sane defaults not its original mode and the shell may
+// redraw its prompt before the watchdog gets to run. It requires /bin/sh.
+// Can be called before or while the loop is running.
+func (self *Loop) InstallExitCleanup() *Loop {
+	self.exit_cleanup_requested = true
+	if self.timers != nil && self.exit_cleanup == nil {
+		self.start_exit_cleanup()
+	}
+	return self
+}
+
+func InstallExitCleanup(self *Loop) {
+	self.InstallExitCleanup()
+}
+
+func (self *Loop) start_exit_cleanup() {
+	if self.is_dumb_terminal {
+		return
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	cmd := exec.Command("/bin/sh", "-c", exit_cleanup_script, "sh", self.TeardownSequence(), exit_cleanup_tty)
+	cmd.Stdin = r
+	if err = cmd.Start(); err != nil {
+		w.Close()
+		return
+	}
+	self.exit_cleanup = &exit_cleanup{cmd: cmd, pipe: w}
+}
+
+func (self *Loop) stop_exit_cleanup() {
+	if self.exit_cleanup != nil {
+		_, _ = self.exit_cleanup.pipe.WriteString("done\n")
+		self.exit_cleanup.pipe.Close()
+		_ = self.exit_cleanup.cmd.Wait()
+		self.exit_cleanup = nil
+	}
+}
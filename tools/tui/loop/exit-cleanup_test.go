@@ -0,0 +1,57 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestExitCleanup(t *testing.T) {
+	tty := filepath.Join(t.TempDir(), "tty")
+	defer func(orig string) { exit_cleanup_tty = orig }(exit_cleanup_tty)
+	exit_cleanup_tty = tty
+	restored := func() string {
+		data, _ := os.ReadFile(tty)
+		os.Remove(tty)
+		return string(data)
+	}
+
+	lp := new_test_loop(InstallExitCleanup)
+	if !lp.exit_cleanup_requested || lp.exit_cleanup != nil {
+		t.Fatalf("Watchdog started before the loop is running")
+	}
+	lp.timers = make([]*timer, 0, 1)
+	// a loop that finishes normally leaves the terminal alone
+	lp.InstallExitCleanup()
+	if lp.exit_cleanup == nil {
+		t.Fatalf("Watchdog not started while the loop is running")
+	}
+	lp.stop_exit_cleanup()
+	if lp.exit_cleanup != nil || restored() != "" {
+		t.Fatalf("Watchdog restored the terminal after the loop finished normally")
+	}
+
+	// the watchdog restores the terminal when the process dies, which closes
+	// its end of the pipe
+	lp.start_exit_cleanup()
+	w := lp.exit_cleanup
+	w.pipe.Close()
+	if err := w.cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if q := restored(); q == "" || q != lp.TeardownSequence() {
+		t.Fatalf("Watchdog did not write the teardown sequence: %#v", q)
+	}
+
+	lp = new_test_loop()
+	lp.is_dumb_terminal = true
+	lp.timers = make([]*timer, 0, 1)
+	if lp.InstallExitCleanup(); lp.exit_cleanup != nil {
+		t.Fatalf("Watchdog started for a dumb terminal")
+	}
+}
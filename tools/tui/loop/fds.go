@@ -0,0 +1,248 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+type FDEvents uint8
+
+const (
+	FD_READABLE FDEvents = 1 << iota
+	FD_WRITABLE
+	// Set when select() reports an exceptional condition on the fd or the fd
+	// is no longer valid, for instance, because it was closed without calling
+	// RemoveFD(). Invalid fds are removed automatically after the callback is
+	// called.
+	FD_ERROR
+)
+
+type FDCallback func(fd int, events FDEvents) error
+
+type fd_watch struct {
+	id       IdType
+	fd       int
+	events   FDEvents
+	callback FDCallback
+}
+
+type fd_ready struct {
+	id      IdType
+	events  FDEvents
+	invalid bool
+}
+
+type fd_watcher struct {
+	watches       []fd_watch
+	watch_channel chan []fd_watch
+	ready_channel chan []fd_ready
+	// sent by the main loop once the callbacks for a report of ready fds
+	// have returned
+	ack_channel    chan struct{}
+	done_channel   chan struct{}
+	wake_r, wake_w *os.File
+}
+
+func is_valid_fd(fd int) bool {
+	_, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0)
+	return err == nil
+}
+
+// Runs in its own goroutine, waiting for the fds in the most recent set of
+// watches to become ready. After reporting ready fds it waits for the main
+// loop to acknowledge that their callbacks have returned, so that fds are not
+// reported again while their callbacks are running, keeping any set of
+// watches sent in the meantime. It quits when done_channel is closed.
+func watch_fds(wake_r *os.File, watch_channel <-chan []fd_watch, ready_channel chan<- []fd_ready, ack_channel, done_channel <-chan struct{}) {
+	defer wake_r.Close()
+	wake_fd := int(wake_r.Fd())
+	buf := make([]byte, 64)
+	var current []fd_watch
+	select {
+	case current = <-watch_channel:
+	case <-done_channel:
+		return
+	}
+	for {
+		selector := utils.CreateSelect(len(current) + 1)
+		selector.RegisterRead(wake_fd)
+		for _, w := range current {
+			if w.events&FD_READABLE != 0 {
+				selector.RegisterRead(w.fd)
+			}
+			if w.events&FD_WRITABLE != 0 {
+				selector.RegisterWrite(w.fd)
+			}
+			selector.RegisterError(w.fd)
+		}
+		_, err := selector.WaitForever()
+		var ready []fd_ready
+		switch err {
+		case nil:
+			for _, w := range current {
+				var ev FDEvents
+				if selector.IsReadyToRead(w.fd) {
+					ev |= FD_READABLE
+				}
+				if selector.IsReadyToWrite(w.fd) {
+					ev |= FD_WRITABLE
+				}
+				if selector.IsErrored(w.fd) {
+					ev |= FD_ERROR
+				}
+				if ev != 0 {
+					ready = append(ready, fd_ready{id: w.id, events: ev & (w.events | FD_ERROR)})
+				}
+			}
+		case unix.EINTR:
+			continue
+		default:
+			for _, w := range current {
+				if !is_valid_fd(w.fd) {
+					ready = append(ready, fd_ready{id: w.id, events: FD_ERROR, invalid: true})
+				}
+			}
+			if len(ready) == 0 {
+				ready = append(ready, fd_ready{events: FD_ERROR})
+			}
+		}
+		if selector.IsReadyToRead(wake_fd) {
+			_, _ = wake_r.Read(buf)
+		}
+		if len(ready) > 0 {
+			select {
+			case ready_channel <- ready:
+			case <-done_channel:
+				return
+			}
+			for acked := false; !acked; {
+				select {
+				case current = <-watch_channel:
+				case <-ack_channel:
+					acked = true
+				case <-done_channel:
+					return
+				}
+			}
+		}
+		select {
+		case current = <-watch_channel:
+		case <-done_channel:
+			return
+		default:
+		}
+	}
+}
+
+// Send the current set of watches to the watcher goroutine
+func (self *fd_watcher) rearm() {
+	watches := make([]fd_watch, len(self.watches))
+	copy(watches, self.watches)
+	// only the latest set of watches matters, and only this goroutine sends,
+	// so there is always room after removing the previous one
+	select {
+	case <-self.watch_channel:
+	default:
+	}
+	self.watch_channel <- watches
+	_, _ = self.wake_w.Write([]byte{1})
+}
+
+// Let the watcher goroutine watch the fds again, after the callbacks for the
+// ready fds it reported have returned
+func (self *fd_watcher) ack() {
+	select {
+	case self.ack_channel <- struct{}{}:
+	default:
+	}
+}
+
+func (self *fd_watcher) shutdown() {
+	close(self.done_channel)
+	_, _ = self.wake_w.Write([]byte{1})
+	self.wake_w.Close()
+}
+
+// Stop watching all fds, called when the run loop exits
+func (self *Loop) close_fd_watcher() {
+	if self.fd_watcher != nil {
+		self.fd_watcher.shutdown()
+		self.fd_watcher = nil
+	}
+}
+
+func (self *Loop) dispatch_ready_fds(ready []fd_ready) error {
+	if w := self.fd_watcher; w != nil {
+		defer w.ack()
+	}
+	for _, r := range ready {
+		if r.id == 0 {
+			return fmt.Errorf("Failed to wait for watched file descriptors to become ready")
+		}
+		for _, w := range self.fd_watcher.watches {
+			if w.id == r.id {
+				if r.invalid {
+					self.RemoveFD(w.id)
+				}
+				if err := w.callback(w.fd, r.events); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Have the loop monitor fd for the specified events, calling callback on the
+// main goroutine when the fd is ready. The callback is called only once per
+// readiness, the fd is watched again after it returns, so it must consume the
+// available data or it will be called again immediately. Returns an id that
+// can be used to stop watching the fd with RemoveFD().
+func (self *Loop) AddFD(fd int, events FDEvents, callback FDCallback) (IdType, error) {
+	if !self.running {
+		return 0, fmt.Errorf("Cannot add fds before starting the run loop, add them in OnInitialize instead")
+	}
+	if fd < 0 || fd >= unix.FD_SETSIZE {
+		return 0, fmt.Errorf("The file descriptor %d cannot be monitored", fd)
+	}
+	if self.fd_watcher == nil {
+		wake_r, wake_w, err := os.Pipe()
+		if err != nil {
+			return 0, err
+		}
+		w := &fd_watcher{
+			watch_channel: make(chan []fd_watch, 1), ready_channel: make(chan []fd_ready, 1),
+			ack_channel: make(chan struct{}, 1), done_channel: make(chan struct{}), wake_r: wake_r, wake_w: wake_w}
+		self.fd_watcher = w
+		go watch_fds(wake_r, w.watch_channel, w.ready_channel, w.ack_channel, w.done_channel)
+	}
+	self.timer_id_counter++
+	self.fd_watcher.watches = append(self.fd_watcher.watches, fd_watch{id: self.timer_id_counter, fd: fd, events: events, callback: callback})
+	self.fd_watcher.rearm()
+	return self.timer_id_counter, nil
+}
+
+// Stop monitoring the fd identified by id, which must have been returned by
+// AddFD(). Remove fds before closing them.
+func (self *Loop) RemoveFD(id IdType) bool {
+	if self.fd_watcher == nil {
+		return false
+	}
+	for i, w := range self.fd_watcher.watches {
+		if w.id == id {
+			self.fd_watcher.watches = append(self.fd_watcher.watches[:i], self.fd_watcher.watches[i+1:]...)
+			self.fd_watcher.rearm()
+			return true
+		}
+	}
+	return false
+}
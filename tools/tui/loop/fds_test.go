@@ -0,0 +1,156 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+var _ = fmt.Print
+
+func test_pipe(t *testing.T) (r, w *os.File) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close(); w.Close() })
+	return
+}
+
+func TestFDWatcher(t *testing.T) {
+	lp := new_test_loop()
+	lp.timers, lp.running = make([]*timer, 0, 1), true
+	r1, w1 := test_pipe(t)
+	r2, w2 := test_pipe(t)
+	var calls []string
+	buf := make([]byte, 16)
+	var id2 IdType
+	id1, err := lp.AddFD(int(r1.Fd()), FD_READABLE, func(fd int, events FDEvents) error {
+		calls = append(calls, "r1")
+		// changing the watches while the callback runs must not cause r1,
+		// which is still readable, to be reported again
+		var err error
+		if id2, err = lp.AddFD(int(r2.Fd()), FD_READABLE, func(fd int, events FDEvents) error {
+			calls = append(calls, "r2")
+			_, _ = r2.Read(buf)
+			return nil
+		}); err != nil {
+			return err
+		}
+		select {
+		case ready := <-lp.fd_watcher.ready_channel:
+			return fmt.Errorf("Ready fds reported before the callback returned: %v", ready)
+		case <-time.After(50 * time.Millisecond):
+		}
+		_, _ = r1.Read(buf)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lp.fd_watcher.shutdown()
+	next_ready := func() []fd_ready {
+		select {
+		case ready := <-lp.fd_watcher.ready_channel:
+			return ready
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for ready fds, after: %v", calls)
+		}
+		return nil
+	}
+	_, _ = w1.Write([]byte("x"))
+	ready := next_ready()
+	if len(ready) != 1 || ready[0].id != id1 || ready[0].events != FD_READABLE {
+		t.Fatalf("Unexpected ready fds: %v", ready)
+	}
+	if err = lp.dispatch_ready_fds(ready); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w2.Write([]byte("y"))
+	ready = next_ready()
+	if len(ready) != 1 || ready[0].id != id2 {
+		t.Fatalf("Unexpected ready fds: %v", ready)
+	}
+	if err = lp.dispatch_ready_fds(ready); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(calls) != "[r1 r2]" {
+		t.Fatalf("Unexpected callbacks: %v", calls)
+	}
+
+	// the callback of a removed fd is not called, even if the watcher
+	// reports it before it sees the removal
+	lp.RemoveFD(id2)
+	_, _ = w2.Write([]byte("y"))
+	_, _ = w1.Write([]byte("x"))
+	for calls = nil; len(calls) == 0; {
+		if err = lp.dispatch_ready_fds(next_ready()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(calls) != "[r1]" {
+		t.Fatalf("Unexpected callbacks: %v", calls)
+	}
+}
+
+func TestFDWatcherLifetime(t *testing.T) {
+	lp := new_test_loop()
+	r, _ := test_pipe(t)
+	callback := func(fd int, events FDEvents) error { return nil }
+	if _, err := lp.AddFD(int(r.Fd()), FD_READABLE, callback); err == nil {
+		t.Fatalf("Adding an fd before the loop is running succeeded")
+	}
+	lp.running = true
+	if _, err := lp.AddFD(int(r.Fd()), FD_READABLE, callback); err != nil {
+		t.Fatal(err)
+	}
+	done_channel := lp.fd_watcher.done_channel
+	// what run() does on exit
+	lp.running = false
+	lp.close_fd_watcher()
+	if lp.fd_watcher != nil {
+		t.Fatalf("The fd watcher was not closed")
+	}
+	select {
+	case <-done_channel:
+	default:
+		t.Fatalf("The fd watcher goroutine was not told to quit")
+	}
+	if _, err := lp.AddFD(int(r.Fd()), FD_READABLE, callback); err == nil {
+		t.Fatalf("Adding an fd after the loop has stopped succeeded")
+	}
+}
+
+func TestFDWatcherShutdown(t *testing.T) {
+	for _, acked := range []bool{false, true} {
+		r, w := test_pipe(t)
+		wake_r, wake_w := test_pipe(t)
+		fw := &fd_watcher{
+			watch_channel: make(chan []fd_watch, 1), ready_channel: make(chan []fd_ready), ack_channel: make(chan struct{}, 1),
+			done_channel: make(chan struct{}), wake_r: wake_r, wake_w: wake_w,
+			watches: []fd_watch{{id: 1, fd: int(r.Fd()), events: FD_READABLE}},
+		}
+		finished := make(chan bool)
+		go func() {
+			watch_fds(wake_r, fw.watch_channel, fw.ready_channel, fw.ack_channel, fw.done_channel)
+			close(finished)
+		}()
+		fw.rearm()
+		_, _ = w.Write([]byte("x"))
+		if acked {
+			<-fw.ready_channel
+			fw.ack()
+		}
+		// the watcher is now either blocked sending a report nobody reads or
+		// watching an fd that is readable again
+		fw.shutdown()
+		select {
+		case <-finished:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("The watcher did not quit on shutdown (acked: %v)", acked)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+var _ = fmt.Print
+
+func TestFlowControl(t *testing.T) {
+	r, term := open_pty(t)
+	defer r.Close()
+	defer term.Close()
+	ixon := func() bool {
+		var state unix.Termios
+		if err := term.Tcgetattr(&state); err != nil {
+			t.Fatal(err)
+		}
+		return state.Iflag&unix.IXON != 0
+	}
+	if ixon() {
+		t.Fatalf("Flow control not turned off in raw mode")
+	}
+	lp, _ := New()
+	// before the loop runs, only the option used at startup is set
+	lp.SetFlowControl(true)
+	if !lp.terminal_options.flow_control || ixon() {
+		t.Fatalf("Flow control not set correctly before the loop runs")
+	}
+	lp.controlling_term = term
+	for _, enabled := range []bool{true, false, true} {
+		lp.SetFlowControl(enabled)
+		if ixon() != enabled {
+			t.Fatalf("Flow control not set to: %v", enabled)
+		}
+	}
+	lp.SetFlowControl(false)
+	// the original setting of a new pty is on
+	if err := term.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if !ixon() {
+		t.Fatalf("Flow control not restored")
+	}
+}
@@ -0,0 +1,96 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+)
+
+var _ = fmt.Print
+
+// Whether the terminal supports the kitty graphics protocol, detected by
+// querying it with a tiny image. The result is cached.
+func (self *Loop) SupportsGraphics() bool {
+	if self.detected.graphics == nil {
+		ans := false
+		found, err := self.query_terminal("\x1b_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\x1b\\", default_query_timeout, func(etype EscapeCodeType, raw []byte) bool {
+			if etype == APC && strings.HasPrefix(string(raw), "Gi=31;") {
+				ans = string(raw) == "Gi=31;OK"
+				return true
+			}
+			return false
+		})
+		if err != nil && !found {
+			return false
+		}
+		self.detected.graphics = &ans
+	}
+	return *self.detected.graphics
+}
+
+// Send a graphics protocol delete command with the specified keys. The
+// terminal is asked not to respond, since there is nothing useful to report.
+func (self *Loop) queue_graphics_delete(keys string) {
+	if self.SupportsGraphics() {
+		self.QueueWriteString("\x1b_Ga=d,q=2," + keys + "\x1b\\")
+	}
+}
+
+// Delete all images on screen, freeing their data in the terminal. Does
+// nothing if the terminal does not support the kitty graphics protocol.
+func (self *Loop) ClearAllGraphics() {
+	self.queue_graphics_delete("d=A")
+}
+
+// Delete the placement with the specified id of the image with the specified
+// id. The image data is kept in the terminal so that the image can be placed
+// again, use DeleteImage() once it is no longer needed. Does nothing if the
+// terminal does not support the kitty graphics protocol.
+func (self *Loop) DeleteImagePlacement(imageId, placementId uint32) {
+	self.queue_graphics_delete(fmt.Sprintf("d=i,i=%d,p=%d", imageId, placementId))
+}
+
+// Delete all placements of the image with the specified id, freeing its data
+// in the terminal. Does nothing if the terminal does not support the kitty
+// graphics protocol.
+func (self *Loop) DeleteImage(imageId uint32) {
+	self.queue_graphics_delete(fmt.Sprintf("d=I,i=%d", imageId))
+}
+
+// Delete all images that intersect the specified region of the screen,
+// freeing their data in the terminal unless they are still placed elsewhere.
+// left and top are 1-based, as for MoveCursorTo(), and the region is width
+// cells wide and height cells tall. Does nothing if the terminal does not
+// support the kitty graphics protocol.
+func (self *Loop) DeleteImagesInRegion(left, top, width, height int) {
+	if width < 1 || height < 1 || !self.SupportsGraphics() {
+		return
+	}
+	// the protocol can delete by cell, row or column, so use whichever
+	// needs the fewest commands
+	var sb strings.Builder
+	w := func(keys string, args ...any) {
+		sb.WriteString("\x1b_Ga=d,q=2,")
+		fmt.Fprintf(&sb, keys, args...)
+		sb.WriteString("\x1b\\")
+	}
+	sz, err := self.ScreenSize()
+	switch {
+	case err == nil && left <= 1 && left+width > int(sz.WidthCells):
+		for y := top; y < top+height; y++ {
+			w("d=Y,y=%d", y)
+		}
+	case err == nil && top <= 1 && top+height > int(sz.HeightCells):
+		for x := left; x < left+width; x++ {
+			w("d=X,x=%d", x)
+		}
+	default:
+		for y := top; y < top+height; y++ {
+			for x := left; x < left+width; x++ {
+				w("d=P,x=%d,y=%d", x, y)
+			}
+		}
+	}
+	self.QueueWriteString(sb.String())
+}
@@ -0,0 +1,52 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestGraphicsDelete(t *testing.T) {
+	lp := new_test_loop()
+	lp.set_screen_size(10, 5)
+	var response []string
+	lp.answer_queries(func(string) []string { return response })
+	output := func() (ans []string) {
+		for _, q := range strings.Split(lp.output(), "\x1b_G") {
+			// the rest are the graphics support query
+			if cmd, is_delete := strings.CutPrefix(q, "a=d,q=2,"); is_delete {
+				ans = append(ans, strings.TrimSuffix(cmd, "\x1b\\"))
+			}
+		}
+		return
+	}
+
+	lp.ClearAllGraphics()
+	lp.DeleteImage(1)
+	if out := output(); len(out) > 0 || lp.SupportsGraphics() {
+		t.Fatalf("Graphics deleted in terminal without graphics support: %#v", out)
+	}
+
+	lp.detected.graphics = nil
+	response = []string{"\x1b_Gi=31;OK\x1b\\"}
+	test := func(f func(), expected ...string) {
+		t.Helper()
+		f()
+		if diff := cmp.Diff(expected, output()); diff != "" {
+			t.Fatalf("Unexpected delete commands:\n%s", diff)
+		}
+	}
+	test(lp.ClearAllGraphics, "d=A")
+	test(func() { lp.DeleteImagePlacement(3, 7) }, "d=i,i=3,p=7")
+	test(func() { lp.DeleteImage(3) }, "d=I,i=3")
+	test(func() { lp.DeleteImagesInRegion(2, 3, 2, 2) }, "d=P,x=2,y=3", "d=P,x=3,y=3", "d=P,x=2,y=4", "d=P,x=3,y=4")
+	test(func() { lp.DeleteImagesInRegion(1, 2, 10, 2) }, "d=Y,y=2", "d=Y,y=3")
+	test(func() { lp.DeleteImagesInRegion(4, 1, 2, 5) }, "d=X,x=4", "d=X,x=5")
+	test(func() { lp.DeleteImagesInRegion(4, 1, 0, 5) })
+}
@@ -0,0 +1,67 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+type hyperlink_state struct {
+	ids        *utils.LRUCache[string, string]
+	id_prefix  string
+	id_counter int
+}
+
+// The maximum number of URIs for which generated ids are remembered, older
+// ones are forgotten first
+const max_hyperlink_ids = 1024
+
+func (self *Loop) hyperlink_id(uri string) string {
+	if self.hyperlinks.ids == nil {
+		self.hyperlinks.ids = utils.NewLRUCache[string, string](max_hyperlink_ids)
+		if self.hyperlinks.id_prefix == "" {
+			// terminals share ids between all programs writing to a screen,
+			// so use a prefix unique to this loop
+			if p, err := utils.HumanRandomId(64); err == nil {
+				self.hyperlinks.id_prefix = p
+			} else {
+				self.hyperlinks.id_prefix = strconv.Itoa(os.Getpid())
+			}
+		}
+	}
+	ans, _ := self.hyperlinks.ids.GetOrCreate(uri, func(string) (string, error) {
+		self.hyperlinks.id_counter++
+		return self.hyperlinks.id_prefix + "-" + strconv.Itoa(self.hyperlinks.id_counter), nil
+	})
+	return ans
+}
+
+// Return text marked up as a hyperlink to uri. If id is empty, an id is
+// generated that is the same for every use of uri, so that the terminal
+// treats all of them as a single link, for example, when hovering.
+func (self *Loop) SprintHyperlink(uri, id, text string) string {
+	if uri == "" {
+		return text
+	}
+	if id == "" {
+		id = self.hyperlink_id(uri)
+	}
+	return "\x1b]8;id=" + id + ";" + uri + "\x1b\\" + text + "\x1b]8;;\x1b\\"
+}
+
+func (self *Loop) PrintHyperlink(uri, id, text string) {
+	self.QueueWriteString(self.SprintHyperlink(uri, id, text))
+}
+
+// Forget the ids generated for URIs, so that links to the same URI written
+// after this are not grouped with the ones written before it. Useful when
+// switching between screens.
+func (self *Loop) ResetHyperlinkIds() {
+	self.hyperlinks.ids = nil
+}
@@ -0,0 +1,30 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestHyperlinkIds(t *testing.T) {
+	lp, _ := New()
+	lp.hyperlinks.id_prefix = "p"
+	for _, x := range []struct{ uri, id, text, expected string }{
+		{"https://a", "", "a", "\x1b]8;id=p-1;https://a\x1b\\a\x1b]8;;\x1b\\"},
+		{"https://b", "", "b", "\x1b]8;id=p-2;https://b\x1b\\b\x1b]8;;\x1b\\"},
+		{"https://a", "", "x", "\x1b]8;id=p-1;https://a\x1b\\x\x1b]8;;\x1b\\"},
+		{"https://a", "mine", "a", "\x1b]8;id=mine;https://a\x1b\\a\x1b]8;;\x1b\\"},
+		{"", "", "plain", "plain"},
+	} {
+		if actual := lp.SprintHyperlink(x.uri, x.id, x.text); actual != x.expected {
+			t.Fatalf("Unexpected hyperlink for %#v: %#v", x.uri, actual)
+		}
+	}
+	lp.ResetHyperlinkIds()
+	if actual := lp.SprintHyperlink("https://a", "", "a"); actual != "\x1b]8;id=p-3;https://a\x1b\\a\x1b]8;;\x1b\\" {
+		t.Fatalf("Ids not reset: %#v", actual)
+	}
+}
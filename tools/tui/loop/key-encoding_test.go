@@ -0,0 +1,128 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestEnterNormalization(t *testing.T) {
+	run := func(reads []string, options ...func(*Loop)) (keys []string, text string) {
+		lp, _ := New(options...)
+		var tb strings.Builder
+		lp.OnKeyEvent = func(ev *KeyEvent) error {
+			keys = append(keys, ev.Key)
+			ev.Handled = ev.Key == "ENTER"
+			return nil
+		}
+		lp.OnText = func(t string, from_key_event, in_bracketed_paste bool) error {
+			tb.WriteString(t)
+			return nil
+		}
+		for _, data := range reads {
+			if err := lp.dispatch_input_data([]byte(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return keys, tb.String()
+	}
+	check_reads := func(reads []string, expected_keys []string, expected_text string, options ...func(*Loop)) {
+		t.Helper()
+		keys, text := run(reads, options...)
+		if fmt.Sprint(keys) != fmt.Sprint(expected_keys) || text != expected_text {
+			t.Fatalf("Input: %#v\nkeys: %#v != %#v\ntext: %#v != %#v", reads, expected_keys, keys, expected_text, text)
+		}
+	}
+	check := func(input string, expected_keys []string, expected_text string, options ...func(*Loop)) {
+		t.Helper()
+		check_reads([]string{input}, expected_keys, expected_text, options...)
+	}
+	check("a\rb", []string{"ENTER"}, "ab")
+	check("\n", []string{"ENTER"}, "")
+	check("\r\n", []string{"ENTER"}, "")
+	check("\r\r\n\n", []string{"ENTER", "ENTER", "ENTER"}, "")
+	// only a \n that arrives together with the \r before it is ignored
+	check_reads([]string{"\r", "\n"}, []string{"ENTER", "ENTER"}, "")
+	check_reads([]string{"\r", "\r\n", "\n"}, []string{"ENTER", "ENTER", "ENTER"}, "")
+	check_reads([]string{"\n", "\n"}, []string{"ENTER", "ENTER"}, "")
+	check_reads([]string{"\r", "\r"}, []string{"ENTER", "ENTER"}, "")
+	check("\x1b[13u", []string{"ENTER"}, "")
+	check("\x1b[57414u", []string{"ENTER"}, "")
+	check("\x1b[57414u", []string{"KP_ENTER"}, "", DistinguishKeypadEnter)
+	// pasted newlines are text not key presses
+	check("\x1b[200~a\r\nb\nc\r\x1b[201~", nil, "a\r\nb\nc\r")
+	// unhandled Enter is delivered as text
+	lp, _ := New()
+	text := ""
+	lp.OnText = func(t string, from_key_event, in_bracketed_paste bool) error {
+		text += t
+		return nil
+	}
+	_ = lp.escape_code_parser.Parse([]byte("\r"))
+	if text != "\r" {
+		t.Fatalf("Unhandled Enter not delivered as text: %#v", text)
+	}
+}
+
+func TestTextWithKey(t *testing.T) {
+	lp, _ := New()
+	var got []string
+	lp.OnText = func(text string, from_key_event, in_bracketed_paste bool) error {
+		t.Fatalf("OnText called though OnTextWithKey is set")
+		return nil
+	}
+	lp.OnTextWithKey = func(text string, key *KeyEvent, in_bracketed_paste bool) error {
+		desc := "nil"
+		if key != nil {
+			desc = key.String()
+		}
+		got = append(got, fmt.Sprintf("%s:%s:%v", text, desc, in_bracketed_paste))
+		return nil
+	}
+	if err := lp.escape_code_parser.Parse([]byte("\x1b[97;3;97ué\x1b[200~x\x1b[201~")); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a:" + (&KeyEvent{Type: PRESS, Mods: ALT, Key: "a", Text: "a"}).String() + ":false", "é:nil:false", "x:nil:true", ":nil:false"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("%#v != %#v", expected, got)
+	}
+}
+
+func TestUnhandledKey(t *testing.T) {
+	lp, _ := New()
+	var got []string
+	lp.OnKeyEvent = func(ev *KeyEvent) error {
+		got = append(got, "key:"+ev.Key)
+		ev.Handled = ev.Key == "x"
+		return nil
+	}
+	lp.OnText = func(text string, from_key_event, in_bracketed_paste bool) error {
+		got = append(got, "text:"+text)
+		return nil
+	}
+	lp.OnUnhandledKey = func(ev *KeyEvent) error {
+		got = append(got, "unhandled:"+ev.Key)
+		return nil
+	}
+	if err := lp.escape_code_parser.Parse([]byte("\x1b[120u\x1b[121u\x1b[121;1:3u\x1b[97;;97u\r")); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"key:x", "key:y", "unhandled:y", "key:y", "key:a", "text:a", "key:ENTER", "unhandled:ENTER"}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Fatalf("Unexpected dispatch:\n%s", diff)
+	}
+	// the legacy Enter key is delivered as text if nothing uses it
+	got, lp.OnUnhandledKey = nil, nil
+	if err := lp.escape_code_parser.Parse([]byte("\r")); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"key:ENTER", "text:\r"}, got); diff != "" {
+		t.Fatalf("Unexpected dispatch of unhandled Enter:\n%s", diff)
+	}
+}
@@ -0,0 +1,96 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+
+	"kitty/tools/utils"
+	"kitty/tools/utils/style"
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+type KVOptions struct {
+	// Align keys to the right edge of the key column instead of the left
+	RightAlignKeys bool
+	// Placed between the key and value columns, defaults to two spaces
+	Separator string
+	// Style for the keys, as accepted by SprintStyled, for example: "bold fg=green"
+	KeyStyle string
+	// The maximum width of the key column, longer keys are truncated.
+	// Defaults to a third of the available width.
+	MaxKeyWidth int
+}
+
+func (self *Loop) render_key_value(pairs [][2]string, width int, opts KVOptions) []string {
+	sep := opts.Separator
+	if sep == "" {
+		sep = "  "
+	}
+	sep_width := wcswidth.Stringwidth(sep)
+	max_key_width := opts.MaxKeyWidth
+	if max_key_width < 1 {
+		max_key_width = width / 3
+	}
+	key_width := 0
+	for _, p := range pairs {
+		key_width = utils.Max(key_width, wcswidth.Stringwidth(p[0]))
+	}
+	key_width = utils.Min(key_width, max_key_width)
+	value_width := width - key_width - sep_width
+	if key_width < 1 || value_width < 1 {
+		return nil
+	}
+	ans := make([]string, 0, len(pairs))
+	blank_key := strings.Repeat(" ", key_width+sep_width)
+	for _, p := range pairs {
+		key, w := wcswidth.TruncateToVisualLengthWithWidth(p[0], key_width)
+		pad := strings.Repeat(" ", key_width-w)
+		if opts.KeyStyle != "" {
+			key = self.SprintStyled(opts.KeyStyle, key)
+		}
+		if opts.RightAlignKeys {
+			key = pad + key
+		} else {
+			key += pad
+		}
+		lines := style.WrapStyledText(p[1], value_width)
+		if len(lines) == 0 {
+			lines = []string{""}
+		}
+		for i, line := range lines {
+			if i == 0 {
+				ans = append(ans, key+sep+line)
+			} else {
+				ans = append(ans, blank_key+line)
+			}
+		}
+	}
+	return ans
+}
+
+// Draw key/value pairs in two columns with the top left corner at the
+// specified row and column (1-based, as for MoveCursorTo). The key column is
+// as wide as the longest key, up to opts.MaxKeyWidth, values are wrapped to fit
+// in the rest of width, with continuation lines indented to the value column.
+// Output is clipped to the screen.
+func (self *Loop) DrawKeyValue(pairs [][2]string, top, left, width int, opts KVOptions) {
+	if top < 1 || left < 1 {
+		return
+	}
+	height := -1
+	if sz, err := self.ScreenSize(); err == nil {
+		width = utils.Min(width, int(sz.WidthCells)-left+1)
+		height = int(sz.HeightCells) - top + 1
+	}
+	for i, line := range self.render_key_value(pairs, width, opts) {
+		if i == height {
+			break
+		}
+		self.MoveCursorTo(left, top+i)
+		self.QueueWriteString(line)
+	}
+}
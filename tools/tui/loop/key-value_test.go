@@ -0,0 +1,43 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestKeyValue(t *testing.T) {
+	lp := new_test_loop()
+	pairs := [][2]string{{"a", "one two three"}, {"long key", "x"}, {"b", ""}}
+	for _, x := range []struct {
+		width    int
+		opts     KVOptions
+		expected []string
+	}{
+		// keys are truncated to a third of the width by default
+		{20, KVOptions{}, []string{"a       one two ", "        three", "long k  x", "b       "}},
+		{20, KVOptions{MaxKeyWidth: 10, RightAlignKeys: true, Separator: ": "}, []string{"       a: one two ", "          three", "long key: x", "       b: "}},
+		// no room for the values
+		{4, KVOptions{MaxKeyWidth: 3}, nil},
+	} {
+		if diff := cmp.Diff(x.expected, lp.render_key_value(pairs, x.width, x.opts)); diff != "" {
+			t.Fatalf("Unexpected lines for width %d and options %+v:\n%s", x.width, x.opts, diff)
+		}
+	}
+	// keys are styled, without the styling counting towards their width
+	if lines := lp.render_key_value(pairs[1:2], 20, KVOptions{KeyStyle: "bold"}); len(lines) != 1 || lines[0] != lp.SprintStyled("bold", "long k")+"  x" {
+		t.Fatalf("Unexpected styled lines: %#v", lines)
+	}
+
+	// clipped to the screen, the key column is a third of the clipped width
+	lp.set_screen_size(12, 3)
+	lp.DrawKeyValue(pairs, 2, 3, 20, KVOptions{})
+	if diff := cmp.Diff("<move 2,3>a    one <move 3,3>     two ", lp.normalized_output()); diff != "" {
+		t.Fatalf("Unexpected output drawing key/value pairs:\n%s", diff)
+	}
+}
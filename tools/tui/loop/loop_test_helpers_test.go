@@ -0,0 +1,122 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var _ = fmt.Print
+
+// A loop for tests, its output is collected from the queue of pending writes
+// instead of being written to a terminal
+type test_loop struct {
+	*Loop
+	// the last write passed to the query answering function
+	last_seen *write_msg
+}
+
+func new_test_loop(options ...func(self *Loop)) *test_loop {
+	lp, err := New(options...)
+	if err != nil {
+		panic(err)
+	}
+	return &test_loop{Loop: lp}
+}
+
+func (self *test_loop) set_screen_size(width, height int) {
+	self.screen_size = ScreenSize{WidthCells: uint(width), HeightCells: uint(height), updated: true}
+}
+
+func (self *test_loop) join_writes(writes []*write_msg) string {
+	var sb strings.Builder
+	for _, w := range writes {
+		if w.bytes != nil {
+			sb.Write(w.bytes)
+		} else {
+			sb.WriteString(w.str)
+		}
+	}
+	return sb.String()
+}
+
+// The output queued since the last call, as it is sent to the terminal,
+// which removes it from the queue
+func (self *test_loop) output() string {
+	var sent []*write_msg
+	for now := time.Now(); len(self.pending_writes) > 0; self.pop_pending_write() {
+		sent = append(sent, self.next_pending_write(now))
+	}
+	return self.join_writes(sent)
+}
+
+// The output queued since the last call, as by NormalizeTerminalOutput()
+func (self *test_loop) normalized_output() string {
+	return NormalizeTerminalOutput(self.output())
+}
+
+// The output since the last call of the query answering function, without
+// removing it from the queue
+func (self *test_loop) unseen_output() string {
+	start := 0
+	for i, w := range self.pending_writes {
+		if w == self.last_seen {
+			start = i + 1
+		}
+	}
+	if len(self.pending_writes) > 0 {
+		self.last_seen = self.pending_writes[len(self.pending_writes)-1]
+	}
+	return self.join_writes(self.pending_writes[start:])
+}
+
+// Parse an escape code as sent by a terminal, with its introducer and
+// terminator
+func parse_test_response(raw string) (etype EscapeCodeType, payload string) {
+	if len(raw) < 2 || raw[0] != 0x1b {
+		panic(fmt.Sprintf("Not an escape code: %#v", raw))
+	}
+	payload = raw[2:]
+	switch raw[1] {
+	case '[':
+		return CSI, payload
+	case ']':
+		etype = OSC
+		if p, found := strings.CutSuffix(payload, "\a"); found {
+			return etype, p
+		}
+	case 'P':
+		etype = DCS
+	case '_':
+		etype = APC
+	default:
+		panic(fmt.Sprintf("Unsupported escape code: %#v", raw))
+	}
+	return etype, strings.TrimSuffix(payload, "\x1b\\")
+}
+
+// Act as a terminal that answers queries. Whenever the loop waits for
+// responses, answer is called with the output queued since it was last
+// called and the escape codes it returns are delivered as responses,
+// followed, unless the loop is done waiting, by the response to the DA1
+// query that ends every batch of queries.
+func (self *test_loop) answer_queries(answer func(output string) []string) {
+	responds := true
+	self.query.responds = &responds
+	self.query.wait_for_responses = func(timeout time.Duration, done func() bool) error {
+		for _, r := range answer(self.unseen_output()) {
+			etype, payload := parse_test_response(r)
+			self.query.response_filter(etype, []byte(payload))
+		}
+		if !done() {
+			self.query.response_filter(CSI, []byte("?62c"))
+		}
+		if done() {
+			return nil
+		}
+		return os.ErrDeadlineExceeded
+	}
+}
@@ -0,0 +1,166 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+)
+
+var _ = fmt.Print
+
+// A combination of terminal modes that is known to cause input problems
+type ModeConflict struct {
+	// The modes that are all set
+	Modes []Mode
+	// When non-zero, the conflict applies only if one of these kitty keyboard
+	// protocol flags is also in effect
+	KeyboardFlags KeyboardStateBits
+	Reason        string
+}
+
+func (self ModeConflict) String() string {
+	modes := make([]string, len(self.Modes))
+	for i, m := range self.Modes {
+		modes[i] = m.String()
+	}
+	ans := strings.Join(modes, " + ")
+	if self.KeyboardFlags != 0 {
+		ans += " + kitty keyboard protocol"
+	}
+	return ans + ": " + self.Reason
+}
+
+func (self Mode) String() string {
+	if self&private > 0 {
+		return fmt.Sprintf("?%d", uint32(self&^private))
+	}
+	return fmt.Sprintf("%d", uint32(self))
+}
+
+// The list of known conflicts, add to this to detect more of them
+var mode_conflicts = []ModeConflict{
+	{Modes: []Mode{MOUSE_BUTTON_TRACKING, MOUSE_MOTION_TRACKING}, Reason: "only one mouse tracking mode can be active, which one wins depends on the terminal"},
+	{Modes: []Mode{MOUSE_BUTTON_TRACKING, MOUSE_MOVE_TRACKING}, Reason: "only one mouse tracking mode can be active, which one wins depends on the terminal"},
+	{Modes: []Mode{MOUSE_MOTION_TRACKING, MOUSE_MOVE_TRACKING}, Reason: "only one mouse tracking mode can be active, which one wins depends on the terminal"},
+	{Modes: []Mode{MOUSE_UTF8_MODE, MOUSE_SGR_MODE}, Reason: "only one mouse encoding can be active, mouse events will be misparsed"},
+	{Modes: []Mode{MOUSE_UTF8_MODE, MOUSE_SGR_PIXEL_MODE}, Reason: "only one mouse encoding can be active, mouse events will be misparsed"},
+	{Modes: []Mode{MOUSE_URXVT_MODE, MOUSE_SGR_MODE}, Reason: "only one mouse encoding can be active, mouse events will be misparsed"},
+	{Modes: []Mode{MOUSE_URXVT_MODE, MOUSE_SGR_PIXEL_MODE}, Reason: "only one mouse encoding can be active, mouse events will be misparsed"},
+	{Modes: []Mode{DECKM}, KeyboardFlags: REPORT_ALL_KEYS_AS_ESCAPE_CODES, Reason: "application cursor keys have no effect when all keys are reported as escape codes, so code relying on them will not see the expected arrow key encoding"},
+}
+
+// The modes set by the TerminalStateOptions, with any changes made by SetMode()
+func (self *Loop) active_modes() map[Mode]bool {
+	ans := map[Mode]bool{DECARM: true, DECAWM: true, DECTCEM: true}
+	opts := &self.terminal_options
+	if opts.alternate_screen {
+		ans[ALTERNATE_SCREEN] = true
+	}
+	if opts.focus_tracking {
+		ans[FOCUS_TRACKING] = true
+	}
+	if opts.mouse_tracking != NO_MOUSE_TRACKING {
+		ans[MOUSE_SGR_MODE], ans[MOUSE_SGR_PIXEL_MODE] = true, true
+		switch opts.mouse_tracking {
+		case BUTTONS_ONLY_MOUSE_TRACKING:
+			ans[MOUSE_BUTTON_TRACKING] = true
+		case BUTTONS_AND_DRAG_MOUSE_TRACKING:
+			ans[MOUSE_MOTION_TRACKING] = true
+		case FULL_MOUSE_TRACKING:
+			ans[MOUSE_MOVE_TRACKING] = true
+		}
+	}
+	for m, on := range self.modes.runtime {
+		ans[m] = on
+	}
+	return ans
+}
+
+func modes_contain(modes []Mode, q Mode) bool {
+	for _, m := range modes {
+		if m == q {
+			return true
+		}
+	}
+	return false
+}
+
+func find_mode_conflicts(modes map[Mode]bool, keyboard_mode KeyboardStateBits) (ans []ModeConflict) {
+	for _, c := range mode_conflicts {
+		matches := c.KeyboardFlags == 0 || keyboard_mode&c.KeyboardFlags != 0
+		for _, m := range c.Modes {
+			matches = matches && modes[m]
+		}
+		if matches {
+			ans = append(ans, c)
+		}
+	}
+	return
+}
+
+// Return the known bad combinations among the modes set by the terminal state
+// options of this loop and SetMode()
+func (self *Loop) ValidateModes() []ModeConflict {
+	return find_mode_conflicts(self.active_modes(), self.terminal_options.kitty_keyboard_mode)
+}
+
+func (self *Loop) warn_about_mode_conflicts(conflicts []ModeConflict) {
+	for _, c := range conflicts {
+		self.DebugPrintln("Conflicting terminal modes:", c)
+	}
+}
+
+// Make SetMode() return an error instead of only warning when a change
+// would result in conflicting modes, see ValidateModes()
+func (self *Loop) StrictModes() *Loop {
+	self.modes.strict = true
+	return self
+}
+
+func StrictModes(self *Loop) {
+	self.modes.strict = true
+}
+
+// Set or reset a terminal mode. If the change results in a known bad
+// combination of modes, a warning is printed via DebugPrintln() and, when
+// StrictModes() is used, an error is returned and the mode is not changed.
+func (self *Loop) SetMode(mode Mode, on bool) error {
+	if on {
+		modes := self.active_modes()
+		modes[mode] = true
+		var conflicts []ModeConflict
+		// only report conflicts caused by this change
+		for _, c := range find_mode_conflicts(modes, self.terminal_options.kitty_keyboard_mode) {
+			if !modes_contain(c.Modes, mode) {
+				continue
+			}
+			conflicts = append(conflicts, c)
+		}
+		if len(conflicts) > 0 {
+			self.warn_about_mode_conflicts(conflicts)
+			if self.modes.strict {
+				return fmt.Errorf("Setting mode %s conflicts with other modes: %s", mode, conflicts[0])
+			}
+		}
+	}
+	if self.modes.runtime == nil {
+		self.modes.runtime = make(map[Mode]bool)
+	}
+	self.modes.runtime[mode] = on
+	switch mode {
+	case ALTERNATE_SCREEN, ALT_SCREEN_NO_CLEAR:
+		self.modes.alt_screen = on
+	case BRACKETED_PASTE:
+		self.modes.bracketed_paste = on
+	case DECOM:
+		self.modes.origin = on
+		self.cursors.current.x, self.cursors.current.y = 0, 0
+	}
+	if on {
+		self.QueueWriteString(mode.EscapeCodeToSet())
+	} else {
+		self.QueueWriteString(mode.EscapeCodeToReset())
+	}
+	return nil
+}
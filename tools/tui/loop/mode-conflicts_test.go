@@ -0,0 +1,44 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestModeConflicts(t *testing.T) {
+	lp, _ := New(OnlyDisambiguateKeys, func(lp *Loop) { lp.MouseTrackingMode(BUTTONS_ONLY_MOUSE_TRACKING) })
+	if c := lp.ValidateModes(); len(c) != 0 {
+		t.Fatalf("Unexpected conflicts: %v", c)
+	}
+	if err := lp.SetMode(BRACKETED_PASTE, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := lp.SetMode(MOUSE_MOVE_TRACKING, true); err != nil {
+		t.Fatal(err)
+	}
+	if c := lp.ValidateModes(); len(c) != 1 || !modes_contain(c[0].Modes, MOUSE_MOVE_TRACKING) {
+		t.Fatalf("Unexpected conflicts: %v", c)
+	}
+	lp.StrictModes()
+	if err := lp.SetMode(MOUSE_UTF8_MODE, true); err == nil {
+		t.Fatalf("No error for conflicting mouse encodings")
+	}
+	if lp.modes.runtime[MOUSE_UTF8_MODE] {
+		t.Fatalf("Conflicting mode was set in strict mode")
+	}
+	// unrelated changes are not rejected because of existing conflicts
+	if err := lp.SetMode(MOUSE_MOVE_TRACKING, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := lp.SetMode(DECKM, true); err != nil {
+		t.Fatal(err)
+	}
+	lp.FullKeyboardProtocol()
+	if c := lp.ValidateModes(); len(c) != 1 || c[0].Modes[0] != DECKM {
+		t.Fatalf("Unexpected conflicts: %v", c)
+	}
+}
@@ -12,6 +12,11 @@ import (
 
 var _ = fmt.Print
 
+type mouse_state struct {
+	sgr_cells, encoding_queried bool
+	legacy_data                 []rune
+}
+
 type MouseEventType uint
 type MouseButtonFlag uint
 
@@ -100,6 +105,10 @@ type MouseEvent struct {
 	Buttons     MouseButtonFlag
 	Mods        KeyModifiers
 	Cell, Pixel struct{ X, Y int }
+	// The position could not be represented by the legacy mouse encoding the
+	// terminal fell back to, the coordinates that were too large are clamped
+	// to the largest it can represent
+	Clamped bool
 }
 
 func (e MouseEvent) String() string {
@@ -111,7 +120,10 @@ func pixel_to_cell(px, length, cell_length int) int {
 	return px / cell_length
 }
 
-func decode_sgr_mouse(text string, screen_size ScreenSize) *MouseEvent {
+// Decode the parameters of an SGR mouse event, the position is in pixels,
+// as for the SGR pixel mode, unless in_cells is true, when it is in 1-based
+// cells, as for the plain SGR mode
+func decode_sgr_mouse(text string, screen_size ScreenSize, in_cells bool) *MouseEvent {
 	last_letter := text[len(text)-1]
 	text = text[:len(text)-1]
 	parts := strings.Split(text, ";")
@@ -131,6 +143,9 @@ func decode_sgr_mouse(text string, screen_size ScreenSize) *MouseEvent {
 		return nil
 	}
 	ans.Pixel.Y, err = strconv.Atoi(parts[2])
+	if err != nil {
+		return nil
+	}
 	if last_letter == 'm' {
 		ans.Event_type = MOUSE_RELEASE
 	} else if cb&MOTION_INDICATOR != 0 {
@@ -144,6 +159,17 @@ func decode_sgr_mouse(text string, screen_size ScreenSize) *MouseEvent {
 	} else if cb3 < 3 {
 		ans.Buttons |= bmap[cb3]
 	}
+	decode_mouse_modifiers(cb, &ans)
+	if in_cells {
+		set_mouse_cell(&ans, ans.Pixel.X-1, ans.Pixel.Y-1, screen_size)
+	} else {
+		ans.Cell.X = pixel_to_cell(ans.Pixel.X, int(screen_size.WidthPx), int(screen_size.CellWidth))
+		ans.Cell.Y = pixel_to_cell(ans.Pixel.Y, int(screen_size.HeightPx), int(screen_size.CellHeight))
+	}
+	return &ans
+}
+
+func decode_mouse_modifiers(cb int, ans *MouseEvent) {
 	if cb&SHIFT_INDICATOR != 0 {
 		ans.Mods |= SHIFT
 	}
@@ -153,13 +179,60 @@ func decode_sgr_mouse(text string, screen_size ScreenSize) *MouseEvent {
 	if cb&CTRL_INDICATOR != 0 {
 		ans.Mods |= CTRL
 	}
-	ans.Cell.X = pixel_to_cell(ans.Pixel.X, int(screen_size.WidthPx), int(screen_size.CellWidth))
-	ans.Cell.Y = pixel_to_cell(ans.Pixel.Y, int(screen_size.HeightPx), int(screen_size.CellHeight))
+}
 
+// Set the position of an event reported in 0-based cells, the pixel position
+// is that of the top left corner of the cell
+func set_mouse_cell(ans *MouseEvent, x, y int, screen_size ScreenSize) {
+	ans.Cell.X, ans.Cell.Y = utils.Max(0, x), utils.Max(0, y)
+	ans.Pixel.X, ans.Pixel.Y = ans.Cell.X*int(screen_size.CellWidth), ans.Cell.Y*int(screen_size.CellHeight)
+}
+
+// The largest 0-based cell coordinate the legacy X10 mouse encoding can
+// represent, each coordinate is sent as a single byte offset by 33
+const max_legacy_mouse_coordinate = 255 - 33
+
+// Decode a mouse event in the legacy X10 encoding, CSI M followed by three
+// characters for the button and the position. Since input is decoded as
+// UTF-8, only bytes below 128 come through intact, positions beyond that,
+// and those beyond the limit of the encoding, which terminals send as zero
+// or other bytes below 33, are clamped.
+func decode_legacy_mouse(data []rune, screen_size ScreenSize) *MouseEvent {
+	if len(data) != 3 || data[0] < 32 || data[0] > 127 {
+		return nil
+	}
+	ans := MouseEvent{}
+	cb := int(data[0] - 32)
+	coord := func(r rune) int {
+		if r < 33 || r > 127 {
+			ans.Clamped = true
+			return max_legacy_mouse_coordinate
+		}
+		return int(r - 33)
+	}
+	x, y := coord(data[1]), coord(data[2])
+	if cb&MOTION_INDICATOR != 0 {
+		ans.Event_type = MOUSE_MOVE
+	} else if cb&3 == 3 && cb < 64 {
+		// the legacy encoding does not say which button was released
+		ans.Event_type = MOUSE_RELEASE
+	}
+	cb3 := cb & 3
+	if cb >= 64 {
+		ans.Buttons |= wbmap[cb3]
+	} else if cb3 < 3 {
+		ans.Buttons |= bmap[cb3]
+	}
+	decode_mouse_modifiers(cb, &ans)
+	set_mouse_cell(&ans, x, y, screen_size)
 	return &ans
 }
 
 func MouseEventFromCSI(csi string, screen_size ScreenSize) *MouseEvent {
+	return mouse_event_from_csi(csi, screen_size, false)
+}
+
+func mouse_event_from_csi(csi string, screen_size ScreenSize, in_cells bool) *MouseEvent {
 	if len(csi) == 0 {
 		return nil
 	}
@@ -170,5 +243,5 @@ func MouseEventFromCSI(csi string, screen_size ScreenSize) *MouseEvent {
 	if !strings.HasPrefix(csi, "<") {
 		return nil
 	}
-	return decode_sgr_mouse(csi[1:], screen_size)
+	return decode_sgr_mouse(csi[1:], screen_size, in_cells)
 }
@@ -0,0 +1,78 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+func TestMouseEncodings(t *testing.T) {
+	lp := new_test_loop(func(lp *Loop) { lp.MouseTrackingMode(BUTTONS_ONLY_MOUSE_TRACKING) })
+	lp.screen_size = ScreenSize{WidthCells: 400, HeightCells: 50, WidthPx: 4000, HeightPx: 1000, CellWidth: 10, CellHeight: 20, updated: true}
+	lp.pending_mouse_events = utils.NewRingBuffer[MouseEvent](4)
+	var events []MouseEvent
+	lp.OnMouseEvent = func(ev *MouseEvent) error {
+		events = append(events, *ev)
+		return nil
+	}
+	test := func(csi string, runes string, etype MouseEventType, buttons MouseButtonFlag, x, y int, clamped bool) {
+		t.Helper()
+		events = nil
+		if err := lp.handle_csi([]byte(csi)); err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range runes {
+			if err := lp.handle_rune(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(events) == 0 {
+			t.Fatalf("No events for %q %q", csi, runes)
+		}
+		ev := events[0]
+		if ev.Event_type != etype || ev.Buttons != buttons || ev.Cell.X != x || ev.Cell.Y != y || ev.Clamped != clamped {
+			t.Fatalf("Unexpected event for %q %q: %s Clamped: %v", csi, runes, ev, ev.Clamped)
+		}
+	}
+
+	// SGR pixel mode, column 300 is pixels 2990 to 2999
+	test("<0;2995;110M", "", MOUSE_PRESS, LEFT_MOUSE_BUTTON, 299, 5, false)
+	// SGR mode, for terminals without the pixel mode, found from the reply
+	// to the query sent at startup, which is not waited for
+	lp.query_mouse_encoding()
+	if q := lp.output(); q != "\x1b[?1016$p" {
+		t.Fatalf("Unexpected mouse encoding query: %#v", q)
+	}
+	var escape_codes []string
+	lp.OnEscapeCode = func(etype EscapeCodeType, raw []byte) error {
+		escape_codes = append(escape_codes, string(raw))
+		return nil
+	}
+	for _, reply := range []string{"?1016;0$y", "?1016;0$y"} {
+		if err := lp.handle_csi([]byte(reply)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// only the reply to the query is consumed
+	if !lp.mouse.sgr_cells || fmt.Sprint(escape_codes) != "[?1016;0$y]" {
+		t.Fatalf("Reply to the mouse encoding query not handled: %v %v", lp.mouse.sgr_cells, escape_codes)
+	}
+	test("<0;300;6M", "", MOUSE_PRESS, LEFT_MOUSE_BUTTON, 299, 5, false)
+	test("<2;300;6m", "", MOUSE_RELEASE, RIGHT_MOUSE_BUTTON, 299, 5, false)
+	if ev := events[0]; ev.Pixel.X != 2990 || ev.Pixel.Y != 100 {
+		t.Fatalf("Unexpected pixel position in SGR mode: %s", ev)
+	}
+	// the legacy encoding
+	test("M", " +&", MOUSE_PRESS, LEFT_MOUSE_BUTTON, 10, 5, false)
+	test("M", "#\x00&", MOUSE_RELEASE, NO_MOUSE_BUTTON, max_legacy_mouse_coordinate, 5, true)
+	test("M", " é", MOUSE_PRESS, LEFT_MOUSE_BUTTON, max_legacy_mouse_coordinate, max_legacy_mouse_coordinate, true)
+	events = nil
+	if err := lp.handle_rune('a'); err != nil || len(events) > 0 {
+		t.Fatalf("Text after a legacy mouse event not handled as text: %v", events)
+	}
+}
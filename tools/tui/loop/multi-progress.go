@@ -0,0 +1,251 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"kitty/tools/utils"
+	"kitty/tools/utils/humanize"
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+const multi_progress_default_fps = 30
+
+type progress_task struct {
+	name, label, rate_unit string
+	done, total            int64
+	completed              bool
+	started_at             time.Time
+}
+
+// Several named progress bars, one per row, that can be updated
+// independently, for example, for parallel downloads. Updates are coalesced
+// and rendered at most MaxFPS times a second, re-drawing only the rows that
+// have changed.
+type MultiProgress struct {
+	// Render a progress bar, width cells wide, for a fraction between zero
+	// and one. Defaults to a simple bar, tui.RenderProgressBar can be used
+	// for a nicer one.
+	RenderBar func(frac float64, width int) string
+	// The maximum number of rows to use, zero means all rows from top to the
+	// bottom of the screen
+	MaxRows int
+	// The maximum number of times per second the bars are rendered
+	MaxFPS int
+
+	lp               *Loop
+	top, left, width int
+	tasks            []*progress_task
+	rendered         []string
+	throttle_key     string
+	now              func() time.Time
+}
+
+// Create a group of progress bars with the top left corner at the specified
+// row and column (1-based, as for MoveCursorTo), width cells wide
+func (self *Loop) NewMultiProgress(top, left, width int) *MultiProgress {
+	ans := &MultiProgress{lp: self, top: top, left: left, width: utils.Max(1, width), MaxFPS: multi_progress_default_fps, now: time.Now}
+	ans.throttle_key = fmt.Sprintf("multi-progress-%p", ans)
+	return ans
+}
+
+func (self *MultiProgress) task(name string) *progress_task {
+	for _, t := range self.tasks {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// Add a task, replacing any existing task with the same name. If rate_unit is
+// not empty, the rate of progress is shown, "B" shows it as bytes per second.
+// A total of zero means the total is not yet known.
+func (self *MultiProgress) Add(name, label string, total int64, rate_unit string) {
+	t := self.task(name)
+	if t == nil {
+		t = &progress_task{name: name}
+		self.tasks = append(self.tasks, t)
+	}
+	*t = progress_task{name: name, label: label, total: total, rate_unit: rate_unit, started_at: self.now()}
+	self.changed()
+}
+
+// Update the progress of a task, a negative total leaves the total unchanged
+func (self *MultiProgress) Update(name string, done, total int64) {
+	if t := self.task(name); t != nil {
+		t.done = done
+		if total >= 0 {
+			t.total = total
+		}
+		self.changed()
+	}
+}
+
+// Mark a task as completed, it remains visible with full progress until
+// removed
+func (self *MultiProgress) Complete(name string) {
+	if t := self.task(name); t != nil {
+		t.completed = true
+		if t.total > 0 {
+			t.done = t.total
+		}
+		self.changed()
+	}
+}
+
+func (self *MultiProgress) Remove(name string) {
+	for i, t := range self.tasks {
+		if t.name == name {
+			self.tasks = append(self.tasks[:i], self.tasks[i+1:]...)
+			self.changed()
+			return
+		}
+	}
+}
+
+func (self *MultiProgress) changed() {
+	interval := time.Duration(0)
+	if self.MaxFPS > 0 {
+		interval = time.Second / time.Duration(self.MaxFPS)
+	}
+	self.lp.Throttle(self.throttle_key, interval, func() error {
+		self.Render()
+		return nil
+	})
+}
+
+func (self *MultiProgress) num_rows() int {
+	ans := self.MaxRows
+	if sz, err := self.lp.ScreenSize(); err == nil {
+		available := int(sz.HeightCells) - self.top + 1
+		if ans <= 0 || ans > available {
+			ans = available
+		}
+	}
+	return utils.Max(1, ans)
+}
+
+func default_progress_bar(frac float64, width int) string {
+	filled := utils.Max(0, utils.Min(width, int(frac*float64(width))))
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+func (self *MultiProgress) rate(t *progress_task) string {
+	if t.rate_unit == "" {
+		return ""
+	}
+	elapsed := self.now().Sub(t.started_at).Seconds()
+	rate := 0.
+	if elapsed > 0 {
+		rate = float64(t.done) / elapsed
+	}
+	if t.rate_unit == "B" {
+		return strings.ReplaceAll(humanize.Bytes(uint64(rate)), " ", "") + "/s"
+	}
+	return fmt.Sprintf("%.1f %s/s", rate, t.rate_unit)
+}
+
+func fit_to_width(text string, width int) string {
+	text = wcswidth.TruncateToVisualLength(text, width)
+	return text + strings.Repeat(" ", width-wcswidth.Stringwidth(text))
+}
+
+func (self *MultiProgress) render_task(t *progress_task, label_width int) string {
+	label := fit_to_width(t.label, label_width)
+	after := ""
+	switch {
+	case t.completed:
+		after = " done"
+	case t.total > 0:
+		after = fmt.Sprintf(" %3d%%", int(100*float64(t.done)/float64(t.total)))
+	}
+	if r := self.rate(t); r != "" {
+		after += " " + r
+	}
+	bar_width := self.width - label_width - 1 - wcswidth.Stringwidth(after)
+	if bar_width < 4 {
+		return fit_to_width(label+after, self.width)
+	}
+	frac := 0.
+	if t.completed {
+		frac = 1
+	} else if t.total > 0 {
+		frac = float64(t.done) / float64(t.total)
+	}
+	render_bar := self.RenderBar
+	if render_bar == nil {
+		render_bar = default_progress_bar
+	}
+	return label + " " + render_bar(frac, bar_width) + after
+}
+
+// The rows to display, when there are more tasks than rows, the last row
+// summarizes the tasks that do not fit
+func (self *MultiProgress) render_rows() []string {
+	num_rows := self.num_rows()
+	label_width := 0
+	for _, t := range self.tasks {
+		label_width = utils.Max(label_width, wcswidth.Stringwidth(t.label))
+	}
+	label_width = utils.Min(label_width, self.width/3)
+	shown := self.tasks
+	summary := ""
+	if len(shown) > num_rows {
+		shown = shown[:num_rows-1]
+		completed := 0
+		for _, t := range self.tasks[num_rows-1:] {
+			if t.completed {
+				completed++
+			}
+		}
+		hidden := len(self.tasks) - len(shown)
+		summary = fmt.Sprintf("… and %d more (%d done)", hidden, completed)
+	}
+	ans := make([]string, 0, len(shown)+1)
+	for _, t := range shown {
+		ans = append(ans, self.render_task(t, label_width))
+	}
+	if summary != "" {
+		ans = append(ans, fit_to_width(summary, self.width))
+	}
+	return ans
+}
+
+// Draw the rows that have changed since the last call. Called automatically
+// when tasks are updated while the loop is running.
+func (self *MultiProgress) Render() {
+	rows := self.render_rows()
+	blank := strings.Repeat(" ", self.width)
+	changed := false
+	for i := 0; i < utils.Max(len(rows), len(self.rendered)); i++ {
+		text := blank
+		if i < len(rows) {
+			text = rows[i]
+		}
+		if i < len(self.rendered) && self.rendered[i] == text {
+			continue
+		}
+		if !changed {
+			changed = true
+			self.lp.SaveCursor()
+		}
+		self.lp.MoveCursorTo(self.left, self.top+i)
+		self.lp.QueueWriteString(text)
+	}
+	if changed {
+		self.lp.RestoreCursor()
+	}
+	self.rendered = rows
+}
+
+// Forget what was drawn, so that the next Render() draws every row, useful
+// after the screen is cleared
+func (self *MultiProgress) Invalidate() {
+	self.rendered = nil
+}
@@ -0,0 +1,82 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestMultiProgress(t *testing.T) {
+	lp, _ := New()
+	mp := lp.NewMultiProgress(1, 1, 30)
+	now := time.Now()
+	mp.now = func() time.Time { return now }
+	mp.MaxRows = 3
+	mp.RenderBar = func(frac float64, width int) string { return fit_to_width(fmt.Sprintf("[%.2f]", frac), width) }
+	check := func(expected ...string) {
+		t.Helper()
+		if diff := cmp.Diff(expected, mp.render_rows()); diff != "" {
+			t.Fatalf("Unexpected rows:\n%s", diff)
+		}
+	}
+	mp.Add("a", "alpha", 200, "B")
+	mp.Add("b", "b", 0, "")
+	now = now.Add(2 * time.Second)
+	mp.Update("a", 50, -1)
+	check(
+		"alpha [0.25]         25% 25B/s",
+		"b     [0.00]                  ",
+	)
+	mp.Complete("b")
+	mp.Add("c", "c", 10, "")
+	mp.Add("d", "d", 10, "")
+	check(
+		"alpha [0.25]         25% 25B/s",
+		"b     [1.00]              done",
+		"… and 2 more (0 done)         ",
+	)
+	mp.Remove("a")
+	mp.Update("d", 10, 20)
+	check(
+		"b [1.00]                  done",
+		"c [0.00]                    0%",
+		"d [0.50]                   50%",
+	)
+}
+
+func TestMultiProgressRender(t *testing.T) {
+	lp := new_test_loop()
+	lp.set_screen_size(40, 10)
+	mp := lp.NewMultiProgress(2, 3, 10)
+	mp.RenderBar = func(frac float64, width int) string {
+		return fit_to_width("\x1b[32m"+strings.Repeat("#", width)+"\x1b[39m", width)
+	}
+	mp.Add("a", "a", 0, "")
+	// the cursor and the attributes set with SetSGR() are restored after
+	// drawing, regardless of the attributes used in the bars
+	lp.MoveCursorTo(5, 6)
+	lp.SetSGR("1")
+	lp.output()
+	mp.Render()
+	out := lp.normalized_output()
+	if !strings.HasPrefix(out, "<move 2,3>") || !strings.HasSuffix(out, "<move 6,5><SGR 1>") {
+		t.Fatalf("Unexpected output rendering progress bars: %#v", out)
+	}
+	// other attributes are restored by the terminal
+	lp.QueueWriteString("\x1b[31m")
+	lp.MoveCursorTo(5, 6)
+	mp.Add("b", "b", 0, "")
+	lp.output()
+	mp.Render()
+	out = lp.normalized_output()
+	if !strings.HasPrefix(out, "<ESC 7><move 3,3>") || !strings.HasSuffix(out, "<ESC 8>") || strings.Contains(out, "<SGR 0>") {
+		t.Fatalf("Unexpected output rendering progress bars: %#v", out)
+	}
+}
@@ -0,0 +1,99 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+// Turn origin mode (DECOM) on or off. In origin mode the terminal interprets
+// cursor positions relative to the top of the scroll region, see
+// SetScrollRegion(). MoveCursorTo() compensates for this, so it always uses
+// absolute screen coordinates, however, rows outside the scroll region cannot
+// be reached in origin mode, the terminal moves the cursor to the nearest row
+// of the region instead. Changing origin mode moves the cursor to the top left
+// corner of the region. Origin mode is turned off when the loop exits or is
+// suspended and turned back on when it resumes. Note that the escape codes
+// from Screen.Flush() use absolute coordinates.
+func (self *Loop) SetOriginMode(enabled bool) {
+	_ = self.SetMode(DECOM, enabled)
+}
+
+// Whether origin mode is on, see SetOriginMode()
+func (self *Loop) OriginMode() bool {
+	return self.modes.origin
+}
+
+// Restrict scrolling to the rows from top to bottom, inclusive and 1-based as
+// for MoveCursorTo(). Use zero for both to scroll the whole screen. Setting
+// the scroll region moves the cursor to the top left corner of the screen or,
+// in origin mode, of the region.
+func (self *Loop) SetScrollRegion(top, bottom int) {
+	if top < 1 || bottom < top {
+		self.modes.scroll_region_top, self.modes.scroll_region_bottom = 0, 0
+		self.QueueWriteString("\x1b[r")
+	} else {
+		self.modes.scroll_region_top, self.modes.scroll_region_bottom = top, bottom
+		self.QueueWriteString(fmt.Sprintf("\x1b[%d;%dr", top, bottom))
+	}
+	self.cursors.current.x, self.cursors.current.y = 0, 0
+}
+
+// The scroll region set by SetScrollRegion(), zero for both when the whole
+// screen scrolls
+func (self *Loop) ScrollRegion() (top, bottom int) {
+	return self.modes.scroll_region_top, self.modes.scroll_region_bottom
+}
+
+// The row to send to the terminal to move the cursor to the absolute row y
+func (self *Loop) terminal_row(y int) int {
+	if self.modes.origin && self.modes.scroll_region_top > 1 {
+		return utils.Max(1, y-self.modes.scroll_region_top+1)
+	}
+	return y
+}
+
+// Run f with origin mode turned off, so that it can draw outside the scroll
+// region. The cursor position is undefined afterwards.
+func (self *Loop) without_origin_mode(f func()) {
+	if !self.modes.origin {
+		f()
+		return
+	}
+	self.QueueWriteString(DECOM.EscapeCodeToReset())
+	self.modes.origin = false
+	defer func() {
+		self.modes.origin = true
+		self.QueueWriteString(DECOM.EscapeCodeToSet())
+		self.cursors.current.x, self.cursors.current.y = 0, 0
+	}()
+	f()
+}
+
+// Escape codes to restore the scroll region and origin mode after the
+// terminal state was reset by the teardown sequence
+func (self *Loop) origin_mode_setup_codes() string {
+	ans := ""
+	if self.modes.scroll_region_top > 0 {
+		ans += fmt.Sprintf("\x1b[%d;%dr", self.modes.scroll_region_top, self.modes.scroll_region_bottom)
+	}
+	if self.modes.origin {
+		ans += DECOM.EscapeCodeToSet()
+	}
+	return ans
+}
+
+func (self *Loop) origin_mode_teardown_codes() string {
+	ans := ""
+	if self.modes.origin {
+		ans += DECOM.EscapeCodeToReset()
+	}
+	if self.modes.scroll_region_top > 0 {
+		ans += "\x1b[r"
+	}
+	return ans
+}
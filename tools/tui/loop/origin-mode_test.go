@@ -0,0 +1,43 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestOriginMode(t *testing.T) {
+	lp := new_test_loop()
+	output := lp.normalized_output
+	lp.SetScrollRegion(5, 20)
+	lp.MoveCursorTo(3, 10)
+	if out := output(); out != "<CSI 5;20r><move 10,3>" {
+		t.Fatalf("Unexpected output without origin mode: %s", out)
+	}
+	lp.SetOriginMode(true)
+	lp.MoveCursorTo(3, 10)
+	lp.MoveCursorTo(1, 2)
+	if out := output(); out != "<set DECOM><move 6,3><move 1,1>" {
+		t.Fatalf("Unexpected output in origin mode: %s", out)
+	}
+	if !lp.OriginMode() || lp.Config().ScrollRegion != [2]int{5, 20} || !lp.Config().OriginMode {
+		t.Fatalf("Origin mode not tracked: %v", lp.Config())
+	}
+	lp.queue_teardown_sequence()
+	if out := output(); !strings.HasPrefix(out, "<reset DECOM><CSI r>") {
+		t.Fatalf("Origin mode not reset on teardown: %s", out)
+	}
+	lp.queue_setup_sequence()
+	if out := output(); !strings.HasSuffix(out, "<CSI 5;20r><set DECOM>") {
+		t.Fatalf("Origin mode not restored on resume: %s", out)
+	}
+	lp.SetScrollRegion(0, 0)
+	lp.MoveCursorTo(3, 10)
+	if out := output(); out != "<CSI r><move 10,3>" {
+		t.Fatalf("Unexpected output after resetting the scroll region: %s", out)
+	}
+}
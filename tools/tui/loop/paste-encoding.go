@@ -0,0 +1,68 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+)
+
+var _ = fmt.Print
+
+const UTF8_ENCODING = "utf-8"
+
+var windows_1252_high_bytes = [32]rune{
+	0x20ac, 0xfffd, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021, 0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0xfffd, 0x017d, 0xfffd,
+	0xfffd, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014, 0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0xfffd, 0x017e, 0x0178,
+}
+
+var single_byte_decoders = map[string]func(byte) rune{
+	"iso-8859-1": func(b byte) rune { return rune(b) },
+	"windows-1252": func(b byte) rune {
+		if b >= 0x80 && b < 0xa0 {
+			return windows_1252_high_bytes[b-0x80]
+		}
+		return rune(b)
+	},
+}
+
+// Normalize pasted text before it is delivered to OnText. A byte order mark
+// at the start of the pasted text is removed and, if fallback_encoding is not
+// empty, bytes that are not valid UTF-8 are decoded using fallback_encoding
+// instead of being dropped. Supported fallback encodings are iso-8859-1
+// (latin-1) and windows-1252, the usual sources of non UTF-8 text in the
+// clipboard. Text that is not pasted is never modified.
+func (self *Loop) NormalizePastedText(fallback_encoding string) error {
+	fallback_encoding = strings.ToLower(fallback_encoding)
+	switch fallback_encoding {
+	case "latin-1", "latin1":
+		fallback_encoding = "iso-8859-1"
+	case "cp1252":
+		fallback_encoding = "windows-1252"
+	}
+	var decoder func(byte) rune
+	if fallback_encoding != "" {
+		d, ok := single_byte_decoders[fallback_encoding]
+		if !ok {
+			return fmt.Errorf("Unsupported encoding for pasted text: %s", fallback_encoding)
+		}
+		decoder = func(b byte) rune {
+			self.paste.current_encoding = fallback_encoding
+			return d(b)
+		}
+	}
+	self.paste.normalize_text, self.paste.fallback_encoding = true, fallback_encoding
+	self.escape_code_parser.DecodeInvalidPasteByte = decoder
+	self.query_parser.DecodeInvalidPasteByte = decoder
+	return nil
+}
+
+// The encoding of the most recently completed paste, UTF8_ENCODING unless some of
+// it had to be decoded using the fallback encoding set with
+// NormalizePastedText().
+func (self *Loop) PastedTextEncoding() string {
+	if self.paste.text_encoding == "" {
+		return UTF8_ENCODING
+	}
+	return self.paste.text_encoding
+}
@@ -0,0 +1,255 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestPastedNewlines(t *testing.T) {
+	run := func(which PasteNewlines, input string) (pasted, typed string) {
+		lp, _ := New()
+		lp.NormalizePastedNewlines(which)
+		var p, ty strings.Builder
+		lp.OnText = func(text string, from_key_event, in_bracketed_paste bool) error {
+			if in_bracketed_paste {
+				p.WriteString(text)
+			} else {
+				ty.WriteString(text)
+			}
+			return nil
+		}
+		if err := lp.escape_code_parser.Parse([]byte(input)); err != nil {
+			t.Fatal(err)
+		}
+		return p.String(), ty.String()
+	}
+	input := "\r\x1b[200~a\r\nb\rc\nd\r\r\ne\r\x1b[201~\n\r"
+	for which, expected := range map[PasteNewlines]string{
+		PASTE_NEWLINES_AS_IS: "a\r\nb\rc\nd\r\r\ne\r",
+		PASTE_NEWLINES_LF:    "a\nb\nc\nd\n\ne\n",
+		PASTE_NEWLINES_CR:    "a\rb\rc\rd\r\re\r",
+	} {
+		pasted, typed := run(which, input)
+		if pasted != expected {
+			t.Fatalf("Normalizing with %d: %#v != %#v", which, expected, pasted)
+		}
+		if typed != "\r\n\r" {
+			t.Fatalf("Typed text was modified when normalizing with %d: %#v", which, typed)
+		}
+	}
+}
+
+func TestPastedTextNormalization(t *testing.T) {
+	run := func(fallback, input string) string {
+		lp, _ := New()
+		if err := lp.NormalizePastedText(fallback); err != nil {
+			t.Fatal(err)
+		}
+		var p strings.Builder
+		lp.OnText = func(text string, from_key_event, in_bracketed_paste bool) error {
+			p.WriteString(text)
+			return nil
+		}
+		if err := lp.escape_code_parser.Parse([]byte(input)); err != nil {
+			t.Fatal(err)
+		}
+		expected_encoding := UTF8_ENCODING
+		if fallback != "" && strings.ContainsAny(input, "\xe9\x80") {
+			expected_encoding = fallback
+		}
+		if lp.PastedTextEncoding() != expected_encoding {
+			t.Fatalf("Pasted text encoding for %#v: %#v != %#v", input, expected_encoding, lp.PastedTextEncoding())
+		}
+		return p.String()
+	}
+	for _, x := range []struct{ fallback, input, expected string }{
+		{"", "\ufeff\x1b[200~\ufeffa\ufeffb\x1b[201~", "\ufeffa\ufeffb"},
+		{"", "\x1b[200~caf\xe9\x1b[201~", "caf"},
+		{"iso-8859-1", "\x1b[200~caf\xe9\x1b[201~", "café"},
+		{"iso-8859-1", "\x1b[200~\xe9a\xe9\x1b[201~", "éaé"},
+		{"windows-1252", "\x1b[200~\x80 café\x1b[201~", "€ café"},
+	} {
+		if actual := run(x.fallback, x.input); actual != x.expected {
+			t.Fatalf("Normalizing paste of %#v with fallback %#v: %#v != %#v", x.input, x.fallback, x.expected, actual)
+		}
+	}
+}
+
+func TestPasteDelivery(t *testing.T) {
+	run := func(which PasteDelivery) (calls []string, enter_presses int) {
+		lp, _ := New()
+		lp.SetPasteDelivery(which)
+		lp.OnText = func(text string, from_key_event, in_bracketed_paste bool) error {
+			if in_bracketed_paste {
+				calls = append(calls, text)
+			}
+			return nil
+		}
+		lp.OnKeyEvent = func(ev *KeyEvent) error {
+			if ev.MatchesPressOrRepeat("enter") {
+				enter_presses++
+			}
+			return nil
+		}
+		if err := lp.escape_code_parser.Parse([]byte("\x1b[200~ls\rrm x\r\x1b[201~\r")); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	calls, enter_presses := run(PASTE_AS_BLOCK)
+	if len(calls) != 1 || calls[0] != "ls\rrm x\r" {
+		t.Fatalf("Paste not delivered as a single block: %#v", calls)
+	}
+	if enter_presses != 1 {
+		t.Fatalf("Unexpected number of enter presses: %d", enter_presses)
+	}
+	calls, enter_presses = run(PASTE_INCREMENTALLY)
+	if strings.Join(calls, "") != "ls\rrm x\r" || len(calls) != 8 {
+		t.Fatalf("Paste not delivered incrementally: %#v", calls)
+	}
+	if enter_presses != 1 {
+		t.Fatalf("Unexpected number of enter presses: %d", enter_presses)
+	}
+}
+
+func TestPasteErrors(t *testing.T) {
+	for _, which := range []string{"block", "end"} {
+		lp, _ := New()
+		failure := errors.New("paste failed")
+		lp.OnText = func(text string, from_key_event, in_bracketed_paste bool) error {
+			if (which == "block" && in_bracketed_paste) || (which == "end" && text == "") {
+				return failure
+			}
+			return nil
+		}
+		if err := lp.dispatch_input_data([]byte("\x1b[200~abc\x1b[201~")); err != failure {
+			t.Fatalf("Error from delivering the %s of a paste not returned: %v", which, err)
+		}
+	}
+}
+
+func TestEscapeCodesDuringPaste(t *testing.T) {
+	for _, which := range []PasteDelivery{PASTE_AS_BLOCK, PASTE_INCREMENTALLY} {
+		lp, _ := New(DispatchRepliesDuringPaste)
+		lp.SetPasteDelivery(which)
+		var events []string
+		var pasted strings.Builder
+		lp.OnText = func(text string, from_key_event, in_bracketed_paste bool) error {
+			if in_bracketed_paste {
+				pasted.WriteString(text)
+			} else if text == "" {
+				events = append(events, "paste end")
+			}
+			return nil
+		}
+		lp.OnFocusEvent = func(focused bool) error {
+			events = append(events, fmt.Sprintf("focused: %v pasted: %d", focused, pasted.Len()))
+			return nil
+		}
+		half := strings.Repeat("line of pasted text\n", 5000)
+		input := "\x1b[200~" + half + "\x1b[O" + half + "\x1b[201~"
+		// deliver in two reads, splitting the focus event across them
+		split := strings.Index(input, "\x1b[O") + 2
+		for _, chunk := range []string{input[:split], input[split:]} {
+			if err := lp.escape_code_parser.Parse([]byte(chunk)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if pasted.String() != half+half {
+			t.Fatalf("Pasted text corrupted with delivery %d, got %d bytes", which, pasted.Len())
+		}
+		expected_pasted_at_focus := len(half)
+		if which == PASTE_AS_BLOCK {
+			expected_pasted_at_focus = 0
+		}
+		expected := []string{fmt.Sprintf("focused: false pasted: %d", expected_pasted_at_focus), "paste end"}
+		if fmt.Sprint(events) != fmt.Sprint(expected) {
+			t.Fatalf("Unexpected events with delivery %d: %v", which, events)
+		}
+		if lp.focused {
+			t.Fatalf("Focus out event not handled")
+		}
+	}
+}
+
+func TestKeysDuringPaste(t *testing.T) {
+	run := func(input string, options ...func(*Loop)) (pasted string, events []string) {
+		lp, _ := New(options...)
+		lp.OnText = func(text string, from_key_event, in_bracketed_paste bool) error {
+			if in_bracketed_paste {
+				pasted += text
+			}
+			return nil
+		}
+		lp.OnKeyEvent = func(ev *KeyEvent) error {
+			events = append(events, ev.String())
+			return nil
+		}
+		lp.OnFocusEvent = func(focused bool) error {
+			events = append(events, fmt.Sprintf("focused: %v", focused))
+			return nil
+		}
+		if err := lp.escape_code_parser.ParseString(input); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	// pasted text that contains an Enter key event must not run the command
+	input := "\x1b[200~rm -rf ~\x1b[13u\x1b[201~"
+	for _, options := range [][]func(*Loop){nil, {DispatchRepliesDuringPaste}} {
+		pasted, events := run(input, options...)
+		if pasted != "rm -rf ~\x1b[13u" || len(events) != 0 {
+			t.Fatalf("Key event in pasted text dispatched: %#v %v", pasted, events)
+		}
+	}
+	input = "\x1b[200~a\x1b[<0;1;1Mb\x1b[Oc\x1b[201~"
+	if pasted, events := run(input); pasted != "a\x1b[<0;1;1Mb\x1b[Oc" || len(events) != 0 {
+		t.Fatalf("Escape code in pasted text dispatched: %#v %v", pasted, events)
+	}
+	if pasted, events := run(input, DispatchRepliesDuringPaste); pasted != "a\x1b[<0;1;1Mbc" || fmt.Sprint(events) != "[focused: false]" {
+		t.Fatalf("Focus event not dispatched: %#v %v", pasted, events)
+	}
+}
+
+func TestOversizedPaste(t *testing.T) {
+	run := func(which OversizedPasteAction) (calls []string, oversized []int) {
+		lp, _ := New()
+		lp.SetMaxPasteBuffer(4).SetOversizedPasteAction(which)
+		lp.OnText = func(text string, from_key_event, in_bracketed_paste bool) error {
+			if in_bracketed_paste || text == "" {
+				calls = append(calls, text)
+			}
+			return nil
+		}
+		lp.OnOversizedPaste = func(buffered int) error {
+			oversized = append(oversized, buffered)
+			return nil
+		}
+		if err := lp.escape_code_parser.Parse([]byte("\x1b[200~abcdefg\x1b[201~\x1b[200~xy\x1b[201~")); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	calls, oversized := run(STREAM_OVERSIZED_PASTE)
+	if diff := cmp.Diff([]string{"abcde", "f", "g", "", "xy", ""}, calls); diff != "" {
+		t.Fatalf("Oversized paste not streamed:\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{5}, oversized); diff != "" {
+		t.Fatalf("OnOversizedPaste not called correctly:\n%s", diff)
+	}
+	calls, oversized = run(ABORT_OVERSIZED_PASTE)
+	if diff := cmp.Diff([]string{"", "xy", ""}, calls); diff != "" {
+		t.Fatalf("Oversized paste not aborted:\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{5}, oversized); diff != "" {
+		t.Fatalf("OnOversizedPaste not called correctly:\n%s", diff)
+	}
+}
@@ -0,0 +1,377 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"kitty/tools/utils"
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+// Marks the second cell of a wide character
+const wide_char_continuation rune = -1
+
+// Reconstructs the text on screen from the output written to the terminal,
+// writing the rows that changed, in reading order, once each update is done
+type plain_text_mirror struct {
+	writer           io.Writer
+	rows             [][]rune
+	emitted          []string
+	x, y             int
+	saved_x, saved_y int
+	// the screen size, zero when unknown in which case there is no wrapping
+	// or scrolling
+	width, height int
+	tab_width     int
+	in_update     bool
+	// an incomplete escape code or UTF-8 sequence at the end of the last write
+	pending []byte
+}
+
+// Write a plain text transcript of what is shown on screen to w, alongside
+// the output sent to the terminal. Escape codes are stripped and positioned
+// writes are placed by tracking the cursor, so the transcript has the visible
+// text in reading order. Rows are written when their text changes, once each
+// write or atomic update, see StartAtomicUpdate(), is done. Useful for audit
+// logs and accessibility. Use nil to turn it off.
+func (self *Loop) SetPlainTextMirror(w io.Writer) {
+	if w == nil {
+		self.plain_text_mirror = nil
+		return
+	}
+	if self.plain_text_mirror == nil {
+		self.plain_text_mirror = &plain_text_mirror{}
+	}
+	self.plain_text_mirror.writer = w
+}
+
+func (self *Loop) mirror_output(data *write_msg) {
+	m := self.plain_text_mirror
+	if m == nil {
+		return
+	}
+	m.tab_width = self.TabWidth()
+	if self.screen_size.updated {
+		m.resize(int(self.screen_size.WidthCells), int(self.screen_size.HeightCells))
+	}
+	if data.bytes != nil {
+		m.feed(data.bytes)
+	} else {
+		m.feed([]byte(data.str))
+	}
+	if !m.in_update {
+		m.emit()
+	}
+}
+
+func (self *plain_text_mirror) resize(width, height int) {
+	self.width, self.height = width, height
+	for height > 0 && len(self.rows) > height {
+		self.scroll_up()
+		self.y--
+	}
+	self.clamp()
+}
+
+func (self *plain_text_mirror) clamp() {
+	self.x, self.y = utils.Max(0, self.x), utils.Max(0, self.y)
+	if self.width > 0 {
+		self.x = utils.Min(self.x, self.width-1)
+	}
+	if self.height > 0 {
+		self.y = utils.Min(self.y, self.height-1)
+	}
+}
+
+func (self *plain_text_mirror) row(y int) []rune {
+	for len(self.rows) <= y {
+		self.rows = append(self.rows, nil)
+		self.emitted = append(self.emitted, "")
+	}
+	return self.rows[y]
+}
+
+// Write the text of every row that changed since it was last written
+func (self *plain_text_mirror) emit() {
+	var sb strings.Builder
+	for y, cells := range self.rows {
+		var line strings.Builder
+		for _, c := range cells {
+			switch c {
+			case 0:
+				line.WriteByte(' ')
+			case wide_char_continuation:
+			default:
+				line.WriteRune(c)
+			}
+		}
+		text := strings.TrimRight(line.String(), " ")
+		if text != self.emitted[y] {
+			self.emitted[y] = text
+			if text != "" {
+				sb.WriteString(text)
+				sb.WriteByte('\n')
+			}
+		}
+	}
+	if sb.Len() > 0 {
+		_, _ = io.WriteString(self.writer, sb.String())
+	}
+}
+
+func (self *plain_text_mirror) scroll_up() {
+	if len(self.rows) > 0 {
+		self.rows, self.emitted = self.rows[1:], self.emitted[1:]
+	}
+}
+
+func (self *plain_text_mirror) scroll_down() {
+	self.rows = append([][]rune{nil}, self.rows...)
+	self.emitted = append([]string{""}, self.emitted...)
+	if self.height > 0 && len(self.rows) > self.height {
+		self.rows, self.emitted = self.rows[:self.height], self.emitted[:self.height]
+	}
+}
+
+func (self *plain_text_mirror) linefeed() {
+	if self.height > 0 && self.y >= self.height-1 {
+		self.scroll_up()
+		return
+	}
+	self.y++
+}
+
+func (self *plain_text_mirror) reverse_index() {
+	if self.y == 0 {
+		self.scroll_down()
+		return
+	}
+	self.y--
+}
+
+func (self *plain_text_mirror) draw(ch rune) {
+	w := wcswidth.Runewidth(ch)
+	if w < 1 {
+		return
+	}
+	if self.width > 0 && self.x+w > self.width {
+		self.x = 0
+		self.linefeed()
+	}
+	cells := self.row(self.y)
+	for len(cells) < self.x+w {
+		cells = append(cells, 0)
+	}
+	cells[self.x] = ch
+	if w > 1 {
+		cells[self.x+1] = wide_char_continuation
+	}
+	self.rows[self.y] = cells
+	self.x += w
+}
+
+// Clear the cells in [start, end) of row y, end < 0 means to the end of the row
+func (self *plain_text_mirror) erase_cells(y, start, end int) {
+	if y >= len(self.rows) {
+		return
+	}
+	cells := self.rows[y]
+	if end < 0 || end > len(cells) {
+		end = len(cells)
+	}
+	for i := start; i < end; i++ {
+		cells[i] = 0
+	}
+}
+
+func (self *plain_text_mirror) erase_rows(start, end int) {
+	for y := start; y < end && y < len(self.rows); y++ {
+		self.rows[y] = nil
+	}
+}
+
+func (self *plain_text_mirror) clear() {
+	self.erase_rows(0, len(self.rows))
+	self.x, self.y = 0, 0
+}
+
+func (self *plain_text_mirror) handle_csi(raw string) {
+	final := raw[len(raw)-1]
+	params := raw[:len(raw)-1]
+	if rest, found := strings.CutPrefix(params, "?"); found {
+		if final == 'h' || final == 'l' {
+			for _, q := range strings.Split(rest, ";") {
+				switch q {
+				case "2026":
+					self.in_update = final == 'h'
+				case "1049", "47":
+					self.clear()
+				}
+			}
+		}
+		return
+	}
+	if strings.ContainsAny(params, "<>=$ ") {
+		return
+	}
+	nums := strings.Split(params, ";")
+	num := func(i, def int) int {
+		if i < len(nums) {
+			if n, err := strconv.Atoi(nums[i]); err == nil {
+				return n
+			}
+		}
+		return def
+	}
+	count := utils.Max(1, num(0, 1))
+	switch final {
+	case 'H', 'f':
+		self.y, self.x = num(0, 1)-1, num(1, 1)-1
+	case 'A':
+		self.y -= count
+	case 'B':
+		self.y += count
+	case 'C':
+		self.x += count
+	case 'D':
+		self.x -= count
+	case 'E':
+		self.x, self.y = 0, self.y+count
+	case 'F':
+		self.x, self.y = 0, self.y-count
+	case 'G', '`':
+		self.x = count - 1
+	case 'd':
+		self.y = count - 1
+	case 'K':
+		switch num(0, 0) {
+		case 0:
+			self.erase_cells(self.y, self.x, -1)
+		case 1:
+			self.erase_cells(self.y, 0, self.x+1)
+		case 2:
+			self.erase_rows(self.y, self.y+1)
+		}
+	case 'J':
+		switch num(0, 0) {
+		case 0:
+			self.erase_cells(self.y, self.x, -1)
+			self.erase_rows(self.y+1, len(self.rows))
+		case 1:
+			self.erase_rows(0, self.y)
+			self.erase_cells(self.y, 0, self.x+1)
+		case 2, 3:
+			self.erase_rows(0, len(self.rows))
+		}
+	}
+	self.clamp()
+}
+
+func (self *plain_text_mirror) handle_esc(ch byte) {
+	switch ch {
+	case '7':
+		self.saved_x, self.saved_y = self.x, self.y
+	case '8':
+		self.x, self.y = self.saved_x, self.saved_y
+		self.clamp()
+	case 'D':
+		self.linefeed()
+	case 'E':
+		self.x = 0
+		self.linefeed()
+	case 'M':
+		self.reverse_index()
+	case 'c':
+		self.clear()
+	}
+}
+
+func (self *plain_text_mirror) feed(data []byte) {
+	if len(self.pending) > 0 {
+		data = append(self.pending, data...)
+		self.pending = nil
+	}
+	// the end of a string escape code, ST or, for OSC, BEL
+	string_end := func(start int) int {
+		for i := start; i < len(data); i++ {
+			if data[i] == 0x07 {
+				return i + 1
+			}
+			if data[i] == 0x1b && i+1 < len(data) && data[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return -1
+	}
+	for i := 0; i < len(data); {
+		ch := data[i]
+		switch {
+		case ch == 0x1b:
+			if i+1 >= len(data) {
+				self.pending = append(self.pending, data[i:]...)
+				return
+			}
+			switch data[i+1] {
+			case '[':
+				end := i + 2
+				for end < len(data) && (data[end] < 0x40 || data[end] > 0x7e) {
+					end++
+				}
+				if end >= len(data) {
+					self.pending = append(self.pending, data[i:]...)
+					return
+				}
+				self.handle_csi(string(data[i+2 : end+1]))
+				i = end + 1
+			case ']', 'P', '_', '^', 'X':
+				end := string_end(i + 2)
+				if end < 0 {
+					self.pending = append(self.pending, data[i:]...)
+					return
+				}
+				i = end
+			default:
+				end := i + 1
+				for end < len(data) && data[end] >= 0x20 && data[end] <= 0x2f {
+					end++
+				}
+				if end >= len(data) {
+					self.pending = append(self.pending, data[i:]...)
+					return
+				}
+				if end == i+1 {
+					self.handle_esc(data[end])
+				}
+				i = end + 1
+			}
+			continue
+		case ch == '\r':
+			self.x = 0
+		case ch == '\n' || ch == 0x0b || ch == 0x0c:
+			self.linefeed()
+		case ch == '\b':
+			self.x = utils.Max(0, self.x-1)
+		case ch == '\t':
+			self.x = (self.x/self.tab_width + 1) * self.tab_width
+			self.clamp()
+		case ch < 0x20 || ch == 0x7f:
+		default:
+			if !utf8.FullRune(data[i:]) {
+				self.pending = append(self.pending, data[i:]...)
+				return
+			}
+			r, sz := utf8.DecodeRune(data[i:])
+			self.draw(r)
+			i += sz
+			continue
+		}
+		i++
+	}
+}
@@ -0,0 +1,52 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestPlainTextMirror(t *testing.T) {
+	lp := new_test_loop()
+	var out strings.Builder
+	lp.SetPlainTextMirror(&out)
+	lp.set_screen_size(12, 3)
+	check := func(expected string) {
+		t.Helper()
+		// the output is mirrored as it is sent
+		lp.output()
+		if out.String() != expected {
+			t.Fatalf("Unexpected mirrored text:\n%#v != %#v", expected, out.String())
+		}
+		out.Reset()
+	}
+
+	lp.QueueWriteString("\x1b[1;31mhello\x1b[m\r\nworld")
+	check("hello\nworld\n")
+	// positioned writes are placed in reading order, only changed rows are written
+	lp.StartAtomicUpdate()
+	lp.MoveCursorTo(1, 3)
+	lp.QueueWriteString("bottom")
+	lp.MoveCursorTo(7, 1)
+	lp.QueueWriteString("there")
+	check("")
+	lp.EndAtomicUpdate()
+	check("hello there\nbottom\n")
+	// redrawing the same content writes nothing
+	lp.StartAtomicUpdate()
+	lp.ClearScreen()
+	lp.QueueWriteString("hello\x1b]8;;http://x\x1b\\ there\x1b]8;;\x1b\\\r\nworld\r\nbottom")
+	lp.EndAtomicUpdate()
+	check("")
+	// scrolling, wrapping, wide characters and writes split inside escape codes
+	lp.QueueWriteString("\r\n\x1b[3")
+	lp.QueueWriteString("2m0123456789ab世")
+	check("0123456789ab\n世\n")
+	// erasing and save/restore of the cursor
+	lp.QueueWriteString("\x1b7\x1b[1;1H\x1b[2Kfirst\x1b8!")
+	check("first\n世!\n")
+}
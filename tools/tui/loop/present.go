@@ -0,0 +1,84 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+// Make the terminal show the contents of buffer, for double buffering, where
+// a frame is built in an off-screen Screen at any time and then shown all at
+// once. Only the cells that differ from what was shown by the last call are
+// sent, as a single atomic update, see StartAtomicUpdate(). If the size of
+// buffer does not match the screen, it is drawn at the top left corner,
+// clipped to the screen, and the rest of the screen is blanked. When the
+// screen size changes, the whole screen is redrawn. Afterwards, buffer
+// records the presented state, so that its own Flush() only sends later
+// changes. The loop keeps its own copy of the presented cells, so buffer can
+// be modified freely after this returns. The cursor position is undefined
+// afterwards. Drawing on the screen by other means makes the presented state
+// stale, call ClearScreen() afterwards to have the next call redraw
+// everything.
+func (self *Loop) Present(buffer *Screen) {
+	width, height := buffer.Size()
+	if sz, err := self.ScreenSize(); err == nil && sz.WidthCells > 0 {
+		width, height = int(sz.WidthCells), int(sz.HeightCells)
+	}
+	front := self.presented
+	if front == nil || front.width != width || front.height != height {
+		self.stop_pulses(false)
+		front = NewScreen(width, height)
+		self.presented = front
+	}
+	for y := 1; y <= height; y++ {
+		for x := 1; x <= width; x++ {
+			c := blank_cell
+			if x <= buffer.width && y <= buffer.height {
+				c = buffer.CellAt(x, y)
+				if buffer.overlay != nil {
+					c = buffer.overlay(x, y, c)
+				}
+				// dont leave half a wide character at the right edge
+				if x == width && width < buffer.width && c.Text != "" && wcswidth.Stringwidth(c.Text) > 1 {
+					c = blank_cell
+				}
+			}
+			front.cells[front.index(x, y)] = c
+		}
+	}
+	buffer.dirty, buffer.overlay_dirty = nil, nil
+	self.queue_presented_update(front.Flush())
+	if buffer.width == width && buffer.height == height {
+		copy(buffer.shown, front.shown)
+	} else {
+		buffer.Invalidate()
+	}
+}
+
+// Send an update of the presented screen as a single atomic update
+func (self *Loop) queue_presented_update(update string) {
+	if update == "" {
+		return
+	}
+	if !self.atomic_update_active {
+		self.StartAtomicUpdate()
+		defer self.EndAtomicUpdate()
+	}
+	self.QueueWriteString(update)
+	self.cursors.current.x, self.cursors.current.y = 0, 0
+	// the update ends by resetting the attributes
+	self.cursors.current.sgr, self.cursors.current.sgr_known = "", true
+}
+
+// Forget what was shown by Present(), so that the next call redraws the
+// whole screen
+func (self *Loop) invalidate_presented() {
+	self.stop_pulses(false)
+	if self.presented != nil {
+		self.presented.Invalidate()
+	}
+}
@@ -0,0 +1,53 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestPresent(t *testing.T) {
+	lp := new_test_loop()
+	lp.set_screen_size(4, 2)
+	present := func(buffer *Screen, expected string) {
+		t.Helper()
+		lp.output()
+		lp.Present(buffer)
+		if diff := cmp.Diff(expected, lp.normalized_output()); diff != "" {
+			t.Fatalf("Unexpected output:\n%s", diff)
+		}
+	}
+	buffer := NewScreen(4, 2)
+	buffer.WriteString(1, 1, "1", "ab")
+	present(buffer, "<set PENDING_UPDATE><move 1,1><SGR 0;1>ab<SGR 0>  <move 2,1>    <reset PENDING_UPDATE>")
+	// the buffer has adopted the presented state
+	if q := buffer.Flush(); q != "" {
+		t.Fatalf("Presented cells flushed again: %#v", q)
+	}
+	present(buffer, "")
+	buffer.WriteString(2, 2, "", "x")
+	present(buffer, "<set PENDING_UPDATE><move 2,2><SGR 0>x<reset PENDING_UPDATE>")
+
+	// a buffer of a different size is clipped and the rest of the screen blanked
+	other := NewScreen(5, 1)
+	other.WriteString(1, 1, "", "abc世")
+	present(other, "<set PENDING_UPDATE><move 1,1><SGR 0>abc<move 2,2> <reset PENDING_UPDATE>")
+	present(other, "")
+	if q := NormalizeTerminalOutput(other.Flush()); q != "<move 1,1><SGR 0>abc世" {
+		t.Fatalf("Mismatched buffer not invalidated: %#v", q)
+	}
+
+	// changes in the screen size or clearing it cause a full redraw
+	lp.screen_size.WidthCells = 3
+	present(buffer, "<set PENDING_UPDATE><move 1,1><SGR 0;1>ab<SGR 0> <move 2,1> x <reset PENDING_UPDATE>")
+	lp.ClearScreen()
+	present(buffer, "<set PENDING_UPDATE><move 1,1><SGR 0;1>ab<SGR 0> <move 2,1> x <reset PENDING_UPDATE>")
+	lp.StartAtomicUpdate()
+	buffer.WriteString(1, 1, "", "c")
+	present(buffer, "<move 1,1><SGR 0>c")
+}
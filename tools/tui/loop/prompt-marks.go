@@ -0,0 +1,150 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+const default_prompt_mark_history = 1000
+
+// The position of a shell prompt in content that contains OSC 133 shell
+// integration marks, see RecordPromptMarks()
+type PromptMark struct {
+	// The screen row (1-based) of the start of the prompt, rows less than
+	// one have scrolled off the top of the screen
+	Row int
+	// The exit code of the command run at this prompt, valid only when
+	// HasExitCode is true, that is, once the command has finished
+	ExitCode    int
+	HasExitCode bool
+}
+
+// Parse the payload of an OSC 133 escape code, returning the mark type, one
+// of A, B, C or D and its parameters
+func parse_prompt_mark(raw []byte) (kind byte, params []string, ok bool) {
+	payload, found := strings.CutPrefix(string(raw), "133;")
+	if !found || payload == "" {
+		return
+	}
+	parts := strings.Split(payload, ";")
+	if len(parts[0]) != 1 {
+		return
+	}
+	return parts[0][0], parts[1:], true
+}
+
+// Scan text that is being written starting at the specified screen row
+// (1-based) for OSC 133 prompt start (A) and command finished (D) marks,
+// recording the positions of prompts and the exit codes of their commands.
+// Rows are counted by newlines, so text must be wrapped to the screen width.
+// Secondary prompts, marked with k=s, are ignored. Recording a prompt at the
+// row of an existing one replaces it, so re-rendering content is harmless.
+func (self *Loop) RecordPromptMarks(text string, row int) {
+	p := wcswidth.EscapeCodeParser{}
+	p.HandleRune = func(ch rune) error {
+		if ch == '\n' {
+			row++
+		}
+		return nil
+	}
+	p.HandleOSC = func(raw []byte) error {
+		kind, params, ok := parse_prompt_mark(raw)
+		if !ok {
+			return nil
+		}
+		switch kind {
+		case 'A':
+			for _, x := range params {
+				if x == "k=s" {
+					return nil
+				}
+			}
+			self.add_prompt_mark(PromptMark{Row: row})
+		case 'D':
+			// the command finished belongs to the most recent prompt above
+			idx := sort.Search(len(self.prompt_marks), func(i int) bool { return self.prompt_marks[i].Row > row }) - 1
+			if idx > -1 && len(params) > 0 {
+				if code, err := strconv.Atoi(params[0]); err == nil {
+					self.prompt_marks[idx].ExitCode, self.prompt_marks[idx].HasExitCode = code, true
+				}
+			}
+		}
+		return nil
+	}
+	_ = p.ParseString(text)
+}
+
+func (self *Loop) add_prompt_mark(m PromptMark) {
+	idx := sort.Search(len(self.prompt_marks), func(i int) bool { return self.prompt_marks[i].Row >= m.Row })
+	if idx < len(self.prompt_marks) && self.prompt_marks[idx].Row == m.Row {
+		self.prompt_marks[idx] = m
+		return
+	}
+	self.prompt_marks = append(self.prompt_marks, PromptMark{})
+	copy(self.prompt_marks[idx+1:], self.prompt_marks[idx:])
+	self.prompt_marks[idx] = m
+	self.trim_prompt_marks()
+}
+
+func (self *Loop) trim_prompt_marks() {
+	limit := self.prompt_mark_history
+	if limit < 1 {
+		limit = default_prompt_mark_history
+	}
+	if extra := len(self.prompt_marks) - limit; extra > 0 {
+		// discard the oldest marks, which are at the top
+		self.prompt_marks = append(self.prompt_marks[:0], self.prompt_marks[extra:]...)
+	}
+}
+
+// Adjust the rows of the recorded prompt marks when content scrolls, positive
+// amounts scroll content up, towards the top of the screen
+func (self *Loop) ScrollPromptMarks(amount int) {
+	for i := range self.prompt_marks {
+		self.prompt_marks[i].Row -= amount
+	}
+}
+
+// The maximum number of prompt marks to remember, the oldest marks are
+// forgotten first. Defaults to 1000.
+func (self *Loop) SetPromptMarkHistory(limit int) {
+	self.prompt_mark_history = limit
+	self.trim_prompt_marks()
+}
+
+func (self *Loop) ClearPromptMarks() {
+	self.prompt_marks = nil
+}
+
+// The recorded prompt marks, ordered from top to bottom
+func (self *Loop) PromptMarks() []PromptMark {
+	return append([]PromptMark{}, self.prompt_marks...)
+}
+
+// The closest prompt mark above the specified row, useful to implement jump
+// to previous prompt
+func (self *Loop) PreviousPromptMark(row int) (PromptMark, bool) {
+	idx := sort.Search(len(self.prompt_marks), func(i int) bool { return self.prompt_marks[i].Row >= row }) - 1
+	if idx < 0 {
+		return PromptMark{}, false
+	}
+	return self.prompt_marks[idx], true
+}
+
+// The closest prompt mark below the specified row, useful to implement jump
+// to next prompt
+func (self *Loop) NextPromptMark(row int) (PromptMark, bool) {
+	idx := sort.Search(len(self.prompt_marks), func(i int) bool { return self.prompt_marks[i].Row > row })
+	if idx >= len(self.prompt_marks) {
+		return PromptMark{}, false
+	}
+	return self.prompt_marks[idx], true
+}
@@ -0,0 +1,42 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestPromptMarks(t *testing.T) {
+	lp, _ := New()
+	a := func(extra string) string { return "\x1b]133;A" + extra + "\x1b\\" }
+	d := func(code int) string { return fmt.Sprintf("\x1b]133;D;%d\x1b\\", code) }
+	lp.RecordPromptMarks(a("")+"$ ls\nfile\n"+d(0)+a("")+"$ false\n"+a(";k=s")+"> x\n"+d(1)+a("")+"$ ", 1)
+	expected := []PromptMark{{Row: 1, HasExitCode: true}, {Row: 3, ExitCode: 1, HasExitCode: true}, {Row: 5}}
+	if diff := cmp.Diff(expected, lp.PromptMarks()); diff != "" {
+		t.Fatalf("Unexpected prompt marks:\n%s", diff)
+	}
+	// re-rendering does not duplicate marks
+	lp.RecordPromptMarks(a("")+"$ ", 5)
+	if len(lp.PromptMarks()) != 3 {
+		t.Fatalf("Duplicate prompt marks: %v", lp.PromptMarks())
+	}
+	lp.ScrollPromptMarks(2)
+	if m, ok := lp.PreviousPromptMark(1); !ok || m.Row != -1 {
+		t.Fatalf("Unexpected previous prompt: %v %v", m, ok)
+	}
+	if m, ok := lp.NextPromptMark(1); !ok || m.Row != 3 {
+		t.Fatalf("Unexpected next prompt: %v %v", m, ok)
+	}
+	if _, ok := lp.NextPromptMark(3); ok {
+		t.Fatalf("Found a prompt below the last one")
+	}
+	lp.SetPromptMarkHistory(2)
+	if diff := cmp.Diff([]PromptMark{{Row: 1, ExitCode: 1, HasExitCode: true}, {Row: 3}}, lp.PromptMarks()); diff != "" {
+		t.Fatalf("Unexpected prompt marks after trimming:\n%s", diff)
+	}
+}
@@ -0,0 +1,142 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"time"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+type pulse struct {
+	top, left, bottom, right int // 1-based, inclusive
+	inverted                 bool
+	remaining_toggles        int
+	// drawn with DECCARA, as there is no presented screen to draw it from
+	use_deccara bool
+}
+
+// Change the SGR attributes of the cells in a rectangle (DECCARA), without
+// touching their contents
+func change_attributes_in_rectangle(top, left, bottom, right int, sgr string) string {
+	return fmt.Sprintf("\x1b[2*x\x1b[%d;%d;%d;%d;%s$r%s", top, left, bottom, right, sgr, DECSACE_DEFAULT_REGION_SELECT)
+}
+
+// The cell in reverse video, or out of it, if it is already in reverse video
+func invert_cell(c Cell) Cell {
+	var pen sgr_pen
+	known := true
+	pen.apply(c.SGR, &known)
+	sgr := "7"
+	if pen.reverse {
+		sgr = "27"
+	}
+	if c.SGR != "" {
+		sgr = c.SGR + ";" + sgr
+	}
+	return Cell{Text: c.Text, SGR: sgr}
+}
+
+// The overlay used for the presented screen while pulses are active
+func (self *Loop) pulse_overlay(x, y int, c Cell) Cell {
+	for _, p := range self.active_pulses {
+		if p.inverted && !p.use_deccara && p.top <= y && y <= p.bottom && p.left <= x && x <= p.right {
+			return invert_cell(c)
+		}
+	}
+	return c
+}
+
+// Redraw the region of the pulse from the presented screen
+func (self *Loop) redraw_pulse(p *pulse) {
+	if p.use_deccara {
+		sgr := "27"
+		if p.inverted {
+			sgr = "7"
+		}
+		self.QueueWriteString(change_attributes_in_rectangle(p.top, p.left, p.bottom, p.right, sgr))
+		return
+	}
+	s := self.presented
+	s.MarkDirty(p.top, p.left, p.bottom-p.top+1, p.right-p.left+1)
+	self.queue_presented_update(s.Flush())
+}
+
+func (self *Loop) toggle_pulse(p *pulse) {
+	p.inverted = !p.inverted
+	p.remaining_toggles--
+	self.redraw_pulse(p)
+}
+
+// Draw attention to a region of the screen by toggling reverse video in it
+// count times, at the specified interval. If Present() has been used, the
+// region is drawn from the cells it last showed and restored from them
+// afterwards. Otherwise, only the attributes of the cells are changed, so
+// cells in the region that were already in reverse video will not be after
+// the pulse finishes. top and left are 1-based as for MoveCursorTo. The pulse
+// is stopped if the presented screen is resized or invalidated, in which case
+// the region is left for the next Present() to redraw, and, with the region
+// restored, if the loop quits.
+func (self *Loop) PulseRegion(top, left, height, width int, count int, interval time.Duration) {
+	if self.timers == nil || count < 1 || top < 1 || left < 1 || height < 1 || width < 1 {
+		return
+	}
+	s := self.presented
+	bottom, right := top+height-1, left+width-1
+	if s != nil {
+		bottom, right = utils.Min(bottom, s.height), utils.Min(right, s.width)
+	} else if sz, err := self.ScreenSize(); err == nil {
+		bottom, right = utils.Min(bottom, int(sz.HeightCells)), utils.Min(right, int(sz.WidthCells))
+	}
+	if bottom < top || right < left {
+		return
+	}
+	p := &pulse{top: top, left: left, bottom: bottom, right: right, remaining_toggles: 2 * count, use_deccara: s == nil}
+	id, err := self.AddTimer(interval, true, func(timer_id IdType) error {
+		self.toggle_pulse(p)
+		if p.remaining_toggles < 1 {
+			self.RemoveTimer(timer_id)
+			delete(self.active_pulses, timer_id)
+			if len(self.active_pulses) == 0 && s != nil {
+				s.overlay = nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	if self.active_pulses == nil {
+		self.active_pulses = make(map[IdType]*pulse)
+	}
+	self.active_pulses[id] = p
+	if s != nil {
+		s.overlay = self.pulse_overlay
+	}
+	self.toggle_pulse(p)
+}
+
+// Stop all pulses. If restore is true the pulsed regions are redrawn as they
+// were presented, otherwise the inverted cells are left for the next
+// Present() to redraw, as they are still recorded as shown. Regions pulsed
+// without a presented screen are always restored, as nothing else redraws them.
+func (self *Loop) stop_pulses(restore bool) {
+	if len(self.active_pulses) == 0 {
+		return
+	}
+	pulses := self.active_pulses
+	self.active_pulses = nil
+	for id, p := range pulses {
+		self.RemoveTimer(id)
+		if (restore || p.use_deccara) && p.inverted {
+			p.inverted = false
+			self.redraw_pulse(p)
+		}
+	}
+	if self.presented != nil {
+		self.presented.overlay = nil
+	}
+}
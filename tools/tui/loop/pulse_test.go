@@ -0,0 +1,97 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestPulseRegion(t *testing.T) {
+	lp := new_test_loop()
+	lp.timers = make([]*timer, 0, 1)
+	lp.set_screen_size(6, 2)
+	tick := func() string {
+		if err := lp.dispatch_timers(time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		return lp.normalized_output()
+	}
+	// without a presented screen only the attributes are changed, with DECCARA
+	lp.PulseRegion(1, 1, 3, 2, 1, time.Millisecond)
+	if q := lp.output(); q != "\x1b[2*x\x1b[1;1;2;2;7$r\x1b[*x" {
+		t.Fatalf("Unexpected output starting a pulse without a presented screen: %#v", q)
+	}
+	if q := tick(); !strings.Contains(q, "1;1;2;2;27$r") {
+		t.Fatalf("Unexpected output ending a pulse without a presented screen: %#v", q)
+	}
+	if len(lp.active_pulses) != 0 || tick() != "" {
+		t.Fatalf("Pulse without a presented screen not stopped")
+	}
+	lp.PulseRegion(1, 1, 1, 1, 3, time.Millisecond)
+	lp.output()
+	lp.stop_pulses(false)
+	if q := lp.output(); q != "\x1b[2*x\x1b[1;1;1;1;27$r\x1b[*x" {
+		t.Fatalf("Pulse without a presented screen not restored when stopped: %#v", q)
+	}
+
+	s := NewScreen(6, 2)
+	s.WriteString(1, 1, "1", "ab")
+	s.WriteString(1, 2, "7", "cd")
+	lp.Present(s)
+	lp.output()
+	lp.PulseRegion(1, 1, 2, 1, 1, time.Millisecond)
+	inverted := "<set PENDING_UPDATE><move 1,1><SGR 0;1;7>a<move 2,1><SGR 0;7;27>c<SGR 0><reset PENDING_UPDATE>"
+	restored := "<set PENDING_UPDATE><move 1,1><SGR 0;1>a<move 2,1><SGR 0;7>c<SGR 0><reset PENDING_UPDATE>"
+	if diff := cmp.Diff(inverted, lp.normalized_output()); diff != "" {
+		t.Fatalf("Unexpected output starting a pulse:\n%s", diff)
+	}
+	// the cells of the presented screen are unchanged, so presenting the
+	// same buffer while pulsing keeps the region inverted
+	lp.Present(s)
+	if q := lp.output(); q != "" {
+		t.Fatalf("Presenting unchanged cells while pulsing redrew them: %#v", q)
+	}
+	if diff := cmp.Diff(restored, tick()); diff != "" {
+		t.Fatalf("Unexpected output ending a pulse:\n%s", diff)
+	}
+	if len(lp.active_pulses) != 0 || lp.presented.overlay != nil || tick() != "" {
+		t.Fatalf("Pulse not stopped")
+	}
+
+	// quitting restores the region
+	lp.PulseRegion(1, 1, 2, 1, 3, time.Millisecond)
+	lp.output()
+	lp.stop_pulses(true)
+	if diff := cmp.Diff(restored, lp.normalized_output()); diff != "" {
+		t.Fatalf("Unexpected output stopping a pulse:\n%s", diff)
+	}
+
+	// clearing the screen stops the pulse without drawing over the screen
+	lp.PulseRegion(1, 1, 2, 1, 3, time.Millisecond)
+	lp.output()
+	lp.ClearScreen()
+	lp.output()
+	if len(lp.active_pulses) != 0 || len(lp.timers) != 0 || tick() != "" {
+		t.Fatalf("Pulse not stopped by clearing the screen")
+	}
+
+	// an inverted region left by a stopped pulse is redrawn by Present()
+	lp.Present(s)
+	lp.PulseRegion(1, 1, 1, 1, 1, time.Millisecond)
+	lp.output()
+	lp.stop_pulses(false)
+	if q := lp.output(); q != "" {
+		t.Fatalf("Stopping a pulse without restoring drew: %#v", q)
+	}
+	lp.Present(s)
+	if diff := cmp.Diff("<set PENDING_UPDATE><move 1,1><SGR 0;1>a<SGR 0><reset PENDING_UPDATE>", lp.normalized_output()); diff != "" {
+		t.Fatalf("Unexpected output presenting after a stopped pulse:\n%s", diff)
+	}
+}
@@ -0,0 +1,322 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+var _ = fmt.Print
+
+// The state of queries to the terminal, see query_terminal()
+type query_state struct {
+	echoed, no_echo_detection bool
+	response_filter           func(EscapeCodeType, []byte) bool
+	deferred_input            []func() error
+	wait_for_responses        func(timeout time.Duration, done func() bool) error
+	responds                  *bool
+	dsr_probe_pending         bool
+	rune_filter               func(rune) bool
+	discard_runes_until_da1   bool
+}
+
+const DA1_QUERY = "\x1b[c"
+const default_query_timeout = 2 * time.Second
+const responds_to_queries_timeout = 250 * time.Millisecond
+
+// How much longer to wait for a slow terminal to answer, before deciding that
+// it does not respond to queries
+const responds_to_queries_retry_timeout = time.Second
+
+// Returned when the queries sent to the terminal are received back verbatim,
+// which typically means the tty is not actually connected to a terminal
+// emulator, for example a misconfigured pty or a pipe.
+var ErrQueryEchoed = errors.New("The query sent to the terminal was echoed back, the tty does not seem to be connected to a terminal emulator")
+
+func is_da1_response(etype EscapeCodeType, raw []byte) bool {
+	return etype == CSI && len(raw) > 1 && raw[0] == '?' && raw[len(raw)-1] == 'c'
+}
+
+func escape_code_as_bytes(etype EscapeCodeType, raw []byte) []byte {
+	prefix, suffix := "", "\x1b\\"
+	switch etype {
+	case CSI:
+		prefix, suffix = "\x1b[", ""
+	case DCS:
+		prefix = "\x1bP"
+	case OSC:
+		prefix = "\x1b]"
+	case APC:
+		prefix = "\x1b_"
+	case SOS:
+		prefix = "\x1bX"
+	case PM:
+		prefix = "\x1b^"
+	}
+	ans := make([]byte, 0, len(prefix)+len(raw)+len(suffix))
+	ans = append(ans, prefix...)
+	ans = append(ans, raw...)
+	return append(ans, suffix...)
+}
+
+// Called by the input handlers, returns true if the input was consumed
+// because a query is in flight. Input that is not a response to the query is
+// deferred and dispatched normally once the query completes.
+func (self *Loop) defer_while_querying(dispatch func() error) bool {
+	if self.query.response_filter == nil {
+		return false
+	}
+	self.query.deferred_input = append(self.query.deferred_input, dispatch)
+	return true
+}
+
+func (self *Loop) intercept_escape_code(etype EscapeCodeType, raw []byte, handler func([]byte) error) bool {
+	if self.query.response_filter == nil {
+		return false
+	}
+	if !self.query.response_filter(etype, raw) {
+		data := bytes.Clone(raw)
+		self.defer_while_querying(func() error { return handler(data) })
+	}
+	return true
+}
+
+func (self *Loop) dispatch_deferred_input() error {
+	for len(self.query.deferred_input) > 0 && self.query.response_filter == nil {
+		d := self.query.deferred_input[0]
+		self.query.deferred_input = self.query.deferred_input[1:]
+		if err := d(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send query to the terminal and wait for a response for which is_response
+// returns true. The query is followed by a DA1 query, which all terminals
+// answer, so terminals that do not understand the query are detected without
+// waiting for the timeout. Blocks the loop until the response is received or
+// timeout expires, all other input received in the meantime is dispatched
+// after this function returns. If the terminal does not answer queries at
+// all, returns immediately without sending anything.
+func (self *Loop) query_terminal(query string, timeout time.Duration, is_response func(EscapeCodeType, []byte) bool) (found bool, err error) {
+	if ok, err := self.can_query(); !ok {
+		return false, err
+	}
+	return self.send_query(query+DA1_QUERY, timeout, is_response, true)
+}
+
+func (self *Loop) can_query() (bool, error) {
+	if self.query.wait_for_responses == nil {
+		return false, fmt.Errorf("Cannot query the terminal before starting the run loop")
+	}
+	if self.is_dumb_terminal {
+		return false, nil
+	}
+	if self.query.echoed {
+		return false, ErrQueryEchoed
+	}
+	if !self.RespondsToQueries() {
+		if self.query.echoed {
+			return false, ErrQueryEchoed
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// Like query_terminal() except that query can contain several queries,
+// every escape code for which is_response returns true is consumed, till the
+// terminal answers the DA1 query that follows them. The DA1 response is also
+// passed to is_response. da1_received is false if the timeout expired first.
+func (self *Loop) query_terminal_batch(query string, timeout time.Duration, is_response func(EscapeCodeType, []byte) bool) (da1_received bool, err error) {
+	if ok, err := self.can_query(); !ok {
+		return false, err
+	}
+	query += DA1_QUERY
+	previous_filter := self.query.response_filter
+	self.query.response_filter = func(etype EscapeCodeType, raw []byte) bool {
+		if !self.query.no_echo_detection && is_echo_of(etype, raw, query) {
+			self.query.echoed = true
+			return true
+		}
+		if is_da1_response(etype, raw) {
+			da1_received = true
+			is_response(etype, raw)
+			return true
+		}
+		if is_response(etype, raw) {
+			return true
+		}
+		return previous_filter != nil && previous_filter(etype, raw)
+	}
+	defer func() { self.query.response_filter = previous_filter }()
+	if previous_filter == nil {
+		self.query_parser.Reset()
+	}
+	self.QueueWriteString(query)
+	err = self.query.wait_for_responses(timeout, func() bool { return da1_received || self.query.echoed })
+	if self.query.echoed {
+		return false, ErrQueryEchoed
+	}
+	return
+}
+
+func (self *Loop) send_query(query string, timeout time.Duration, is_response func(EscapeCodeType, []byte) bool, wait_for_da1 bool) (found bool, err error) {
+	da1_received := false
+	previous_filter := self.query.response_filter
+	self.query.response_filter = func(etype EscapeCodeType, raw []byte) bool {
+		if !self.query.no_echo_detection && !found && is_echo_of(etype, raw, query) {
+			self.query.echoed = true
+			return true
+		}
+		if !found && is_response(etype, raw) {
+			found = true
+			return true
+		}
+		if is_da1_response(etype, raw) {
+			da1_received = true
+			return true
+		}
+		return previous_filter != nil && previous_filter(etype, raw)
+	}
+	defer func() { self.query.response_filter = previous_filter }()
+	// an empty query waits for the answer to one that was already sent
+	if query != "" {
+		if previous_filter == nil {
+			self.query_parser.Reset()
+		}
+		self.QueueWriteString(query)
+	}
+	err = self.query.wait_for_responses(timeout, func() bool {
+		if wait_for_da1 {
+			return da1_received || self.query.echoed
+		}
+		return found || self.query.echoed
+	})
+	if self.query.echoed {
+		return false, ErrQueryEchoed
+	}
+	return
+}
+
+func is_dsr_status_response(etype EscapeCodeType, raw []byte) bool {
+	return etype == CSI && (string(raw) == "0n" || string(raw) == "3n")
+}
+
+// Send the device status report used by RespondsToQueries() before anything
+// else, so that its answer is usually in by the time the first query is made
+func (self *Loop) start_dsr_probe() {
+	if self.query.responds != nil || self.is_dumb_terminal {
+		return
+	}
+	self.QueueWriteString("\x1b[5n")
+	self.query.dsr_probe_pending = true
+}
+
+// Report whether the terminal answers queries at all. Some terminals accept
+// escape codes that set state but never respond to queries, which would make
+// every query wait for its timeout. This is detected once, with a device
+// status report sent when the loop starts, whose answer is waited for with a
+// short timeout the first time it is needed, after which all query based
+// detection is skipped for such terminals, falling back to conservative
+// defaults. A terminal that misses the short timeout, for
+// instance, over a slow link, is given longer to answer before being
+// considered unresponsive, and an answer that arrives even later still
+// marks it as responsive for subsequent queries.
+func (self *Loop) RespondsToQueries() bool {
+	if self.query.responds != nil {
+		return *self.query.responds
+	}
+	if self.query.wait_for_responses == nil || self.is_dumb_terminal {
+		return false
+	}
+	query := "\x1b[5n"
+	if self.query.dsr_probe_pending {
+		// wait for the answer to the probe sent at startup
+		query, self.query.dsr_probe_pending = "", false
+	}
+	found, err := self.send_query(query, responds_to_queries_timeout, is_dsr_status_response, false)
+	if !found && !self.query.echoed && errors.Is(err, os.ErrDeadlineExceeded) {
+		// keep waiting for the answer to the query already sent
+		found, err = self.send_query("", responds_to_queries_retry_timeout, is_dsr_status_response, false)
+	}
+	if found || self.query.echoed || errors.Is(err, os.ErrDeadlineExceeded) {
+		self.query.responds = &found
+	}
+	return found
+}
+
+// Called for device status reports that are not consumed by a query, returns
+// true if it is the answer to the probe sent at startup, arriving before
+// anything needed it, or the late answer to the query sent by
+// RespondsToQueries()
+func (self *Loop) handle_late_dsr_status(raw []byte) bool {
+	if self.query.echoed || !is_dsr_status_response(CSI, raw) {
+		return false
+	}
+	if self.query.dsr_probe_pending {
+		self.query.dsr_probe_pending = false
+	} else if r := self.query.responds; r == nil || *r {
+		return false
+	}
+	responds := true
+	self.query.responds = &responds
+	return true
+}
+
+// Heuristic check for whether a received escape code is our own query echoed
+// back instead of a response to it
+func is_echo_of(etype EscapeCodeType, raw []byte, query string) bool {
+	q := escape_code_as_bytes(etype, raw)
+	return string(q) == DA1_QUERY || (len(q) > 2 && bytes.Contains([]byte(query), q))
+}
+
+// Send an arbitrary query to the terminal and report whether a response for
+// which expectResponse returns true is received within timeout. expectResponse
+// is called with every escape code received while waiting, in full, using
+// 7-bit introducers and terminators. Use this to detect support for terminal
+// features not covered by the builtin detection. This blocks the loop till a
+// response is received, other input received in the meantime is buffered and
+// dispatched normally afterwards.
+//
+// Be careful with the queries you send: terminals that do not understand a
+// query may display it, or if it is mangled by something in between, it could
+// end up being interpreted as input by the program running in the terminal
+// once this one exits. Only send well formed escape codes.
+func (self *Loop) ProbeSequence(query string, expectResponse func([]byte) bool, timeout time.Duration) bool {
+	found, _ := self.query_terminal(query, timeout, func(etype EscapeCodeType, raw []byte) bool {
+		return expectResponse(escape_code_as_bytes(etype, raw))
+	})
+	return found
+}
+
+// Query the terminal for the currently active kitty keyboard protocol
+// enhancement flags. ok is false if the terminal does not support the
+// keyboard protocol. The result is cached till the loop itself changes the
+// keyboard mode, for instance, on suspend/resume.
+func (self *Loop) CurrentKeyboardFlags() (flags int, ok bool) {
+	if self.detected.keyboard_flags != nil {
+		return *self.detected.keyboard_flags, *self.detected.keyboard_flags > -1
+	}
+	flags = -1
+	found, err := self.query_terminal("\x1b[?u", default_query_timeout, func(etype EscapeCodeType, raw []byte) bool {
+		if etype != CSI || len(raw) < 3 || raw[0] != '?' || raw[len(raw)-1] != 'u' {
+			return false
+		}
+		n, err := strconv.Atoi(string(raw[1 : len(raw)-1]))
+		if err == nil {
+			flags = n
+		}
+		return err == nil
+	})
+	if err == nil || found {
+		self.detected.keyboard_flags = &flags
+	}
+	return flags, found
+}
@@ -0,0 +1,201 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"kitty/tools/wcswidth"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestRespondsToQueries(t *testing.T) {
+	var timeouts []time.Duration
+	// the terminal answers after the response is waited for the given number
+	// of times, never if negative
+	run := func(answer_after int) *test_loop {
+		lp := new_test_loop()
+		timeouts = nil
+		waits := 0
+		lp.query.wait_for_responses = func(timeout time.Duration, done func() bool) error {
+			timeouts = append(timeouts, timeout)
+			if waits++; waits == answer_after {
+				lp.query.response_filter(CSI, []byte("0n"))
+			}
+			if done() {
+				return nil
+			}
+			return os.ErrDeadlineExceeded
+		}
+		return lp
+	}
+
+	lp := run(1)
+	if !lp.RespondsToQueries() || len(timeouts) != 1 || timeouts[0] != responds_to_queries_timeout {
+		t.Fatalf("Prompt answer not detected: %v", timeouts)
+	}
+
+	// a slow terminal is given longer to answer the same query
+	lp = run(2)
+	if !lp.RespondsToQueries() || len(timeouts) != 2 || timeouts[1] != responds_to_queries_retry_timeout {
+		t.Fatalf("Slow answer not detected: %v", timeouts)
+	}
+	if q := lp.output(); q != "\x1b[5n" {
+		t.Fatalf("Unexpected queries sent: %#v", q)
+	}
+
+	// an unresponsive terminal is detected once
+	lp = run(-1)
+	if lp.RespondsToQueries() || lp.RespondsToQueries() || len(timeouts) != 2 {
+		t.Fatalf("Unresponsive terminal not detected: %v", timeouts)
+	}
+	// and an answer arriving even later is consumed and fixes the result
+	var escape_codes []string
+	lp.OnEscapeCode = func(etype EscapeCodeType, raw []byte) error {
+		escape_codes = append(escape_codes, string(raw))
+		return nil
+	}
+	for _, x := range []string{"0n", "0n"} {
+		if err := lp.handle_csi([]byte(x)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !lp.RespondsToQueries() || len(timeouts) != 2 || fmt.Sprint(escape_codes) != "[0n]" {
+		t.Fatalf("Late answer not handled: %v %v", timeouts, escape_codes)
+	}
+
+	// the probe sent at startup is not sent again and its answer, received
+	// before anything queries, is used without waiting
+	lp = run(-1)
+	lp.start_dsr_probe()
+	if q := lp.output(); q != "\x1b[5n" {
+		t.Fatalf("Unexpected probe sent: %#v", q)
+	}
+	if err := lp.dispatch_input_data([]byte("\x1b[0n")); err != nil {
+		t.Fatal(err)
+	}
+	if !lp.RespondsToQueries() || len(timeouts) != 0 || lp.output() != "" {
+		t.Fatalf("Answer to the probe not used: %v", timeouts)
+	}
+	// an answer to the probe arriving while querying is waited for
+	lp = run(1)
+	lp.start_dsr_probe()
+	lp.output()
+	if !lp.RespondsToQueries() || len(timeouts) != 1 || lp.output() != "" {
+		t.Fatalf("Answer to the probe not waited for: %v", timeouts)
+	}
+}
+
+func TestProbeSequence(t *testing.T) {
+	lp := new_test_loop()
+	if lp.ProbeSequence("\x1b[>q", func([]byte) bool { return true }, time.Second) {
+		t.Fatalf("Probe succeeded before the loop runs")
+	}
+	var answers []string
+	lp.answer_queries(func(string) []string { return answers })
+	var seen []string
+	probe := func(raw []byte) bool {
+		seen = append(seen, string(raw))
+		return string(raw) == "\x1bP1+r544e=6b\x1b\\"
+	}
+	answers = []string{"\x1b]11;rgb:0/0/0\x1b\\", "\x1bP1+r544e=6b\x1b\\"}
+	if !lp.ProbeSequence("\x1bP+q544e\x1b\\", probe, time.Second) {
+		t.Fatalf("Probe response not found")
+	}
+	// escape codes are passed in full
+	if diff := cmp.Diff(answers, seen); diff != "" {
+		t.Fatalf("Unexpected escape codes passed to the probe:\n%s", diff)
+	}
+	if q := lp.output(); q != "\x1bP+q544e\x1b\\\x1b[c" {
+		t.Fatalf("Unexpected probe sent: %#v", q)
+	}
+	// terminals that do not understand the probe answer only DA1
+	answers, seen = nil, nil
+	if lp.ProbeSequence("\x1b[?999$p", probe, time.Second) || len(seen) != 1 || seen[0] != "\x1b[?62c" {
+		t.Fatalf("Unexpected result for an unanswered probe: %#v", seen)
+	}
+}
+
+func TestQueryEchoDetection(t *testing.T) {
+	// a tty that echoes everything written to it
+	echoing_loop := func(options ...func(*Loop)) *test_loop {
+		lp := new_test_loop(options...)
+		lp.query.wait_for_responses = func(timeout time.Duration, done func() bool) error {
+			output := lp.unseen_output()
+			p := wcswidth.EscapeCodeParser{HandleCSI: func(raw []byte) error {
+				lp.query.response_filter(CSI, raw)
+				return nil
+			}}
+			_ = p.ParseString(output)
+			if output == "\x1b[5n" && !done() {
+				// the answer from the terminal
+				lp.query.response_filter(CSI, []byte("0n"))
+			}
+			if done() {
+				return nil
+			}
+			return os.ErrDeadlineExceeded
+		}
+		return lp
+	}
+	lp := echoing_loop()
+	if lp.RespondsToQueries() {
+		t.Fatalf("A tty that echoes queries responds to them")
+	}
+	if _, err := lp.query_terminal("\x1b[?u", time.Second, func(EscapeCodeType, []byte) bool { return true }); err != ErrQueryEchoed {
+		t.Fatalf("Unexpected error for a query to a tty that echoes queries: %v", err)
+	}
+	// later queries fail fast, without being sent
+	if q := lp.output(); q != "\x1b[5n" {
+		t.Fatalf("Unexpected queries sent to a tty that echoes queries: %#v", q)
+	}
+
+	lp = echoing_loop(NoEchoDetection)
+	if !lp.RespondsToQueries() {
+		t.Fatalf("Echo detected with echo detection turned off")
+	}
+	// the echoed DA1 query is not taken for a response
+	if found, err := lp.query_terminal("\x1b[?u", 0, func(EscapeCodeType, []byte) bool { return false }); found || err != os.ErrDeadlineExceeded {
+		t.Fatalf("Unexpected result of a query with echo detection turned off: %v %v", found, err)
+	}
+}
+
+func TestCurrentKeyboardFlags(t *testing.T) {
+	lp := new_test_loop()
+	answer := "\x1b[?31u"
+	queries := 0
+	lp.answer_queries(func(output string) []string {
+		queries++
+		if answer == "" {
+			return nil
+		}
+		return []string{answer}
+	})
+	if flags, ok := lp.CurrentKeyboardFlags(); !ok || flags != 31 {
+		t.Fatalf("Unexpected keyboard flags: %d %v", flags, ok)
+	}
+	// cached till the keyboard mode is changed by the loop
+	answer = "\x1b[?1u"
+	if flags, _ := lp.CurrentKeyboardFlags(); flags != 31 || queries != 1 {
+		t.Fatalf("Keyboard flags not cached: %d", flags)
+	}
+	lp.queue_setup_sequence()
+	if flags, ok := lp.CurrentKeyboardFlags(); !ok || flags != 1 || queries != 2 {
+		t.Fatalf("Keyboard flags not queried again after setup: %d", flags)
+	}
+	// terminals without the keyboard protocol answer only DA1
+	lp.detected.keyboard_flags = nil
+	answer = ""
+	if flags, ok := lp.CurrentKeyboardFlags(); ok || flags != -1 {
+		t.Fatalf("Unexpected keyboard flags for a terminal without the keyboard protocol: %d %v", flags, ok)
+	}
+	if _, ok := lp.CurrentKeyboardFlags(); ok || queries != 3 {
+		t.Fatalf("Lack of the keyboard protocol not cached")
+	}
+}
@@ -0,0 +1,95 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+// How often RCBatch() checks its context for cancellation while waiting
+const rc_batch_poll_interval = 50 * time.Millisecond
+
+// A remote control command, as for kitten @, for example, Name: "launch"
+// with Payload: map[string]any{"type": "window"}
+type RCCommand struct {
+	Name    string
+	Payload any
+}
+
+// The response from kitty to a remote control command
+type RCResponse struct {
+	Ok    bool   `json:"ok"`
+	Data  string `json:"data"`
+	Error string `json:"error"`
+}
+
+// Send several remote control commands to the kitty instance the program is
+// running in, in a single write, and wait for all their responses, which are
+// returned in the same order as the commands. Commands are run in order, each
+// one succeeds or fails independently, check the Ok field of each response.
+// The returned error is for failures of the batch as a whole: the terminal is
+// not kitty (ErrNotKitty), a response could not be parsed, or ctx is done
+// before all responses are received, in which case ctx.Err() is returned,
+// along with the responses received so far, the rest have Ok false. Use a ctx
+// with a deadline, as kitty does not respond if remote control is disabled.
+// Requires remote control to be enabled in kitty. This blocks the loop, other
+// input received in the meantime is dispatched normally afterwards.
+func (self *Loop) RCBatch(ctx context.Context, cmds []RCCommand) ([]RCResponse, error) {
+	responses := make([]RCResponse, len(cmds))
+	for i := range responses {
+		responses[i].Error = "No response received from kitty"
+	}
+	if err := self.check_can_send_rc_commands(); err != nil {
+		return responses, err
+	}
+	query := ""
+	for _, c := range cmds {
+		q, err := rc_command_escape_code(c.Name, c.Payload)
+		if err != nil {
+			return responses, err
+		}
+		query += q
+	}
+	received := 0
+	var parse_err error
+	previous_filter := self.query.response_filter
+	self.query.response_filter = func(etype EscapeCodeType, raw []byte) bool {
+		if received < len(responses) && is_rc_response(etype, raw) {
+			r, err := parse_rc_response(raw)
+			if err != nil && parse_err == nil {
+				parse_err = err
+			}
+			responses[received] = r
+			received++
+			return true
+		}
+		return previous_filter != nil && previous_filter(etype, raw)
+	}
+	defer func() { self.query.response_filter = previous_filter }()
+	if previous_filter == nil {
+		self.query_parser.Reset()
+	}
+	self.QueueWriteString(query)
+	for received < len(responses) {
+		if err := ctx.Err(); err != nil {
+			return responses, err
+		}
+		timeout := rc_batch_poll_interval
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = utils.Max(0, utils.Min(timeout, time.Until(deadline)))
+		}
+		err := self.query.wait_for_responses(timeout, func() bool { return received >= len(responses) })
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			return responses, err
+		}
+	}
+	return responses, parse_err
+}
@@ -0,0 +1,59 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestRCBatch(t *testing.T) {
+	t.Setenv("KITTY_WINDOW_ID", "1")
+	lp := new_test_loop()
+	var replies []string
+	lp.answer_queries(func(string) []string {
+		// answer one command per wait, as kitty may
+		if len(replies) == 0 {
+			return nil
+		}
+		r := replies[0]
+		replies = replies[1:]
+		return []string{"\x1bP@kitty-cmd" + r + "\x1b\\"}
+	})
+	cmds := []RCCommand{{Name: "launch", Payload: map[string]any{"type": "window"}}, {Name: "goto-layout"}, {Name: "ls"}}
+
+	replies = []string{`{"ok": true, "data": "7"}`, `{"ok": false, "error": "Unknown layout\n"}`, `{"ok": true}`}
+	responses, err := lp.RCBatch(context.Background(), cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]RCResponse{{Ok: true, Data: "7"}, {Error: "Unknown layout"}, {Ok: true}}, responses); diff != "" {
+		t.Fatalf("Unexpected responses:\n%s", diff)
+	}
+	sent := lp.pending_writes[len(lp.pending_writes)-1].str
+	if strings.Count(sent, "\x1bP@kitty-cmd") != 3 || !strings.Contains(sent, `"cmd":"goto-layout"`) {
+		t.Fatalf("Commands not sent in a single write: %#v", sent)
+	}
+	if lp.query.response_filter != nil {
+		t.Fatalf("Response filter not removed")
+	}
+
+	replies = []string{`{"ok": true, "data": "8"}`}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	responses, err = lp.RCBatch(ctx, cmds)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !responses[0].Ok || responses[0].Data != "8" || responses[1].Ok || responses[2].Ok {
+		t.Fatalf("Unexpected partial responses: %#v", responses)
+	}
+}
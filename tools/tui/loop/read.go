@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"golang.org/x/sys/unix"
 
@@ -16,13 +17,20 @@ import (
 var _ = fmt.Print
 
 func (self *Loop) dispatch_input_data(data []byte) error {
+	if self.interactive.boost > 0 {
+		self.interactive.until = time.Now().Add(self.interactive.boost)
+		self.interactive.queueing_writes = true
+		defer func() { self.interactive.queueing_writes = false }()
+	}
 	if self.OnReceivedData != nil {
 		err := self.OnReceivedData(data)
 		if err != nil {
 			return err
 		}
 	}
-	err := self.escape_code_parser.Parse(data)
+	// \r\n is a single press of Enter only when sent together
+	self.typed_after_cr = false
+	err := self.input_parser().Parse(data)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,212 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+var mode_names = map[Mode]string{
+	LNM: "LNM", IRM: "IRM", DECKM: "DECKM", DECCOLM: "DECCOLM", DECSCNM: "DECSCNM", DECOM: "DECOM", DECAWM: "DECAWM", DECARM: "DECARM",
+	DECTCEM: "DECTCEM", MOUSE_BUTTON_TRACKING: "MOUSE_BUTTON_TRACKING", MOUSE_MOTION_TRACKING: "MOUSE_MOTION_TRACKING",
+	MOUSE_MOVE_TRACKING: "MOUSE_MOVE_TRACKING", FOCUS_TRACKING: "FOCUS_TRACKING", MOUSE_UTF8_MODE: "MOUSE_UTF8_MODE",
+	MOUSE_SGR_MODE: "MOUSE_SGR_MODE", MOUSE_URXVT_MODE: "MOUSE_URXVT_MODE", MOUSE_SGR_PIXEL_MODE: "MOUSE_SGR_PIXEL_MODE",
+	ALT_SCREEN_NO_CLEAR: "ALT_SCREEN_NO_CLEAR", ALTERNATE_SCREEN: "ALTERNATE_SCREEN", BRACKETED_PASTE: "BRACKETED_PASTE",
+	PENDING_UPDATE: "PENDING_UPDATE",
+}
+
+var control_names = map[byte]string{
+	0x00: "NUL", 0x05: "ENQ", 0x07: "BEL", 0x08: "BS", '\t': "TAB", 0x0b: "VT", 0x0c: "FF", 0x7f: "DEL",
+}
+
+// Escape bytes that are not printable so that tokens stay on one line
+func escape_token_payload(raw string) string {
+	var sb strings.Builder
+	for _, ch := range raw {
+		if ch < 0x20 || ch == 0x7f || ch == '>' || ch == '\\' {
+			fmt.Fprintf(&sb, "\\x%02x", ch)
+		} else {
+			sb.WriteRune(ch)
+		}
+	}
+	return sb.String()
+}
+
+func csi_token(raw string) string {
+	if raw == "" {
+		return "<CSI>"
+	}
+	final := raw[len(raw)-1]
+	params := raw[:len(raw)-1]
+	num := func(s string) string {
+		if s == "" {
+			return "1"
+		}
+		return s
+	}
+	switch final {
+	case 'm':
+		if params == "" {
+			params = "0"
+		}
+		return "<SGR " + params + ">"
+	case 'H', 'f':
+		if !strings.ContainsAny(params, "?<>=") {
+			row, col, _ := strings.Cut(params, ";")
+			return fmt.Sprintf("<move %s,%s>", num(row), num(col))
+		}
+	case 'A', 'B', 'C', 'D':
+		if !strings.ContainsAny(params, "?<>=;") {
+			return fmt.Sprintf("<%s %s>", map[byte]string{'A': "up", 'B': "down", 'C': "right", 'D': "left"}[final], num(params))
+		}
+	case 'J', 'K':
+		if !strings.ContainsAny(params, "?<>=;") {
+			if params == "" {
+				params = "0"
+			}
+			return fmt.Sprintf("<erase %s %s>", map[byte]string{'J': "display", 'K': "line"}[final], params)
+		}
+	case 'h', 'l':
+		action := "set"
+		if final == 'l' {
+			action = "reset"
+		}
+		private_modes := strings.HasPrefix(params, "?")
+		var names []string
+		for _, x := range strings.Split(strings.TrimPrefix(params, "?"), ";") {
+			n, err := strconv.ParseUint(x, 10, 31)
+			if err != nil {
+				return "<CSI " + escape_token_payload(raw) + ">"
+			}
+			m := Mode(n)
+			if private_modes {
+				m |= private
+			}
+			if name, found := mode_names[m]; found {
+				names = append(names, name)
+			} else {
+				names = append(names, m.String())
+			}
+		}
+		return fmt.Sprintf("<%s %s>", action, strings.Join(names, " "))
+	}
+	return "<CSI " + escape_token_payload(raw) + ">"
+}
+
+func osc_token(raw string) string {
+	if rest, found := strings.CutPrefix(raw, "8;"); found {
+		// the params, such as id, are omitted as ids are random
+		if _, uri, found := strings.Cut(rest, ";"); found {
+			if uri == "" {
+				return "<end link>"
+			}
+			return "<link " + escape_token_payload(uri) + ">"
+		}
+	}
+	return "<OSC " + escape_token_payload(raw) + ">"
+}
+
+// Convert terminal output into a stable, readable text representation
+// suitable for comparison with golden files in tests. Text is kept as is,
+// with CRLF converted to newlines, and escape codes and control characters
+// are converted into tokens such as <move 1,1>, <SGR 1;31>, <set DECTCEM>,
+// <link uri>, <ESC 7>, <CSI raw>, <OSC raw>, <DCS raw> and <BEL>. Unrecognized
+// escape codes keep their raw contents, with unprintable bytes as \xNN, so
+// the original output can be reconstructed when debugging. A literal < in
+// text is written as <lt>.
+func NormalizeTerminalOutput(raw string) string {
+	var sb strings.Builder
+	string_terminated := func(start int) (payload string, end int) {
+		// terminated by ST or, for OSC, BEL
+		for i := start; i < len(raw); i++ {
+			if raw[i] == 0x07 {
+				return raw[start:i], i + 1
+			}
+			if raw[i] == 0x1b && i+1 < len(raw) && raw[i+1] == '\\' {
+				return raw[start:i], i + 2
+			}
+		}
+		return raw[start:], len(raw)
+	}
+	for i := 0; i < len(raw); {
+		ch := raw[i]
+		switch {
+		case ch == 0x1b && i+1 < len(raw):
+			switch raw[i+1] {
+			case '[':
+				end := i + 2
+				for end < len(raw) && (raw[end] < 0x40 || raw[end] > 0x7e) {
+					end++
+				}
+				end = utils.Min(end+1, len(raw))
+				sb.WriteString(csi_token(raw[i+2 : end]))
+				i = end
+			case ']':
+				payload, end := string_terminated(i + 2)
+				sb.WriteString(osc_token(payload))
+				i = end
+			case 'P', '_', '^', 'X':
+				payload, end := string_terminated(i + 2)
+				kind := map[byte]string{'P': "DCS", '_': "APC", '^': "PM", 'X': "SOS"}[raw[i+1]]
+				sb.WriteString("<" + kind + " " + escape_token_payload(payload) + ">")
+				i = end
+			default:
+				end := i + 1
+				for end < len(raw) && raw[end] >= 0x20 && raw[end] <= 0x2f {
+					end++
+				}
+				end = utils.Min(end+1, len(raw))
+				sb.WriteString("<ESC " + escape_token_payload(raw[i+1:end]) + ">")
+				i = end
+			}
+		case ch == '\r' && i+1 < len(raw) && raw[i+1] == '\n':
+			sb.WriteByte('\n')
+			i += 2
+		case ch == '\r':
+			sb.WriteString("<CR>")
+			i++
+		case ch == '\n':
+			sb.WriteString("<LF>\n")
+			i++
+		case ch == '<':
+			sb.WriteString("<lt>")
+			i++
+		case ch < 0x20 || ch == 0x7f:
+			if name, found := control_names[ch]; found {
+				sb.WriteString("<" + name + ">")
+			} else {
+				fmt.Fprintf(&sb, "<0x%02x>", ch)
+			}
+			i++
+		default:
+			sb.WriteByte(ch)
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// Run render and return the output it queues, normalized with
+// NormalizeTerminalOutput(), instead of sending it to the terminal. Useful to
+// test rendering code against golden snapshots without a terminal.
+func (self *Loop) RenderToString(render func()) string {
+	saved_writes, saved_bytes := self.pending_writes, self.backlog.pending_bytes
+	self.pending_writes, self.backlog.pending_bytes = nil, 0
+	defer func() { self.pending_writes, self.backlog.pending_bytes = saved_writes, saved_bytes }()
+	render()
+	var sb strings.Builder
+	for _, w := range self.pending_writes {
+		if w.bytes != nil {
+			sb.Write(w.bytes)
+		} else {
+			sb.WriteString(w.str)
+		}
+	}
+	return NormalizeTerminalOutput(sb.String())
+}
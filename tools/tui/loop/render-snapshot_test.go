@@ -0,0 +1,28 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestRenderToString(t *testing.T) {
+	lp, _ := New()
+	lp.QueueWriteString("before")
+	actual := lp.RenderToString(func() {
+		lp.MoveCursorTo(3, 2)
+		lp.QueueWriteString("\x1b[1;31mred\x1b[m a<b\r\nnext\rx\a")
+		lp.SetCursorVisible(false)
+		lp.QueueWriteString("\x1b7\x1b]8;id=x;https://k.org\x1b\\link\x1b]8;;\x1b\\\x1b]2;title\a\x1bP@x\x1b\\\x1b[5 q\x1b[2K\x1b[A")
+	})
+	expected := "<move 2,3><SGR 1;31>red<SGR 0> a<lt>b\nnext<CR>x<BEL><reset DECTCEM><ESC 7><link https://k.org>link<end link><OSC 2;title><DCS @x><CSI 5 q><erase line 2><up 1>"
+	if actual != expected {
+		t.Fatalf("Unexpected snapshot:\n%s\n!=\n%s", actual, expected)
+	}
+	if len(lp.pending_writes) != 1 || lp.pending_writes[0].str != "before" {
+		t.Fatalf("Queued output not restored")
+	}
+}
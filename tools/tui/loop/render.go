@@ -0,0 +1,61 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"time"
+)
+
+var _ = fmt.Print
+
+type render_state struct {
+	redraw_requested bool
+	max_fps          int
+	last_at          time.Time
+	timer            IdType
+}
+
+// Request that OnRender be called. Multiple requests are coalesced into a
+// single call of OnRender.
+func (self *Loop) RequestRedraw() {
+	self.render.redraw_requested = true
+}
+
+// Limit the number of times OnRender is called per second. Redraw requests
+// made before the next frame is due are coalesced and rendered once the
+// frame is due, so the latest state is always rendered, at the cost of up to
+// one frame of latency. This trades latency for throughput when the state
+// changes very frequently, for instance, with fast scrolling logs. Zero, the
+// default, means unlimited, rendering once per iteration of the loop.
+func (self *Loop) SetMaxFPS(fps int) {
+	if fps < 0 {
+		fps = 0
+	}
+	self.render.max_fps = fps
+}
+
+func (self *Loop) render_if_needed(now time.Time) (err error) {
+	if !self.render.redraw_requested || self.OnRender == nil || self.render.timer != 0 {
+		return nil
+	}
+	if self.render.max_fps > 0 {
+		next_frame_at := self.render.last_at.Add(time.Second / time.Duration(self.render.max_fps))
+		if now.Before(next_frame_at) {
+			self.render.timer, err = self.AddTimer(next_frame_at.Sub(now), false, func(IdType) error {
+				self.render.timer = 0
+				return self.render_if_needed(time.Now())
+			})
+			return err
+		}
+	}
+	self.render.redraw_requested = false
+	self.render.last_at = now
+	self.hide_cursor_for_update()
+	defer self.show_cursor_after_update()
+	if self.interactive.boost > 0 && now.Before(self.interactive.until) {
+		self.interactive.queueing_writes = true
+		defer func() { self.interactive.queueing_writes = false }()
+	}
+	return self.OnRender()
+}
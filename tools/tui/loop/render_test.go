@@ -0,0 +1,69 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var _ = fmt.Print
+
+func TestRender(t *testing.T) {
+	lp := new_test_loop()
+	lp.timers = make([]*timer, 0, 1)
+	renders := 0
+	lp.OnRender = func() error {
+		renders++
+		return nil
+	}
+	render := func(now time.Time, expected int) {
+		t.Helper()
+		if err := lp.render_if_needed(now); err != nil {
+			t.Fatal(err)
+		}
+		if renders != expected {
+			t.Fatalf("Rendered %d times instead of %d", renders, expected)
+		}
+	}
+	// the frames are in the past, so that timers are due immediately
+	start := time.Now().Add(-time.Second)
+	render(start, 0)
+	// requests are coalesced
+	lp.RequestRedraw()
+	lp.RequestRedraw()
+	render(start, 1)
+	render(start, 1)
+	lp.RequestRedraw()
+	render(start.Add(time.Millisecond), 2)
+
+	lp.SetMaxFPS(10)
+	lp.RequestRedraw()
+	render(start.Add(50*time.Millisecond), 2)
+	if lp.render.timer == 0 || !lp.render.redraw_requested {
+		t.Fatalf("Rendering of a redraw requested before the next frame is due not scheduled")
+	}
+	// further requests and iterations of the loop wait for the frame
+	lp.RequestRedraw()
+	render(start.Add(60*time.Millisecond), 2)
+	if err := lp.dispatch_timers(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if renders != 3 || lp.render.timer != 0 || lp.render.redraw_requested || len(lp.timers) != 0 {
+		t.Fatalf("Scheduled frame not rendered: %d", renders)
+	}
+	// once the frame is due, rendering is immediate
+	lp.RequestRedraw()
+	render(time.Now().Add(time.Second), 4)
+
+	lp.SetMaxFPS(-1)
+	if lp.render.max_fps != 0 {
+		t.Fatalf("Negative frame rate not treated as unlimited: %d", lp.render.max_fps)
+	}
+	lp.OnRender = func() error { return fmt.Errorf("failed") }
+	lp.RequestRedraw()
+	if err := lp.render_if_needed(time.Now()); err == nil || err.Error() != "failed" {
+		t.Fatalf("Error from OnRender not returned: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+var _ = fmt.Print
+
+type replay_state struct {
+	ignore_tty_input bool
+	active           int
+}
+
+type replay_event struct {
+	at   time.Duration
+	data []byte
+}
+
+// Parse the input events from an asciicast v2 recording
+func parse_asciicast_input(r io.Reader) (ans []replay_event, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	first := true
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if first {
+			first = false
+			if line[0] == '{' { // header
+				continue
+			}
+		}
+		var ev []any
+		if err = json.Unmarshal(line, &ev); err != nil {
+			return nil, fmt.Errorf("Invalid asciicast event: %#v with error: %w", string(line), err)
+		}
+		if len(ev) < 3 {
+			return nil, fmt.Errorf("Invalid asciicast event: %#v", string(line))
+		}
+		t, tok := ev[0].(float64)
+		code, cok := ev[1].(string)
+		data, dok := ev[2].(string)
+		if !tok || !cok || !dok {
+			return nil, fmt.Errorf("Invalid asciicast event: %#v", string(line))
+		}
+		if code == "i" {
+			ans = append(ans, replay_event{at: time.Duration(t * float64(time.Second)), data: []byte(data)})
+		}
+	}
+	return ans, scanner.Err()
+}
+
+// Have the loop ignore input from the terminal while ReplayInput() is
+// replaying recorded input, instead of merging the two
+func (self *Loop) IgnoreTTYInputWhileReplaying() *Loop {
+	self.replay.ignore_tty_input = true
+	return self
+}
+
+func IgnoreTTYInputWhileReplaying(self *Loop) {
+	self.replay.ignore_tty_input = true
+}
+
+func (self *Loop) is_replaying() bool { return self.replay.active > 0 }
+
+// Feed recorded input to the loop as if it was received from the terminal, to
+// reproduce bugs or test the UI. When timing is false, r must contain the raw
+// bytes received from the terminal and they are all fed in the next iteration
+// of the loop. When timing is true, r must contain an asciicast v2 recording
+// and its input events are fed with the recorded delays between them. Output
+// events in the recording are ignored. Input from the terminal continues to be
+// processed as well, unless IgnoreTTYInputWhileReplaying() is used.
+func (self *Loop) ReplayInput(r io.Reader, timing bool) error {
+	if self.timers == nil {
+		return fmt.Errorf("Cannot replay input before starting the run loop")
+	}
+	var events []replay_event
+	if timing {
+		var err error
+		if events, err = parse_asciicast_input(r); err != nil {
+			return err
+		}
+	} else {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		events = append(events, replay_event{data: data})
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	start := time.Now()
+	var schedule func() error
+	schedule = func() error {
+		_, err := self.AddTimer(time.Until(start.Add(events[0].at)), false, func(IdType) error {
+			ev := events[0]
+			events = events[1:]
+			if len(events) > 0 {
+				if err := schedule(); err != nil {
+					return err
+				}
+			} else {
+				self.replay.active--
+			}
+			return self.dispatch_input_data(ev.data)
+		})
+		return err
+	}
+	if err := schedule(); err != nil {
+		return err
+	}
+	self.replay.active++
+	return nil
+}
@@ -0,0 +1,89 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestParseAsciicast(t *testing.T) {
+	events, err := parse_asciicast_input(strings.NewReader(`{"version": 2, "width": 80, "height": 24}
+[0.5, "o", "ignored"]
+[1.25, "i", "a\u001b[A"]
+
+[2, "i", "b"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []replay_event{{at: 1250 * time.Millisecond, data: []byte("a\x1b[A")}, {at: 2 * time.Second, data: []byte("b")}}
+	if diff := cmp.Diff(expected, events, cmp.AllowUnexported(replay_event{})); diff != "" {
+		t.Fatalf("Unexpected events:\n%s", diff)
+	}
+	for _, bad := range []string{`[1, "i"]`, `[1, "i", 3]`, `["1", "i", "a"]`, `{"version": 2}` + "\n" + `{"a": 1}`, `not json`} {
+		if _, err := parse_asciicast_input(strings.NewReader(bad)); err == nil {
+			t.Fatalf("No error for invalid recording: %#v", bad)
+		}
+	}
+}
+
+func TestReplayInput(t *testing.T) {
+	lp := new_test_loop()
+	if err := lp.ReplayInput(strings.NewReader("a"), false); err == nil {
+		t.Fatalf("Replaying before the loop runs did not fail")
+	}
+	lp.timers = make([]*timer, 0, 1)
+	var received []string
+	lp.OnText = func(text string, from_key_event, in_bracketed_paste bool) error {
+		received = append(received, text)
+		return nil
+	}
+	lp.OnKeyEvent = func(ev *KeyEvent) error {
+		received = append(received, ev.String())
+		return nil
+	}
+	tick := func() {
+		t.Helper()
+		if err := lp.dispatch_timers(time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// raw input is fed all at once
+	if err := lp.ReplayInput(strings.NewReader("ab\x1b[A"), false); err != nil {
+		t.Fatal(err)
+	}
+	if !lp.is_replaying() || received != nil {
+		t.Fatalf("Input fed before the next iteration of the loop")
+	}
+	tick()
+	if lp.is_replaying() || fmt.Sprint(received) != "[a b PRESS{ UP }]" {
+		t.Fatalf("Unexpected input after replaying: %#v", received)
+	}
+
+	// recorded input is fed one event at a time
+	received = nil
+	if err := lp.ReplayInput(strings.NewReader("[0, \"i\", \"x\"]\n[0.001, \"o\", \"out\"]\n[0.002, \"i\", \"y\"]\n"), true); err != nil {
+		t.Fatal(err)
+	}
+	tick()
+	if !lp.is_replaying() || fmt.Sprint(received) != "[x]" {
+		t.Fatalf("Unexpected input after the first event: %#v", received)
+	}
+	tick()
+	if lp.is_replaying() || fmt.Sprint(received) != "[x y]" || len(lp.timers) != 0 {
+		t.Fatalf("Unexpected input after the last event: %#v", received)
+	}
+
+	// a recording without input does nothing
+	if err := lp.ReplayInput(strings.NewReader("{}\n[0, \"o\", \"out\"]\n"), true); err != nil || lp.is_replaying() {
+		t.Fatalf("Replaying a recording without input failed: %v", err)
+	}
+}
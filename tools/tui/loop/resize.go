@@ -0,0 +1,81 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"time"
+)
+
+var _ = fmt.Print
+
+type resize_state struct {
+	debounce, pixel_debounce time.Duration
+	pending                  bool
+	size_before              ScreenSize
+}
+
+const resize_debounce_key = "loop-resize"
+
+// Wait until the terminal has not been resized for d before calling
+// OnResize, when the number of rows or columns changes. Useful to avoid
+// re-laying out repeatedly while the user drags the window border. Zero, the
+// default, calls OnResize immediately. See also SetPixelResizeDebounce().
+func (self *Loop) SetResizeDebounce(d time.Duration) {
+	self.resize.debounce = d
+}
+
+// Wait until the terminal has not been resized for d before calling
+// OnResize, when only the size in pixels changes, as happens while changing
+// the font size. Graphics applications can use this to avoid repeatedly
+// re-rendering images while the user zooms, while still reacting immediately
+// to changes in the number of cells, see SetResizeDebounce(). Zero, the
+// default, calls OnResize immediately. OnResize is always called with the
+// final size once the resizing stops and its old size argument is the size
+// at the previous call, so no change is missed.
+func (self *Loop) SetPixelResizeDebounce(d time.Duration) {
+	self.resize.pixel_debounce = d
+}
+
+// Called with the updated screen size when the terminal is resized
+func (self *Loop) on_resize() error {
+	if self.OnResizeImmediate != nil {
+		self.OnResizeImmediate(self.screen_size)
+	}
+	if self.OnResize != nil {
+		return self.dispatch_resize()
+	}
+	if self.status_line != nil {
+		self.status_line.Refresh()
+	}
+	return nil
+}
+
+func (self *Loop) dispatch_resize() error {
+	old, current := self.resize.size_before, self.screen_size
+	delay := self.resize.debounce
+	if old.WidthCells == current.WidthCells && old.HeightCells == current.HeightCells {
+		delay = self.resize.pixel_debounce
+	}
+	if delay <= 0 || self.timers == nil {
+		// any pending pixel only change is superseded by this one
+		self.cancel_debounce(resize_debounce_key)
+		return self.deliver_resize()
+	}
+	self.resize.pending = true
+	self.Debounce(resize_debounce_key, delay, self.deliver_resize)
+	return nil
+}
+
+func (self *Loop) deliver_resize() error {
+	self.resize.pending = false
+	if self.OnResize != nil {
+		if err := self.OnResize(self.resize.size_before, self.screen_size); err != nil {
+			return err
+		}
+	}
+	if self.status_line != nil {
+		self.status_line.Refresh()
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var _ = fmt.Print
+
+func TestPixelResizeDebounce(t *testing.T) {
+	lp, _ := New()
+	lp.timers = make([]*timer, 0, 1)
+	lp.SetPixelResizeDebounce(100 * time.Millisecond)
+	var calls []string
+	lp.OnResize = func(old, new ScreenSize) error {
+		calls = append(calls, fmt.Sprintf("%dx%d@%d->%dx%d@%d", old.WidthCells, old.HeightCells, old.WidthPx, new.WidthCells, new.HeightCells, new.WidthPx))
+		return nil
+	}
+	resize := func(cells, px uint) {
+		// what on_SIGWINCH does, without a terminal
+		if !lp.resize.pending {
+			lp.resize.size_before = lp.screen_size
+		}
+		lp.screen_size = ScreenSize{WidthCells: cells, HeightCells: 10, WidthPx: px, HeightPx: 100}
+		if err := lp.dispatch_resize(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		if err := lp.dispatch_timers(now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(expected ...string) {
+		t.Helper()
+		if fmt.Sprint(calls) != fmt.Sprint(expected) {
+			t.Fatalf("Expected resizes: %v got: %v", expected, calls)
+		}
+		calls = nil
+	}
+	lp.screen_size = ScreenSize{WidthCells: 10, HeightCells: 10, WidthPx: 100, HeightPx: 100}
+	resize(10, 105)
+	resize(10, 109)
+	check()
+	advance(time.Second)
+	check("10x10@100->10x10@109")
+	// cell count changes are delivered immediately and include pending pixel changes
+	resize(10, 103)
+	resize(11, 110)
+	check("10x10@109->11x10@110")
+	advance(time.Second)
+	check()
+	lp.SetResizeDebounce(50 * time.Millisecond)
+	resize(12, 120)
+	resize(12, 122)
+	advance(time.Second)
+	check("11x10@110->12x10@122")
+}
+
+func TestResizeImmediate(t *testing.T) {
+	lp, _ := New()
+	lp.timers = make([]*timer, 0, 1)
+	lp.SetResizeDebounce(100 * time.Millisecond)
+	var immediate, debounced []uint
+	lp.OnResizeImmediate = func(new_size ScreenSize) {
+		sz, err := lp.ScreenSize()
+		if err != nil || sz != new_size {
+			t.Fatalf("ScreenSize() inconsistent in OnResizeImmediate: %v != %v (%v)", sz, new_size, err)
+		}
+		immediate = append(immediate, new_size.WidthCells)
+	}
+	lp.OnResize = func(old, new ScreenSize) error {
+		debounced = append(debounced, new.WidthCells)
+		return nil
+	}
+	for _, w := range []uint{11, 12, 13} {
+		// what on_SIGWINCH does, without a terminal
+		if !lp.resize.pending {
+			lp.resize.size_before = lp.screen_size
+		}
+		lp.screen_size = ScreenSize{WidthCells: w, HeightCells: 10, updated: true}
+		if err := lp.on_resize(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(immediate) != "[11 12 13]" || len(debounced) != 0 {
+		t.Fatalf("Unexpected resizes: %v %v", immediate, debounced)
+	}
+	if err := lp.dispatch_timers(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(debounced) != "[13]" {
+		t.Fatalf("Unexpected debounced resizes: %v", debounced)
+	}
+}
@@ -0,0 +1,245 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"kitty/tools/utils"
+	"kitty/tools/utils/style"
+)
+
+var _ = fmt.Print
+
+type rich_text_span struct {
+	text, style, uri string
+}
+
+type rich_text_frame struct {
+	closer, style string
+	// for links, the index of the first span of the link text
+	first_span int
+}
+
+// Parse the markup used by RenderRichText() into spans of uniformly styled
+// text
+func parse_rich_text(markup string) (ans []rich_text_span) {
+	var stack []rich_text_frame
+	var text strings.Builder
+	current_style := func() string {
+		styles := make([]string, 0, len(stack))
+		for _, f := range stack {
+			if f.style != "" {
+				styles = append(styles, f.style)
+			}
+		}
+		return strings.Join(styles, " ")
+	}
+	flush := func() {
+		if text.Len() > 0 {
+			ans = append(ans, rich_text_span{text: text.String(), style: current_style()})
+			text.Reset()
+		}
+	}
+	top := func() string {
+		if len(stack) > 0 {
+			return stack[len(stack)-1].closer
+		}
+		return ""
+	}
+	push := func(closer, style string) {
+		flush()
+		stack = append(stack, rich_text_frame{closer: closer, style: style, first_span: len(ans)})
+	}
+	pop := func() rich_text_frame {
+		flush()
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return f
+	}
+	for i := 0; i < len(markup); i++ {
+		ch := markup[i]
+		switch {
+		case ch == '\\' && i+1 < len(markup):
+			i++
+			text.WriteByte(markup[i])
+		case ch == '*' && top() == "*" && strings.HasPrefix(markup[i:], "***"):
+			// close italic before bold, for example: **bold *both***
+			pop()
+		case strings.HasPrefix(markup[i:], "**"):
+			i++
+			if top() == "**" {
+				pop()
+			} else {
+				push("**", "bold")
+			}
+		case ch == '*':
+			if top() == "*" {
+				pop()
+			} else {
+				push("*", "italic")
+			}
+		case ch == '{':
+			spec, _, found := strings.Cut(markup[i+1:], ":")
+			if !found || strings.ContainsAny(spec, "{}") {
+				text.WriteByte(ch)
+				break
+			}
+			push("}", spec)
+			i += len(spec) + 1
+		case ch == '}' && top() == "}":
+			pop()
+		case ch == '[':
+			push("]", "")
+		case ch == ']' && top() == "]":
+			uri, _, found := strings.Cut(markup[i+1:], ")")
+			f := pop()
+			if found && strings.HasPrefix(uri, "(") {
+				i += len(uri) + 1
+				for j := f.first_span; j < len(ans); j++ {
+					ans[j].uri = uri[1:]
+				}
+			} else {
+				// not a link, so keep the brackets as text
+				ans = append(ans[:f.first_span], append([]rich_text_span{{text: "[", style: current_style()}}, ans[f.first_span:]...)...)
+				text.WriteByte(']')
+			}
+		default:
+			text.WriteByte(ch)
+		}
+	}
+	flush()
+	return
+}
+
+// Remove colors from a style spec that cannot be displayed with the
+// specified number of colors
+func degrade_colors(spec string, color_count int) string {
+	parts := strings.Fields(spec)
+	ans := parts[:0]
+	for _, p := range parts {
+		key, val, found := strings.Cut(p, "=")
+		if found {
+			switch key {
+			case "fg", "bg", "uc", "ucol", "underline_color":
+				required := 8
+				if strings.HasPrefix(val, "#") || strings.HasPrefix(val, "rgb:") {
+					required = TRUECOLOR_COLOR_COUNT
+				} else if n, err := strconv.Atoi(val); err == nil {
+					switch {
+					case n >= 16:
+						required = 256
+					case n >= 8:
+						required = 16
+					}
+				}
+				if required > color_count {
+					continue
+				}
+			}
+		}
+		ans = append(ans, p)
+	}
+	return strings.Join(ans, " ")
+}
+
+// Terminals known to support OSC 8 hyperlinks, keyed by the prefix of their
+// XTVERSION response
+var terminals_with_hyperlinks = []string{"kitty(", "foot(", "WezTerm "}
+
+func (self *Loop) supports_hyperlinks() bool {
+	if self.is_dumb_terminal {
+		return false
+	}
+	switch os.Getenv("TERM_PROGRAM") {
+	case "iTerm.app", "WezTerm":
+		return true
+	}
+	if v, err := strconv.Atoi(os.Getenv("VTE_VERSION")); err == nil && v >= 5000 {
+		return true
+	}
+	if self.is_kitty() {
+		return true
+	}
+	v := self.terminal_version()
+	for _, q := range terminals_with_hyperlinks {
+		if strings.HasPrefix(v, q) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *Loop) render_rich_text(markup string, width, color_count int, hyperlinks bool) []string {
+	spans := parse_rich_text(markup)
+	var sb strings.Builder
+	for i, s := range spans {
+		text := s.text
+		if spec := degrade_colors(s.style, color_count); spec != "" {
+			text = self.SprintStyled(spec, text)
+		}
+		if s.uri != "" {
+			if hyperlinks {
+				text = self.SprintHyperlink(s.uri, "", text)
+			} else if i+1 >= len(spans) || spans[i+1].uri != s.uri {
+				// show the URI after the last span of the link text, unless
+				// the text is the URI
+				if link_text := link_text_ending_at(spans, i); link_text != s.uri {
+					text += " (" + s.uri + ")"
+				}
+			}
+		}
+		sb.WriteString(text)
+	}
+	return style.WrapStyledText(sb.String(), width)
+}
+
+func link_text_ending_at(spans []rich_text_span, i int) string {
+	start := i
+	for start > 0 && spans[start-1].uri == spans[i].uri {
+		start--
+	}
+	var sb strings.Builder
+	for _, s := range spans[start : i+1] {
+		sb.WriteString(s.text)
+	}
+	return sb.String()
+}
+
+// Render text containing lightweight markup, wrapped to width, with the top
+// left corner at the specified row and column (1-based, as for MoveCursorTo).
+// Returns the number of rows used. Output is clipped to the screen. The
+// markup is:
+//
+//	**bold**
+//	*italic*
+//	{style:text} where style is a style spec as for SprintStyled(), for example, {fg=red bold:text}
+//	[text](uri) a hyperlink
+//	\ the next character is used as is, for example, \* for a literal *
+//
+// Spans can be nested, spans that are not closed extend to the end of the
+// text. Newlines start new paragraphs. Colors the terminal cannot display are
+// dropped and when the terminal does not support hyperlinks, the URI is shown
+// after the link text.
+func (self *Loop) RenderRichText(markup string, top, left, width int) int {
+	if top < 1 || left < 1 {
+		return 0
+	}
+	height := -1
+	if sz, err := self.ScreenSize(); err == nil {
+		width = utils.Min(width, int(sz.WidthCells)-left+1)
+		height = int(sz.HeightCells) - top + 1
+	}
+	lines := self.render_rich_text(markup, width, self.ColorCount(), self.supports_hyperlinks())
+	if height > -1 && len(lines) > height {
+		lines = lines[:height]
+	}
+	for i, line := range lines {
+		self.MoveCursorTo(left, top+i)
+		self.QueueWriteString(line)
+	}
+	return len(lines)
+}
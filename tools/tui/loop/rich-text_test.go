@@ -0,0 +1,64 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+func TestRichText(t *testing.T) {
+	type s = rich_text_span
+	for markup, expected := range map[string][]s{
+		"plain":                       {{text: "plain"}},
+		"a **b *c*** d":               {{text: "a "}, {text: "b ", style: "bold"}, {text: "c", style: "bold italic"}, {text: " d"}},
+		"{fg=red:x **y**}z":           {{text: "x ", style: "fg=red"}, {text: "y", style: "fg=red bold"}, {text: "z"}},
+		"see [the *docs*](https://a)": {{text: "see "}, {text: "the ", uri: "https://a"}, {text: "docs", style: "italic", uri: "https://a"}},
+		"*[x] y*":                     {{text: "[", style: "italic"}, {text: "x", style: "italic"}, {text: "] y", style: "italic"}},
+		`2\*3 {a}`:                    {{text: "2*3 {a}"}},
+		"**unclosed":                  {{text: "unclosed", style: "bold"}},
+	} {
+		if diff := cmp.Diff(expected, parse_rich_text(markup), cmp.AllowUnexported(s{})); diff != "" {
+			t.Fatalf("Failed to parse %#v:\n%s", markup, diff)
+		}
+	}
+
+	for spec, expected := range map[string][2]string{
+		"fg=red bold":           {"fg=red bold", "fg=red bold"},
+		"fg=#ff0000 bg=200 i":   {"i", "bg=200 i"},
+		"fg=12 uc=rgb:ff/00/00": {"", "fg=12"},
+	} {
+		if actual := degrade_colors(spec, 8); actual != expected[0] {
+			t.Fatalf("Failed to degrade %#v to 8 colors: %#v", spec, actual)
+		}
+		if actual := degrade_colors(spec, 256); actual != expected[1] {
+			t.Fatalf("Failed to degrade %#v to 256 colors: %#v", spec, actual)
+		}
+	}
+
+	lp, _ := New()
+	lp.hyperlinks.id_prefix = "p"
+	plain := func(lines []string) (ans []string) {
+		for _, l := range lines {
+			ans = append(ans, wcswidth.StripEscapeCodes(l))
+		}
+		return
+	}
+	lines := lp.render_rich_text("one [two three](https://x) four", 12, 256, false)
+	if diff := cmp.Diff([]string{"one two ", "three ", "(https://x) ", "four"}, plain(lines)); diff != "" {
+		t.Fatalf("Unexpected rendering without hyperlinks:\n%s", diff)
+	}
+	lines = lp.render_rich_text("one [two three](https://x) four", 12, 256, true)
+	if diff := cmp.Diff([]string{"one two ", "three four"}, plain(lines)); diff != "" {
+		t.Fatalf("Unexpected rendering with hyperlinks:\n%s", diff)
+	}
+	if lines[1] != "\x1b]8;id=p-1;https://x\x1b\\three\x1b]8;;\x1b\\ four" {
+		t.Fatalf("Hyperlink not preserved across wrap: %#v", lines)
+	}
+}
@@ -0,0 +1,218 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+type bidi_class uint8
+
+const (
+	bidi_neutral bidi_class = iota
+	bidi_ltr
+	bidi_rtl
+	bidi_number
+	// separators such as . and , which are part of a number when between digits
+	bidi_number_separator
+	bidi_whitespace
+)
+
+var rtl_scripts = []*unicode.RangeTable{unicode.Hebrew, unicode.Arabic, unicode.Syriac, unicode.Thaana, unicode.Nko, unicode.Samaritan, unicode.Mandaic}
+
+func bidi_class_of(ch rune) bidi_class {
+	switch {
+	case unicode.IsDigit(ch):
+		return bidi_number
+	case unicode.In(ch, rtl_scripts...):
+		return bidi_rtl
+	case unicode.IsLetter(ch):
+		return bidi_ltr
+	case unicode.IsSpace(ch):
+		return bidi_whitespace
+	case strings.ContainsRune(".,:/+-", ch):
+		return bidi_number_separator
+	}
+	return bidi_neutral
+}
+
+var bidi_mirrors = map[rune]rune{'(': ')', ')': '(', '[': ']', ']': '[', '{': '}', '}': '{', '<': '>', '>': '<', '«': '»', '»': '«'}
+
+// The text of a right-to-left paragraph laid out for display
+type RTLLayout struct {
+	// The text in the order it is displayed, left to right
+	Visual string
+	// The width of the text in cells
+	Width int
+	// the offset, in cells from the left edge, of each cell of the text in
+	// logical order
+	offsets []int
+	// the number of bytes of the text in each cell, in logical order
+	sizes []int
+}
+
+// Lay out text as a right-to-left paragraph, as for Arabic or Hebrew, using a
+// simplified form of the Unicode bidirectional algorithm: runs of
+// left-to-right text, such as Latin words and numbers, keep their order while
+// the paragraph as a whole reads from right to left, and brackets in
+// right-to-left runs are mirrored. Explicit directional formatting characters
+// are not supported.
+func LayoutRTL(text string) RTLLayout {
+	var cells []string
+	for it := wcswidth.NewCellIterator(text); it.Forward(); {
+		cells = append(cells, it.Current())
+	}
+	classes := make([]bidi_class, len(cells))
+	for i, c := range cells {
+		ch, _ := utf8.DecodeRuneInString(c)
+		classes[i] = bidi_class_of(ch)
+	}
+	// a single separator between digits is part of the number
+	for i := 1; i+1 < len(cells); i++ {
+		if classes[i] == bidi_number_separator && classes[i-1] == bidi_number && classes[i+1] == bidi_number {
+			classes[i] = bidi_number
+		}
+	}
+	// numbers after left-to-right text are left-to-right text, numbers
+	// elsewhere act as right-to-left for resolving neutrals
+	strong := make([]bidi_class, len(cells))
+	prev := bidi_rtl
+	for i, c := range classes {
+		switch c {
+		case bidi_ltr, bidi_rtl:
+			prev = c
+			strong[i] = c
+		case bidi_number:
+			strong[i] = bidi_rtl
+			if prev == bidi_ltr {
+				strong[i] = bidi_ltr
+			}
+		}
+	}
+	// levels are 1 for right-to-left and 2 for left-to-right, neutrals take
+	// the direction of the text around them if it is the same on both sides,
+	// otherwise that of the paragraph
+	levels := make([]int, len(cells))
+	for i := 0; i < len(cells); {
+		if strong[i] != bidi_neutral {
+			levels[i] = 1
+			if strong[i] == bidi_ltr || classes[i] == bidi_number {
+				levels[i] = 2
+			}
+			i++
+			continue
+		}
+		end := i
+		for end < len(cells) && strong[end] == bidi_neutral {
+			end++
+		}
+		level := 1
+		if i > 0 && end < len(cells) && strong[i-1] == bidi_ltr && strong[end] == bidi_ltr {
+			level = 2
+		}
+		for ; i < end; i++ {
+			levels[i] = level
+		}
+	}
+	// trailing whitespace is at the paragraph level
+	for i := len(cells) - 1; i >= 0 && classes[i] == bidi_whitespace; i-- {
+		levels[i] = 1
+	}
+	// reverse runs at level 2 and then everything, leaving only runs of
+	// left-to-right text in their original order
+	order := make([]int, len(cells))
+	for i := range order {
+		order[i] = i
+	}
+	reverse := func(s []int) {
+		for a, b := 0, len(s)-1; a < b; a, b = a+1, b-1 {
+			s[a], s[b] = s[b], s[a]
+		}
+	}
+	for i := 0; i < len(order); {
+		if levels[order[i]] < 2 {
+			i++
+			continue
+		}
+		end := i
+		for end < len(order) && levels[order[end]] >= 2 {
+			end++
+		}
+		reverse(order[i:end])
+		i = end
+	}
+	reverse(order)
+	ans := RTLLayout{offsets: make([]int, len(cells)), sizes: make([]int, len(cells))}
+	var sb strings.Builder
+	for _, idx := range order {
+		c := cells[idx]
+		if levels[idx] == 1 {
+			if ch, sz := utf8.DecodeRuneInString(c); len(c) == sz {
+				if m, found := bidi_mirrors[ch]; found {
+					c = string(m)
+				}
+			}
+		}
+		ans.offsets[idx] = ans.Width
+		ans.sizes[idx] = len(cells[idx])
+		sb.WriteString(c)
+		ans.Width += wcswidth.Stringwidth(c)
+	}
+	ans.Visual = sb.String()
+	return ans
+}
+
+// The offset, in cells from the left edge of the laid out text, at which to
+// place the cursor when editing at the specified byte offset into the
+// original text: on the cell containing the character at that offset. At the
+// end of the text it is just left of the text, where the next right-to-left
+// character will appear. Offsets in the middle of a cell use that cell.
+func (self RTLLayout) CursorOffset(pos int) int {
+	for i, sz := range self.sizes {
+		if pos < sz {
+			return self.offsets[i]
+		}
+		pos -= sz
+	}
+	return -1
+}
+
+// Write text as a right-to-left paragraph, see LayoutRTL(), on the specified
+// row with its last cell at the column rightCol, so that it extends leftwards
+// from there. Columns are 1-based as for MoveCursorTo(). Text that would
+// extend beyond the left edge of the screen is clipped, dropping the cells
+// that are displayed leftmost.
+func (self *Loop) WriteRTL(text string, row, rightCol int) {
+	if row < 1 || rightCol < 1 {
+		return
+	}
+	l := LayoutRTL(text)
+	left := rightCol - l.Width + 1
+	visual := l.Visual
+	for left < 1 && visual != "" {
+		it := wcswidth.NewCellIterator(visual)
+		it.Forward()
+		left += wcswidth.Stringwidth(it.Current())
+		visual = visual[len(it.Current()):]
+	}
+	if visual == "" {
+		return
+	}
+	self.MoveCursorTo(left, row)
+	self.QueueWriteString(visual)
+}
+
+// The screen column (1-based) at which to place the cursor when editing text
+// written with WriteRTL() at the specified byte offset into it, see
+// RTLLayout.CursorOffset()
+func RTLCursorColumn(text string, rightCol, pos int) int {
+	l := LayoutRTL(text)
+	return rightCol - l.Width + 1 + l.CursorOffset(pos)
+}
@@ -0,0 +1,53 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestLayoutRTL(t *testing.T) {
+	for _, x := range []struct{ text, visual string }{
+		{"שלום world", "world םולש"},
+		{"مرحبا (abc 123) كيف", "فيك (abc 123) ابحرم"},
+		{"السعر 1,500.25 دينار", "رانيد 1,500.25 رعسلا"},
+		{"عدد 3 و 4", "4 و 3 ددع"},
+		{"hello world", "hello world"},
+		{"مرحبا   ", "   ابحرم"},
+		{"", ""},
+	} {
+		l := LayoutRTL(x.text)
+		if l.Visual != x.visual {
+			t.Fatalf("Layout of %#v: %#v != %#v", x.text, x.visual, l.Visual)
+		}
+	}
+	text := "مرحبا abc"
+	l := LayoutRTL(text)
+	if l.Width != 9 {
+		t.Fatalf("Unexpected width: %d", l.Width)
+	}
+	// the first logical character is rightmost, the Latin word is leftmost
+	// and reads left to right
+	for pos, expected := range map[int]int{0: 8, len("مرحب"): 4, len("مرحبا "): 0, len("مرحبا a"): 1, len(text): -1} {
+		if actual := l.CursorOffset(pos); actual != expected {
+			t.Fatalf("Cursor offset for %d: %d != %d", pos, expected, actual)
+		}
+	}
+	if c := RTLCursorColumn(text, 20, 0); c != 20 {
+		t.Fatalf("Unexpected cursor column: %d", c)
+	}
+
+	lp, _ := New()
+	lp.WriteRTL(text, 2, 20)
+	if out := lp.pending_writes[0].str + lp.pending_writes[1].str; out != "\x1b[2;12Habc ابحرم" {
+		t.Fatalf("Unexpected output: %#v", out)
+	}
+	lp.pending_writes = nil
+	lp.WriteRTL(text, 1, 5)
+	if out := lp.pending_writes[0].str + lp.pending_writes[1].str; out != "\x1b[1;1Hابحرم" {
+		t.Fatalf("Unexpected clipped output: %#v", out)
+	}
+}
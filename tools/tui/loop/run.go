@@ -9,12 +9,14 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"golang.org/x/sys/unix"
 
 	"kitty/tools/tty"
 	"kitty/tools/utils"
+	"kitty/tools/wcswidth"
 )
 
 var SIGNULL unix.Signal
@@ -24,23 +26,68 @@ func new_loop() *Loop {
 	l.terminal_options.alternate_screen = true
 	l.terminal_options.restore_colors = true
 	l.terminal_options.kitty_keyboard_mode = DISAMBIGUATE_KEYS | REPORT_ALTERNATE_KEYS | REPORT_ALL_KEYS_AS_ESCAPE_CODES | REPORT_TEXT_WITH_KEYS
-	l.escape_code_parser.HandleCSI = l.handle_csi
-	l.escape_code_parser.HandleOSC = l.handle_osc
-	l.escape_code_parser.HandleDCS = l.handle_dcs
-	l.escape_code_parser.HandleAPC = l.handle_apc
-	l.escape_code_parser.HandleSOS = l.handle_sos
-	l.escape_code_parser.HandlePM = l.handle_pm
-	l.escape_code_parser.HandleRune = l.handle_rune
-	l.escape_code_parser.HandleEndOfBracketedPaste = l.handle_end_of_bracketed_paste
+	l.configure_input_parser(&l.escape_code_parser)
+	l.query_parser = l.escape_code_parser
 	l.style_cache = make(map[string]func(...any) string)
 	l.style_ctx.AllowEscapeCodes = true
 	return &l
 }
 
+func (self *Loop) configure_input_parser(p *wcswidth.EscapeCodeParser) {
+	p.HandleCSI = self.handle_csi
+	p.HandleOSC = self.handle_osc
+	p.HandleDCS = self.handle_dcs
+	p.HandleAPC = self.handle_apc
+	p.HandleSOS = self.handle_sos
+	p.HandlePM = self.handle_pm
+	p.HandleRune = self.handle_rune
+	p.HandleEndOfBracketedPaste = self.handle_end_of_bracketed_paste
+	p.DispatchEscapeCodesInBracketedPaste = self.paste.dispatch_replies
+	p.DispatchInBracketedPaste = is_terminal_reply
+}
+
+// Whether an escape code that arrived inside a bracketed paste is a reply
+// from the terminal. Terminals do not remove escape codes from pasted text,
+// kitty for instance removes only the end of paste marker, so key and mouse
+// events inside a paste may well have been pasted and are never replies.
+func is_terminal_reply(raw []byte) bool {
+	payload, is_csi := bytes.CutPrefix(raw, []byte("\x1b["))
+	if !is_csi {
+		// OSC, DCS, APC, PM and SOS are not used for input
+		return true
+	}
+	csi := string(payload)
+	switch {
+	case csi == "I" || csi == "O":
+		// focus events
+		return true
+	case strings.HasPrefix(csi, "?") || strings.HasPrefix(csi, ">"):
+		// DA1, DA2, DECRPM for private modes, keyboard protocol flags
+		return true
+	case strings.HasSuffix(csi, "$y") || strings.HasSuffix(csi, "n") || strings.HasSuffix(csi, "t"):
+		// DECRPM, DSR and window reports
+		return true
+	}
+	return false
+}
+
+// The parser for input from the terminal, nested runs of the loop use their
+// own parser as the outer one may be in the middle of dispatching
+func (self *Loop) input_parser() *wcswidth.EscapeCodeParser {
+	if n := len(self.nested.parsers); n > 0 {
+		return self.nested.parsers[n-1]
+	}
+	return &self.escape_code_parser
+}
+
 func is_temporary_error(err error) bool {
 	return errors.Is(err, unix.EINTR) || errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EWOULDBLOCK) || errors.Is(err, io.ErrShortWrite)
 }
 
+func is_dumb_term(term string) bool {
+	return term == "dumb"
+}
+
 func kill_self(sig unix.Signal) {
 	unix.Kill(os.Getpid(), sig)
 	// Give the signal time to be delivered
@@ -65,15 +112,40 @@ func (self *Loop) update_screen_size() error {
 }
 
 func (self *Loop) handle_csi(raw []byte) error {
+	if self.intercept_escape_code(CSI, raw, self.handle_csi) {
+		return nil
+	}
+	if self.query.discard_runes_until_da1 && is_da1_response(CSI, raw) {
+		// the late response to QueryAnswerback()
+		self.query.discard_runes_until_da1, self.query.rune_filter = false, nil
+		return nil
+	}
 	csi := string(raw)
+	if csi == "I" || csi == "O" {
+		return self.handle_focus_event(csi == "I", raw)
+	}
+	if self.handle_late_dsr_status(raw) {
+		return nil
+	}
+	if self.mouse.encoding_queried {
+		if state, ok := parse_decrpm_response(MOUSE_SGR_PIXEL_MODE, raw); ok {
+			self.mouse.encoding_queried, self.mouse.sgr_cells = false, !state.IsSet()
+			return nil
+		}
+	}
 	ke := KeyEventFromCSI(csi)
 	if ke != nil {
-		return self.handle_key_event(ke)
+		self.typed_after_cr = false
+		return self.dispatch_key_event(ke)
+	}
+	if csi == "M" && self.terminal_options.mouse_tracking != NO_MOUSE_TRACKING {
+		// the legacy mouse encoding, the event is in the next three characters
+		self.mouse.legacy_data = make([]rune, 0, 3)
+		return nil
 	}
 	sz, err := self.ScreenSize()
 	if err == nil {
-		me := MouseEventFromCSI(csi, sz)
-		if me != nil {
+		if me := mouse_event_from_csi(csi, sz, self.mouse.sgr_cells); me != nil {
 			return self.handle_mouse_event(me)
 		}
 	}
@@ -83,6 +155,32 @@ func (self *Loop) handle_csi(raw []byte) error {
 	return nil
 }
 
+func (self *Loop) handle_focus_event(focused bool, raw []byte) error {
+	self.focused = focused
+	if focused && self.attention_requested {
+		self.SetAttention(false)
+	}
+	if self.OnFocusEvent != nil {
+		return self.OnFocusEvent(focused)
+	}
+	if self.OnEscapeCode != nil {
+		return self.OnEscapeCode(CSI, raw)
+	}
+	return nil
+}
+
+// Terminals that do not support the SGR pixel mouse mode fall back to the
+// SGR mode, which reports positions in cells. The query is not waited for, so
+// as not to delay startup, handle_csi() picks up the reply, which the
+// terminal sends before any mouse events that follow it.
+func (self *Loop) query_mouse_encoding() {
+	if self.terminal_options.mouse_tracking == NO_MOUSE_TRACKING || self.is_dumb_terminal {
+		return
+	}
+	self.mouse.encoding_queried = true
+	self.QueueWriteString(decrqm_query(MOUSE_SGR_PIXEL_MODE))
+}
+
 func is_click(a, b *MouseEvent) bool {
 	if a.Event_type != MOUSE_PRESS || b.Event_type != MOUSE_RELEASE {
 		return false
@@ -121,6 +219,9 @@ func (self *Loop) handle_mouse_event(ev *MouseEvent) error {
 }
 
 func (self *Loop) handle_key_event(ev *KeyEvent) error {
+	if ev.Key == "KP_ENTER" && !self.distinguish_keypad_enter {
+		ev.Key = "ENTER"
+	}
 	if self.OnKeyEvent != nil {
 		err := self.OnKeyEvent(ev)
 		if err != nil {
@@ -138,13 +239,49 @@ func (self *Loop) handle_key_event(ev *KeyEvent) error {
 		ev.Handled = true
 		return self.on_SIGTSTP()
 	}
-	if ev.Text != "" && self.OnText != nil {
-		return self.OnText(ev.Text, true, false)
+	if ev.Text != "" {
+		return self.dispatch_text(ev.Text, ev, false)
+	}
+	return nil
+}
+
+// Dispatch a key event received from the terminal, calling OnUnhandledKey if
+// nothing used it
+func (self *Loop) dispatch_key_event(ev *KeyEvent) error {
+	if err := self.handle_key_event(ev); err != nil || ev.Handled || ev.Text != "" {
+		return err
+	}
+	if self.OnUnhandledKey != nil && ev.Type != RELEASE {
+		ev.Handled = true
+		return self.OnUnhandledKey(ev)
+	}
+	return nil
+}
+
+// Deliver text to OnTextWithKey, or, if it is not set, to OnText. key is the
+// key event the text is from, if any.
+func (self *Loop) dispatch_text(text string, key *KeyEvent, in_bracketed_paste bool) error {
+	if self.OnTextWithKey != nil {
+		return self.OnTextWithKey(text, key, in_bracketed_paste)
+	}
+	if self.OnText != nil {
+		return self.OnText(text, key != nil, in_bracketed_paste)
 	}
 	return nil
 }
 
 func (self *Loop) handle_osc(raw []byte) error {
+	if self.intercept_escape_code(OSC, raw, self.handle_osc) {
+		return nil
+	}
+	if handled, err := self.handle_clipboard_response(raw); handled {
+		return err
+	}
+	if self.OnColorTableResponse != nil {
+		if colors, ok := parse_color_table_response(raw); ok {
+			return self.OnColorTableResponse(colors)
+		}
+	}
 	if self.OnEscapeCode != nil {
 		return self.OnEscapeCode(OSC, raw)
 	}
@@ -152,6 +289,9 @@ func (self *Loop) handle_osc(raw []byte) error {
 }
 
 func (self *Loop) handle_dcs(raw []byte) error {
+	if self.intercept_escape_code(DCS, raw, self.handle_dcs) {
+		return nil
+	}
 	if self.OnRCResponse != nil && bytes.HasPrefix(raw, utils.UnsafeStringToBytes("@kitty-cmd")) {
 		return self.OnRCResponse(raw[len("@kitty-cmd"):])
 	}
@@ -162,6 +302,9 @@ func (self *Loop) handle_dcs(raw []byte) error {
 }
 
 func (self *Loop) handle_apc(raw []byte) error {
+	if self.intercept_escape_code(APC, raw, self.handle_apc) {
+		return nil
+	}
 	if self.OnEscapeCode != nil {
 		return self.OnEscapeCode(APC, raw)
 	}
@@ -169,6 +312,9 @@ func (self *Loop) handle_apc(raw []byte) error {
 }
 
 func (self *Loop) handle_sos(raw []byte) error {
+	if self.intercept_escape_code(SOS, raw, self.handle_sos) {
+		return nil
+	}
 	if self.OnEscapeCode != nil {
 		return self.OnEscapeCode(SOS, raw)
 	}
@@ -176,23 +322,168 @@ func (self *Loop) handle_sos(raw []byte) error {
 }
 
 func (self *Loop) handle_pm(raw []byte) error {
+	if self.intercept_escape_code(PM, raw, self.handle_pm) {
+		return nil
+	}
 	if self.OnEscapeCode != nil {
 		return self.OnEscapeCode(PM, raw)
 	}
 	return nil
 }
 
-func (self *Loop) handle_rune(raw rune) error {
-	if self.OnText != nil {
-		return self.OnText(string(raw), false, self.escape_code_parser.InBracketedPaste())
+func (self *Loop) normalize_pasted_newline(raw rune) (rune, bool) {
+	after_cr := self.paste.after_cr
+	self.paste.after_cr = raw == '\r'
+	switch raw {
+	case '\n':
+		if after_cr {
+			return raw, false
+		}
+	case '\r':
+	default:
+		return raw, true
+	}
+	if self.paste.newlines == PASTE_NEWLINES_CR {
+		return '\r', true
+	}
+	return '\n', true
+}
+
+func (self *Loop) handle_legacy_mouse_data(raw rune) error {
+	self.mouse.legacy_data = append(self.mouse.legacy_data, raw)
+	if raw > 127 {
+		// a byte for a large coordinate, possibly combined with the
+		// following one by UTF-8 decoding, treat the rest as too large too
+		for len(self.mouse.legacy_data) < 3 {
+			self.mouse.legacy_data = append(self.mouse.legacy_data, 0)
+		}
+	}
+	if len(self.mouse.legacy_data) < 3 {
+		return nil
+	}
+	data := self.mouse.legacy_data
+	self.mouse.legacy_data = nil
+	sz, err := self.ScreenSize()
+	if err != nil {
+		return nil
+	}
+	if me := decode_legacy_mouse(data, sz); me != nil {
+		return self.handle_mouse_event(me)
 	}
 	return nil
 }
 
-func (self *Loop) handle_end_of_bracketed_paste() {
-	if self.OnText != nil {
-		self.OnText("", false, false)
+func (self *Loop) handle_rune(raw rune) error {
+	if self.mouse.legacy_data != nil {
+		return self.handle_legacy_mouse_data(raw)
+	}
+	if self.query.rune_filter != nil && self.query.rune_filter(raw) {
+		return nil
+	}
+	in_bracketed_paste := self.input_parser().InBracketedPaste()
+	if self.query.response_filter != nil {
+		in_bracketed_paste = self.query_parser.InBracketedPaste()
+	}
+	if in_bracketed_paste && !self.paste.started {
+		self.paste.started = true
+		if self.paste.normalize_text && raw == 0xfeff { // byte order mark
+			return nil
+		}
 	}
+	if in_bracketed_paste && self.paste.newlines != PASTE_NEWLINES_AS_IS {
+		var keep bool
+		if raw, keep = self.normalize_pasted_newline(raw); !keep {
+			return nil
+		}
+	}
+	dispatch := func() error {
+		return self.dispatch_text(string(raw), nil, in_bracketed_paste)
+	}
+	after_cr := self.typed_after_cr
+	self.typed_after_cr = !in_bracketed_paste && raw == '\r'
+	if in_bracketed_paste && self.paste.delivery == PASTE_AS_BLOCK && !self.paste.oversized {
+		self.paste.buffer.WriteRune(raw)
+		if limit := self.max_paste_buffer_size(); limit > 0 && self.paste.buffer.Len() > limit {
+			return self.handle_oversized_paste()
+		}
+		return nil
+	}
+	if in_bracketed_paste && self.paste.oversized && self.paste.oversized_action == ABORT_OVERSIZED_PASTE {
+		return nil
+	}
+	if !in_bracketed_paste {
+		switch raw {
+		case '\n':
+			if after_cr { // \r\n is a single press of Enter
+				return nil
+			}
+			fallthrough
+		case '\r':
+			text_dispatch := dispatch
+			dispatch = func() error {
+				// the text is delivered only if nothing, including
+				// OnUnhandledKey, used the key
+				ev := &KeyEvent{Type: PRESS, Key: "ENTER"}
+				if err := self.dispatch_key_event(ev); err != nil || ev.Handled {
+					return err
+				}
+				return text_dispatch()
+			}
+		}
+	}
+	if self.defer_while_querying(dispatch) {
+		return nil
+	}
+	return dispatch()
+}
+
+func (self *Loop) max_paste_buffer_size() int {
+	if self.paste.max_buffer == 0 {
+		return default_max_paste_buffer
+	}
+	return self.paste.max_buffer
+}
+
+// The paste being held for delivery as a block is too large, deliver what
+// has been held so far and the rest as it arrives or discard it all
+func (self *Loop) handle_oversized_paste() error {
+	self.paste.oversized = true
+	block := self.paste.buffer.String()
+	self.paste.buffer.Reset()
+	dispatch := func() error {
+		if self.OnOversizedPaste != nil {
+			if err := self.OnOversizedPaste(len(block)); err != nil {
+				return err
+			}
+		}
+		if self.paste.oversized_action == STREAM_OVERSIZED_PASTE {
+			return self.dispatch_text(block, nil, true)
+		}
+		return nil
+	}
+	if self.defer_while_querying(dispatch) {
+		return nil
+	}
+	return dispatch()
+}
+
+func (self *Loop) handle_end_of_bracketed_paste() error {
+	self.paste.after_cr, self.paste.started, self.paste.oversized = false, false, false
+	self.paste.text_encoding, self.paste.current_encoding = self.paste.current_encoding, ""
+	block := self.paste.buffer.String()
+	self.paste.buffer.Reset()
+	dispatch := func() error {
+		if block != "" {
+			if err := self.dispatch_text(block, nil, true); err != nil {
+				return err
+			}
+		}
+		return self.dispatch_text("", nil, false)
+	}
+	if self.defer_while_querying(dispatch) {
+		return nil
+	}
+	return dispatch()
 }
 
 func (self *Loop) on_signal(s unix.Signal) error {
@@ -226,15 +517,16 @@ func (self *Loop) on_SIGPIPE() error {
 
 func (self *Loop) on_SIGWINCH() error {
 	self.screen_size.updated = false
-	if self.OnResize != nil {
-		old_size := self.screen_size
-		err := self.update_screen_size()
-		if err != nil {
+	self.stop_pulses(false)
+	if self.OnResize != nil || self.OnResizeImmediate != nil {
+		if !self.resize.pending {
+			self.resize.size_before = self.screen_size
+		}
+		if err := self.update_screen_size(); err != nil {
 			return err
 		}
-		return self.OnResize(old_size, self.screen_size)
 	}
-	return nil
+	return self.on_resize()
 }
 
 func (self *Loop) on_SIGTERM() error {
@@ -249,6 +541,52 @@ func (self *Loop) on_SIGHUP() error {
 	return nil
 }
 
+func (self *Loop) queue_setup_sequence() IdType {
+	self.detected.keyboard_flags = nil
+	if self.sgr_optimizer != nil {
+		// the attributes may have been changed while suspended
+		self.sgr_optimizer.known = false
+	}
+	self.invalidate_presented()
+	seq := self.SetupSequence()
+	if !self.is_dumb_terminal && self.modes.alt_screen != self.terminal_options.alternate_screen {
+		// restore the screen selected by EnterAltScreen() or ExitAltScreen()
+		// when resuming after a suspend
+		seq += alt_screen_toggle(self.modes.alt_screen)
+	}
+	if !self.is_dumb_terminal && self.modes.bracketed_paste {
+		// the setup sequence turns it off, restore it when resuming after
+		// a suspend
+		seq += BRACKETED_PASTE.EscapeCodeToSet()
+	}
+	if !self.is_dumb_terminal {
+		seq = self.column_mode_setup_codes() + seq + self.origin_mode_setup_codes()
+	}
+	return self.QueueWriteString(seq)
+}
+
+func (self *Loop) queue_teardown_sequence() IdType {
+	self.detected.keyboard_flags = nil
+	seq := self.TeardownSequence()
+	if !self.is_dumb_terminal && self.modes.alt_screen && !self.terminal_options.alternate_screen {
+		seq = alt_screen_toggle(false) + seq
+	}
+	if !self.is_dumb_terminal {
+		seq = self.origin_mode_teardown_codes() + self.column_mode_teardown_codes() + seq
+	}
+	return self.QueueWriteString(seq)
+}
+
+func (self *Loop) termios_operations() []tty.TermiosOperation {
+	ops := []tty.TermiosOperation{tty.SetRaw, tty.SetFlowControl(self.terminal_options.flow_control)}
+	if self.is_dumb_terminal {
+		// dumb terminals have no cursor movement, so rely on the terminal
+		// driver to turn a bare \n into a newline
+		ops = append(ops, tty.SetPostProcessOutput)
+	}
+	return ops
+}
+
 func (self *Loop) run() (err error) {
 	signal_channel := make(chan os.Signal, 256)
 	handled_signals := []os.Signal{unix.SIGINT, unix.SIGTERM, unix.SIGTSTP, unix.SIGHUP, unix.SIGWINCH, unix.SIGPIPE}
@@ -264,7 +602,8 @@ func (self *Loop) run() (err error) {
 		controlling_term.RestoreAndClose()
 		self.controlling_term = nil
 	}()
-	err = controlling_term.ApplyOperations(tty.TCSANOW, tty.SetRaw)
+	self.is_dumb_terminal = is_dumb_term(os.Getenv("TERM"))
+	err = controlling_term.ApplyOperations(tty.TCSANOW, self.termios_operations()...)
 	if err != nil {
 		return nil
 	}
@@ -277,12 +616,33 @@ func (self *Loop) run() (err error) {
 	tty_reading_done_channel := make(chan byte)
 	self.wakeup_channel = make(chan byte, 256)
 	self.pending_writes = make([]*write_msg, 0, 256)
+	self.backlog.pending_bytes, self.backlog.backlogged = 0, false
 	err_channel := make(chan error, 8)
 	self.death_signal = SIGNULL
 	self.escape_code_parser.Reset()
 	self.exit_code = 0
 	self.atomic_update_active = false
 	self.timers = make([]*timer, 0, 1)
+	self.query = query_state{no_echo_detection: self.query.no_echo_detection}
+	self.detected = detected_capabilities{}
+	self.cursors.current, self.cursors.stack = logical_cursor{}, nil
+	self.focused = true
+	self.modes.runtime = nil
+	self.resize.pending = false
+	self.modes.bracketed_paste = false
+	self.modes.origin, self.modes.scroll_region_top, self.modes.scroll_region_bottom = false, 0, 0
+	self.modes.column = nil
+	self.mouse = mouse_state{}
+	self.paste.buffer.Reset()
+	self.paste.oversized = false
+	self.stripped_sequences = nil
+	self.clipboard_read = nil
+	self.cursors.hidden, self.cursors.hide_depth = false, 0
+	self.title_stack = nil
+	self.render.redraw_requested, self.render.timer = false, 0
+	self.replay.active = 0
+	self.debounced, self.throttled = nil, nil
+	self.clear_diagnostics()
 	no_timeout_channel := make(<-chan time.Time)
 	finalizer := ""
 
@@ -298,24 +658,53 @@ func (self *Loop) run() (err error) {
 	} else {
 		return err
 	}
-	self.QueueWriteString(self.terminal_options.SetStateEscapeCodes())
-	needs_reset_escape_codes := true
+	self.modes.alt_screen = self.terminal_options.alternate_screen && !self.is_dumb_terminal
+	if len(self.pending_scrollback) > 0 {
+		self.QueueWriteString(strings.Join(self.pending_scrollback, ""))
+		self.pending_scrollback = nil
+	}
+	self.queue_setup_sequence()
+	needs_reset_escape_codes := !self.is_dumb_terminal
+	if self.exit_cleanup_requested {
+		self.start_exit_cleanup()
+	}
+	// registered before the shutdown handler so that it runs after the teardown sequence is written
+	defer self.stop_exit_cleanup()
 
+	self.running = true
 	defer func() {
+		self.running = false
+		self.close_fd_watcher()
 		// notify tty reader that we are shutting down
 		r_w.Close()
 		close(tty_reading_done_channel)
 
+		self.stop_pulses(true)
+		self.cancel_rate_limited_calls()
 		if self.OnFinalize != nil {
 			finalizer += self.OnFinalize()
 		}
-		if finalizer != "" {
-			self.QueueWriteString(finalizer)
+		if self.status_line != nil {
+			self.status_line.Close()
 		}
 		if needs_reset_escape_codes {
-			self.QueueWriteString(self.terminal_options.ResetStateEscapeCodes())
+			finalizer += self.TeardownSequence()
+			self.detected.keyboard_flags = nil
+		}
+		finalizer += self.default_tab_stops_escape_code()
+		if self.attention_requested {
+			finalizer += attention_request(false)
+			self.attention_requested = false
+		}
+		if len(self.pending_scrollback) > 0 {
+			finalizer += strings.Join(self.pending_scrollback, "")
+			self.pending_scrollback = nil
+		}
+		if finalizer != "" {
+			self.QueueWriteString(finalizer)
 		}
 		// flush queued data and wait for it to be written for a timeout, then wait for writer to shutdown
+		self.prepare_pending_writes()
 		flush_writer(w_w, tty_write_channel, write_done_channel, self.pending_writes, 2*time.Second)
 		self.pending_writes = nil
 		// wait for tty reader to exit cleanly
@@ -323,18 +712,63 @@ func (self *Loop) run() (err error) {
 		}
 	}()
 
-	go write_to_tty(w_r, controlling_term, tty_write_channel, err_channel, write_done_channel)
+	go write_to_tty(w_r, controlling_term, &self.write_chunker, tty_write_channel, err_channel, write_done_channel)
 	go read_from_tty(r_r, controlling_term, tty_read_channel, err_channel, tty_reading_done_channel)
 
+	self.query.wait_for_responses = func(timeout time.Duration, done func() bool) error {
+		deadline := time.Now().Add(timeout)
+		for !done() {
+			self.flush_pending_writes(tty_write_channel)
+			timeout = time.Until(deadline)
+			if timeout <= 0 {
+				return os.ErrDeadlineExceeded
+			}
+			select {
+			case <-time.After(timeout):
+				return os.ErrDeadlineExceeded
+			case msg_id := <-write_done_channel:
+				if self.OnWriteComplete != nil {
+					self.query.deferred_input = append(self.query.deferred_input, func() error { return self.OnWriteComplete(msg_id) })
+				}
+			case rwerr := <-err_channel:
+				err_channel <- rwerr // let the main loop handle it
+				return rwerr
+			case input_data, more := <-tty_read_channel:
+				if !more {
+					return io.EOF
+				}
+				self.record_input(input_data)
+				if self.OnReceivedData != nil {
+					self.query.deferred_input = append(self.query.deferred_input, func() error { return self.OnReceivedData(input_data) })
+				}
+				// use a separate parser as the main one may be in the middle of dispatching
+				self.typed_after_cr = false
+				if err := self.query_parser.Parse(input_data); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	defer func() { self.query.wait_for_responses = nil }()
+	self.start_dsr_probe()
+	self.warn_about_mode_conflicts(self.ValidateModes())
+
 	if self.OnInitialize != nil {
 		finalizer, err = self.OnInitialize()
 		if err != nil {
 			return err
 		}
 	}
+	self.query_mouse_encoding()
+	if self.terminal_options.focus_tracking && self.OnFocusEvent != nil {
+		if err = self.OnFocusEvent(self.focused); err != nil {
+			return err
+		}
+	}
 
 	self.Suspend = func() (func() error, error) {
-		write_id := self.QueueWriteString(self.terminal_options.ResetStateEscapeCodes())
+		write_id := self.queue_teardown_sequence()
 		needs_reset_escape_codes = false
 		err := self.wait_for_write_to_complete(write_id, tty_write_channel, write_done_channel, 2*time.Second)
 		if err != nil {
@@ -349,7 +783,7 @@ func (self *Loop) run() (err error) {
 			if err != nil {
 				return
 			}
-			write_id = self.QueueWriteString(self.terminal_options.SetStateEscapeCodes())
+			write_id = self.queue_setup_sequence()
 			needs_reset_escape_codes = true
 			return self.wait_for_write_to_complete(write_id, tty_write_channel, write_done_channel, 2*time.Second)
 		}, nil
@@ -357,7 +791,7 @@ func (self *Loop) run() (err error) {
 	}
 
 	self.on_SIGTSTP = func() error {
-		write_id := self.QueueWriteString(self.terminal_options.ResetStateEscapeCodes())
+		write_id := self.queue_teardown_sequence()
 		needs_reset_escape_codes = false
 		err := self.wait_for_write_to_complete(write_id, tty_write_channel, write_done_channel, 2*time.Second)
 		if err != nil {
@@ -371,7 +805,7 @@ func (self *Loop) run() (err error) {
 		if err != nil {
 			return err
 		}
-		write_id = self.QueueWriteString(self.terminal_options.SetStateEscapeCodes())
+		write_id = self.queue_setup_sequence()
 		needs_reset_escape_codes = true
 		err = self.wait_for_write_to_complete(write_id, tty_write_channel, write_done_channel, 2*time.Second)
 		if err != nil {
@@ -383,26 +817,44 @@ func (self *Loop) run() (err error) {
 		return nil
 	}
 
-	for self.keep_going {
-		self.flush_pending_writes(tty_write_channel)
-		timeout_chan := no_timeout_channel
+	// a single iteration of the loop
+	iterate := func() (err error) {
+		if err = self.dispatch_deferred_input(); err != nil {
+			return err
+		}
 		if len(self.timers) > 0 {
-			now := time.Now()
-			err = self.dispatch_timers(now)
+			err = self.dispatch_timers(time.Now())
 			if err != nil {
 				return err
 			}
-			var timeout time.Duration
-			if len(self.timers) > 0 {
-				timeout = self.timers[0].deadline.Sub(now)
-				if timeout < 0 {
-					timeout = 0
-				}
+		}
+		self.flush_scrollback()
+		if err = self.render_if_needed(time.Now()); err != nil {
+			return err
+		}
+		self.flush_pending_writes(tty_write_channel)
+		if err = self.check_write_backlog(); err != nil {
+			return err
+		}
+		timeout_chan := no_timeout_channel
+		if len(self.timers) > 0 {
+			timeout := time.Until(self.timers[0].deadline)
+			if timeout < 0 {
+				timeout = 0
 			}
 			timeout_chan = time.After(timeout)
 		}
+		var fd_ready_channel chan []fd_ready
+		if self.fd_watcher != nil {
+			fd_ready_channel = self.fd_watcher.ready_channel
+		}
 		select {
 		case <-timeout_chan:
+		case ready := <-fd_ready_channel:
+			err = self.dispatch_ready_fds(ready)
+			if err != nil {
+				return err
+			}
 		case <-self.wakeup_channel:
 			for len(self.wakeup_channel) > 0 {
 				<-self.wakeup_channel
@@ -437,12 +889,37 @@ func (self *Loop) run() (err error) {
 					return fmt.Errorf("Failed to read from terminal: %w", io.EOF)
 				}
 			}
+			self.record_input(input_data)
+			if self.replay.ignore_tty_input && self.is_replaying() {
+				break
+			}
 			err := self.dispatch_input_data(input_data)
 			if err != nil {
 				return err
 			}
 
 		}
+		return nil
+	}
+
+	self.nested.run = func(done func() bool) error {
+		p := wcswidth.EscapeCodeParser{DecodeInvalidPasteByte: self.escape_code_parser.DecodeInvalidPasteByte, ReplaceInvalidUtf8Bytes: self.escape_code_parser.ReplaceInvalidUtf8Bytes}
+		self.configure_input_parser(&p)
+		self.nested.parsers = append(self.nested.parsers, &p)
+		defer func() { self.nested.parsers = self.nested.parsers[:len(self.nested.parsers)-1] }()
+		for self.keep_going && !done() {
+			if err := iterate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	defer func() { self.nested.run = nil }()
+
+	for self.keep_going {
+		if err = iterate(); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -0,0 +1,61 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var _ = fmt.Print
+
+func TestDumbTerminal(t *testing.T) {
+	for term, expected := range map[string]bool{"dumb": true, "": false, "xterm-kitty": false, "vt100": false} {
+		if actual := is_dumb_term(term); actual != expected {
+			t.Fatalf("Unexpected result for TERM=%#v: %v", term, actual)
+		}
+	}
+	lp := new_test_loop()
+	lp.is_dumb_terminal = true
+	lp.query.wait_for_responses = func(time.Duration, func() bool) error {
+		t.Fatalf("Waited for a response from a dumb terminal")
+		return nil
+	}
+	if lp.RespondsToQueries() {
+		t.Fatalf("Dumb terminals must not be queried")
+	}
+	if _, ok := lp.QueryMode(BRACKETED_PASTE); ok {
+		t.Fatalf("Query to a dumb terminal succeeded")
+	}
+	lp.MoveCursorTo(3, 4)
+	lp.QueueWriteString("\x1b[1;31mred\x1b[m line\r\n")
+	if q := lp.output(); q != "red line\r\n" {
+		t.Fatalf("Escape codes not stripped from output to a dumb terminal: %#v", q)
+	}
+	if found, _ := lp.query_terminal("\x1b[?2004$p", time.Second, func(EscapeCodeType, []byte) bool { return true }); found {
+		t.Fatalf("Query to a dumb terminal succeeded")
+	}
+	if q := lp.output(); q != "" {
+		t.Fatalf("Query sent to a dumb terminal: %#v", q)
+	}
+}
+
+func TestDumbTerminalTermios(t *testing.T) {
+	apply := func(dumb bool) (ans unix.Termios) {
+		lp := new_test_loop()
+		lp.is_dumb_terminal = dumb
+		for _, op := range lp.termios_operations() {
+			op(&ans)
+		}
+		return
+	}
+	if apply(false).Oflag&unix.OPOST != 0 {
+		t.Fatalf("Output post-processing not turned off for a normal terminal")
+	}
+	if apply(true).Oflag&(unix.OPOST|unix.ONLCR) != unix.OPOST|unix.ONLCR {
+		t.Fatalf("Newlines not translated for a dumb terminal")
+	}
+}
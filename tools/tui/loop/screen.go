@@ -0,0 +1,240 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+
+	"kitty/tools/utils"
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+type Cell struct {
+	// The text of the cell, a single grapheme. Empty for the second cell of
+	// a wide character.
+	Text string
+	// The SGR parameters for the cell, such as "1;31", empty for default
+	// formatting
+	SGR string
+}
+
+var blank_cell = Cell{Text: " "}
+
+// never matches a real cell, used for cells whose displayed contents are unknown
+var unknown_cell = Cell{Text: "\x00"}
+
+type rect struct{ top, left, bottom, right int } // 0-based, inclusive
+
+// An off-screen buffer of cells that can be efficiently drawn to the terminal
+// by only sending the cells that changed since the last time it was flushed.
+// Coordinates are 1-based, as for MoveCursorTo.
+type Screen struct {
+	width, height int
+	cells, shown  []Cell
+	dirty         []rect
+	// modifies how cells are displayed without changing them, used to
+	// highlight selections
+	overlay       func(x, y int, c Cell) Cell
+	overlay_dirty []rect
+}
+
+func NewScreen(width, height int) *Screen {
+	ans := &Screen{}
+	ans.Resize(width, height)
+	return ans
+}
+
+func (self *Screen) Size() (width, height int) { return self.width, self.height }
+
+// Resize the screen, keeping the contents of the area common to the old and
+// new sizes. As the terminal generally reflows or clears its contents when it
+// is resized, the whole screen is redrawn on the next Flush().
+func (self *Screen) Resize(width, height int) {
+	width, height = utils.Max(0, width), utils.Max(0, height)
+	cells := make([]Cell, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < self.width && y < self.height {
+				cells[y*width+x] = self.cells[y*self.width+x]
+			} else {
+				cells[y*width+x] = blank_cell
+			}
+		}
+		// dont leave half a wide character at the right edge
+		if width > 0 && width < self.width && cells[y*width+width-1].Text != "" && wcswidth.Stringwidth(cells[y*width+width-1].Text) > 1 {
+			cells[y*width+width-1] = blank_cell
+		}
+	}
+	self.width, self.height, self.cells = width, height, cells
+	self.shown = make([]Cell, len(cells))
+	self.Invalidate()
+}
+
+// Forget what is displayed on the terminal, so that the whole screen is
+// redrawn on the next Flush(). Use this if something other than this
+// screen has drawn on the terminal, for instance, after the screen was
+// cleared.
+func (self *Screen) Invalidate() {
+	for i := range self.shown {
+		self.shown[i] = unknown_cell
+	}
+	self.dirty, self.overlay_dirty = nil, nil
+}
+
+func (self *Screen) index(x, y int) int {
+	if x < 1 || y < 1 || x > self.width || y > self.height {
+		return -1
+	}
+	return (y-1)*self.width + x - 1
+}
+
+// The cell at the specified position, returns a blank cell if the position is
+// outside the screen
+func (self *Screen) CellAt(x, y int) Cell {
+	if i := self.index(x, y); i > -1 {
+		return self.cells[i]
+	}
+	return blank_cell
+}
+
+// Blank out the cell at index i, along with the other half of the wide
+// character it is part of, if any
+func (self *Screen) blank(i int) {
+	x := i % self.width
+	if self.cells[i].Text == "" && x > 0 {
+		self.cells[i-1] = blank_cell
+	} else if x+1 < self.width && self.cells[i+1].Text == "" {
+		self.cells[i+1] = blank_cell
+	}
+	self.cells[i] = blank_cell
+}
+
+// Write text with the specified SGR formatting starting at the specified
+// position. Text is clipped at the right edge of the screen, it does not wrap.
+// A wide character that does not fit is replaced by a blank. Returns the number
+// of cells written.
+func (self *Screen) WriteString(x, y int, sgr, text string) (num_cells int) {
+	start := self.index(x, y)
+	if start < 0 {
+		return 0
+	}
+	it := wcswidth.NewCellIterator(text)
+	for x <= self.width && it.Forward() {
+		g := it.Current()
+		w := wcswidth.Stringwidth(g)
+		if w < 1 {
+			continue
+		}
+		i := self.index(x, y)
+		self.blank(i)
+		if w > 1 {
+			if x == self.width {
+				self.cells[i] = Cell{Text: " ", SGR: sgr}
+				return num_cells + 1
+			}
+			self.blank(i + 1)
+			self.cells[i+1] = Cell{SGR: sgr}
+		}
+		self.cells[i] = Cell{Text: g, SGR: sgr}
+		x += w
+		num_cells += w
+	}
+	return
+}
+
+// Fill the screen with blank cells
+func (self *Screen) Clear() {
+	for i := range self.cells {
+		self.cells[i] = blank_cell
+	}
+}
+
+// Tell the next Flush() that the specified region has changed. Once any region
+// is marked dirty, Flush() only looks for changes in the dirty regions,
+// everything else is assumed unchanged. This avoids comparing large areas
+// that are known to be static, such as headers. If no region is marked dirty
+// the whole screen is checked for changes.
+func (self *Screen) MarkDirty(top, left, height, width int) {
+	self.dirty = self.add_rect(self.dirty, top, left, height, width)
+}
+
+func (self *Screen) add_rect(rects []rect, top, left, height, width int) []rect {
+	r := rect{top: utils.Max(0, top-1), left: utils.Max(0, left-1), bottom: utils.Min(self.height, top+height-1) - 1, right: utils.Min(self.width, left+width-1) - 1}
+	if r.bottom >= r.top && r.right >= r.left {
+		rects = append(rects, r)
+	}
+	return rects
+}
+
+// Tell the next Flush() that the overlay has changed in the specified region.
+// Unlike MarkDirty() this does not stop Flush() from checking the whole
+// screen when no region is marked dirty.
+func (self *Screen) mark_overlay_dirty(top, left, height, width int) {
+	self.overlay_dirty = self.add_rect(self.overlay_dirty, top, left, height, width)
+}
+
+// Return the escape codes needed to update the terminal to match the screen,
+// suitable for QueueWriteString(), only cells that have changed since the last
+// call are sent. The cursor position and formatting are left undefined.
+func (self *Screen) Flush() string {
+	dirty := self.dirty
+	if len(dirty) == 0 {
+		dirty = []rect{{bottom: self.height - 1, right: self.width - 1}}
+	} else {
+		dirty = append(dirty, self.overlay_dirty...)
+	}
+	self.dirty, self.overlay_dirty = nil, nil
+	var sb strings.Builder
+	cx, cy := -1, -1
+	current_sgr := "\x00"
+	for _, r := range dirty {
+		for y := r.top; y <= r.bottom; y++ {
+			row, shown := self.cells[y*self.width:(y+1)*self.width], self.shown[y*self.width:(y+1)*self.width]
+			cell := func(x int) Cell {
+				if self.overlay != nil {
+					return self.overlay(x+1, y+1, row[x])
+				}
+				return row[x]
+			}
+			for x := r.left; x <= r.right; x++ {
+				if cell(x) == shown[x] {
+					continue
+				}
+				if row[x].Text == "" && x > 0 { // second half of a wide character
+					x--
+				}
+				c := cell(x)
+				if x != cx || y != cy {
+					sb.WriteString(fmt.Sprintf(MoveCursorToTemplate, y+1, x+1))
+				}
+				if c.SGR != current_sgr {
+					sb.WriteString("\x1b[0")
+					if c.SGR != "" {
+						sb.WriteString(";" + c.SGR)
+					}
+					sb.WriteString("m")
+					current_sgr = c.SGR
+				}
+				text := c.Text
+				if text == "" {
+					text = " "
+				}
+				sb.WriteString(text)
+				shown[x] = c
+				cx, cy = x+1, y
+				if x+1 < self.width && row[x+1].Text == "" {
+					x++
+					shown[x] = cell(x)
+					cx++
+				}
+			}
+		}
+	}
+	if current_sgr != "\x00" && current_sgr != "" {
+		sb.WriteString("\x1b[m")
+	}
+	return sb.String()
+}
@@ -0,0 +1,70 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestScreenFlush(t *testing.T) {
+	s := NewScreen(6, 3)
+	s.WriteString(1, 1, "", "ab")
+	full := s.Flush()
+	if !strings.HasPrefix(full, "\x1b[1;1H\x1b[0mab    \x1b[2;1H") {
+		t.Fatalf("Unexpected initial flush: %#v", full)
+	}
+	if ans := s.Flush(); ans != "" {
+		t.Fatalf("Flushing an unchanged screen produced output: %#v", ans)
+	}
+	s.WriteString(2, 2, "1", "x🌷")
+	if ans, expected := s.Flush(), "\x1b[2;2H\x1b[0;1mx🌷\x1b[m"; ans != expected {
+		t.Fatalf("%#v != %#v", expected, ans)
+	}
+	// overwriting the second half of a wide character blanks the first half
+	s.WriteString(4, 2, "", "y")
+	if c := s.CellAt(3, 2); c != blank_cell {
+		t.Fatalf("Wide character not blanked: %#v", c)
+	}
+	if ans, expected := s.Flush(), "\x1b[2;3H\x1b[0m y"; ans != expected {
+		t.Fatalf("%#v != %#v", expected, ans)
+	}
+	// a wide character that does not fit is replaced by a blank
+	if n := s.WriteString(6, 3, "", "🌷"); n != 1 || s.CellAt(6, 3) != blank_cell {
+		t.Fatalf("Wide character at right edge not handled: %d %#v", n, s.CellAt(6, 3))
+	}
+	// changes outside the dirty region are not sent
+	s.WriteString(1, 1, "", "A")
+	s.WriteString(1, 3, "", "C")
+	s.MarkDirty(3, 1, 1, 6)
+	if ans, expected := s.Flush(), "\x1b[3;1H\x1b[0mC"; ans != expected {
+		t.Fatalf("%#v != %#v", expected, ans)
+	}
+	s.Resize(4, 3)
+	if ans := s.Flush(); !strings.HasPrefix(ans, "\x1b[1;1H\x1b[0mAb  ") {
+		t.Fatalf("Resize did not redraw everything: %#v", ans)
+	}
+}
+
+func benchmark_mostly_static_screen(b *testing.B, mark_dirty bool) {
+	s := NewScreen(300, 100)
+	for y := 1; y <= 100; y++ {
+		s.WriteString(1, y, "32", strings.Repeat("static content ", 20))
+	}
+	s.Flush()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.WriteString(1, 100, "", fmt.Sprintf("status: %d", i))
+		if mark_dirty {
+			s.MarkDirty(100, 1, 1, 300)
+		}
+		s.Flush()
+	}
+}
+
+func BenchmarkScreenFlushFull(b *testing.B) { benchmark_mostly_static_screen(b, false) }
+
+func BenchmarkScreenFlushDirtyRegion(b *testing.B) { benchmark_mostly_static_screen(b, true) }
@@ -0,0 +1,94 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+)
+
+var _ = fmt.Print
+
+// Write text to the main screen so that it ends up in the scrollback and
+// persists after the program exits, even while the alternate screen is in use.
+// Text written before the loop starts is written to the main screen before
+// switching to the alternate screen. Text written while the loop is running
+// is coalesced and written once per iteration of the loop by briefly
+// switching to the main screen, without clearing or otherwise disturbing the
+// contents of the alternate screen or the cursor position on either screen.
+// A trailing newline is added if text does not end with one. When the
+// alternate screen is not used, text is simply written to the screen. Use
+// ScrollbackCapabilities() to check if the terminal supports this.
+func (self *Loop) PrintToScrollback(text string) {
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+	if !strings.HasSuffix(text, "\r\n") {
+		text += "\r\n"
+	}
+	if self.timers != nil && !self.modes.alt_screen {
+		self.QueueWriteString(text)
+		return
+	}
+	self.pending_scrollback = append(self.pending_scrollback, text)
+}
+
+func (self *Loop) flush_scrollback() {
+	if len(self.pending_scrollback) == 0 {
+		return
+	}
+	if !self.modes.alt_screen {
+		// the alternate screen was exited after the text was queued
+		self.QueueWriteString(strings.Join(self.pending_scrollback, ""))
+		self.pending_scrollback = nil
+		return
+	}
+	var sb strings.Builder
+	atomic := !self.atomic_update_active
+	if atomic {
+		sb.WriteString(PENDING_UPDATE.EscapeCodeToSet())
+	}
+	// save the alternate screen cursor and switch to the main screen
+	// restoring its cursor
+	sb.WriteString(SAVE_CURSOR + ALTERNATE_SCREEN.EscapeCodeToReset())
+	for _, text := range self.pending_scrollback {
+		sb.WriteString(text)
+	}
+	// save the main screen cursor for when the alternate screen is finally
+	// exited, switch back without clearing and restore the cursor
+	sb.WriteString(SAVE_CURSOR + ALT_SCREEN_NO_CLEAR.EscapeCodeToSet() + RESTORE_CURSOR)
+	if atomic {
+		sb.WriteString(PENDING_UPDATE.EscapeCodeToReset())
+	}
+	self.pending_scrollback = nil
+	self.QueueWriteString(sb.String())
+}
+
+func alt_screen_toggle(enter bool) string {
+	if enter {
+		return ALTERNATE_SCREEN.EscapeCodeToSet() + CLEAR_SCREEN
+	}
+	return ALTERNATE_SCREEN.EscapeCodeToReset()
+}
+
+// Whether the alternate screen is currently in use, either because the loop
+// uses it, see NoAlternateScreen(), or because of EnterAltScreen() and
+// ExitAltScreen(). This remains accurate across suspending and resuming.
+func (self *Loop) IsAltScreen() bool {
+	return self.modes.alt_screen
+}
+
+// Switch to a clear alternate screen, does nothing if it is already in use
+// or the terminal is dumb
+func (self *Loop) EnterAltScreen() {
+	if !self.modes.alt_screen && !self.is_dumb_terminal {
+		self.QueueWriteString(alt_screen_toggle(true))
+		self.modes.alt_screen = true
+	}
+}
+
+// Switch back to the main screen, does nothing if it is already in use
+func (self *Loop) ExitAltScreen() {
+	if self.modes.alt_screen {
+		self.QueueWriteString(alt_screen_toggle(false))
+		self.modes.alt_screen = false
+	}
+}
@@ -0,0 +1,90 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestPrintToScrollback(t *testing.T) {
+	lp := new_test_loop()
+	// before the loop runs, text is kept for writing before the alternate
+	// screen is entered, with newlines normalized and a trailing one added
+	lp.PrintToScrollback("a\nb")
+	lp.PrintToScrollback("c\r\n")
+	if diff := cmp.Diff([]string{"a\r\nb\r\n", "c\r\n"}, lp.pending_scrollback); diff != "" {
+		t.Fatalf("Unexpected pending scrollback:\n%s", diff)
+	}
+	lp.timers = make([]*timer, 0, 1)
+	lp.modes.alt_screen = true
+	// while running in the alternate screen, text is written by switching
+	// to the main screen once per iteration of the loop
+	lp.flush_scrollback()
+	switch_to_main := SAVE_CURSOR + ALTERNATE_SCREEN.EscapeCodeToReset()
+	switch_back := SAVE_CURSOR + ALT_SCREEN_NO_CLEAR.EscapeCodeToSet() + RESTORE_CURSOR
+	expected := PENDING_UPDATE.EscapeCodeToSet() + switch_to_main + "a\r\nb\r\nc\r\n" + switch_back + PENDING_UPDATE.EscapeCodeToReset()
+	if diff := cmp.Diff(expected, lp.output()); diff != "" {
+		t.Fatalf("Unexpected output writing to the scrollback:\n%s", diff)
+	}
+	lp.flush_scrollback()
+	if q := lp.output(); q != "" || lp.pending_scrollback != nil {
+		t.Fatalf("Scrollback written twice: %#v", q)
+	}
+	// an atomic update in progress is not ended
+	lp.StartAtomicUpdate()
+	lp.output()
+	lp.PrintToScrollback("d")
+	lp.flush_scrollback()
+	if diff := cmp.Diff(switch_to_main+"d\r\n"+switch_back, lp.output()); diff != "" {
+		t.Fatalf("Unexpected output writing to the scrollback during an atomic update:\n%s", diff)
+	}
+	lp.EndAtomicUpdate()
+	lp.output()
+	// text queued before the alternate screen is exited is written as is
+	lp.PrintToScrollback("e")
+	lp.ExitAltScreen()
+	lp.flush_scrollback()
+	if diff := cmp.Diff(alt_screen_toggle(false)+"e\r\n", lp.output()); diff != "" {
+		t.Fatalf("Unexpected output writing to the scrollback after exiting the alternate screen:\n%s", diff)
+	}
+	// and written immediately in the main screen
+	lp.PrintToScrollback("f")
+	if q := lp.output(); q != "f\r\n" || lp.pending_scrollback != nil {
+		t.Fatalf("Unexpected output writing to the scrollback in the main screen: %#v", q)
+	}
+}
+
+func TestAltScreenTracking(t *testing.T) {
+	lp := new_test_loop()
+	lp.modes.alt_screen = true
+	output := lp.output
+	lp.EnterAltScreen()
+	if output() != "" {
+		t.Fatalf("Entering the alternate screen twice")
+	}
+	lp.ExitAltScreen()
+	if lp.IsAltScreen() || output() != ALTERNATE_SCREEN.EscapeCodeToReset() {
+		t.Fatalf("Alternate screen not exited")
+	}
+	// resuming after a suspend restores the main screen
+	lp.queue_setup_sequence()
+	if setup := output(); !strings.HasSuffix(setup, ALTERNATE_SCREEN.EscapeCodeToReset()) {
+		t.Fatalf("Setup sequence does not restore the main screen: %#v", setup)
+	}
+	lp.NoAlternateScreen()
+	lp.EnterAltScreen()
+	output()
+	lp.queue_teardown_sequence()
+	if teardown := output(); !strings.HasPrefix(teardown, ALTERNATE_SCREEN.EscapeCodeToReset()) {
+		t.Fatalf("Teardown sequence does not exit the alternate screen: %#v", teardown)
+	}
+	if err := lp.SetMode(ALTERNATE_SCREEN, false); err != nil || lp.IsAltScreen() {
+		t.Fatalf("SetMode() did not update the alternate screen state")
+	}
+}
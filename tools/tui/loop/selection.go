@@ -0,0 +1,262 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+type cell_position struct{ x, y int }
+
+// Tracks a selection of the text on a Screen made with the mouse, see
+// EnableTextSelection()
+type TextSelection struct {
+	// The screen the text is selected from, selection does nothing until it
+	// is set
+	Screen *Screen
+	// Select a rectangle of cells instead of the text between the start and
+	// end of the selection, a rectangle is also selected when ctrl+alt are
+	// held down while pressing the mouse button
+	Rectangular bool
+	// The SGR parameters added to selected cells, defaults to reverse video
+	HighlightSGR string
+	// Called to scroll the content of the Screen by amount lines when the
+	// mouse is dragged into the top or bottom AutoScrollEdge rows, negative
+	// amounts reveal content above. Return false if the content could not be
+	// scrolled. The selection is adjusted for the scroll, however only text
+	// on the Screen can be extracted. Auto scrolling is off if this is nil.
+	OnAutoScroll func(amount int) bool
+	// The height of the edge zones in cells, defaults to 1
+	AutoScrollEdge int
+	// The number of lines scrolled per second, defaults to 20
+	AutoScrollSpeed int
+
+	lp                       *Loop
+	on_copy                  func(string)
+	start, end               cell_position
+	active, has_selection    bool
+	rectangular_for_this_one bool
+	auto_scroll_timer        IdType
+	auto_scroll_direction    int
+}
+
+// Let the user select text on a Screen by dragging with the left mouse
+// button. When the button is released, the selected text is passed to
+// on_copy, for example, to send it to CopyTextToClipboard(). Selected cells
+// are highlighted by Screen.Flush(), a redraw is requested whenever the
+// selection changes. Set the Screen of the returned selection before any
+// text can be selected. Mouse events are also passed to any OnMouseEvent
+// handler already set. Uses mouse tracking with drag reporting, unless a mode
+// that reports more events is already set, which takes effect only if called
+// before Run().
+func (self *Loop) EnableTextSelection(on_copy func(text string)) *TextSelection {
+	ans := &TextSelection{lp: self, on_copy: on_copy, HighlightSGR: "7", AutoScrollEdge: 1, AutoScrollSpeed: 20}
+	if self.terminal_options.mouse_tracking < BUTTONS_AND_DRAG_MOUSE_TRACKING {
+		self.terminal_options.mouse_tracking = BUTTONS_AND_DRAG_MOUSE_TRACKING
+	}
+	prev := self.OnMouseEvent
+	self.OnMouseEvent = func(ev *MouseEvent) error {
+		ans.handle_mouse_event(ev)
+		if prev != nil {
+			return prev(ev)
+		}
+		return nil
+	}
+	return ans
+}
+
+func (self *TextSelection) is_rectangular() bool {
+	return self.Rectangular || self.rectangular_for_this_one
+}
+
+// The start and end of the selection in reading order
+func (self *TextSelection) ordered() (start, end cell_position) {
+	start, end = self.start, self.end
+	if end.y < start.y || (end.y == start.y && end.x < start.x) {
+		start, end = end, start
+	}
+	return
+}
+
+// The first and last selected columns in the specified row or ok == false
+// if no cells are selected in that row
+func (self *TextSelection) columns(y, width int) (first, last int, ok bool) {
+	if !self.has_selection {
+		return
+	}
+	start, end := self.ordered()
+	if y < start.y || y > end.y {
+		return
+	}
+	if self.is_rectangular() {
+		return utils.Min(start.x, end.x), utils.Max(start.x, end.x), true
+	}
+	first, last = 1, width
+	if y == start.y {
+		first = start.x
+	}
+	if y == end.y {
+		last = end.x
+	}
+	return first, last, true
+}
+
+// Whether the cell at the specified position is selected
+func (self *TextSelection) Contains(x, y int) bool {
+	width := 0
+	if self.Screen != nil {
+		width, _ = self.Screen.Size()
+	}
+	first, last, ok := self.columns(y, width)
+	return ok && first <= x && x <= last
+}
+
+func (self *TextSelection) HasSelection() bool {
+	return self.has_selection
+}
+
+// The selected text, with trailing spaces removed from every line
+func (self *TextSelection) Text() string {
+	if self.Screen == nil || !self.has_selection {
+		return ""
+	}
+	width, height := self.Screen.Size()
+	start, end := self.ordered()
+	lines := make([]string, 0, end.y-start.y+1)
+	// parts of the selection may have been scrolled off screen
+	for y := utils.Max(1, start.y); y <= utils.Min(height, end.y); y++ {
+		first, last, _ := self.columns(y, width)
+		if self.Screen.CellAt(first, y).Text == "" && first > 1 {
+			// include the whole of a wide character
+			first--
+		}
+		var sb strings.Builder
+		for x := first; x <= utils.Min(last, width); x++ {
+			sb.WriteString(self.Screen.CellAt(x, y).Text)
+		}
+		lines = append(lines, strings.TrimRight(sb.String(), " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Remove the selection
+func (self *TextSelection) Clear() {
+	if self.has_selection {
+		self.mark_dirty()
+		self.has_selection, self.active = false, false
+		self.lp.RequestRedraw()
+	}
+}
+
+func (self *TextSelection) mark_dirty() {
+	if self.Screen != nil && self.has_selection {
+		width, _ := self.Screen.Size()
+		start, end := self.ordered()
+		self.Screen.mark_overlay_dirty(start.y, 1, end.y-start.y+1, width)
+	}
+}
+
+func (self *TextSelection) overlay(x, y int, c Cell) Cell {
+	if self.Contains(x, y) {
+		if c.SGR == "" {
+			c.SGR = self.HighlightSGR
+		} else {
+			c.SGR += ";" + self.HighlightSGR
+		}
+	}
+	return c
+}
+
+func (self *TextSelection) handle_mouse_event(ev *MouseEvent) {
+	if self.Screen == nil || ev.Buttons&LEFT_MOUSE_BUTTON == 0 {
+		return
+	}
+	self.Screen.overlay = self.overlay
+	// mouse events use 0-based cells
+	pos := cell_position{ev.Cell.X + 1, ev.Cell.Y + 1}
+	if ev.Event_type != MOUSE_MOVE {
+		self.stop_auto_scroll()
+	}
+	switch ev.Event_type {
+	case MOUSE_PRESS:
+		self.Clear()
+		self.start, self.end, self.active = pos, pos, true
+		self.rectangular_for_this_one = ev.Mods&(CTRL|ALT) == CTRL|ALT
+	case MOUSE_MOVE:
+		if self.active && pos != self.end {
+			self.mark_dirty()
+			self.end, self.has_selection = pos, true
+			self.mark_dirty()
+			self.lp.RequestRedraw()
+		}
+		if self.active {
+			self.update_auto_scroll(pos.y)
+		}
+	case MOUSE_RELEASE:
+		if self.active {
+			self.active = false
+			if pos != self.end {
+				self.mark_dirty()
+				self.end, self.has_selection = pos, true
+				self.mark_dirty()
+				self.lp.RequestRedraw()
+			}
+			if self.has_selection && self.on_copy != nil {
+				self.on_copy(self.Text())
+			}
+		}
+	}
+}
+
+func (self *TextSelection) update_auto_scroll(y int) {
+	direction := 0
+	if self.OnAutoScroll != nil {
+		_, height := self.Screen.Size()
+		edge := utils.Max(1, self.AutoScrollEdge)
+		switch {
+		case y <= edge:
+			direction = -1
+		case y > height-edge:
+			direction = 1
+		}
+	}
+	if direction == self.auto_scroll_direction {
+		return
+	}
+	self.stop_auto_scroll()
+	if direction == 0 {
+		return
+	}
+	interval := time.Second / time.Duration(utils.Max(1, self.AutoScrollSpeed))
+	if id, err := self.lp.AddTimer(interval, true, self.auto_scroll); err == nil {
+		self.auto_scroll_timer, self.auto_scroll_direction = id, direction
+	}
+}
+
+func (self *TextSelection) stop_auto_scroll() {
+	if self.auto_scroll_direction != 0 {
+		self.lp.RemoveTimer(self.auto_scroll_timer)
+		self.auto_scroll_direction = 0
+	}
+}
+
+func (self *TextSelection) auto_scroll(IdType) error {
+	if !self.active || self.OnAutoScroll == nil || !self.OnAutoScroll(self.auto_scroll_direction) {
+		return nil
+	}
+	// the start of the selection moves with the content, the end stays with
+	// the mouse, in the edge zone
+	self.start.y -= self.auto_scroll_direction
+	self.has_selection = true
+	if width, height := self.Screen.Size(); height > 0 {
+		self.Screen.mark_overlay_dirty(1, 1, height, width)
+	}
+	self.lp.RequestRedraw()
+	return nil
+}
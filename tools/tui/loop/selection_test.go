@@ -0,0 +1,112 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+var _ = fmt.Print
+
+func TestTextSelection(t *testing.T) {
+	lp, _ := New()
+	copied := ""
+	sel := lp.EnableTextSelection(func(text string) { copied = text })
+	if lp.terminal_options.mouse_tracking != BUTTONS_AND_DRAG_MOUSE_TRACKING {
+		t.Fatalf("Mouse tracking not turned on")
+	}
+	s := NewScreen(8, 3)
+	s.WriteString(1, 1, "", "abc  ")
+	s.WriteString(1, 2, "1", "d世ef")
+	s.WriteString(1, 3, "", "ghijkl")
+	sel.Screen = s
+	s.Flush()
+	mouse := func(et MouseEventType, x, y int, mods KeyModifiers) {
+		ev := MouseEvent{Event_type: et, Buttons: LEFT_MOUSE_BUTTON, Mods: mods}
+		// mouse events use 0-based cells
+		ev.Cell.X, ev.Cell.Y = x-1, y-1
+		if err := lp.OnMouseEvent(&ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drag := func(x1, y1, x2, y2 int, mods KeyModifiers) {
+		copied = ""
+		mouse(MOUSE_PRESS, x1, y1, mods)
+		mouse(MOUSE_MOVE, x2, y2, mods)
+		mouse(MOUSE_RELEASE, x2, y2, mods)
+	}
+	drag(2, 1, 3, 3, 0)
+	if copied != "bc\nd世ef\nghi" {
+		t.Fatalf("Unexpected linear selection: %#v", copied)
+	}
+	if !sel.Contains(8, 2) || sel.Contains(1, 1) || sel.Contains(4, 3) {
+		t.Fatalf("Selection contains the wrong cells")
+	}
+	// the highlight is drawn without changing the cells
+	out := s.Flush()
+	if !strings.Contains(out, "\x1b[0;1;7m") || s.CellAt(2, 1).SGR != "" {
+		t.Fatalf("Selection not highlighted: %#v", out)
+	}
+	// selecting backwards and starting in the middle of a wide character
+	drag(5, 3, 3, 2, CTRL|ALT)
+	if copied != "世ef\nijk" {
+		t.Fatalf("Unexpected rectangular selection: %#v", copied)
+	}
+	mouse(MOUSE_PRESS, 1, 1, 0)
+	if sel.HasSelection() {
+		t.Fatalf("Selection not cleared by a press")
+	}
+	out = s.Flush()
+	if strings.Contains(out, ";7m") || out == "" {
+		t.Fatalf("Selection highlight not removed: %#v", out)
+	}
+}
+
+func TestTextSelectionAutoScroll(t *testing.T) {
+	lp, _ := New()
+	lp.timers = make([]*timer, 0, 1)
+	sel := lp.EnableTextSelection(nil)
+	sel.Screen = NewScreen(4, 4)
+	scrolled := 0
+	sel.OnAutoScroll = func(amount int) bool { scrolled += amount; return true }
+	mouse := func(et MouseEventType, x, y int) {
+		ev := MouseEvent{Event_type: et, Buttons: LEFT_MOUSE_BUTTON}
+		ev.Cell.X, ev.Cell.Y = x-1, y-1
+		_ = lp.OnMouseEvent(&ev)
+	}
+	now := time.Now()
+	tick := func() {
+		now = now.Add(time.Second)
+		if err := lp.dispatch_timers(now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mouse(MOUSE_PRESS, 2, 2)
+	mouse(MOUSE_MOVE, 2, 3)
+	if len(lp.timers) != 0 {
+		t.Fatalf("Auto scroll started outside the edge zone")
+	}
+	mouse(MOUSE_MOVE, 2, 4)
+	tick()
+	tick()
+	if scrolled != 2 || sel.start.y != 0 || !sel.Contains(1, 1) {
+		t.Fatalf("Unexpected auto scroll: %d start: %v", scrolled, sel.start)
+	}
+	mouse(MOUSE_MOVE, 2, 1)
+	tick()
+	if scrolled != 1 || len(lp.timers) != 1 {
+		t.Fatalf("Auto scroll did not change direction: %d", scrolled)
+	}
+	mouse(MOUSE_MOVE, 2, 2)
+	if len(lp.timers) != 0 {
+		t.Fatalf("Auto scroll not stopped on leaving the edge zone")
+	}
+	mouse(MOUSE_MOVE, 2, 4)
+	mouse(MOUSE_RELEASE, 2, 4)
+	if len(lp.timers) != 0 {
+		t.Fatalf("Auto scroll not stopped on release")
+	}
+}
@@ -0,0 +1,50 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+
+	"golang.org/x/exp/slices"
+)
+
+var _ = fmt.Print
+
+// Send setSeq to the terminal and then call verify to check that it took
+// effect, returning the result of verify. verify must query the terminal,
+// for example, with QueryMode(), which ensures that setSeq has been sent
+// before the query. See ModeVerifier() for the common case of modes.
+// Sequences that did not take effect are reported by StrippedSequences(),
+// useful to warn users when something between the program and the
+// terminal, such as a broken SSH wrapper or a recording proxy, strips or
+// mangles escape codes.
+func (self *Loop) VerifySequenceApplied(setSeq string, verify func() bool) bool {
+	self.QueueWriteString(setSeq)
+	if verify() {
+		return true
+	}
+	if !slices.Contains(self.stripped_sequences, setSeq) {
+		self.stripped_sequences = append(self.stripped_sequences, setSeq)
+	}
+	return false
+}
+
+// The sequences VerifySequenceApplied() found did not take effect, in the
+// order they were found
+func (self *Loop) StrippedSequences() []string {
+	return self.stripped_sequences
+}
+
+// Return a function for use with VerifySequenceApplied() that checks whether
+// the mode m is on or off, as specified, using DECRQM. It returns true when
+// this cannot be checked, because the terminal does not answer DECRQM or does
+// not recognize the mode.
+func (self *Loop) ModeVerifier(m Mode, on bool) func() bool {
+	return func() bool {
+		state, ok := self.QueryMode(m)
+		if !ok || state == MODE_NOT_RECOGNIZED {
+			return true
+		}
+		return state.IsSet() == on
+	}
+}
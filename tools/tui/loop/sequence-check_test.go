@@ -0,0 +1,49 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestVerifySequenceApplied(t *testing.T) {
+	lp := new_test_loop()
+	// a terminal behind a layer that strips the SGR mouse mode
+	modes := map[string]bool{}
+	lp.answer_queries(func(out string) (ans []string) {
+		for _, m := range []Mode{MOUSE_BUTTON_TRACKING, MOUSE_SGR_MODE} {
+			if strings.Contains(out, m.EscapeCodeToSet()) && m != MOUSE_SGR_MODE {
+				modes[m.String()] = true
+			}
+			if strings.Contains(out, decrqm_query(m)) {
+				state := 2
+				if modes[m.String()] {
+					state = 1
+				}
+				ans = append(ans, fmt.Sprintf("\x1b[?%d;%d$y", uint32(m&^private), state))
+			}
+		}
+		return
+	})
+	if !lp.VerifySequenceApplied(MOUSE_BUTTON_TRACKING.EscapeCodeToSet(), lp.ModeVerifier(MOUSE_BUTTON_TRACKING, true)) {
+		t.Fatalf("Mode that was set reported as stripped")
+	}
+	for i := 0; i < 2; i++ {
+		if lp.VerifySequenceApplied(MOUSE_SGR_MODE.EscapeCodeToSet(), lp.ModeVerifier(MOUSE_SGR_MODE, true)) {
+			t.Fatalf("Stripped mode reported as set")
+		}
+	}
+	if diff := cmp.Diff([]string{MOUSE_SGR_MODE.EscapeCodeToSet()}, lp.StrippedSequences()); diff != "" {
+		t.Fatalf("Unexpected stripped sequences:\n%s", diff)
+	}
+	// modes the terminal does not report cannot be checked
+	if !lp.VerifySequenceApplied(MOUSE_URXVT_MODE.EscapeCodeToSet(), lp.ModeVerifier(MOUSE_URXVT_MODE, true)) {
+		t.Fatalf("Unrecognized mode reported as stripped")
+	}
+}
@@ -0,0 +1,325 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var _ = fmt.Print
+
+// The text attributes set by SGR
+type sgr_pen struct {
+	bold, dim, italic, blink, rapid_blink, reverse, invisible, strikethrough, overline bool
+	// the underline style, zero for no underline
+	underline               int
+	fg, bg, underline_color Color
+}
+
+// Apply the SGR parameters to the pen. known is set to false if the
+// parameters include any that are not understood, as their effect on the pen
+// is unknown, and to true by a reset.
+func (self *sgr_pen) apply(sgr string, known *bool) {
+	params := strings.Split(sgr, ";")
+	for i := 0; i < len(params); i++ {
+		base, sub, has_sub := strings.Cut(params[i], ":")
+		n := 0
+		if base != "" {
+			var err error
+			if n, err = strconv.Atoi(base); err != nil {
+				*known = false
+				continue
+			}
+		}
+		switch {
+		case n == 0:
+			*self, *known = sgr_pen{}, true
+		case n == 1:
+			self.bold = true
+		case n == 2:
+			self.dim = true
+		case n == 3:
+			self.italic = true
+		case n == 4:
+			self.underline = 1
+			if has_sub {
+				if self.underline, _ = strconv.Atoi(sub); self.underline < 0 || self.underline > 5 {
+					*known = false
+				}
+			}
+		case n == 5:
+			self.blink = true
+		case n == 6:
+			self.rapid_blink = true
+		case n == 7:
+			self.reverse = true
+		case n == 8:
+			self.invisible = true
+		case n == 9:
+			self.strikethrough = true
+		case n == 21:
+			self.underline = 2
+		case n == 22:
+			self.bold, self.dim = false, false
+		case n == 221:
+			self.bold = false
+		case n == 222:
+			self.dim = false
+		case n == 23:
+			self.italic = false
+		case n == 24:
+			self.underline = 0
+		case n == 25:
+			self.blink, self.rapid_blink = false, false
+		case n == 27:
+			self.reverse = false
+		case n == 28:
+			self.invisible = false
+		case n == 29:
+			self.strikethrough = false
+		case n == 53:
+			self.overline = true
+		case n == 55:
+			self.overline = false
+		case 30 <= n && n <= 37:
+			self.fg = Color{Type: COLOR_INDEXED, Index: uint8(n - 30)}
+		case 90 <= n && n <= 97:
+			self.fg = Color{Type: COLOR_INDEXED, Index: uint8(n - 90 + 8)}
+		case n == 39:
+			self.fg = Color{}
+		case 40 <= n && n <= 47:
+			self.bg = Color{Type: COLOR_INDEXED, Index: uint8(n - 40)}
+		case 100 <= n && n <= 107:
+			self.bg = Color{Type: COLOR_INDEXED, Index: uint8(n - 100 + 8)}
+		case n == 49:
+			self.bg = Color{}
+		case n == 59:
+			self.underline_color = Color{}
+		case n == 38 || n == 48 || n == 58:
+			var c Color
+			if has_sub {
+				c, _ = parse_extended_color(strings.Split(sub, ":"))
+			} else {
+				var consumed int
+				c, consumed = parse_extended_color(params[i+1:])
+				i += consumed
+			}
+			if c.Type == COLOR_DEFAULT {
+				*known = false
+			}
+			switch n {
+			case 38:
+				self.fg = c
+			case 48:
+				self.bg = c
+			case 58:
+				self.underline_color = c
+			}
+		default:
+			*known = false
+		}
+	}
+}
+
+// The SGR parameters that set the attributes of the pen, starting from none
+func (self sgr_pen) params() (ans []string) {
+	flags := []struct {
+		on    bool
+		param string
+	}{
+		{self.bold, "1"}, {self.dim, "2"}, {self.italic, "3"}, {self.blink, "5"}, {self.rapid_blink, "6"},
+		{self.reverse, "7"}, {self.invisible, "8"}, {self.strikethrough, "9"}, {self.overline, "53"},
+	}
+	for _, f := range flags {
+		if f.on {
+			ans = append(ans, f.param)
+		}
+	}
+	switch self.underline {
+	case 0:
+	case 1:
+		ans = append(ans, "4")
+	default:
+		ans = append(ans, fmt.Sprintf("4:%d", self.underline))
+	}
+	color := func(c Color, base, first_basic, first_bright int) {
+		switch {
+		case c.Type == COLOR_DEFAULT:
+		case c.Type == COLOR_RGB:
+			ans = append(ans, fmt.Sprintf("%d;2;%d;%d;%d", base, c.Red, c.Green, c.Blue))
+		case first_basic > 0 && c.Index < 8:
+			ans = append(ans, strconv.Itoa(first_basic+int(c.Index)))
+		case first_bright > 0 && c.Index < 16:
+			ans = append(ans, strconv.Itoa(first_bright+int(c.Index)-8))
+		default:
+			ans = append(ans, fmt.Sprintf("%d;5;%d", base, c.Index))
+		}
+	}
+	color(self.fg, 38, 30, 90)
+	color(self.bg, 48, 40, 100)
+	color(self.underline_color, 58, 0, 0)
+	return
+}
+
+// Removes SGR codes that do not change the text attributes from the output
+type sgr_optimizer struct {
+	pen sgr_pen
+	// whether the attributes in the terminal are known to be those in pen
+	known bool
+}
+
+// Turn on an optimization of the output written to the terminal that removes
+// SGR escape codes that do not change the text attributes. Consecutive SGR
+// codes, with no text between them, are considered together, so the common
+// pattern of resetting and then setting attributes before every piece of
+// styled text is only written when the attributes actually change. This
+// can substantially reduce the amount of output for heavily styled text,
+// such as syntax highlighted code. Output after anything that could change
+// the attributes in ways the loop cannot track, such as restoring the cursor
+// or switching screens, is left alone till the next SGR reset. The output is
+// optimized as it is sent, so the optimization remains correct when writes
+// are reordered, see SetInteractiveBoost().
+func (self *Loop) OptimizeSGR() *Loop {
+	self.sgr_optimizer = &sgr_optimizer{}
+	return self
+}
+
+func OptimizeSGR(self *Loop) {
+	self.sgr_optimizer = &sgr_optimizer{}
+}
+
+func (self *Loop) optimize_output(data *write_msg) {
+	if self.sgr_optimizer == nil || self.is_dumb_terminal {
+		return
+	}
+	if data.bytes != nil {
+		if bytes.IndexByte(data.bytes, 0x1b) > -1 {
+			data.bytes = self.sgr_optimizer.optimize(data.bytes)
+		}
+	} else if strings.IndexByte(data.str, 0x1b) > -1 {
+		data.str = string(self.sgr_optimizer.optimize([]byte(data.str)))
+	}
+}
+
+// Modes that save or restore the attributes along with the cursor
+var modes_that_restore_attributes = []string{"1049", "1048", "1047", "47"}
+
+func (self *sgr_optimizer) optimize(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	var pending []byte
+	pen, known := self.pen, self.known
+	flush := func() {
+		if len(pending) > 0 {
+			if !known || !self.known || pen != self.pen {
+				out = append(out, pending...)
+			}
+			pending = pending[:0]
+			self.pen, self.known = pen, known
+		}
+	}
+	// the end of a string escape code, ST or, for OSC, BEL
+	string_end := func(start int) int {
+		for i := start; i < len(data); i++ {
+			if data[i] == 0x07 {
+				return i + 1
+			}
+			if data[i] == 0x1b && i+1 < len(data) && data[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return -1
+	}
+	// an escape code that is cut off, the attributes may be changed by the
+	// rest of it
+	incomplete := func(start int) []byte {
+		flush()
+		self.known = false
+		return append(out, data[start:]...)
+	}
+	for i := 0; i < len(data); {
+		if data[i] != 0x1b {
+			end := bytes.IndexByte(data[i:], 0x1b)
+			if end < 0 {
+				end = len(data)
+			} else {
+				end += i
+			}
+			flush()
+			out = append(out, data[i:end]...)
+			i = end
+			continue
+		}
+		if i+1 >= len(data) {
+			return incomplete(i)
+		}
+		var end int
+		switch data[i+1] {
+		case '[':
+			end = i + 2
+			for end < len(data) && (data[end] < 0x40 || data[end] > 0x7e) {
+				end++
+			}
+			if end >= len(data) {
+				return incomplete(i)
+			}
+			params, final := string(data[i+2:end]), data[end]
+			end++
+			if final == 'm' && strings.Trim(params, "0123456789;:") == "" {
+				pen.apply(params, &known)
+				pending = append(pending, data[i:end]...)
+				i = end
+				continue
+			}
+			flush()
+			switch {
+			case final == 'p' && params == "!":
+				// DECSTR soft reset
+				self.pen, self.known = sgr_pen{}, true
+			case final == 'u' && params == "":
+				// SCORC restore cursor
+				self.known = false
+			case (final == 'h' || final == 'l') && strings.HasPrefix(params, "?"):
+				for _, m := range strings.Split(params[1:], ";") {
+					for _, q := range modes_that_restore_attributes {
+						if m == q {
+							self.known = false
+						}
+					}
+				}
+			}
+		case ']', 'P', '_', '^', 'X':
+			if end = string_end(i + 2); end < 0 {
+				return incomplete(i)
+			}
+			flush()
+		default:
+			end = i + 1
+			for end < len(data) && data[end] >= 0x20 && data[end] <= 0x2f {
+				end++
+			}
+			if end >= len(data) {
+				return incomplete(i)
+			}
+			flush()
+			if end == i+1 {
+				switch data[end] {
+				case '8':
+					// DECRC restore cursor
+					self.known = false
+				case 'c':
+					// RIS full reset
+					self.pen, self.known = sgr_pen{}, true
+				}
+			}
+			end++
+		}
+		pen, known = self.pen, self.known
+		out = append(out, data[i:end]...)
+		i = end
+	}
+	flush()
+	return out
+}
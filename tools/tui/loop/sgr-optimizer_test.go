@@ -0,0 +1,114 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestSGROptimizer(t *testing.T) {
+	lp := new_test_loop(OptimizeSGR)
+	test := func(input, expected string) {
+		t.Helper()
+		lp.output()
+		lp.QueueWriteString(input)
+		if diff := cmp.Diff(expected, lp.output()); diff != "" {
+			t.Fatalf("Unexpected output for %q:\n%s", input, diff)
+		}
+	}
+	// the initial attributes are unknown
+	test("\x1b[31ma", "\x1b[31ma")
+	test("\x1b[mb\x1b[0m\x1b[31mc\x1b[0m\x1b[31md", "\x1b[mb\x1b[0m\x1b[31mcd")
+	test("\x1b[0;31me\x1b[39;31mf\x1b[1m\x1b[22mg", "efg")
+	test("\x1b[0m\x1b[31m", "")
+	test("\x1b[0mh", "\x1b[0mh")
+	test("\x1b[38:2::1:2:3mi\x1b[38;2;1;2;3mi", "\x1b[38:2::1:2:3mii")
+	test("\x1b[4:3mj\x1b[4mk", "\x1b[4:3mj\x1b[4mk")
+	// only SGR codes are removed
+	test("\x1b[H\x1b[>4;1m\x1b]8;;x\x1b\\\x1b[mk", "\x1b[H\x1b[>4;1m\x1b]8;;x\x1b\\\x1b[mk")
+	// the attributes are unknown after these till the next reset
+	for _, q := range []string{"\x1b8", "\x1b[u", "\x1b[?1049l", "\x1b[1;999m", "\x1b[0"} {
+		test(q, q)
+		test("\x1b[1ml\x1b[1m", "\x1b[1ml\x1b[1m")
+		test("\x1b[0ml\x1b[0m", "\x1b[0ml")
+	}
+	// resuming after a suspend
+	lp.queue_setup_sequence()
+	test("\x1b[0mm", "\x1b[0mm")
+}
+
+func TestSGROptimizerWithInteractiveBoost(t *testing.T) {
+	lp := new_test_loop(OptimizeSGR)
+	var mirror strings.Builder
+	lp.SetPlainTextMirror(&mirror)
+	lp.set_screen_size(20, 3)
+	lp.SetInteractiveBoost(time.Minute)
+	lp.OnKeyEvent = func(ev *KeyEvent) error {
+		lp.QueueWriteString("\x1b[0;32mkey")
+		return nil
+	}
+	lp.QueueWriteString("\x1b[0;31mlog1 ")
+	lp.output()
+	// this would be elided if optimized when queued, but the interactive
+	// write, which changes the attributes, is sent before it
+	lp.QueueWriteString("\x1b[0;31mlog2")
+	if err := lp.dispatch_input_data([]byte("\x1b[97u")); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("\x1b[0;32mkey\x1b[0;31mlog2", lp.output()); diff != "" {
+		t.Fatalf("Unexpected output:\n%s", diff)
+	}
+	if diff := cmp.Diff("log1\nlog1 key\nlog1 keylog2\n", mirror.String()); diff != "" {
+		t.Fatalf("Output not mirrored in the order it was sent:\n%s", diff)
+	}
+}
+
+// A full screen of syntax highlighted text, as it is typically drawn, with
+// each token resetting the attributes and then setting its own
+func colorful_render() []string {
+	var ans []string
+	colors := []string{"31", "1;32", "33", "34;3", "35", "36"}
+	for y := 1; y <= 50; y++ {
+		ans = append(ans, fmt.Sprintf("\x1b[%dH", y))
+		for x := 0; x < 40; x++ {
+			ans = append(ans, "\x1b[0m\x1b["+colors[(x/4+y)%len(colors)]+"m", "wd")
+		}
+	}
+	return ans
+}
+
+func render_size(lp *test_loop, render []string) int {
+	lp.output()
+	for _, w := range render {
+		lp.QueueWriteString(w)
+	}
+	return len(lp.output())
+}
+
+func TestSGROptimizerReduction(t *testing.T) {
+	render := colorful_render()
+	plain, lp := new_test_loop(), new_test_loop(OptimizeSGR)
+	before, after := render_size(plain, render), render_size(lp, render)
+	if after*2 > before {
+		t.Fatalf("Optimized output is not at most half the size: %d -> %d", before, after)
+	}
+}
+
+func BenchmarkSGROptimizer(b *testing.B) {
+	render := colorful_render()
+	plain, lp := new_test_loop(), new_test_loop(OptimizeSGR)
+	before := render_size(plain, render)
+	b.ResetTimer()
+	after := 0
+	for i := 0; i < b.N; i++ {
+		after = render_size(lp, render)
+	}
+	b.ReportMetric(100*float64(before-after)/float64(before), "%reduction")
+}
@@ -0,0 +1,122 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"kitty/tools/utils"
+	"kitty/tools/utils/style"
+)
+
+var _ = fmt.Print
+
+var sparkline_blocks = [...]string{" ", "▁", "▂", "▃", "▄", "▅", "▆", "▇", "█"}
+
+type SparklineStyle struct {
+	// The number of rows the chart occupies, defaults to one
+	Height int
+	// The range of values mapped onto the height of the chart. If both are
+	// zero, the range of the data is used.
+	Min, Max float64
+	// Colors for the bars, the lowest values use the first color, the
+	// highest the last and colors in between are interpolated. Uses the
+	// default foreground color if empty.
+	Gradient []style.RGBA
+}
+
+func downsample(values []float64, width int) []float64 {
+	if len(values) <= width {
+		return values
+	}
+	ans := make([]float64, width)
+	for i := range ans {
+		start, end := i*len(values)/width, (i+1)*len(values)/width
+		sum := 0.0
+		for _, v := range values[start:end] {
+			sum += v
+		}
+		ans[i] = sum / float64(end-start)
+	}
+	return ans
+}
+
+func gradient_color(gradient []style.RGBA, frac float64) style.RGBA {
+	if len(gradient) == 1 {
+		return gradient[0]
+	}
+	pos := frac * float64(len(gradient)-1)
+	idx := utils.Min(int(pos), len(gradient)-2)
+	t := pos - float64(idx)
+	a, b := gradient[idx], gradient[idx+1]
+	mix := func(x, y uint8) uint8 { return uint8(math.Round(float64(x) + t*(float64(y)-float64(x)))) }
+	return style.RGBA{Red: mix(a.Red, b.Red), Green: mix(a.Green, b.Green), Blue: mix(a.Blue, b.Blue)}
+}
+
+// Render values as a chart at most width cells wide, returning one string per
+// row of the chart, top row first. When there are more values than width they
+// are averaged down to fit.
+func RenderSparkline(values []float64, width int, s SparklineStyle) []string {
+	height := utils.Max(1, s.Height)
+	if width < 1 || len(values) == 0 {
+		return nil
+	}
+	values = downsample(values, width)
+	lo, hi := s.Min, s.Max
+	if lo == 0 && hi == 0 {
+		lo, hi = values[0], values[0]
+		for _, v := range values {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	levels := make([]int, len(values))
+	fracs := make([]float64, len(values))
+	for i, v := range values {
+		frac := 0.5 // constant data is drawn at half height
+		if hi > lo {
+			frac = math.Max(0, math.Min(1, (v-lo)/(hi-lo)))
+		}
+		fracs[i] = frac
+		// always draw at least the lowest block so that every value is visible
+		levels[i] = utils.Max(1, int(math.Round(frac*float64(height*8))))
+	}
+	rows := make([]string, height)
+	var sb strings.Builder
+	for r := range rows {
+		sb.Reset()
+		base := (height - 1 - r) * 8
+		for i, level := range levels {
+			block := sparkline_blocks[utils.Max(0, utils.Min(8, level-base))]
+			if len(s.Gradient) > 0 && block != " " {
+				c := gradient_color(s.Gradient, fracs[i])
+				sb.WriteString(fmt.Sprintf("\x1b[38:2:%d:%d:%dm%s", c.Red, c.Green, c.Blue, block))
+			} else {
+				sb.WriteString(block)
+			}
+		}
+		if len(s.Gradient) > 0 {
+			sb.WriteString("\x1b[39m")
+		}
+		rows[r] = sb.String()
+	}
+	return rows
+}
+
+// Draw a chart of values using block characters, with its top left corner at
+// the specified row and column (1-based, as for MoveCursorTo). The chart is
+// clipped to the screen.
+func (self *Loop) DrawSparkline(values []float64, row, col, width int, s SparklineStyle) {
+	if sz, err := self.ScreenSize(); err == nil {
+		width = utils.Min(width, int(sz.WidthCells)-col+1)
+		s.Height = utils.Min(utils.Max(1, s.Height), int(sz.HeightCells)-row+1)
+	}
+	if row < 1 || col < 1 || s.Height < 1 {
+		return
+	}
+	for i, line := range RenderSparkline(values, width, s) {
+		self.MoveCursorTo(col, row+i)
+		self.QueueWriteString(line)
+	}
+}
@@ -0,0 +1,57 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+
+	"kitty/tools/utils/style"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestSparkline(t *testing.T) {
+	red, green, blue := style.RGBA{Red: 255}, style.RGBA{Green: 255}, style.RGBA{Blue: 255}
+	for _, x := range []struct {
+		values   []float64
+		width    int
+		s        SparklineStyle
+		expected []string
+	}{
+		{[]float64{0, 1, 2, 3, 4, 5, 6, 7, 8}, 9, SparklineStyle{}, []string{"▁▁▂▃▄▅▆▇█"}},
+		{[]float64{0, 4, 8}, 5, SparklineStyle{Height: 2}, []string{"  █", "▁██"}},
+		// constant data is drawn at half height
+		{[]float64{5, 5}, 2, SparklineStyle{}, []string{"▄▄"}},
+		// values are averaged down to the width
+		{[]float64{0, 2, 4, 6}, 2, SparklineStyle{}, []string{"▁█"}},
+		// a fixed range, with values outside it clamped
+		{[]float64{5, 20, -3}, 3, SparklineStyle{Min: 0, Max: 10}, []string{"▄█▁"}},
+		{[]float64{0, 8}, 2, SparklineStyle{Gradient: []style.RGBA{red, blue}}, []string{"\x1b[38:2:255:0:0m▁\x1b[38:2:0:0:255m█\x1b[39m"}},
+		{[]float64{0, 4, 8}, 3, SparklineStyle{Gradient: []style.RGBA{red, green, blue}}, []string{
+			"\x1b[38:2:255:0:0m▁\x1b[38:2:0:255:0m▄\x1b[38:2:0:0:255m█\x1b[39m"}},
+		// blank cells are not colored
+		{[]float64{0, 8}, 2, SparklineStyle{Height: 2, Gradient: []style.RGBA{red}}, []string{
+			" \x1b[38:2:255:0:0m█\x1b[39m", "\x1b[38:2:255:0:0m▁\x1b[38:2:255:0:0m█\x1b[39m"}},
+		{nil, 2, SparklineStyle{}, nil},
+		{[]float64{1}, 0, SparklineStyle{}, nil},
+	} {
+		if diff := cmp.Diff(x.expected, RenderSparkline(x.values, x.width, x.s)); diff != "" {
+			t.Fatalf("Unexpected sparkline for %v in %d cells with %+v:\n%s", x.values, x.width, x.s, diff)
+		}
+	}
+
+	lp := new_test_loop()
+	lp.set_screen_size(4, 2)
+	// clipped to the screen
+	lp.DrawSparkline([]float64{0, 1, 2, 3, 4, 5, 6, 7, 8}, 2, 3, 10, SparklineStyle{Height: 3})
+	if diff := cmp.Diff("<move 2,3>▁█", lp.normalized_output()); diff != "" {
+		t.Fatalf("Unexpected output drawing a sparkline:\n%s", diff)
+	}
+	lp.DrawSparkline([]float64{1}, 3, 1, 10, SparklineStyle{})
+	if q := lp.output(); q != "" {
+		t.Fatalf("Sparkline below the screen drawn: %#v", q)
+	}
+}
@@ -0,0 +1,229 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+
+	"kitty/tools/utils"
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+type Direction int
+
+const (
+	// Panes are placed side by side, from left to right
+	HORIZONTAL Direction = iota
+	// Panes are stacked, from top to bottom
+	VERTICAL
+)
+
+// A rectangular area of the screen. Left and Top are 1-based, as for
+// MoveCursorTo().
+type Rect struct {
+	Left, Top, Width, Height int
+}
+
+func (self Rect) String() string {
+	return fmt.Sprintf("%dx%d@%d,%d", self.Width, self.Height, self.Left, self.Top)
+}
+
+func (self Rect) IsEmpty() bool { return self.Width < 1 || self.Height < 1 }
+
+// The area of the screen occupied by a pane, output written via it is
+// clipped to the area
+type PaneArea struct {
+	Rect
+	lp *Loop
+}
+
+// Write text on row y of the pane starting at column x, where x and y are
+// 1-based and relative to the pane. The parts of the text outside the pane
+// are not written. text must not contain newlines.
+func (self PaneArea) Print(x, y int, text string) {
+	if y < 1 || y > self.Height || x > self.Width {
+		return
+	}
+	if x < 1 {
+		w := wcswidth.Stringwidth(text)
+		if w <= 1-x {
+			return
+		}
+		text = wcswidth.ScrollWindow(text, 1-x, w-(1-x))
+		x = 1
+	}
+	text = wcswidth.TruncateToVisualLength(text, self.Width-x+1)
+	if text == "" {
+		return
+	}
+	self.lp.MoveCursorTo(self.Left+x-1, self.Top+y-1)
+	self.lp.QueueWriteString(text)
+}
+
+// Fill the pane with blanks
+func (self PaneArea) Clear() {
+	blank := strings.Repeat(" ", self.Width)
+	for y := 1; y <= self.Height; y++ {
+		self.Print(1, y, blank)
+	}
+}
+
+// A pane in a Split. Its size along the direction of the split is either
+// fixed or a share of the space left over after the fixed size panes.
+type Pane struct {
+	// A fixed size, in cells, zero means the pane gets a share of the
+	// remaining space
+	Size int
+	// The share of the remaining space this pane gets, relative to the other
+	// panes without a fixed size, zero is the same as one
+	Weight int
+	// The pane is hidden when it would be smaller than this, its space going
+	// to the other panes
+	MinSize int
+	// Draw the pane. Not called for hidden or empty panes.
+	OnRender func(area PaneArea) error
+	// Divide the pane further, OnRender is not used for such panes
+	Split *Split
+}
+
+// Divides a region of the screen into panes, see NewSplit()
+type Split struct {
+	// The region of the screen to divide, when empty the whole screen is used
+	Region Rect
+
+	lp        *Loop
+	direction Direction
+	children  []Pane
+	rects     []Rect
+}
+
+// Create a split that divides the screen, or Split.Region, into the
+// specified panes, placed in the specified direction. Call Render() from
+// OnRender to draw the panes. Pane rectangles are recomputed on every render,
+// so the layout follows changes to the screen size.
+func (self *Loop) NewSplit(direction Direction, children []Pane) *Split {
+	return &Split{lp: self, direction: direction, children: children}
+}
+
+func (self *Split) Panes() []Pane { return self.children }
+
+// The rectangles of the panes as of the last call to Layout() or Render().
+// Hidden panes have empty rectangles.
+func (self *Split) Rects() []Rect { return self.rects }
+
+// Sizes for the panes when dividing length cells, hidden panes get zero
+func (self *Split) sizes(length int) []int {
+	n := len(self.children)
+	hidden := make([]bool, n)
+	ans := make([]int, n)
+	for {
+		left := length
+		total_weight := 0
+		for i, p := range self.children {
+			ans[i] = 0
+			switch {
+			case hidden[i]:
+			case p.Size > 0:
+				ans[i] = utils.Min(p.Size, left)
+				left -= ans[i]
+			default:
+				total_weight += utils.Max(1, p.Weight)
+			}
+		}
+		if total_weight > 0 {
+			distributed := 0
+			for i, p := range self.children {
+				if !hidden[i] && p.Size < 1 {
+					ans[i] = left * utils.Max(1, p.Weight) / total_weight
+					distributed += ans[i]
+				}
+			}
+			// cells lost to rounding go to the first panes
+			for i, p := range self.children {
+				if distributed >= left {
+					break
+				}
+				if !hidden[i] && p.Size < 1 {
+					ans[i]++
+					distributed++
+				}
+			}
+		}
+		// hide panes that are too small, starting with the last one
+		too_small := -1
+		for i, p := range self.children {
+			if !hidden[i] && ans[i] < p.MinSize {
+				too_small = i
+			}
+		}
+		if too_small < 0 {
+			return ans
+		}
+		hidden[too_small] = true
+	}
+}
+
+// Compute the rectangles of the panes, and of the panes of nested splits,
+// when dividing the specified region
+func (self *Split) Layout(region Rect) []Rect {
+	length := region.Width
+	if self.direction == VERTICAL {
+		length = region.Height
+	}
+	pos := 0
+	self.rects = make([]Rect, len(self.children))
+	for i, sz := range self.sizes(length) {
+		r := region
+		if self.direction == VERTICAL {
+			r.Top, r.Height = region.Top+pos, sz
+		} else {
+			r.Left, r.Width = region.Left+pos, sz
+		}
+		pos += sz
+		if sz < 1 {
+			r = Rect{}
+		}
+		self.rects[i] = r
+		if s := self.children[i].Split; s != nil {
+			s.Layout(r)
+		}
+	}
+	return self.rects
+}
+
+func (self *Split) render() error {
+	for i, p := range self.children {
+		r := self.rects[i]
+		if r.IsEmpty() {
+			continue
+		}
+		if p.Split != nil {
+			if err := p.Split.render(); err != nil {
+				return err
+			}
+		} else if p.OnRender != nil {
+			if err := p.OnRender(PaneArea{Rect: r, lp: self.lp}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Lay out the panes in the region of the screen occupied by the split and
+// draw them
+func (self *Split) Render() error {
+	region := self.Region
+	if region.IsEmpty() {
+		sz, err := self.lp.ScreenSize()
+		if err != nil {
+			return err
+		}
+		region = Rect{Left: 1, Top: 1, Width: int(sz.WidthCells), Height: int(sz.HeightCells)}
+	}
+	self.Layout(region)
+	return self.render()
+}
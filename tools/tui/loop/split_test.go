@@ -0,0 +1,53 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestSplit(t *testing.T) {
+	lp := new_test_loop()
+	lp.set_screen_size(20, 10)
+	rendered := map[string]Rect{}
+	pane := func(name string, size, weight, min_size int) Pane {
+		return Pane{Size: size, Weight: weight, MinSize: min_size, OnRender: func(a PaneArea) error {
+			rendered[name] = a.Rect
+			return nil
+		}}
+	}
+	preview := lp.NewSplit(VERTICAL, []Pane{pane("info", 2, 0, 0), pane("preview", 0, 0, 3)})
+	s := lp.NewSplit(HORIZONTAL, []Pane{pane("files", 0, 2, 0), {Size: 1}, {Split: preview, MinSize: 5}})
+
+	test := func(expected map[string]Rect) {
+		t.Helper()
+		rendered = map[string]Rect{}
+		if err := s.Render(); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(expected, rendered); diff != "" {
+			t.Fatalf("Unexpected layout for %s:\n%s", s.Region, diff)
+		}
+	}
+	// 19 cells shared 2:1, with the extra cell going to the first pane
+	test(map[string]Rect{"files": {1, 1, 13, 10}, "info": {15, 1, 6, 2}, "preview": {15, 3, 6, 8}})
+	lp.set_screen_size(9, 4)
+	test(map[string]Rect{"files": {1, 1, 8, 4}})
+	s.Region = Rect{Left: 3, Top: 2, Width: 16, Height: 4}
+	test(map[string]Rect{"files": {3, 2, 10, 4}, "info": {14, 2, 5, 2}})
+
+	lp.output()
+	a := PaneArea{Rect: Rect{Left: 3, Top: 2, Width: 4, Height: 2}, lp: lp.Loop}
+	a.Print(2, 1, "abcdef")
+	a.Print(-1, 2, "abcdef")
+	a.Print(1, 3, "abcdef")
+	a.Print(-6, 1, "abcdef")
+	if diff := cmp.Diff("<move 2,4>abc<move 3,3>cdef", lp.normalized_output()); diff != "" {
+		t.Fatalf("Output not clipped to the pane:\n%s", diff)
+	}
+}
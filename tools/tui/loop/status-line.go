@@ -0,0 +1,133 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+
+	"kitty/tools/utils"
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+// A status line on the bottom row of the screen, see NewStatusLine()
+type StatusLine struct {
+	lp                  *Loop
+	left, center, right string
+	closed              bool
+}
+
+// Reserve the bottom row of the screen for a status line with left, center
+// and right aligned segments. A scroll region is set on the rest of the
+// screen so that normal output scrolls above the status line without
+// overwriting it. The status line is redrawn whenever a segment changes and
+// when the screen is resized, after OnResize is called. If the application
+// clears the screen it must call Refresh(). Segments can contain SGR
+// formatting and tabs, which are laid out using the tab stops set with
+// SetTabStops(). When the segments do not all fit, the left segment has
+// priority, followed by the right and then the center. Only one status line
+// can be active at a time, creating a new one closes the previous one.
+func (self *Loop) NewStatusLine() *StatusLine {
+	if self.status_line != nil {
+		self.status_line.Close()
+	}
+	self.status_line = &StatusLine{lp: self}
+	self.status_line.Refresh()
+	return self.status_line
+}
+
+func (self *StatusLine) SetLeft(text string)   { self.set(&self.left, text) }
+func (self *StatusLine) SetCenter(text string) { self.set(&self.center, text) }
+func (self *StatusLine) SetRight(text string)  { self.set(&self.right, text) }
+
+func (self *StatusLine) set(which *string, text string) {
+	if *which != text {
+		*which = text
+		self.Refresh()
+	}
+}
+
+// Place text so that it ends as close to the end column as it can, starting
+// no earlier than the zero based column min_start, truncating it if it does
+// not fit. As tabs advance to tab stops, how wide text is depends on where it
+// starts. Returns the possibly truncated text, its start column and width.
+func right_align_with_tabs(text string, min_start, end, tab_width int) (string, int, int) {
+	for start := end; start >= min_start; start-- {
+		if w := wcswidth.StringwidthWithTabs(text, start, tab_width); start+w <= end {
+			return text, start, w
+		}
+	}
+	text, w := wcswidth.TruncateToVisualLengthWithTabs(text, end-min_start, min_start, tab_width)
+	return text, min_start, w
+}
+
+func layout_status_line(left, center, right string, width, tab_width int) string {
+	left, lw := wcswidth.TruncateToVisualLengthWithTabs(left, width, 0, tab_width)
+	min_start := lw
+	if lw > 0 {
+		min_start++ // keep a gap between segments
+	}
+	right, rstart, rw := right_align_with_tabs(right, min_start, width, tab_width)
+	gap_start, gap_end := min_start, rstart // the cells [gap_start, gap_end) are free
+	if rw > 0 {
+		gap_end--
+	}
+	var sb strings.Builder
+	sb.WriteString(left)
+	pos := lw
+	if center != "" && gap_end > gap_start {
+		_, cw := wcswidth.TruncateToVisualLengthWithTabs(center, gap_end-gap_start, gap_start, tab_width)
+		start := utils.Max(gap_start, utils.Min((width-cw)/2, gap_end-cw))
+		// the width of text with tabs changes with where it starts
+		center, cw = wcswidth.TruncateToVisualLengthWithTabs(center, gap_end-start, start, tab_width)
+		sb.WriteString(strings.Repeat(" ", start-pos))
+		sb.WriteString(center)
+		pos = start + cw
+	}
+	if rw > 0 {
+		sb.WriteString(strings.Repeat(" ", rstart-pos))
+		sb.WriteString(right)
+	}
+	return sb.String()
+}
+
+// Redraw the status line and reset the scroll region
+func (self *StatusLine) Refresh() {
+	if self.closed {
+		return
+	}
+	sz, err := self.lp.ScreenSize()
+	if err != nil || sz.HeightCells < 2 || sz.WidthCells < 1 {
+		return
+	}
+	h := int(sz.HeightCells)
+	self.lp.SaveCursor()
+	// setting the scroll region moves the cursor to the top left corner
+	self.lp.SetScrollRegion(1, h-1)
+	self.lp.without_origin_mode(func() {
+		self.lp.MoveCursorTo(1, h)
+		self.lp.QueueWriteString("\x1b[m\x1b[2K" + layout_status_line(self.left, self.center, self.right, int(sz.WidthCells), self.lp.TabWidth()) + "\x1b[m")
+	})
+	self.lp.RestoreCursor()
+}
+
+// Remove the status line, blanking the bottom row and restoring the full
+// screen scroll region
+func (self *StatusLine) Close() {
+	if self.closed {
+		return
+	}
+	self.closed = true
+	if self.lp.status_line == self {
+		self.lp.status_line = nil
+	}
+	self.lp.SaveCursor()
+	self.lp.SetScrollRegion(0, 0)
+	if sz, err := self.lp.ScreenSize(); err == nil {
+		self.lp.MoveCursorTo(1, int(sz.HeightCells))
+		self.lp.QueueWriteString("\x1b[m\x1b[2K")
+	}
+	self.lp.RestoreCursor()
+}
@@ -0,0 +1,55 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestStatusLineRefresh(t *testing.T) {
+	lp := new_test_loop()
+	lp.set_screen_size(12, 5)
+	refresh := func(sl *StatusLine, expected string, x, y int) {
+		t.Helper()
+		lp.output()
+		sl.Refresh()
+		if diff := cmp.Diff(expected, lp.normalized_output()); diff != "" {
+			t.Fatalf("Unexpected output:\n%s", diff)
+		}
+		if lp.cursors.current.x != x || lp.cursors.current.y != y {
+			t.Fatalf("Cursor left at %d,%d instead of %d,%d", lp.cursors.current.x, lp.cursors.current.y, x, y)
+		}
+	}
+	sl := lp.NewStatusLine()
+	sl.SetLeft("left")
+	sl.SetRight("right")
+	// the cursor position is not known, so it must be restored by the
+	// terminal every time, not left on the status line
+	for i := 0; i < 2; i++ {
+		refresh(sl, "<ESC 7><CSI 1;4r><move 5,1><SGR 0><erase line 2>left   right<SGR 0><ESC 8>", 0, 0)
+	}
+	lp.MoveCursorTo(3, 2)
+	for i := 0; i < 2; i++ {
+		refresh(sl, "<ESC 7><CSI 1;4r><move 5,1><SGR 0><erase line 2>left   right<SGR 0><ESC 8>", 3, 2)
+	}
+	// the attributes active when the status line is drawn are restored
+	// afterwards, by the loop if they were set with SetSGR(), otherwise by
+	// the terminal
+	lp.SetSGR("1")
+	refresh(sl, "<CSI 1;4r><move 5,1><SGR 0><erase line 2>left   right<SGR 0><move 2,3><SGR 1>", 3, 2)
+	lp.QueueWriteString("\x1b[31m")
+	lp.MoveCursorTo(3, 2)
+	refresh(sl, "<ESC 7><CSI 1;4r><move 5,1><SGR 0><erase line 2>left   right<SGR 0><ESC 8>", 3, 2)
+	sl.Close()
+	if diff := cmp.Diff("<ESC 7><CSI r><move 5,1><SGR 0><erase line 2><ESC 8>", lp.normalized_output()); diff != "" {
+		t.Fatalf("Unexpected output closing the status line:\n%s", diff)
+	}
+	if lp.cursors.current.x != 3 || lp.cursors.current.y != 2 || lp.cursors.current.sgr_known {
+		t.Fatalf("Unexpected cursor state after closing the status line: %+v", lp.cursors.current)
+	}
+}
@@ -0,0 +1,119 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+type tab_stops_state struct {
+	width   int
+	changed bool
+}
+
+// The distance between the tab stops of the terminal, as set by
+// SetTabStops() or found by QueryTabStops(), defaults to eight. Use it with
+// wcswidth.StringwidthWithTabs() to measure text containing tabs.
+func (self *Loop) TabWidth() int {
+	if self.tab_stops.width > 0 {
+		return self.tab_stops.width
+	}
+	return wcswidth.DefaultTabWidth
+}
+
+func tab_stops_escape_code(width, screen_width int) string {
+	var sb strings.Builder
+	// clear all tab stops and then set one every width columns
+	sb.WriteString("\x1b[3g")
+	for col := 1 + width; col <= screen_width; col += width {
+		sb.WriteString(fmt.Sprintf("\x1b[%dG\x1bH", col))
+	}
+	return sb.String()
+}
+
+// Set tab stops every width columns across the screen, a width of zero
+// means the default of every eight columns. The cursor is left where it was.
+// The default tab stops are restored when the loop exits.
+func (self *Loop) SetTabStops(width int) {
+	if width < 1 {
+		width = wcswidth.DefaultTabWidth
+	}
+	sz, err := self.ScreenSize()
+	if err != nil || sz.WidthCells == 0 {
+		return
+	}
+	self.SaveCursor()
+	self.QueueWriteString(tab_stops_escape_code(width, int(sz.WidthCells)))
+	self.RestoreCursor()
+	self.tab_stops.width = width
+	self.tab_stops.changed = width != wcswidth.DefaultTabWidth
+	if self.status_line != nil {
+		// tabs in it are laid out using the tab stops
+		self.status_line.Refresh()
+	}
+}
+
+func (self *Loop) default_tab_stops_escape_code() string {
+	if !self.tab_stops.changed || self.is_dumb_terminal || !self.screen_size.updated {
+		return ""
+	}
+	self.tab_stops.width, self.tab_stops.changed = 0, false
+	return "\x1b7" + tab_stops_escape_code(wcswidth.DefaultTabWidth, int(self.screen_size.WidthCells)) + "\x1b8"
+}
+
+// Parse the DECTABSR response, the list of tab stop columns separated by /
+func parse_tab_stops_report(raw string) (ans []int, ok bool) {
+	if raw == "" {
+		return ans, true
+	}
+	for _, x := range strings.Split(raw, "/") {
+		col, err := strconv.Atoi(x)
+		if err != nil || col < 1 {
+			return nil, false
+		}
+		ans = append(ans, col)
+	}
+	return ans, true
+}
+
+// Query the terminal for the columns (1-based) of its tab stops using
+// DECTABSR. If the tab stops are evenly spaced from the first column, the
+// spacing is used for TabWidth(). Blocks till the terminal answers, buffering
+// other input received in the meantime. Returns ErrQueryNotAnswered if the
+// terminal does not support the query.
+func (self *Loop) QueryTabStops() (stops []int, err error) {
+	ok := false
+	found, err := self.query_terminal("\x1b[2$w", default_query_timeout, func(etype EscapeCodeType, raw []byte) bool {
+		if etype != DCS {
+			return false
+		}
+		val, is_report := strings.CutPrefix(string(raw), "2$u")
+		if !is_report {
+			return false
+		}
+		stops, ok = parse_tab_stops_report(val)
+		return true
+	})
+	if !ok {
+		if err == nil || found {
+			err = ErrQueryNotAnswered
+		}
+		return nil, err
+	}
+	if len(stops) > 0 && stops[0] > 1 {
+		width := stops[0] - 1
+		for i, col := range stops {
+			if col != 1+(i+1)*width {
+				return
+			}
+		}
+		self.tab_stops.width = width
+	}
+	return
+}
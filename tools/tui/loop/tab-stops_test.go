@@ -0,0 +1,89 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestTabStops(t *testing.T) {
+	lp := new_test_loop()
+	var out strings.Builder
+	lp.SetPlainTextMirror(&out)
+	lp.set_screen_size(20, 3)
+	if lp.TabWidth() != 8 {
+		t.Fatalf("Unexpected default tab width: %d", lp.TabWidth())
+	}
+	lp.MoveCursorTo(3, 2)
+	lp.output()
+	lp.SetTabStops(6)
+	if diff := cmp.Diff("<ESC 7><CSI 3g><CSI 7G><ESC H><CSI 13G><ESC H><CSI 19G><ESC H><ESC 8>", lp.normalized_output()); diff != "" {
+		t.Fatalf("Unexpected output setting tab stops:\n%s", diff)
+	}
+	if lp.TabWidth() != 6 {
+		t.Fatalf("Tab width not updated: %d", lp.TabWidth())
+	}
+
+	// a status line with tabs in its segments is laid out using the tab stops
+	sl := lp.NewStatusLine()
+	sl.SetLeft("file\tsize")
+	sl.SetRight("OK")
+	lp.output()
+	out.Reset()
+	lp.SetTabStops(4)
+	lp.output()
+	if diff := cmp.Diff("file    size      OK\n", out.String()); diff != "" {
+		t.Fatalf("Status line not aligned:\n%s", diff)
+	}
+	// the right segment is placed where its tab leaves it ending at the edge
+	sl.SetRight("x\tdone")
+	out.Reset()
+	lp.output()
+	if diff := cmp.Diff("file    size  x done\n", out.String()); diff != "" {
+		t.Fatalf("Status line not aligned:\n%s", diff)
+	}
+	sl.Close()
+
+	lp.output()
+	if q := lp.default_tab_stops_escape_code(); !strings.HasPrefix(q, "\x1b7\x1b[3g\x1b[9G\x1bH\x1b[17G\x1bH\x1b8") || lp.TabWidth() != 8 {
+		t.Fatalf("Default tab stops not restored: %#v", q)
+	}
+	if q := lp.default_tab_stops_escape_code(); q != "" {
+		t.Fatalf("Default tab stops restored twice: %#v", q)
+	}
+
+	response := ""
+	lp.answer_queries(func(string) []string { return []string{"\x1bP" + response + "\x1b\\"} })
+	for _, x := range []struct {
+		response  string
+		stops     []int
+		tab_width int
+	}{
+		{"2$u5/9/13", []int{5, 9, 13}, 4},
+		{"2$u9/17/20", []int{9, 17, 20}, 4},
+		{"2$u", nil, 4},
+		{"2$u11", []int{11}, 10},
+	} {
+		response = x.response
+		stops, err := lp.QueryTabStops()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(x.stops, stops); diff != "" {
+			t.Fatalf("Unexpected tab stops for %#v:\n%s", x.response, diff)
+		}
+		if lp.TabWidth() != x.tab_width {
+			t.Fatalf("Unexpected tab width for %#v: %d", x.response, lp.TabWidth())
+		}
+	}
+	response = "1$r0m"
+	if _, err := lp.QueryTabStops(); err != ErrQueryNotAnswered {
+		t.Fatalf("Unexpected error for an unanswered query: %v", err)
+	}
+}
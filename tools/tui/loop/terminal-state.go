@@ -48,6 +48,7 @@ const (
 	LNM                    Mode = 20
 	IRM                    Mode = 4
 	DECKM                  Mode = 1 | private
+	DECCOLM                Mode = 3 | private
 	DECSCNM                Mode = 5 | private
 	DECOM                  Mode = 6 | private
 	DECAWM                 Mode = 7 | private
@@ -61,6 +62,7 @@ const (
 	MOUSE_SGR_MODE         Mode = 1006 | private
 	MOUSE_URXVT_MODE       Mode = 1015 | private
 	MOUSE_SGR_PIXEL_MODE   Mode = 1016 | private
+	ALT_SCREEN_NO_CLEAR    Mode = 47 | private
 	ALTERNATE_SCREEN       Mode = 1049 | private
 	BRACKETED_PASTE        Mode = 2004 | private
 	PENDING_UPDATE         Mode = 2026 | private
@@ -98,6 +100,18 @@ type TerminalStateOptions struct {
 	alternate_screen, restore_colors bool
 	mouse_tracking                   MouseTracking
 	kitty_keyboard_mode              KeyboardStateBits
+	focus_tracking                   bool
+	flow_control                     bool
+}
+
+// The terminal modes changed while the loop runs, as opposed to the ones
+// set up from TerminalStateOptions
+type mode_state struct {
+	origin, alt_screen, bracketed_paste     bool
+	scroll_region_top, scroll_region_bottom int
+	column                                  *column_mode_state
+	runtime                                 map[Mode]bool
+	strict                                  bool
 }
 
 func set_modes(sb *strings.Builder, modes ...Mode) {
@@ -128,6 +142,9 @@ func (self *TerminalStateOptions) SetStateEscapeCodes() string {
 		IRM, DECKM, DECSCNM, BRACKETED_PASTE, FOCUS_TRACKING,
 		MOUSE_BUTTON_TRACKING, MOUSE_MOTION_TRACKING, MOUSE_MOVE_TRACKING, MOUSE_UTF8_MODE, MOUSE_SGR_MODE)
 	set_modes(&sb, DECARM, DECAWM, DECTCEM)
+	if self.focus_tracking {
+		set_modes(&sb, FOCUS_TRACKING)
+	}
 	if self.alternate_screen {
 		set_modes(&sb, ALTERNATE_SCREEN)
 		sb.WriteString(CLEAR_SCREEN)
@@ -138,7 +155,9 @@ func (self *TerminalStateOptions) SetStateEscapeCodes() string {
 		sb.WriteString("\033[>u")
 	}
 	if self.mouse_tracking != NO_MOUSE_TRACKING {
-		sb.WriteString(MOUSE_SGR_PIXEL_MODE.EscapeCodeToSet())
+		// terminals that do not support the pixel mode use the SGR mode
+		// rather than the legacy encoding, which is limited to 223 columns
+		set_modes(&sb, MOUSE_SGR_MODE, MOUSE_SGR_PIXEL_MODE)
 		switch self.mouse_tracking {
 		case BUTTONS_ONLY_MOUSE_TRACKING:
 			sb.WriteString(MOUSE_BUTTON_TRACKING.EscapeCodeToSet())
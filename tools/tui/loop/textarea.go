@@ -0,0 +1,423 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"kitty/tools/utils"
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+// A multi-line text input widget that occupies a rectangular region of the
+// screen. Lines are word wrapped to fit the width and the region scrolls to
+// keep the cursor visible. Feed it key events and text via OnKeyEvent() and
+// OnText() and call Draw() to render it.
+type TextArea struct {
+	lp                       *Loop
+	top, left, height, width int
+	lines                    []string
+	// the cursor as a line number and a byte offset into that line
+	cursor_line, cursor_offset int
+	// the first visible visual row
+	scroll int
+	// the column to aim for when moving the cursor up or down, -1 when unset
+	preferred_x int
+}
+
+// A single row on screen, the byte range [start, end) of a line
+type visual_row struct {
+	line, start, end int
+}
+
+type text_cell struct {
+	text       string
+	pos, width int
+}
+
+// Create a text area with its top left corner at the specified row and column
+// (1-based, as for MoveCursorTo)
+func (self *Loop) NewTextArea(top, left, height, width int) *TextArea {
+	ans := &TextArea{lp: self, lines: []string{""}, preferred_x: -1}
+	ans.SetGeometry(top, left, height, width)
+	return ans
+}
+
+func (self *TextArea) SetGeometry(top, left, height, width int) {
+	self.top, self.left = top, left
+	self.height, self.width = utils.Max(1, height), utils.Max(1, width)
+	self.ensure_cursor_visible()
+}
+
+func (self *TextArea) Text() string {
+	return strings.Join(self.lines, "\n")
+}
+
+// Replace the text, placing the cursor at the end
+func (self *TextArea) SetText(text string) {
+	self.lines = strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	self.cursor_line = len(self.lines) - 1
+	self.cursor_offset = len(self.lines[self.cursor_line])
+	self.scroll, self.preferred_x = 0, -1
+	self.ensure_cursor_visible()
+}
+
+// The cursor position as a (zero-based) line number and a byte offset into
+// that line
+func (self *TextArea) CursorPosition() (line, offset int) {
+	return self.cursor_line, self.cursor_offset
+}
+
+func (self *TextArea) SetCursorPosition(line, offset int) {
+	self.cursor_line = utils.Max(0, utils.Min(line, len(self.lines)-1))
+	self.cursor_offset = utils.Max(0, utils.Min(offset, len(self.lines[self.cursor_line])))
+	self.preferred_x = -1
+	self.ensure_cursor_visible()
+}
+
+func cells_of(text string) (ans []text_cell) {
+	ci := wcswidth.NewCellIterator(text)
+	pos := 0
+	for ci.Forward() {
+		c := ci.Current()
+		ans = append(ans, text_cell{text: c, pos: pos, width: wcswidth.Stringwidth(c)})
+		pos += len(c)
+	}
+	return
+}
+
+func is_space_cell(c string) bool {
+	return c == " "
+}
+
+func (self *TextArea) wrap_line(line int) (ans []visual_row) {
+	text := self.lines[line]
+	start, width := 0, 0
+	// the position and row width just after the last space in the current row
+	brk, brk_width := -1, 0
+	for _, c := range cells_of(text) {
+		for width+c.width > self.width && width > 0 {
+			if brk > start {
+				ans = append(ans, visual_row{line, start, brk})
+				start, width = brk, width-brk_width
+			} else {
+				ans = append(ans, visual_row{line, start, c.pos})
+				start, width = c.pos, 0
+			}
+			brk = -1
+		}
+		width += c.width
+		if is_space_cell(c.text) {
+			brk, brk_width = c.pos+len(c.text), width
+		}
+	}
+	return append(ans, visual_row{line, start, len(text)})
+}
+
+func (self *TextArea) visual_rows() (ans []visual_row) {
+	for i := range self.lines {
+		ans = append(ans, self.wrap_line(i)...)
+	}
+	return
+}
+
+// The row the cursor is on and its column in cells within that row
+func (self *TextArea) cursor_visual_position(rows []visual_row) (row, x int) {
+	for i, r := range rows {
+		if r.line != self.cursor_line || self.cursor_offset < r.start {
+			continue
+		}
+		last_row_of_line := i+1 >= len(rows) || rows[i+1].line != r.line
+		if self.cursor_offset < r.end || last_row_of_line {
+			return i, wcswidth.Stringwidth(self.lines[r.line][r.start:self.cursor_offset])
+		}
+	}
+	return 0, 0
+}
+
+// The byte offset in the line of the cell in row closest to column x
+func (self *TextArea) offset_for_x(r visual_row, x int) int {
+	text := self.lines[r.line]
+	width := 0
+	for _, c := range cells_of(text[r.start:r.end]) {
+		if width+c.width > x {
+			return r.start + c.pos
+		}
+		width += c.width
+	}
+	if r.end > r.start && r.end < len(text) {
+		// the end of a wrapped row is the start of the next row, so stop on
+		// the last cell of this row instead
+		cells := cells_of(text[r.start:r.end])
+		return r.start + cells[len(cells)-1].pos
+	}
+	return r.end
+}
+
+func (self *TextArea) ensure_cursor_visible() {
+	if self.lines == nil {
+		return
+	}
+	row, _ := self.cursor_visual_position(self.visual_rows())
+	if row < self.scroll {
+		self.scroll = row
+	} else if row >= self.scroll+self.height {
+		self.scroll = row - self.height + 1
+	}
+}
+
+func (self *TextArea) current_line() string { return self.lines[self.cursor_line] }
+
+func (self *TextArea) cell_before_cursor() string {
+	cells := cells_of(self.current_line()[:self.cursor_offset])
+	if len(cells) == 0 {
+		return ""
+	}
+	return cells[len(cells)-1].text
+}
+
+func (self *TextArea) cell_after_cursor() string {
+	cells := cells_of(self.current_line()[self.cursor_offset:])
+	if len(cells) == 0 {
+		return ""
+	}
+	return cells[0].text
+}
+
+func (self *TextArea) insert_text(text string) {
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+	line := self.current_line()
+	before, after := line[:self.cursor_offset], line[self.cursor_offset:]
+	new_lines := strings.Split(before+text, "\n")
+	self.cursor_offset = len(new_lines[len(new_lines)-1])
+	new_lines[len(new_lines)-1] += after
+	self.lines = append(self.lines[:self.cursor_line], append(new_lines, self.lines[self.cursor_line+1:]...)...)
+	self.cursor_line += len(new_lines) - 1
+}
+
+// Delete the text between the cursor and the specified position on the
+// current line, which can be before or after the cursor
+func (self *TextArea) erase_to(offset int) {
+	line := self.current_line()
+	start, end := utils.Min(offset, self.cursor_offset), utils.Max(offset, self.cursor_offset)
+	self.lines[self.cursor_line] = line[:start] + line[end:]
+	self.cursor_offset = start
+}
+
+func (self *TextArea) join_with_next_line() {
+	if self.cursor_line+1 < len(self.lines) {
+		self.lines[self.cursor_line] += self.lines[self.cursor_line+1]
+		self.lines = append(self.lines[:self.cursor_line+1], self.lines[self.cursor_line+2:]...)
+	}
+}
+
+func (self *TextArea) move_left() bool {
+	if c := self.cell_before_cursor(); c != "" {
+		self.cursor_offset -= len(c)
+		return true
+	}
+	if self.cursor_line > 0 {
+		self.cursor_line--
+		self.cursor_offset = len(self.current_line())
+		return true
+	}
+	return false
+}
+
+func (self *TextArea) move_right() bool {
+	if c := self.cell_after_cursor(); c != "" {
+		self.cursor_offset += len(c)
+		return true
+	}
+	if self.cursor_line+1 < len(self.lines) {
+		self.cursor_line++
+		self.cursor_offset = 0
+		return true
+	}
+	return false
+}
+
+func is_word_cell(c string) bool {
+	for _, ch := range c {
+		if unicode.IsLetter(ch) || unicode.IsDigit(ch) {
+			return true
+		}
+	}
+	return false
+}
+
+// The offset of the start of the word before the cursor on the current line
+func (self *TextArea) start_of_previous_word() int {
+	cells := cells_of(self.current_line()[:self.cursor_offset])
+	i := len(cells) - 1
+	for ; i >= 0 && !is_word_cell(cells[i].text); i-- {
+	}
+	for ; i >= 0 && is_word_cell(cells[i].text); i-- {
+	}
+	if i < 0 {
+		return 0
+	}
+	return cells[i].pos + len(cells[i].text)
+}
+
+// The offset of the end of the word after the cursor on the current line
+func (self *TextArea) end_of_next_word() int {
+	cells := cells_of(self.current_line()[self.cursor_offset:])
+	i := 0
+	for ; i < len(cells) && !is_word_cell(cells[i].text); i++ {
+	}
+	for ; i < len(cells) && is_word_cell(cells[i].text); i++ {
+	}
+	if i >= len(cells) {
+		return len(self.current_line())
+	}
+	return self.cursor_offset + cells[i].pos
+}
+
+func (self *TextArea) move_vertically(amt int) bool {
+	rows := self.visual_rows()
+	row, x := self.cursor_visual_position(rows)
+	if self.preferred_x < 0 {
+		self.preferred_x = x
+	}
+	target := row + amt
+	if target < 0 || target >= len(rows) {
+		return false
+	}
+	r := rows[target]
+	self.cursor_line, self.cursor_offset = r.line, self.offset_for_x(r, self.preferred_x)
+	return true
+}
+
+func (self *TextArea) current_visual_row() visual_row {
+	rows := self.visual_rows()
+	row, _ := self.cursor_visual_position(rows)
+	return rows[row]
+}
+
+func (self *TextArea) perform_key_action(ev *KeyEvent) bool {
+	switch {
+	case ev.MatchesPressOrRepeat("enter") || ev.MatchesPressOrRepeat("shift+enter"):
+		self.insert_text("\n")
+	case ev.MatchesPressOrRepeat("left") || ev.MatchesPressOrRepeat("ctrl+b"):
+		self.move_left()
+	case ev.MatchesPressOrRepeat("right") || ev.MatchesPressOrRepeat("ctrl+f"):
+		self.move_right()
+	case ev.MatchesPressOrRepeat("up"):
+		self.move_vertically(-1)
+		return true
+	case ev.MatchesPressOrRepeat("down"):
+		self.move_vertically(1)
+		return true
+	case ev.MatchesPressOrRepeat("page_up"):
+		self.move_vertically(-utils.Min(self.height, self.cursor_row()))
+		return true
+	case ev.MatchesPressOrRepeat("page_down"):
+		rows := self.visual_rows()
+		row, _ := self.cursor_visual_position(rows)
+		self.move_vertically(utils.Min(self.height, len(rows)-1-row))
+		return true
+	case ev.MatchesPressOrRepeat("home") || ev.MatchesPressOrRepeat("ctrl+a"):
+		self.cursor_offset = self.current_visual_row().start
+	case ev.MatchesPressOrRepeat("end") || ev.MatchesPressOrRepeat("ctrl+e"):
+		r := self.current_visual_row()
+		self.cursor_offset = self.offset_for_x(r, self.width)
+	case ev.MatchesPressOrRepeat("ctrl+home"):
+		self.cursor_line, self.cursor_offset = 0, 0
+	case ev.MatchesPressOrRepeat("ctrl+end"):
+		self.cursor_line = len(self.lines) - 1
+		self.cursor_offset = len(self.current_line())
+	case ev.MatchesPressOrRepeat("ctrl+left") || ev.MatchesPressOrRepeat("alt+left") || ev.MatchesPressOrRepeat("alt+b"):
+		if self.cursor_offset == 0 {
+			self.move_left()
+		} else {
+			self.cursor_offset = self.start_of_previous_word()
+		}
+	case ev.MatchesPressOrRepeat("ctrl+right") || ev.MatchesPressOrRepeat("alt+right") || ev.MatchesPressOrRepeat("alt+f"):
+		if self.cursor_offset == len(self.current_line()) {
+			self.move_right()
+		} else {
+			self.cursor_offset = self.end_of_next_word()
+		}
+	case ev.MatchesPressOrRepeat("backspace") || ev.MatchesPressOrRepeat("ctrl+h"):
+		if self.cursor_offset > 0 {
+			self.erase_to(self.cursor_offset - len(self.cell_before_cursor()))
+		} else if self.move_left() {
+			self.join_with_next_line()
+		}
+	case ev.MatchesPressOrRepeat("delete"):
+		if self.cursor_offset < len(self.current_line()) {
+			self.erase_to(self.cursor_offset + len(self.cell_after_cursor()))
+		} else {
+			self.join_with_next_line()
+		}
+	case ev.MatchesPressOrRepeat("ctrl+backspace") || ev.MatchesPressOrRepeat("alt+backspace") || ev.MatchesPressOrRepeat("ctrl+w"):
+		if self.cursor_offset > 0 {
+			self.erase_to(self.start_of_previous_word())
+		} else if self.move_left() {
+			self.join_with_next_line()
+		}
+	case ev.MatchesPressOrRepeat("ctrl+delete") || ev.MatchesPressOrRepeat("alt+d"):
+		if self.cursor_offset < len(self.current_line()) {
+			self.erase_to(self.end_of_next_word())
+		} else {
+			self.join_with_next_line()
+		}
+	case ev.MatchesPressOrRepeat("ctrl+k"):
+		if self.cursor_offset < len(self.current_line()) {
+			self.erase_to(len(self.current_line()))
+		} else {
+			self.join_with_next_line()
+		}
+	case ev.MatchesPressOrRepeat("ctrl+u"):
+		self.erase_to(0)
+	default:
+		return false
+	}
+	self.preferred_x = -1
+	return true
+}
+
+func (self *TextArea) cursor_row() int {
+	row, _ := self.cursor_visual_position(self.visual_rows())
+	return row
+}
+
+// Handle a key event, setting ev.Handled if the key event was used by the
+// text area. Call Draw() afterwards to update the screen.
+func (self *TextArea) OnKeyEvent(ev *KeyEvent) error {
+	if self.perform_key_action(ev) {
+		ev.Handled = true
+		self.ensure_cursor_visible()
+	}
+	return nil
+}
+
+// Insert text at the cursor, suitable for use as the handler for OnText
+func (self *TextArea) OnText(text string, from_key_event, in_bracketed_paste bool) error {
+	self.insert_text(text)
+	self.preferred_x = -1
+	self.ensure_cursor_visible()
+	return nil
+}
+
+// Render the visible rows of the text area and place the cursor in it
+func (self *TextArea) Draw() {
+	rows := self.visual_rows()
+	cursor_row, cursor_x := self.cursor_visual_position(rows)
+	blank := strings.Repeat(" ", self.width)
+	for i := 0; i < self.height; i++ {
+		self.lp.MoveCursorTo(self.left, self.top+i)
+		text := ""
+		if r := self.scroll + i; r < len(rows) {
+			text = self.lines[rows[r].line][rows[r].start:rows[r].end]
+		}
+		w := wcswidth.Stringwidth(text)
+		self.lp.QueueWriteString(text + blank[:utils.Max(0, self.width-w)])
+	}
+	self.lp.MoveCursorTo(self.left+utils.Min(cursor_x, self.width-1), self.top+cursor_row-self.scroll)
+}
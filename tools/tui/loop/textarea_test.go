@@ -0,0 +1,104 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestTextArea(t *testing.T) {
+	lp, _ := New()
+	ta := lp.NewTextArea(1, 1, 2, 6)
+	rows := func() (ans []string) {
+		for _, r := range ta.visual_rows() {
+			ans = append(ans, ta.lines[r.line][r.start:r.end])
+		}
+		return
+	}
+	key := func(spec string) {
+		t.Helper()
+		ps := ParseShortcut(spec)
+		ev := &KeyEvent{Type: PRESS, Key: ps.KeyName, Mods: ps.Mods}
+		if _ = ta.OnKeyEvent(ev); !ev.Handled {
+			t.Fatalf("Key %s not handled", spec)
+		}
+	}
+	cursor := func(line, offset int) {
+		t.Helper()
+		l, o := ta.CursorPosition()
+		if l != line || o != offset {
+			t.Fatalf("Cursor at (%d, %d) expected (%d, %d) text: %#v", l, o, line, offset, ta.Text())
+		}
+	}
+
+	ta.SetText("one two three")
+	if diff := cmp.Diff([]string{"one ", "two ", "three"}, rows()); diff != "" {
+		t.Fatalf("Word wrap incorrect:\n%s", diff)
+	}
+	if ta.scroll != 1 {
+		t.Fatalf("Did not scroll to show cursor: %d", ta.scroll)
+	}
+	ta.SetText("abcdefghij")
+	if diff := cmp.Diff([]string{"abcdef", "ghij"}, rows()); diff != "" {
+		t.Fatalf("Hard wrap incorrect:\n%s", diff)
+	}
+	ta.SetText("ab一丁丂")
+	if diff := cmp.Diff([]string{"ab一丁", "丂"}, rows()); diff != "" {
+		t.Fatalf("Wide char wrap incorrect:\n%s", diff)
+	}
+	key("up")
+	cursor(0, 2)
+	key("down")
+	cursor(0, 2+9)
+	key("left")
+	cursor(0, 2+6)
+	key("up")
+	cursor(0, 0)
+
+	ta.SetText("éx")
+	key("left")
+	key("left")
+	cursor(0, 0)
+	key("delete")
+	if ta.Text() != "x" {
+		t.Fatalf("Grapheme not deleted: %#v", ta.Text())
+	}
+
+	ta.SetText("hello")
+	key("enter")
+	_ = ta.OnText("world", false, false)
+	if ta.Text() != "hello\nworld" {
+		t.Fatalf("Unexpected text: %#v", ta.Text())
+	}
+	cursor(1, 5)
+	key("home")
+	cursor(1, 0)
+	key("backspace")
+	cursor(0, 5)
+	if ta.Text() != "helloworld" {
+		t.Fatalf("Lines not joined: %#v", ta.Text())
+	}
+	key("end")
+	cursor(0, 5)
+	key("ctrl+end")
+	cursor(0, 10)
+	_ = ta.OnText(" foo bar", false, true)
+	key("ctrl+w")
+	if ta.Text() != "helloworld foo " {
+		t.Fatalf("Word not deleted: %#v", ta.Text())
+	}
+	key("ctrl+home")
+	key("alt+d")
+	if ta.Text() != " foo " {
+		t.Fatalf("Next word not deleted: %#v", ta.Text())
+	}
+	_ = ta.OnText("a\r\nb", false, true)
+	if ta.Text() != "a\nb foo " {
+		t.Fatalf("Pasted newlines not handled: %#v", ta.Text())
+	}
+}
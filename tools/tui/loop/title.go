@@ -0,0 +1,74 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+)
+
+var _ = fmt.Print
+
+type saved_title struct {
+	in_terminal_stack, known bool
+	title                    string
+}
+
+// An allowlist of terminals known to implement the XTWINOPS title stack, as
+// prefixes of their XTVERSION response. Terminals cannot be asked whether they
+// support the title stack and those that do not silently ignore its escape
+// codes, so support is assumed only for these terminals. Others use the
+// fallback of querying the title.
+var title_stack_allowlist = []string{"kitty(", "XTerm(", "foot(", "WezTerm ", "tmux "}
+
+func (self *Loop) supports_title_stack() bool {
+	if self.detected.title_stack == nil {
+		ans := self.terminal_version_has_prefix(title_stack_allowlist...)
+		self.detected.title_stack = &ans
+	}
+	return *self.detected.title_stack
+}
+
+// Query the current window title using XTWINOPS
+func (self *Loop) query_title() (title string, ok bool) {
+	ok, _ = self.query_terminal("\x1b[21t", default_query_timeout, func(etype EscapeCodeType, raw []byte) bool {
+		if etype == OSC && len(raw) > 0 && raw[0] == 'l' {
+			title = string(raw[1:])
+			return true
+		}
+		return false
+	})
+	return
+}
+
+// Set the window title, saving the current title so that it can be restored
+// by the matching call to PopTitle(). Calls can be nested. Uses the title
+// stack of the terminal in the terminals known to support it, which
+// restores the title exactly
+// even if something else, such as a subprocess, changes the title in the
+// meantime. Otherwise, falls back to querying the current title, which many
+// terminals do not allow, in which case PopTitle() cannot restore it.
+func (self *Loop) PushTitle(title string) {
+	s := saved_title{}
+	if self.supports_title_stack() {
+		s.in_terminal_stack = true
+		self.QueueWriteString("\x1b[22;2t")
+	} else {
+		s.title, s.known = self.query_title()
+	}
+	self.title_stack = append(self.title_stack, s)
+	self.SetWindowTitle(title)
+}
+
+// Restore the title saved by the matching call to PushTitle()
+func (self *Loop) PopTitle() {
+	if len(self.title_stack) == 0 {
+		return
+	}
+	s := self.title_stack[len(self.title_stack)-1]
+	self.title_stack = self.title_stack[:len(self.title_stack)-1]
+	if s.in_terminal_stack {
+		self.QueueWriteString("\x1b[23;2t")
+	} else if s.known {
+		self.SetWindowTitle(s.title)
+	}
+}
@@ -0,0 +1,38 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestPushTitle(t *testing.T) {
+	for version, expected := range map[string][]string{
+		// allowlisted terminals use the title stack
+		"kitty(0.28.1)": {"\x1b[>q\x1b[c\x1b[22;2t\x1b]2;new\x1b\\", "\x1b[23;2t"},
+		"tmux 3.3a":     {"\x1b[>q\x1b[c\x1b[22;2t\x1b]2;new\x1b\\", "\x1b[23;2t"},
+		// others query the title
+		"Unknown 1.0": {"\x1b[>q\x1b[c\x1b[21t\x1b[c\x1b]2;new\x1b\\", "\x1b]2;old\x1b\\"},
+	} {
+		lp := new_test_loop()
+		lp.answer_queries(func(output string) []string {
+			switch {
+			case strings.HasPrefix(output, "\x1b[>q"):
+				return []string{"\x1bP>|" + version + "\x1b\\"}
+			case strings.HasPrefix(output, "\x1b[21t"):
+				return []string{"\x1b]lold\x1b\\"}
+			}
+			return nil
+		})
+		lp.PushTitle("new")
+		pushed := lp.output()
+		lp.PopTitle()
+		if actual := []string{pushed, lp.output()}; fmt.Sprintf("%#v", actual) != fmt.Sprintf("%#v", expected) {
+			t.Fatalf("Unexpected output for %#v: %#v", version, actual)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+
+	"kitty/tools/utils"
+	"kitty/tools/wcswidth"
+)
+
+var _ = fmt.Print
+
+// A node in a TreeView
+type TreeNode struct {
+	Label string
+	// Arbitrary data for use by the application
+	Data     any
+	Children []*TreeNode
+	// Called the first time a node without Children is expanded to load its
+	// children. A node with this set is shown as expandable till it has been
+	// loaded.
+	LoadChildren func(node *TreeNode) ([]*TreeNode, error)
+	Expanded     bool
+
+	parent *TreeNode
+	loaded bool
+}
+
+// The node this node is a child of, nil for the root. Only set once the node
+// has been shown in a TreeView.
+func (self *TreeNode) Parent() *TreeNode {
+	return self.parent
+}
+
+func (self *TreeNode) is_expandable() bool {
+	return len(self.Children) > 0 || (self.LoadChildren != nil && !self.loaded)
+}
+
+// The indicator drawn before the label of the node
+func (self *TreeNode) indicator(leaf string) string {
+	switch {
+	case !self.is_expandable():
+		return leaf
+	case self.Expanded:
+		return "▾"
+	}
+	return "▸"
+}
+
+// A collapsible hierarchical list of nodes that occupies a rectangular region
+// of the screen, with one node selected. Nodes are drawn indented below their
+// parents with guide lines joining them, and with an indicator showing
+// whether they are expanded. The region scrolls to keep the selected node
+// visible. Feed it key events via OnKeyEvent() and call Draw() to render it.
+//
+// Keys: up and down move the selection, page_up, page_down, home and end
+// move it further. right expands the selected node or, if it is already
+// expanded, moves to its first child. left collapses the selected node or, if
+// it is not expanded, moves to its parent. enter expands or collapses the
+// selected node or, if it has no children, calls OnSelect.
+type TreeView struct {
+	// Called when enter is pressed on a node that has no children
+	OnSelect func(node *TreeNode) error
+	// Called when the LoadChildren callback of a node fails, if not set, the
+	// error is returned from OnKeyEvent(). The node is left collapsed and
+	// loading is tried again the next time it is expanded.
+	OnLoadError func(node *TreeNode, err error) error
+	// Shortcuts handled before the built-in keys, keyed by shortcut spec,
+	// such as ctrl+r, called with the selected node
+	Keys map[string]func(node *TreeNode) error
+	// Show only the descendants of the root, not the root itself
+	HideRoot bool
+	// The SGR parameters used to highlight the selected node, defaults to
+	// reverse video
+	SelectedSGR string
+
+	lp                       *Loop
+	root, selected           *TreeNode
+	top, left, height, width int
+	// the first visible row
+	scroll int
+}
+
+// A visible node and the guide lines drawn before it
+type tree_row struct {
+	node   *TreeNode
+	prefix string
+}
+
+// Create a tree view showing root, which is expanded if it has Children. It
+// occupies the whole screen until SetGeometry() is called.
+func (self *Loop) NewTreeView(root *TreeNode) *TreeView {
+	root.parent, root.Expanded = nil, len(root.Children) > 0
+	return &TreeView{lp: self, root: root, selected: root, SelectedSGR: "7", top: 1, left: 1}
+}
+
+// Place the top left corner of the tree view at the specified row and column
+// (1-based, as for MoveCursorTo), a height or width of zero means up to the
+// bottom or right edge of the screen
+func (self *TreeView) SetGeometry(top, left, height, width int) {
+	self.top, self.left = utils.Max(1, top), utils.Max(1, left)
+	self.height, self.width = utils.Max(0, height), utils.Max(0, width)
+	self.ensure_selected_visible(self.rows())
+}
+
+func (self *TreeView) size() (height, width int) {
+	height, width = self.height, self.width
+	if height == 0 || width == 0 {
+		sz, err := self.lp.ScreenSize()
+		if err != nil || sz.WidthCells == 0 {
+			return utils.Max(1, height), utils.Max(1, width)
+		}
+		if height == 0 {
+			height = int(sz.HeightCells) - self.top + 1
+		}
+		if width == 0 {
+			width = int(sz.WidthCells) - self.left + 1
+		}
+	}
+	return utils.Max(1, height), utils.Max(1, width)
+}
+
+func (self *TreeView) Root() *TreeNode {
+	return self.root
+}
+
+// The selected node, never nil
+func (self *TreeView) Selected() *TreeNode {
+	return self.selected
+}
+
+// Select node, expanding its ancestors so that it is visible. Does nothing
+// if node is not in the tree.
+func (self *TreeView) Select(node *TreeNode) {
+	var path []*TreeNode
+	var find func(n *TreeNode) bool
+	find = func(n *TreeNode) bool {
+		if n == node {
+			return true
+		}
+		for _, c := range n.Children {
+			if find(c) {
+				path = append(path, n)
+				return true
+			}
+		}
+		return false
+	}
+	if !find(self.root) {
+		return
+	}
+	for _, n := range path {
+		n.Expanded = true
+	}
+	self.selected = node
+	if self.HideRoot && node == self.root {
+		self.selected = self.first_visible()
+	}
+	self.ensure_selected_visible(self.rows())
+}
+
+// The visible nodes in display order
+func (self *TreeView) rows() (ans []tree_row) {
+	var add func(n *TreeNode, guides string, depth int)
+	add = func(n *TreeNode, guides string, depth int) {
+		if !n.Expanded {
+			return
+		}
+		for i, c := range n.Children {
+			c.parent = n
+			last := i == len(n.Children)-1
+			indicator := c.indicator("─")
+			if depth == 0 {
+				ans = append(ans, tree_row{node: c, prefix: c.indicator(" ") + " "})
+				add(c, "", depth+1)
+				continue
+			}
+			connector, guide := "├─", "│ "
+			if last {
+				connector, guide = "└─", "  "
+			}
+			ans = append(ans, tree_row{node: c, prefix: guides + connector + indicator + " "})
+			add(c, guides+guide, depth+1)
+		}
+	}
+	if self.HideRoot {
+		add(self.root, "", 0)
+	} else {
+		ans = append(ans, tree_row{node: self.root, prefix: self.root.indicator(" ") + " "})
+		add(self.root, "", 1)
+	}
+	return
+}
+
+func (self *TreeView) first_visible() *TreeNode {
+	if rows := self.rows(); len(rows) > 0 {
+		return rows[0].node
+	}
+	return self.root
+}
+
+func index_of_node(rows []tree_row, node *TreeNode) int {
+	for i, r := range rows {
+		if r.node == node {
+			return i
+		}
+	}
+	return -1
+}
+
+func (self *TreeView) ensure_selected_visible(rows []tree_row) {
+	height, _ := self.size()
+	idx := utils.Max(0, index_of_node(rows, self.selected))
+	if idx < self.scroll {
+		self.scroll = idx
+	} else if idx >= self.scroll+height {
+		self.scroll = idx - height + 1
+	}
+	self.scroll = utils.Max(0, utils.Min(self.scroll, len(rows)-height))
+}
+
+// Expand node, loading its children if needed
+func (self *TreeView) expand(node *TreeNode) error {
+	if !node.loaded && node.LoadChildren != nil && len(node.Children) == 0 {
+		children, err := node.LoadChildren(node)
+		if err != nil {
+			if self.OnLoadError != nil {
+				return self.OnLoadError(node, err)
+			}
+			return err
+		}
+		node.Children, node.loaded = children, true
+	}
+	node.Expanded = len(node.Children) > 0
+	return nil
+}
+
+func (self *TreeView) move_selection(rows []tree_row, amt int) {
+	if len(rows) == 0 {
+		return
+	}
+	idx := index_of_node(rows, self.selected) + amt
+	self.selected = rows[utils.Max(0, utils.Min(idx, len(rows)-1))].node
+}
+
+func (self *TreeView) perform_key_action(ev *KeyEvent) (bool, error) {
+	for spec, f := range self.Keys {
+		if ev.MatchesPressOrRepeat(spec) {
+			return true, f(self.selected)
+		}
+	}
+	rows := self.rows()
+	height, _ := self.size()
+	node := self.selected
+	switch {
+	case ev.MatchesPressOrRepeat("up"):
+		self.move_selection(rows, -1)
+	case ev.MatchesPressOrRepeat("down"):
+		self.move_selection(rows, 1)
+	case ev.MatchesPressOrRepeat("page_up"):
+		self.move_selection(rows, -height)
+	case ev.MatchesPressOrRepeat("page_down"):
+		self.move_selection(rows, height)
+	case ev.MatchesPressOrRepeat("home"):
+		self.move_selection(rows, -len(rows))
+	case ev.MatchesPressOrRepeat("end"):
+		self.move_selection(rows, len(rows))
+	case ev.MatchesPressOrRepeat("right"):
+		if node.Expanded && len(node.Children) > 0 {
+			self.move_selection(rows, 1)
+		} else if !node.Expanded && node.is_expandable() {
+			return true, self.expand(node)
+		}
+	case ev.MatchesPressOrRepeat("left"):
+		if node.Expanded && !(self.HideRoot && node == self.root) {
+			node.Expanded = false
+		} else if p := node.parent; p != nil && !(self.HideRoot && p == self.root) {
+			self.selected = p
+		}
+	case ev.MatchesPressOrRepeat("enter"):
+		switch {
+		case node.Expanded:
+			node.Expanded = false
+		case node.is_expandable():
+			return true, self.expand(node)
+		case self.OnSelect != nil:
+			return true, self.OnSelect(node)
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// Handle a key event, setting ev.Handled if the key event was used by the
+// tree view. Call Draw() afterwards to update the screen.
+func (self *TreeView) OnKeyEvent(ev *KeyEvent) (err error) {
+	var handled bool
+	if handled, err = self.perform_key_action(ev); handled {
+		ev.Handled = true
+		rows := self.rows()
+		if index_of_node(rows, self.selected) < 0 {
+			self.selected = self.first_visible()
+		}
+		self.ensure_selected_visible(rows)
+	}
+	return
+}
+
+// The text of the visible rows, clipped to the width of the tree view and
+// padded with spaces to it
+func (self *TreeView) render_rows(rows []tree_row) (ans []string) {
+	height, width := self.size()
+	for i := self.scroll; i < len(rows) && i < self.scroll+height; i++ {
+		label := strings.NewReplacer("\n", " ", "\r", " ", "\t", " ").Replace(rows[i].node.Label)
+		text, w := wcswidth.TruncateToVisualLengthWithWidth(rows[i].prefix+label, width)
+		ans = append(ans, text+strings.Repeat(" ", width-w))
+	}
+	return
+}
+
+// Render the visible rows of the tree view, highlighting the selected node
+func (self *TreeView) Draw() {
+	rows := self.rows()
+	if index_of_node(rows, self.selected) < 0 {
+		self.selected = self.first_visible()
+	}
+	self.ensure_selected_visible(rows)
+	height, width := self.size()
+	lines := self.render_rows(rows)
+	blank := strings.Repeat(" ", width)
+	for i := 0; i < height; i++ {
+		self.lp.MoveCursorTo(self.left, self.top+i)
+		switch {
+		case i >= len(lines):
+			self.lp.QueueWriteString(blank)
+		case rows[self.scroll+i].node == self.selected:
+			self.lp.QueueWriteString("\x1b[" + self.SelectedSGR + "m" + lines[i] + "\x1b[m")
+		default:
+			self.lp.QueueWriteString(lines[i])
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestTreeView(t *testing.T) {
+	lp := new_test_loop()
+	lp.set_screen_size(20, 10)
+	loads := 0
+	var load_err error
+	lazy := &TreeNode{Label: "lazy", LoadChildren: func(*TreeNode) ([]*TreeNode, error) {
+		loads++
+		return []*TreeNode{{Label: "loaded"}}, load_err
+	}}
+	api := &TreeNode{Label: "api.go"}
+	root := &TreeNode{Label: "src", Children: []*TreeNode{
+		{Label: "loop", Children: []*TreeNode{api, {Label: "测试测试.go"}}},
+		lazy,
+		{Label: "README"},
+	}}
+	tv := lp.NewTreeView(root)
+	tv.SetGeometry(2, 3, 4, 12)
+	var selected []string
+	tv.OnSelect = func(n *TreeNode) error {
+		selected = append(selected, n.Label)
+		return nil
+	}
+	key := func(spec string) {
+		t.Helper()
+		ps := ParseShortcut(spec)
+		ev := &KeyEvent{Type: PRESS, Key: ps.KeyName, Mods: ps.Mods}
+		if err := tv.OnKeyEvent(ev); err != nil {
+			t.Fatal(err)
+		}
+		if !ev.Handled {
+			t.Fatalf("Key %s not handled", spec)
+		}
+	}
+	check := func(selection string, expected ...string) {
+		t.Helper()
+		if diff := cmp.Diff(expected, tv.render_rows(tv.rows())); diff != "" {
+			t.Fatalf("Unexpected rows:\n%s", diff)
+		}
+		if tv.Selected().Label != selection {
+			t.Fatalf("Unexpected selection: %s != %s", tv.Selected().Label, selection)
+		}
+	}
+
+	check("src", "▾ src       ", "├─▸ loop    ", "├─▸ lazy    ", "└── README  ")
+	key("down")
+	key("right")
+	check("loop", "▾ src       ", "├─▾ loop    ", "│ ├── api.go", "│ └── 测试测")
+	// scrolling to keep the selection visible
+	key("right")
+	key("down")
+	key("down")
+	check("lazy", "├─▾ loop    ", "│ ├── api.go", "│ └── 测试测", "├─▸ lazy    ")
+	// lazy loading, only once
+	key("enter")
+	key("left")
+	key("right")
+	if loads != 1 {
+		t.Fatalf("Children loaded %d times", loads)
+	}
+	key("right")
+	check("loaded", "│ ├── api.go", "│ └── 测试测", "├─▾ lazy    ", "│ └── loaded")
+	key("enter")
+	if diff := cmp.Diff([]string{"loaded"}, selected); diff != "" {
+		t.Fatalf("Unexpected selections:\n%s", diff)
+	}
+	// left goes to the parent and then collapses it
+	key("left")
+	key("left")
+	key("home")
+	check("src", "▾ src       ", "├─▾ loop    ", "│ ├── api.go", "│ └── 测试测")
+	key("left")
+	check("src", "▸ src       ")
+	key("end")
+	check("src", "▸ src       ")
+	tv.Select(api)
+	check("api.go", "▾ src       ", "├─▾ loop    ", "│ ├── api.go", "│ └── 测试测")
+
+	// failed loads are retried
+	load_err = errors.New("failed")
+	lazy.Children, lazy.loaded, lazy.Expanded = nil, false, false
+	tv.Select(lazy)
+	var failed *TreeNode
+	tv.OnLoadError = func(n *TreeNode, err error) error {
+		failed = n
+		return nil
+	}
+	key("right")
+	if failed != lazy || lazy.Expanded || loads != 2 {
+		t.Fatalf("Load error not reported")
+	}
+
+	// without the root, the top level nodes have no guide lines
+	tv.HideRoot = true
+	tv.Keys = map[string]func(*TreeNode) error{"ctrl+r": func(n *TreeNode) error { n.Label += "!"; return nil }}
+	key("ctrl+r")
+	key("home")
+	check("loop", "▾ loop      ", "├── api.go  ", "└── 测试测试", "▸ lazy!     ")
+	key("left")
+	key("left")
+	check("loop", "▸ loop      ", "▸ lazy!     ", "  README    ")
+	lp.output()
+	tv.Draw()
+	expected := "<move 2,3><SGR 7>▸ loop      <SGR 0><move 3,3>▸ lazy!     <move 4,3>  README    <move 5,3>            "
+	if diff := cmp.Diff(expected, lp.normalized_output()); diff != "" {
+		t.Fatalf("Unexpected output:\n%s", diff)
+	}
+}
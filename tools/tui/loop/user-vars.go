@@ -0,0 +1,140 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"kitty"
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+var ErrNotKitty = errors.New("The terminal is not kitty")
+
+// Set a user variable on the kitty window the program is running in. User
+// variables are readable by other programs via remote control, so they can be
+// used to attach metadata to the window. Does nothing in other terminals.
+func (self *Loop) SetUserVar(name, value string) {
+	if self.is_dumb_terminal || !self.is_kitty() {
+		return
+	}
+	self.QueueWriteString("\x1b]1337;SetUserVar=" + name + "=" + base64.StdEncoding.EncodeToString([]byte(value)) + "\a")
+}
+
+type user_vars_window struct {
+	IsSelf   bool              `json:"is_self"`
+	UserVars map[string]string `json:"user_vars"`
+}
+
+type user_vars_os_window struct {
+	Tabs []struct {
+		Windows []user_vars_window `json:"windows"`
+	} `json:"tabs"`
+}
+
+func (self *Loop) check_can_send_rc_commands() error {
+	if self.query.wait_for_responses == nil {
+		return fmt.Errorf("Cannot query the terminal before starting the run loop")
+	}
+	if self.is_dumb_terminal || !self.is_kitty() {
+		return ErrNotKitty
+	}
+	if !self.RespondsToQueries() {
+		return fmt.Errorf("The terminal does not respond to queries")
+	}
+	return nil
+}
+
+func rc_command_escape_code(name string, payload any) (string, error) {
+	v := kitty.Version
+	cmd, err := json.Marshal(utils.RemoteControlCmd{Cmd: name, Version: [3]int{v.Major, v.Minor, v.Patch}, Payload: payload})
+	if err != nil {
+		return "", err
+	}
+	return "\x1bP@kitty-cmd" + string(cmd) + "\x1b\\", nil
+}
+
+func is_rc_response(etype EscapeCodeType, raw []byte) bool {
+	return etype == DCS && bytes.HasPrefix(raw, []byte("@kitty-cmd"))
+}
+
+func parse_rc_response(raw []byte) (ans RCResponse, err error) {
+	if err = json.Unmarshal(raw[len("@kitty-cmd"):], &ans); err != nil {
+		err = fmt.Errorf("Invalid response from kitty: %w", err)
+	}
+	ans.Error = strings.TrimSpace(ans.Error)
+	return
+}
+
+// Run a remote control command in the kitty instance the program is running
+// in and return the data from its response. Requires remote control to be
+// enabled in kitty.
+func (self *Loop) send_rc_command(name string, payload any) (string, error) {
+	if err := self.check_can_send_rc_commands(); err != nil {
+		return "", err
+	}
+	q, err := rc_command_escape_code(name, payload)
+	if err != nil {
+		return "", err
+	}
+	var response []byte
+	// kitty responds to remote control commands after responding to DA1
+	// so dont wait for DA1
+	found, err := self.send_query(q, default_query_timeout, func(etype EscapeCodeType, raw []byte) bool {
+		if is_rc_response(etype, raw) {
+			response = bytes.Clone(raw)
+			return true
+		}
+		return false
+	}, false)
+	if !found {
+		if err == nil {
+			err = fmt.Errorf("No response received from kitty")
+		}
+		return "", err
+	}
+	rc_response, err := parse_rc_response(response)
+	if err != nil {
+		return "", err
+	}
+	if !rc_response.Ok {
+		return "", fmt.Errorf("The %s command failed with error: %s", name, rc_response.Error)
+	}
+	return rc_response.Data, nil
+}
+
+// Get the value of a user variable on the kitty window the program is running
+// in, as set by SetUserVar() or any other program. This uses remote control,
+// so it requires remote control to be enabled in kitty. Returns ErrNotKitty if
+// the terminal is not kitty and an error if there is no such variable or
+// kitty does not respond in time.
+func (self *Loop) GetUserVar(name string) (string, error) {
+	data, err := self.send_rc_command("ls", nil)
+	if err != nil {
+		return "", err
+	}
+	var os_windows []user_vars_os_window
+	if err = json.Unmarshal([]byte(data), &os_windows); err != nil {
+		return "", fmt.Errorf("Invalid window list from kitty: %w", err)
+	}
+	for _, osw := range os_windows {
+		for _, tab := range osw.Tabs {
+			for _, w := range tab.Windows {
+				if w.IsSelf {
+					if val, ok := w.UserVars[name]; ok {
+						return val, nil
+					}
+					return "", fmt.Errorf("No user variable named: %s", name)
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("Could not find the window this program is running in")
+}
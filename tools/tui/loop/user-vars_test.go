@@ -0,0 +1,73 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestUserVars(t *testing.T) {
+	t.Setenv("TERM", "xterm-kitty")
+	t.Setenv("KITTY_WINDOW_ID", "")
+	lp := new_test_loop()
+	lp.SetUserVar("a", "1 2")
+	if q := lp.output(); q != "\x1b]1337;SetUserVar=a=MSAy\a" {
+		t.Fatalf("Unexpected output setting a user variable: %#v", q)
+	}
+	if _, err := lp.GetUserVar("a"); err == nil {
+		t.Fatalf("Getting a user variable before the loop runs did not fail")
+	}
+
+	windows := `[{"tabs": [{"windows": [{"is_self": false, "user_vars": {"a": "other"}}, {"is_self": true, "user_vars": {"a": "1 2"}}]}]}]`
+	response := func(ok bool, data, error_message string) string {
+		r, _ := json.Marshal(RCResponse{Ok: ok, Data: data, Error: error_message})
+		return "\x1bP@kitty-cmd" + string(r) + "\x1b\\"
+	}
+	var answer string
+	var sent []string
+	lp.answer_queries(func(output string) []string {
+		sent = append(sent, output)
+		if answer == "" {
+			return nil
+		}
+		return []string{answer}
+	})
+	answer = response(true, windows, "")
+	if val, err := lp.GetUserVar("a"); err != nil || val != "1 2" {
+		t.Fatalf("Unexpected value of a user variable: %#v %v", val, err)
+	}
+	if len(sent) != 1 || !strings.HasPrefix(sent[0], "\x1bP@kitty-cmd"+`{"cmd":"ls","version":[`) {
+		t.Fatalf("Unexpected command sent: %#v", sent)
+	}
+	for _, x := range []struct{ answer, err string }{
+		{response(true, windows, ""), "No user variable named: b"},
+		{response(true, `[]`, ""), "Could not find the window this program is running in"},
+		{response(true, `{`, ""), "Invalid window list from kitty"},
+		{response(false, "", " Remote control is disabled\n"), "The ls command failed with error: Remote control is disabled"},
+		{"\x1bP@kitty-cmd{\x1b\\", "Invalid response from kitty"},
+		{"", "i/o timeout"},
+	} {
+		answer = x.answer
+		if _, err := lp.GetUserVar("b"); err == nil || !strings.HasPrefix(err.Error(), x.err) {
+			t.Fatalf("Unexpected error for %#v: %v", x.answer, err)
+		}
+	}
+
+	// other terminals, detected by XTVERSION
+	t.Setenv("TERM", "xterm")
+	lp = new_test_loop()
+	lp.answer_queries(func(output string) []string { return []string{"\x1bP>|XTerm(389)\x1b\\"} })
+	lp.SetUserVar("a", "1")
+	if _, err := lp.GetUserVar("a"); !errors.Is(err, ErrNotKitty) {
+		t.Fatalf("Unexpected error for a terminal that is not kitty: %v", err)
+	}
+	if q := lp.output(); q != "\x1b[>q\x1b[c" {
+		t.Fatalf("Unexpected output in a terminal that is not kitty: %#v", q)
+	}
+}
@@ -0,0 +1,266 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+// A full screen view managed by a ViewManager. All callbacks are optional.
+type View struct {
+	Name string
+	// Draw the view, the screen is cleared before this is called
+	OnRender func() error
+	// Called for key events not handled by Keys
+	OnKeyEvent func(event *KeyEvent) error
+	OnText     func(text string, from_key_event bool, in_bracketed_paste bool) error
+	// Called instead of OnText when set, see Loop.OnTextWithKey
+	OnTextWithKey func(text string, key *KeyEvent, in_bracketed_paste bool) error
+	OnResize      func(old_size ScreenSize, new_size ScreenSize) error
+	// Called when the view becomes the top view and when it stops being the
+	// top view
+	OnShow, OnHide func() error
+	// Shortcuts handled before OnKeyEvent, keyed by shortcut spec, such as
+	// ctrl+r
+	Keys map[string]func() error
+}
+
+type Transition int
+
+const (
+	TRANSITION_NONE Transition = iota
+	// Wipe the screen from the side the new view comes from
+	TRANSITION_WIPE
+)
+
+const view_transition_frame_interval = 16 * time.Millisecond
+
+type view_transition struct {
+	timer_id                   IdType
+	from_right                 bool
+	frame, num_frames, covered int
+}
+
+// A stack of full screen views, only the top view is rendered and receives
+// events
+type ViewManager struct {
+	// Quit the loop when the last view is popped
+	QuitWhenEmpty bool
+	// The animation used when the top view changes and how long it takes
+	Transition         Transition
+	TransitionDuration time.Duration
+	// Shortcuts handled for all views, after the shortcuts of the top view
+	Keys map[string]func() error
+
+	lp         *Loop
+	views      []*View
+	transition *view_transition
+}
+
+// Create a view manager, it takes over the OnRender, OnKeyEvent, OnText,
+// OnTextWithKey and OnResize callbacks of the loop, which are routed to the
+// top view instead
+func (self *Loop) NewViewManager() *ViewManager {
+	ans := &ViewManager{lp: self, QuitWhenEmpty: true, Transition: TRANSITION_WIPE, TransitionDuration: 100 * time.Millisecond}
+	self.OnRender = ans.on_render
+	self.OnKeyEvent = ans.on_key_event
+	self.OnText = ans.on_text
+	self.OnTextWithKey = ans.on_text_with_key
+	self.OnResize = ans.on_resize
+	return ans
+}
+
+// The top view or nil if there are no views
+func (self *ViewManager) Top() *View {
+	if len(self.views) == 0 {
+		return nil
+	}
+	return self.views[len(self.views)-1]
+}
+
+func (self *ViewManager) Len() int {
+	return len(self.views)
+}
+
+func (self *ViewManager) hide_top() error {
+	if v := self.Top(); v != nil && v.OnHide != nil {
+		return v.OnHide()
+	}
+	return nil
+}
+
+func (self *ViewManager) show_top(from_right bool) error {
+	self.start_transition(from_right)
+	self.lp.RequestRedraw()
+	if v := self.Top(); v != nil && v.OnShow != nil {
+		return v.OnShow()
+	}
+	return nil
+}
+
+// Show v on top of the current view
+func (self *ViewManager) Push(v *View) error {
+	if err := self.hide_top(); err != nil {
+		return err
+	}
+	self.views = append(self.views, v)
+	return self.show_top(true)
+}
+
+// Remove the top view, showing the one below it. If there are no more views
+// and QuitWhenEmpty is set, the loop is quit.
+func (self *ViewManager) Pop() error {
+	if len(self.views) == 0 {
+		return nil
+	}
+	if err := self.hide_top(); err != nil {
+		return err
+	}
+	self.views = self.views[:len(self.views)-1]
+	if len(self.views) == 0 {
+		self.finish_transition()
+		if self.QuitWhenEmpty {
+			self.lp.Quit(0)
+		}
+		return nil
+	}
+	return self.show_top(false)
+}
+
+// Replace the top view with v
+func (self *ViewManager) Replace(v *View) error {
+	if err := self.hide_top(); err != nil {
+		return err
+	}
+	if len(self.views) > 0 {
+		self.views[len(self.views)-1] = v
+	} else {
+		self.views = append(self.views, v)
+	}
+	return self.show_top(true)
+}
+
+func (self *ViewManager) start_transition(from_right bool) {
+	self.finish_transition()
+	if self.Transition == TRANSITION_NONE || self.TransitionDuration <= 0 || self.lp.timers == nil {
+		return
+	}
+	t := &view_transition{from_right: from_right, num_frames: utils.Max(1, int(self.TransitionDuration/view_transition_frame_interval))}
+	var err error
+	if t.timer_id, err = self.lp.AddTimer(view_transition_frame_interval, true, self.transition_step); err == nil {
+		self.transition = t
+	}
+}
+
+func (self *ViewManager) finish_transition() {
+	if self.transition != nil {
+		self.lp.RemoveTimer(self.transition.timer_id)
+		self.transition = nil
+		self.lp.RequestRedraw()
+	}
+}
+
+// Blank the next band of columns of the wipe
+func (self *ViewManager) transition_step(IdType) error {
+	t := self.transition
+	if t == nil {
+		return nil
+	}
+	t.frame++
+	if t.frame >= t.num_frames {
+		self.finish_transition()
+		return nil
+	}
+	sz, err := self.lp.ScreenSize()
+	if err != nil {
+		self.finish_transition()
+		return nil
+	}
+	width, height := int(sz.WidthCells), int(sz.HeightCells)
+	covered := width * t.frame / t.num_frames
+	if covered > t.covered {
+		x := t.covered + 1
+		if t.from_right {
+			x = width - covered + 1
+		}
+		blank := strings.Repeat(" ", covered-t.covered)
+		self.lp.StartAtomicUpdate()
+		for y := 1; y <= height; y++ {
+			self.lp.MoveCursorTo(x, y)
+			self.lp.QueueWriteString(blank)
+		}
+		self.lp.EndAtomicUpdate()
+		t.covered = covered
+	}
+	return nil
+}
+
+func (self *ViewManager) on_render() error {
+	v := self.Top()
+	if self.transition != nil || v == nil {
+		// rendered when the transition finishes
+		return nil
+	}
+	self.lp.StartAtomicUpdate()
+	defer self.lp.EndAtomicUpdate()
+	self.lp.ClearScreen()
+	if v.OnRender != nil {
+		return v.OnRender()
+	}
+	return nil
+}
+
+func match_view_keys(keys map[string]func() error, ev *KeyEvent) (bool, error) {
+	for spec, f := range keys {
+		if ev.MatchesPressOrRepeat(spec) {
+			ev.Handled = true
+			return true, f()
+		}
+	}
+	return false, nil
+}
+
+func (self *ViewManager) on_key_event(ev *KeyEvent) error {
+	v := self.Top()
+	if v != nil {
+		if found, err := match_view_keys(v.Keys, ev); found {
+			return err
+		}
+	}
+	if found, err := match_view_keys(self.Keys, ev); found {
+		return err
+	}
+	if v != nil && v.OnKeyEvent != nil {
+		return v.OnKeyEvent(ev)
+	}
+	return nil
+}
+
+func (self *ViewManager) on_text(text string, from_key_event bool, in_bracketed_paste bool) error {
+	if v := self.Top(); v != nil && v.OnText != nil {
+		return v.OnText(text, from_key_event, in_bracketed_paste)
+	}
+	return nil
+}
+
+func (self *ViewManager) on_text_with_key(text string, key *KeyEvent, in_bracketed_paste bool) error {
+	if v := self.Top(); v != nil && v.OnTextWithKey != nil {
+		return v.OnTextWithKey(text, key, in_bracketed_paste)
+	}
+	return self.on_text(text, key != nil, in_bracketed_paste)
+}
+
+func (self *ViewManager) on_resize(old_size ScreenSize, new_size ScreenSize) error {
+	self.finish_transition()
+	self.lp.RequestRedraw()
+	if v := self.Top(); v != nil && v.OnResize != nil {
+		return v.OnResize(old_size, new_size)
+	}
+	return nil
+}
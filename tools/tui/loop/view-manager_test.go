@@ -0,0 +1,86 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ = fmt.Print
+
+func TestViewManager(t *testing.T) {
+	lp, _ := New()
+	lp.keep_going = true
+	vm := lp.NewViewManager()
+	var events []string
+	record := func(x string) func() error {
+		return func() error { events = append(events, x); return nil }
+	}
+	check := func(expected ...string) {
+		t.Helper()
+		if diff := cmp.Diff(expected, events); diff != "" {
+			t.Fatalf("Unexpected events:\n%s", diff)
+		}
+		events = nil
+	}
+	key := func(spec string) *KeyEvent {
+		ps := ParseShortcut(spec)
+		ev := &KeyEvent{Type: PRESS, Key: ps.KeyName, Mods: ps.Mods}
+		if err := lp.OnKeyEvent(ev); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+	view := func(name string) *View {
+		return &View{
+			Name: name, OnRender: record(name + ":render"), OnShow: record(name + ":show"), OnHide: record(name + ":hide"),
+			OnKeyEvent: func(ev *KeyEvent) error { events = append(events, name+":"+ev.Key); return nil },
+			Keys:       map[string]func() error{"ctrl+r": record(name + ":ctrl+r")},
+		}
+	}
+	vm.Keys = map[string]func() error{"ctrl+r": record("global:ctrl+r"), "esc": func() error { return vm.Pop() }}
+
+	if err := vm.Push(view("main")); err != nil {
+		t.Fatal(err)
+	}
+	check("main:show")
+	_ = lp.OnRender()
+	check("main:render")
+	_ = vm.Push(view("detail"))
+	check("main:hide", "detail:show")
+	key("a")
+	if ev := key("ctrl+r"); !ev.Handled {
+		t.Fatalf("Shortcut not marked as handled")
+	}
+	check("detail:a", "detail:ctrl+r")
+	vm.Top().Keys = nil
+	key("ctrl+r")
+	check("global:ctrl+r")
+	_ = vm.Replace(view("settings"))
+	check("detail:hide", "settings:show")
+	key("esc")
+	check("settings:hide", "main:show")
+	if vm.Len() != 1 || vm.Top().Name != "main" {
+		t.Fatalf("Unexpected views after pop")
+	}
+	// text goes to the top view, even if it wants the key events it is from
+	vm.Top().OnText = func(text string, from_key_event, in_bracketed_paste bool) error {
+		events = append(events, "main:text:"+text)
+		return nil
+	}
+	_ = lp.OnTextWithKey("a", nil, false)
+	vm.Top().OnTextWithKey = func(text string, key *KeyEvent, in_bracketed_paste bool) error {
+		events = append(events, "main:text with key:"+text)
+		return nil
+	}
+	_ = lp.OnTextWithKey("b", nil, false)
+	check("main:text:a", "main:text with key:b")
+	key("esc")
+	check("main:hide")
+	if lp.keep_going {
+		t.Fatalf("Popping the last view did not quit")
+	}
+}
@@ -0,0 +1,64 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var _ = fmt.Print
+
+var ErrResizeNotSupported = errors.New("The terminal does not support resize requests")
+
+// Terminals known to implement the XTWINOPS resize request, keyed by the
+// prefix of their XTVERSION response. xterm honors it only when allowWindowOps
+// is set.
+var terminals_with_resize_requests = []string{"XTerm(", "foot(", "WezTerm "}
+
+// Larger sizes are certainly mistakes
+const max_resize_request_dimension = 10000
+
+func (self *Loop) supports_resize_requests() bool {
+	if self.is_dumb_terminal {
+		return false
+	}
+	if os.Getenv("VTE_VERSION") != "" {
+		return true
+	}
+	v := self.terminal_version()
+	for _, q := range terminals_with_resize_requests {
+		if strings.HasPrefix(v, q) {
+			return true
+		}
+	}
+	return false
+}
+
+func resize_request(rows, cols int) (string, error) {
+	if rows < 1 || cols < 1 || rows > max_resize_request_dimension || cols > max_resize_request_dimension {
+		return "", fmt.Errorf("Invalid terminal size requested: %d rows and %d columns", rows, cols)
+	}
+	return fmt.Sprintf("\x1b[8;%d;%dt", rows, cols), nil
+}
+
+// Ask the terminal to resize its window to the specified number of rows and
+// columns using XTWINOPS. If the terminal honors the request, OnResize is
+// called once the new size takes effect. Returns ErrResizeNotSupported for
+// terminals not known to implement resize requests. Note that even terminals
+// that implement it may silently ignore the request, for example, because
+// the window is maximized or window operations are disabled in their
+// settings, so do not rely on the size changing.
+func (self *Loop) RequestResize(rows, cols int) error {
+	q, err := resize_request(rows, cols)
+	if err != nil {
+		return err
+	}
+	if !self.supports_resize_requests() {
+		return ErrResizeNotSupported
+	}
+	self.QueueWriteString(q)
+	return nil
+}
@@ -0,0 +1,21 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestResizeRequest(t *testing.T) {
+	if q, err := resize_request(24, 80); err != nil || q != "\x1b[8;24;80t" {
+		t.Fatalf("Unexpected resize request: %#v %v", q, err)
+	}
+	for _, x := range [][2]int{{0, 80}, {24, -1}, {24, 100000}} {
+		if _, err := resize_request(x[0], x[1]); err == nil {
+			t.Fatalf("No error for invalid size: %v", x)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"kitty/tools/utils"
+)
+
+var _ = fmt.Print
+
+// Terminals known to use the working directory reported via OSC 7 for new
+// windows, keyed by the prefix of their XTVERSION response
+var terminals_with_osc7 = []string{"kitty(", "foot(", "WezTerm "}
+
+func (self *Loop) supports_working_directory_reports() bool {
+	if self.is_dumb_terminal {
+		return false
+	}
+	if os.Getenv("VTE_VERSION") != "" {
+		return true
+	}
+	switch os.Getenv("TERM_PROGRAM") {
+	case "iTerm.app", "Apple_Terminal", "WezTerm":
+		return true
+	}
+	if self.is_kitty() {
+		return true
+	}
+	v := self.terminal_version()
+	for _, q := range terminals_with_osc7 {
+		if strings.HasPrefix(v, q) {
+			return true
+		}
+	}
+	return false
+}
+
+func working_directory_report(dir, hostname string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	u := url.URL{Scheme: "file", Host: hostname, Path: filepath.ToSlash(dir)}
+	return "\x1b]7;" + u.String() + "\x1b\\"
+}
+
+// Tell the terminal the current working directory using OSC 7, so that new
+// windows and tabs opened from this one start in it. Does nothing if the
+// terminal is not known to support it.
+func (self *Loop) ReportWorkingDirectory(dir string) {
+	if self.supports_working_directory_reports() {
+		self.QueueWriteString(working_directory_report(dir, utils.Hostname()))
+	}
+}
@@ -0,0 +1,22 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func TestWorkingDirectoryReport(t *testing.T) {
+	for dir, expected := range map[string]string{
+		"/a/b":      "file://host/a/b",
+		"/a b/%c#d": "file://host/a%20b/%25c%23d",
+		"/ü":        "file://host/%C3%BC",
+	} {
+		if actual := working_directory_report(dir, "host"); actual != "\x1b]7;"+expected+"\x1b\\" {
+			t.Fatalf("Unexpected report for %#v: %#v", dir, actual)
+		}
+	}
+}
@@ -6,18 +6,42 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sys/unix"
 
 	"kitty/tools/tty"
 	"kitty/tools/utils"
+	"kitty/tools/wcswidth"
 )
 
+type write_backlog struct {
+	pending_bytes, high, low int
+	backlogged               bool
+}
+
+type interactive_state struct {
+	boost           time.Duration
+	until           time.Time
+	queueing_writes bool
+}
+
 type write_msg struct {
 	id    IdType
 	bytes []byte
 	str   string
+	// queued in response to user input, see SetInteractiveBoost()
+	interactive bool
+	// chosen as the next write to send, see next_pending_write()
+	prepared bool
+}
+
+func (self *write_msg) size() int {
+	if self.bytes != nil {
+		return len(self.bytes)
+	}
+	return len(self.str)
 }
 
 func (self *write_msg) String() string {
@@ -59,23 +83,88 @@ func writestring_ignoring_temporary_errors(f *tty.Term, buf string) (int, error)
 	return n, err
 }
 
+// The next pending write to send, moved to the front of the queue, where it
+// stays till it is sent. Interactive writes are sent before others while the
+// interactive boost is active. The output filters that depend on what was
+// sent before, such as the SGR optimizer, are applied to it here, so that
+// they see the output in the order it is sent.
+func (self *Loop) next_pending_write(now time.Time) *write_msg {
+	i := 0
+	if !self.pending_writes[0].prepared && self.interactive.boost > 0 && now.Before(self.interactive.until) {
+		for q, w := range self.pending_writes {
+			if w.interactive {
+				i = q
+				break
+			}
+		}
+	}
+	w := self.pending_writes[i]
+	if i > 0 {
+		copy(self.pending_writes[1:i+1], self.pending_writes[:i])
+		self.pending_writes[0] = w
+	}
+	self.prepare_write(w)
+	return w
+}
+
+func (self *Loop) prepare_write(w *write_msg) {
+	if !w.prepared {
+		w.prepared = true
+		self.backlog.pending_bytes -= w.size()
+		self.optimize_output(w)
+		self.record_output(w)
+		self.mirror_output(w)
+		self.backlog.pending_bytes += w.size()
+	}
+}
+
+// Remove the write returned by next_pending_write() from the queue once it
+// has been sent
+func (self *Loop) pop_pending_write() {
+	self.backlog.pending_bytes -= self.pending_writes[0].size()
+	self.pending_writes = self.pending_writes[1:]
+}
+
 func (self *Loop) flush_pending_writes(tty_write_channel chan<- *write_msg) {
+	now := time.Now()
 	for len(self.pending_writes) > 0 {
 		select {
-		case tty_write_channel <- self.pending_writes[0]:
-			n := copy(self.pending_writes, self.pending_writes[1:])
-			self.pending_writes = self.pending_writes[:n]
+		case tty_write_channel <- self.next_pending_write(now):
+			self.pop_pending_write()
 		default:
 			return
 		}
 	}
 }
 
+// Apply the output filters to all pending writes, for when they are sent in
+// order, without going through next_pending_write()
+func (self *Loop) prepare_pending_writes() {
+	for _, w := range self.pending_writes {
+		self.prepare_write(w)
+	}
+}
+
+// Prioritize writing output produced in response to user input over other
+// output, such as from a background task streaming logs, for duration d after
+// each input event, so that the UI remains responsive. Writes queued while
+// handling input and in OnRender during that time are sent to the terminal
+// before other pending writes, while preserving the order of writes within
+// each group. Individual writes are never split or interleaved, so a write
+// already being sent, which happens in chunks of WriteChunkSize(), finishes
+// first. Both kinds of writes count towards the limits set by
+// SetWriteBacklogLimits(). Output that depends on the cursor position must be
+// written as a single self-contained write to remain correct when reordered.
+// Zero, the default, turns this off.
+func (self *Loop) SetInteractiveBoost(d time.Duration) {
+	self.interactive.boost = utils.Max(0, d)
+}
+
 func (self *Loop) wait_for_write_to_complete(sentinel IdType, tty_write_channel chan<- *write_msg, write_done_channel <-chan IdType, timeout time.Duration) error {
 	for len(self.pending_writes) > 0 {
 		select {
-		case tty_write_channel <- self.pending_writes[0]:
-			self.pending_writes = self.pending_writes[1:]
+		case tty_write_channel <- self.next_pending_write(time.Now()):
+			self.pop_pending_write()
 		case write_id, more := <-write_done_channel:
 			if write_id == sentinel {
 				return nil
@@ -97,7 +186,47 @@ func (self *Loop) wait_for_write_to_complete(sentinel IdType, tty_write_channel
 }
 
 func (self *Loop) add_write_to_pending_queue(data *write_msg) {
+	if self.is_dumb_terminal {
+		if data.bytes != nil {
+			data.str, data.bytes = string(data.bytes), nil
+		}
+		data.str = wcswidth.StripEscapeCodes(data.str)
+	}
+	data.interactive = self.interactive.queueing_writes
 	self.pending_writes = append(self.pending_writes, data)
+	self.backlog.pending_bytes += data.size()
+}
+
+const default_write_backlog_high = 4 * 1024 * 1024
+const default_write_backlog_low = 256 * 1024
+
+// Set the high and low water marks, in bytes, for OnWriteBacklog and
+// OnWriteBacklogCleared. Zero means use the default.
+func (self *Loop) SetWriteBacklogLimits(high, low int) {
+	self.backlog.high, self.backlog.low = high, low
+}
+
+func (self *Loop) check_write_backlog() error {
+	high, low := self.backlog.high, self.backlog.low
+	if high <= 0 {
+		high = default_write_backlog_high
+	}
+	if low <= 0 {
+		low = default_write_backlog_low
+	}
+	low = utils.Min(low, high)
+	if !self.backlog.backlogged && self.backlog.pending_bytes > high {
+		self.backlog.backlogged = true
+		if self.OnWriteBacklog != nil {
+			return self.OnWriteBacklog(self.backlog.pending_bytes)
+		}
+	} else if self.backlog.backlogged && self.backlog.pending_bytes < low {
+		self.backlog.backlogged = false
+		if self.OnWriteBacklogCleared != nil {
+			return self.OnWriteBacklogCleared()
+		}
+	}
+	return nil
 }
 
 func create_write_dispatcher(msg *write_msg) *write_dispatcher {
@@ -110,11 +239,62 @@ func create_write_dispatcher(msg *write_msg) *write_dispatcher {
 	return &self
 }
 
-func (self *write_dispatcher) write(f *tty.Term) (int, error) {
+func (self *write_dispatcher) write(f *tty.Term, limit int) (int, error) {
+	if self.is_string {
+		return writestring_ignoring_temporary_errors(f, self.str[:utils.Min(limit, len(self.str))])
+	}
+	return write_ignoring_temporary_errors(f, self.bytes[:utils.Min(limit, len(self.bytes))])
+}
+
+const min_write_chunk_size = 1024
+const max_write_chunk_size = 1024 * 1024
+const initial_write_chunk_size = 16 * 1024
+
+// Tunes the size of the writes made to the tty to how much it accepts in a
+// single write. A write that is accepted in full means the chunk size can grow,
+// a partial write means the tty buffer is full, so the chunk size shrinks
+// towards what was accepted. This keeps writes large on fast local terminals
+// and avoids needlessly large writes on slow links.
+type write_chunker struct {
+	size  atomic.Int64
+	fixed bool // disables tuning, for comparison in benchmarks
+}
+
+func (self *write_chunker) chunk_size() int {
+	if ans := int(self.size.Load()); ans > 0 {
+		return ans
+	}
+	return initial_write_chunk_size
+}
+
+func (self *write_chunker) update(requested, accepted int) {
+	if self.fixed {
+		return
+	}
+	size := self.chunk_size()
+	switch {
+	case accepted >= requested:
+		if requested >= size {
+			size = utils.Min(max_write_chunk_size, 2*size)
+		}
+	default:
+		size = utils.Max(min_write_chunk_size, (size+accepted)/2)
+	}
+	self.size.Store(int64(size))
+}
+
+// The size of the chunks in which data is currently being written to the
+// terminal, tuned automatically based on how much data the terminal accepts
+// without blocking
+func (self *Loop) WriteChunkSize() int {
+	return self.write_chunker.chunk_size()
+}
+
+func (self *write_dispatcher) size() int {
 	if self.is_string {
-		return writestring_ignoring_temporary_errors(f, self.str)
+		return len(self.str)
 	}
-	return write_ignoring_temporary_errors(f, self.bytes)
+	return len(self.bytes)
 }
 
 func (self *write_dispatcher) slice(n int) {
@@ -128,7 +308,7 @@ func (self *write_dispatcher) slice(n int) {
 }
 
 func write_to_tty(
-	pipe_r *os.File, term *tty.Term,
+	pipe_r *os.File, term *tty.Term, chunker *write_chunker,
 	job_channel <-chan *write_msg, err_channel chan<- error, write_done_channel chan<- IdType,
 ) {
 	keep_going := true
@@ -166,7 +346,10 @@ func write_to_tty(
 			if !keep_going {
 				return
 			}
-			n, err := data.write(term)
+			limit := chunker.chunk_size()
+			requested := utils.Min(limit, data.size())
+			n, err := data.write(term, limit)
+			chunker.update(requested, n)
 			if err != nil {
 				err_channel <- err
 				keep_going = false
@@ -0,0 +1,82 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"kitty/tools/tty"
+)
+
+var _ = fmt.Print
+
+func open_pty(b testing.TB) (*os.File, *tty.Term) {
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		b.Skip("Could not open a pty: ", err)
+	}
+	if err = unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		b.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		b.Fatal(err)
+	}
+	term, err := tty.OpenTerm(fmt.Sprintf("/dev/pts/%d", n), tty.SetRaw)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return os.NewFile(uintptr(fd), "ptmx"), term
+}
+
+// Write data through the tty writer to a pty that is drained slowly, in small
+// reads with a delay between them, simulating a slow link
+func benchmark_writing_to_slow_fd(b *testing.B, chunker *write_chunker) {
+	data := make([]byte, 4*1024*1024)
+	for i := 0; i < b.N; i++ {
+		r, term := open_pty(b)
+		pipe_r, pipe_w, _ := os.Pipe()
+		job_channel, err_channel, done_channel := make(chan *write_msg, 1), make(chan error, 1), make(chan IdType)
+		reader_done := make(chan bool)
+		go func() {
+			buf := make([]byte, 8192)
+			for {
+				if _, err := r.Read(buf); err != nil {
+					break
+				}
+				time.Sleep(time.Microsecond)
+			}
+			reader_done <- true
+		}()
+		go write_to_tty(pipe_r, term, chunker, job_channel, err_channel, done_channel)
+		job_channel <- &write_msg{id: 1, bytes: data}
+		select {
+		case <-done_channel:
+		case err := <-err_channel:
+			b.Fatal(err)
+		}
+		close(job_channel)
+		for range done_channel {
+		}
+		pipe_w.Close()
+		term.Close()
+		<-reader_done
+		r.Close()
+	}
+	b.ReportMetric(float64(chunker.chunk_size()), "final-chunk-bytes")
+}
+
+func BenchmarkWriteFixedChunks(b *testing.B) {
+	c := &write_chunker{fixed: true}
+	c.size.Store(initial_write_chunk_size)
+	benchmark_writing_to_slow_fd(b, c)
+}
+
+func BenchmarkWriteAdaptiveChunks(b *testing.B) {
+	benchmark_writing_to_slow_fd(b, &write_chunker{})
+}
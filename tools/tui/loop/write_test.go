@@ -0,0 +1,149 @@
+// License: GPLv3 Copyright: 2023, Kovid Goyal, <kovid at kovidgoyal.net>
+
+package loop
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var _ = fmt.Print
+
+func TestInteractiveBoost(t *testing.T) {
+	lp, _ := New()
+	lp.OnKeyEvent = func(ev *KeyEvent) error {
+		lp.QueueWriteString("key")
+		return nil
+	}
+	flushed := func() (ans []string) {
+		ch := make(chan *write_msg, 16)
+		lp.flush_pending_writes(ch)
+		close(ch)
+		for m := range ch {
+			ans = append(ans, m.str)
+		}
+		return
+	}
+	run := func() []string {
+		lp.QueueWriteString("log1")
+		if err := lp.dispatch_input_data([]byte("\x1b[97u")); err != nil {
+			t.Fatal(err)
+		}
+		lp.QueueWriteString("log2")
+		return flushed()
+	}
+	if actual := fmt.Sprint(run()); actual != "[log1 key log2]" {
+		t.Fatalf("Writes reordered without boost: %s", actual)
+	}
+	lp.SetInteractiveBoost(time.Minute)
+	if actual := fmt.Sprint(run()); actual != "[key log1 log2]" {
+		t.Fatalf("Interactive writes not prioritized: %s", actual)
+	}
+	lp.interactive.until = time.Now().Add(-time.Second)
+	lp.QueueWriteString("log1")
+	lp.interactive.queueing_writes = true
+	lp.QueueWriteString("key")
+	lp.interactive.queueing_writes = false
+	if actual := fmt.Sprint(flushed()); actual != "[log1 key]" {
+		t.Fatalf("Writes reordered after boost expired: %s", actual)
+	}
+}
+
+func TestWriteBacklog(t *testing.T) {
+	lp := new_test_loop()
+	var events []string
+	lp.OnWriteBacklog = func(pending int) error {
+		events = append(events, fmt.Sprintf("backlog %d", pending))
+		return nil
+	}
+	lp.OnWriteBacklogCleared = func() error {
+		events = append(events, "cleared")
+		return nil
+	}
+	lp.SetWriteBacklogLimits(10, 4)
+	check := func(expected string) {
+		t.Helper()
+		if err := lp.check_write_backlog(); err != nil {
+			t.Fatal(err)
+		}
+		if actual := fmt.Sprint(events); actual != expected {
+			t.Fatalf("Unexpected backlog events: %s != %s", actual, expected)
+		}
+	}
+	send_one := func() {
+		lp.next_pending_write(time.Now())
+		lp.pop_pending_write()
+	}
+	lp.QueueWriteString("0123456789")
+	check("[]")
+	lp.QueueWriteString("a")
+	lp.QueueWriteString("bcdef")
+	check("[backlog 16]")
+	// reported once till the backlog is cleared
+	lp.QueueWriteString("g")
+	check("[backlog 16]")
+	send_one()
+	// still above the low water mark
+	check("[backlog 16]")
+	send_one()
+	send_one()
+	check("[backlog 16 cleared]")
+	check("[backlog 16 cleared]")
+	lp.output()
+	if lp.backlog.pending_bytes != 0 {
+		t.Fatalf("Pending bytes not zero after all writes were sent: %d", lp.backlog.pending_bytes)
+	}
+
+	// the defaults
+	lp.SetWriteBacklogLimits(0, 0)
+	events = nil
+	lp.QueueWriteString(string(make([]byte, default_write_backlog_high)))
+	check("[]")
+	lp.QueueWriteString("x")
+	check(fmt.Sprintf("[backlog %d]", default_write_backlog_high+1))
+	lp.output()
+	check(fmt.Sprintf("[backlog %d cleared]", default_write_backlog_high+1))
+}
+
+func TestWriteChunker(t *testing.T) {
+	const K = 1024
+	c := write_chunker{}
+	for i, x := range []struct {
+		requested, accepted, expected int
+	}{
+		// full writes of at least the chunk size grow it
+		{16 * K, 16 * K, 32 * K},
+		// full writes smaller than the chunk size leave it alone
+		{K, K, 32 * K},
+		// partial writes shrink it towards what was accepted
+		{32 * K, 4 * K, 18 * K},
+		{18 * K, 0, 9 * K},
+		// but never below the minimum
+		{9 * K, 0, 4608},
+		{4608, 0, 2304},
+		{2304, 0, 1152},
+		{1152, 0, min_write_chunk_size},
+		{K, 0, min_write_chunk_size},
+		{K, K, 2 * K},
+	} {
+		c.update(x.requested, x.accepted)
+		if actual := c.chunk_size(); actual != x.expected {
+			t.Fatalf("Unexpected chunk size after update %d (%d requested, %d accepted): %d != %d", i, x.requested, x.accepted, x.expected, actual)
+		}
+	}
+	// growth stops at the maximum
+	for i := 0; i < 20; i++ {
+		c.update(c.chunk_size(), c.chunk_size())
+	}
+	if actual := c.chunk_size(); actual != max_write_chunk_size {
+		t.Fatalf("Chunk size did not stop growing at the maximum: %d", actual)
+	}
+	// no tuning for fixed chunkers
+	c = write_chunker{fixed: true}
+	c.update(initial_write_chunk_size, initial_write_chunk_size)
+	c.update(initial_write_chunk_size, 0)
+	if actual := c.chunk_size(); actual != initial_write_chunk_size {
+		t.Fatalf("Fixed chunker changed its chunk size: %d", actual)
+	}
+}
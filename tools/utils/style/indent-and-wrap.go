@@ -399,11 +399,14 @@ type wrapper struct {
 	current_line            line_builder
 	lines                   []string
 	ignore_lines_containing []string
+	close_at_end_of_line    bool
 }
 
 func (self *wrapper) newline_prefix() {
-	self.current_line.add_escape_code(self.sgr.as_escape_codes(true))
-	self.current_line.add_escape_code(self.hyperlink.as_escape_codes(true))
+	if !self.close_at_end_of_line {
+		self.current_line.add_escape_code(self.sgr.as_escape_codes(true))
+		self.current_line.add_escape_code(self.hyperlink.as_escape_codes(true))
+	}
 	self.current_line.add_indent(self.indent, self.indent_width)
 	self.current_line.add_escape_code(self.sgr.as_escape_codes(false))
 	self.current_line.add_escape_code(self.hyperlink.as_escape_codes(false))
@@ -419,6 +422,10 @@ func (self *wrapper) append_line(line string) {
 }
 
 func (self *wrapper) end_current_line() {
+	if self.close_at_end_of_line {
+		self.current_line.add_escape_code(self.sgr.as_escape_codes(true))
+		self.current_line.add_escape_code(self.hyperlink.as_escape_codes(true))
+	}
 	line := self.current_line.reset(self.trim_whitespace)
 	if strings.HasSuffix(line, self.indent) && wcswidth.Stringwidth(line) == self.indent_width {
 		line = line[:len(line)-len(self.indent)]
@@ -535,3 +542,13 @@ func WrapTextAsLines(text string, width int, opts WrapOptions) []string {
 func WrapText(text string, width int, opts WrapOptions) string {
 	return strings.Join(WrapTextAsLines(text, width, opts), "\n")
 }
+
+// Wrap text that already contains SGR formatting and hyperlinks. Every line is
+// self-contained: formatting active at a wrap point is closed at the end of
+// the line and re-opened at the start of the next one, so lines can be
+// rendered independently, for example, when paging.
+func WrapStyledText(text string, width int) []string {
+	w := new_wrapper(WrapOptions{}, width)
+	w.close_at_end_of_line = true
+	return w.wrap_text(text)
+}
@@ -67,3 +67,26 @@ func TestFormatWithIndent(t *testing.T) {
 		"\x1b[1mbold\x1b[221m no \nmore \nbold",
 	)
 }
+
+func TestWrapStyledText(t *testing.T) {
+	tx := func(text string, width int, expected ...string) {
+		actual := WrapStyledText(text, width)
+		if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+			t.Fatalf("\nFailed for: %#v width: %d\nexpected: %#v\nactual:   %#v", text, width, expected, actual)
+		}
+	}
+	tx("one two", 4, "one ", "two")
+	tx("\x1b[31mred text", 4, "\x1b[31mred \x1b[39m", "\x1b[31mtext\x1b[39m")
+	tx("\x1b[1;31mab \x1b[4:3mcd \x1b[22mef", 3,
+		"\x1b[1;31mab \x1b[221;39m",
+		"\x1b[1;31m\x1b[4:3mcd \x1b[221;4:0;39m",
+		"\x1b[1;4:3;31m\x1b[22mef\x1b[4:0;39m")
+	tx("\x1b[32mgreen \x1b[44mon blue\x1b[m plain", 6,
+		"\x1b[32mgreen \x1b[39m",
+		"\x1b[32m\x1b[44mon \x1b[39;49m",
+		"\x1b[32;44mblue\x1b[m ",
+		"plain")
+	tx("\x1b]8;;http://x.com\x1b\\link text\x1b]8;;\x1b\\", 5,
+		"\x1b]8;;http://x.com\x1b\\link \x1b]8;;\x1b\\",
+		"\x1b]8;;http://x.com\x1b\\text\x1b]8;;\x1b\\")
+}
@@ -46,12 +46,29 @@ type EscapeCodeParser struct {
 	current_buffer         []byte
 	bracketed_paste_buffer []utils.UTF8State
 	current_callback       func([]byte) error
+	utf8_pending           []byte
+	// set while parsing an escape code that arrived inside a bracketed paste
+	in_paste_escape_code bool
+	// the bytes of that escape code, as received
+	paste_escape_code []byte
 
 	ReplaceInvalidUtf8Bytes bool
+	// If set, bytes in bracketed paste content that are not valid UTF-8 are
+	// decoded using this function, instead of being dropped or replaced
+	DecodeInvalidPasteByte func(byte) rune
+	// If set, CSI, OSC, DCS, APC, PM and SOS escape codes that arrive inside a
+	// bracketed paste, such as focus events or query responses from the
+	// terminal, are dispatched to their callbacks and the paste continues
+	// after them, instead of being treated as pasted text
+	DispatchEscapeCodesInBracketedPaste bool
+	// If set, only those escape codes inside a bracketed paste for which
+	// this returns true are dispatched, the rest are delivered as pasted
+	// text. It is called with the complete escape code, as received.
+	DispatchInBracketedPaste func(raw []byte) bool
 
 	// Callbacks
 	HandleRune                func(rune) error
-	HandleEndOfBracketedPaste func()
+	HandleEndOfBracketedPaste func() error
 	HandleCSI                 func([]byte) error
 	HandleOSC                 func([]byte) error
 	HandleDCS                 func([]byte) error
@@ -70,8 +87,10 @@ func (self *EscapeCodeParser) ParseByte(b byte) error {
 	switch self.state {
 	case normal, bracketed_paste:
 		prev_utf8_state := self.utf8_state
+		decode_invalid := self.state == bracketed_paste && self.DecodeInvalidPasteByte != nil
 		switch utils.DecodeUtf8(&self.utf8_state, &self.utf8_codep, b) {
 		case utils.UTF8_ACCEPT:
+			self.utf8_pending = self.utf8_pending[:0]
 			err := self.dispatch_char(self.utf8_codep)
 			if err != nil {
 				self.reset_state()
@@ -79,18 +98,36 @@ func (self *EscapeCodeParser) ParseByte(b byte) error {
 			}
 		case utils.UTF8_REJECT:
 			self.utf8_state = utils.UTF8_ACCEPT
+			if decode_invalid {
+				for _, pb := range self.utf8_pending {
+					if err := self.dispatch_char(utils.UTF8State(self.DecodeInvalidPasteByte(pb))); err != nil {
+						return err
+					}
+				}
+				self.utf8_pending = self.utf8_pending[:0]
+			}
 			if prev_utf8_state != utils.UTF8_ACCEPT {
 				// reparse this byte with state set to UTF8_ACCEPT
 				return self.ParseByte(b)
 			}
+			if decode_invalid {
+				return self.dispatch_char(utils.UTF8State(self.DecodeInvalidPasteByte(b)))
+			}
 			if self.ReplaceInvalidUtf8Bytes {
 				err := self.dispatch_char(utils.UTF8State(0xfffd))
 				if err != nil {
 					return err
 				}
 			}
+		default:
+			if decode_invalid {
+				self.utf8_pending = append(self.utf8_pending, b)
+			}
 		}
 	default:
+		if self.in_paste_escape_code {
+			self.paste_escape_code = append(self.paste_escape_code, b)
+		}
 		err := self.dispatch_byte(b)
 		if err != nil {
 			self.reset_state()
@@ -134,11 +171,24 @@ func csi_type(ch byte) csi_char_type {
 func (self *EscapeCodeParser) reset_state() {
 	self.current_buffer = self.current_buffer[:0]
 	self.bracketed_paste_buffer = self.bracketed_paste_buffer[:0]
+	self.utf8_pending = self.utf8_pending[:0]
 	self.state = normal
 	self.utf8_state = utils.UTF8_ACCEPT
 	self.utf8_codep = utils.UTF8_ACCEPT
 	self.current_callback = nil
 	self.csi_state = parameter
+	self.in_paste_escape_code = false
+	self.paste_escape_code = self.paste_escape_code[:0]
+}
+
+// Reset the state after an escape code, returning to the bracketed paste if
+// the escape code arrived inside one
+func (self *EscapeCodeParser) reset_state_after_escape_code() {
+	resume_paste := self.in_paste_escape_code
+	self.reset_state()
+	if resume_paste {
+		self.state = bracketed_paste
+	}
 }
 
 func (self *EscapeCodeParser) dispatch_esc_code() error {
@@ -147,16 +197,79 @@ func (self *EscapeCodeParser) dispatch_esc_code() error {
 		self.state = bracketed_paste
 		return nil
 	}
+	if self.in_paste_escape_code && self.DispatchInBracketedPaste != nil && !self.DispatchInBracketedPaste(self.paste_escape_code) {
+		return self.paste_escape_code_as_text()
+	}
 	var err error
 	if self.current_callback != nil {
 		err = self.current_callback(self.current_buffer)
 	}
-	self.reset_state()
+	self.reset_state_after_escape_code()
 	return err
 }
 
-func (self *EscapeCodeParser) invalid_escape_code() {
+func (self *EscapeCodeParser) invalid_escape_code() error {
+	if self.in_paste_escape_code {
+		return self.paste_escape_code_as_text()
+	}
 	self.reset_state()
+	return nil
+}
+
+// Deliver the escape code that arrived inside a bracketed paste as pasted
+// text and continue the paste
+func (self *EscapeCodeParser) paste_escape_code_as_text() error {
+	raw := string(self.paste_escape_code)
+	self.reset_state_after_escape_code()
+	for _, ch := range raw {
+		if err := self.dispatch_rune(utils.UTF8State(ch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start parsing an escape code inside a bracketed paste, returns false if ch
+// does not continue an escape code that can be dispatched
+func (self *EscapeCodeParser) start_paste_escape_code(ch utils.UTF8State) bool {
+	buf := self.bracketed_paste_buffer
+	if len(buf) == 1 {
+		switch ch {
+		case '[', ']', 'P', '_', '^', 'X':
+		default:
+			return false
+		}
+		self.paste_escape_code = append(self.paste_escape_code[:0], 0x1b, byte(ch))
+		self.bracketed_paste_buffer = buf[:0]
+		self.in_paste_escape_code = true
+		self.state = esc
+		if ch == 'X' {
+			self.state = st
+			self.current_callback = self.HandleSOS
+			return true
+		}
+		_ = self.dispatch_byte(byte(ch))
+		return true
+	}
+	// a CSI whose start matched the end of the paste
+	if csi_type(byte(ch)) == unknown_csi_char {
+		return false
+	}
+	params := buf[2:]
+	self.paste_escape_code = self.paste_escape_code[:0]
+	for _, c := range buf {
+		self.paste_escape_code = append(self.paste_escape_code, byte(c))
+	}
+	self.paste_escape_code = append(self.paste_escape_code, byte(ch))
+	self.bracketed_paste_buffer = buf[:0]
+	self.in_paste_escape_code = true
+	self.state = csi
+	self.csi_state = parameter
+	self.current_callback = self.HandleCSI
+	for _, c := range params {
+		self.write_ch(byte(c))
+	}
+	return true
 }
 
 func (self *EscapeCodeParser) dispatch_rune(ch utils.UTF8State) error {
@@ -181,6 +294,12 @@ func (self *EscapeCodeParser) bp_buffer_equals(chars []utils.UTF8State) bool {
 func (self *EscapeCodeParser) dispatch_char(ch utils.UTF8State) error {
 	if self.state == bracketed_paste {
 		dispatch := func() error {
+			if self.DispatchEscapeCodesInBracketedPaste && len(self.bracketed_paste_buffer) > 0 && ch < 0x80 && self.start_paste_escape_code(ch) {
+				if self.state == csi {
+					return self.dispatch_byte(byte(ch))
+				}
+				return nil
+			}
 			if len(self.bracketed_paste_buffer) > 0 {
 				for _, c := range self.bracketed_paste_buffer {
 					err := self.dispatch_rune(c)
@@ -189,6 +308,11 @@ func (self *EscapeCodeParser) dispatch_char(ch utils.UTF8State) error {
 					}
 				}
 				self.bracketed_paste_buffer = self.bracketed_paste_buffer[:0]
+				if ch == 0x1b && self.DispatchEscapeCodesInBracketedPaste {
+					// the start of the next escape code
+					self.bracketed_paste_buffer = append(self.bracketed_paste_buffer, ch)
+					return nil
+				}
 			}
 			return self.dispatch_rune(ch)
 		}
@@ -198,7 +322,7 @@ func (self *EscapeCodeParser) dispatch_char(ch utils.UTF8State) error {
 				if self.bracketed_paste_buffer[len(self.bracketed_paste_buffer)-1] == '~' {
 					self.reset_state()
 					if self.HandleEndOfBracketedPaste != nil {
-						self.HandleEndOfBracketedPaste()
+						return self.HandleEndOfBracketedPaste()
 					}
 				}
 				return nil
@@ -287,12 +411,12 @@ func (self *EscapeCodeParser) dispatch_byte(ch byte) error {
 			case final_csi_char:
 				return self.dispatch_esc_code()
 			case unknown_csi_char:
-				self.invalid_escape_code()
+				return self.invalid_escape_code()
 			}
 		case intermediate:
 			switch csi_type(ch) {
 			case parameter_csi_char, unknown_csi_char:
-				self.invalid_escape_code()
+				return self.invalid_escape_code()
 			case final_csi_char:
 				return self.dispatch_esc_code()
 			}
@@ -54,4 +54,12 @@ func TestEscapeCodeParsing(t *testing.T) {
 	test("a\x1b_b\x1b\x1b\x1bc\x1b\\d", "CH: a\nAPC: b\x1b\x1bc\nCH: d")
 	test("\x1b]X\x07\x1b]X\x1b\x07\x1b\\", "OSC: X\nOSC: X\x1b\x07")
 
+	test_parser.DispatchEscapeCodesInBracketedPaste = true
+	test("\x1b[200~a\x1b[Ob\x1b[201m\x1b[2x\x1b]11;?\x07c\x1b[201~\x1b[x", "CH: a\nCSI: O\nCH: b\nCSI: 201m\nCSI: 2x\nOSC: 11;?\nCH: c\nCSI: x")
+	test("\x1b[200~a\x1b[20\x1b[201~", "CH: a\nCH: \x1b\nCH: [\nCH: 2\nCH: 0")
+	// invalid escape codes are pasted text
+	test("\x1b[200~\x1b[1\x01\x1b[201~", "CH: \x1b\nCH: [\nCH: 1\nCH: \x01")
+	test_parser.DispatchInBracketedPaste = func(raw []byte) bool { return raw[len(raw)-1] != 'u' }
+	test("\x1b[200~a\x1b[13u\x1b[2u\x1b[Ob\x1b[201~", "CH: a\nCH: \x1b\nCH: [\nCH: 1\nCH: 3\nCH: u\nCH: \x1b\nCH: [\nCH: 2\nCH: u\nCSI: O\nCH: b")
+
 }
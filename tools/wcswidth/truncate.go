@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"kitty/tools/utils"
 )
@@ -91,18 +92,70 @@ func (self *truncate_iterator) parse(b []byte) (ans int, width int) {
 }
 
 func TruncateToVisualLengthWithWidth(text string, length int) (truncated string, width_of_truncated int) {
+	return TruncateToVisualLengthWithTabs(text, length, 0, 0)
+}
+
+// Like TruncateToVisualLengthWithWidth() except that tabs advance to the next
+// tab stop, with tab stops every tab_width cells, for text written starting
+// at the zero based column start_col, see StringwidthWithTabs().
+func TruncateToVisualLengthWithTabs(text string, length, start_col, tab_width int) (truncated string, width_of_truncated int) {
 	if length < 1 {
 		return text[:0], 0
 	}
+	start_col = utils.Max(0, start_col)
 	t := create_truncate_iterator()
-	t.limit = length
+	t.w.SetTabWidth(tab_width)
+	t.limit = start_col + length
 	t.limit_exceeded_at = nil
-	t.w.current_width = 0
+	t.w.current_width = start_col
 	truncate_point, width := t.parse(utils.UnsafeStringToBytes(text))
-	return text[:truncate_point], width
+	return text[:truncate_point], width - start_col
 }
 
 func TruncateToVisualLength(text string, length int) string {
 	ans, _ := TruncateToVisualLengthWithWidth(text, length)
 	return ans
 }
+
+// Return the part of text that is visible in a window width cells wide,
+// starting offset cells from the start of text. Wide characters are never
+// split, if one straddles an edge of the window, the part of it inside the
+// window is replaced by spaces. Tabs are expanded to spaces up to the next
+// default tab stop. The result is padded with spaces to width, so an offset
+// past the end of text gives only blanks.
+func ScrollWindow(text string, offset, width int) string {
+	if width < 1 {
+		return ""
+	}
+	offset = utils.Max(0, offset)
+	end := offset + width
+	buf := strings.Builder{}
+	buf.Grow(width)
+	pos := 0
+	it := NewCellIterator(text)
+	// give tabs a width, so that they are cells of their own
+	it.forward_iter.width_iter.SetTabWidth(DefaultTabWidth)
+	for pos < end && it.Forward() {
+		cell := it.Current()
+		cell_end := pos + Stringwidth(cell)
+		if cell == "\t" {
+			// tabs are expanded to spaces as the window moves the tab stops
+			cell_end = (pos/DefaultTabWidth + 1) * DefaultTabWidth
+			cell = strings.Repeat(" ", cell_end-pos)
+		}
+		switch {
+		case cell_end < offset || (cell_end == offset && pos < offset):
+		case pos < offset:
+			buf.WriteString(strings.Repeat(" ", utils.Min(cell_end, end)-offset))
+		case cell_end <= end:
+			buf.WriteString(cell)
+		default:
+			buf.WriteString(strings.Repeat(" ", end-pos))
+		}
+		pos = cell_end
+	}
+	if pos < end {
+		buf.WriteString(strings.Repeat(" ", end-utils.Max(pos, offset)))
+	}
+	return buf.String()
+}
@@ -22,12 +22,17 @@ func IsFlagPair(a rune, b rune) bool {
 
 type ecparser_state uint8
 
+// The distance between the tab stops of a terminal, unless changed by the
+// application
+const DefaultTabWidth = 8
+
 type WCWidthIterator struct {
 	prev_ch                   rune
 	prev_width, current_width int
 	parser                    EscapeCodeParser
 	state                     ecparser_state
 	rune_count                uint
+	tab_width                 int
 }
 
 func CreateWCWidthIterator() *WCWidthIterator {
@@ -37,6 +42,14 @@ func CreateWCWidthIterator() *WCWidthIterator {
 	return &ans
 }
 
+// Set the distance between tab stops, tabs advance to the next tab stop
+// counting from the start of the text. Zero, the default, means tabs have no
+// width.
+func (self *WCWidthIterator) SetTabWidth(tab_width int) *WCWidthIterator {
+	self.tab_width = utils.Max(0, tab_width)
+	return self
+}
+
 func (self *WCWidthIterator) Reset() {
 	self.prev_ch = 0
 	self.prev_width = 0
@@ -76,6 +89,11 @@ func (self *WCWidthIterator) handle_rune(ch rune) error {
 		fallthrough
 	case normal:
 		switch ch {
+		case '\t':
+			if self.tab_width > 0 {
+				self.current_width = (self.current_width/self.tab_width + 1) * self.tab_width
+			}
+			self.prev_width = 0
 		case 0xfe0f:
 			if IsEmojiPresentationBase(self.prev_ch) && self.prev_width == 1 {
 				self.current_width += 1
@@ -131,6 +149,17 @@ func Stringwidth(text string) int {
 	return w.Parse(utils.UnsafeStringToBytes(text))
 }
 
+// Like Stringwidth() except that tabs advance to the next tab stop, with tab
+// stops every tab_width cells, for text written starting at the zero based
+// column start_col. Stringwidth() gives tabs no width.
+func StringwidthWithTabs(text string, start_col, tab_width int) int {
+	start_col = utils.Max(0, start_col)
+	w := CreateWCWidthIterator().SetTabWidth(tab_width)
+	w.current_width = start_col
+	w.parser.Parse(utils.UnsafeStringToBytes(text))
+	return w.current_width - start_col
+}
+
 func StripEscapeCodes(text string) string {
 	out := strings.Builder{}
 	out.Grow(len(text))
@@ -37,6 +37,25 @@ func TestWCSWidth(t *testing.T) {
 	wcswidth("\U0001F1E6\U0001F1E8\U0001F1E6", 4)
 	wcswidth("a\u00adb", 2)
 	wcswidth("a\x1b[22bcd", 25)
+	// Tabs have no width unless tab stops are specified, when they advance
+	// to the next tab stop
+	wcswidth("a\tb", 2)
+	for _, x := range []struct {
+		text                        string
+		start_col, tab_width, width int
+	}{
+		{"a\tb", 0, 8, 9},
+		{"abcdefgh\tb\t", 0, 8, 24},
+		{"\x1b[31ma\x1b[m\t|", 0, 8, 9},
+		{"ab\tc\t", 0, 4, 8},
+		{"a\tb", 5, 8, 4},
+		{"\tb", 8, 8, 9},
+		{"a\tb", 0, 0, 2},
+	} {
+		if w := StringwidthWithTabs(x.text, x.start_col, x.tab_width); w != x.width {
+			t.Fatalf("The width of %#v at column %d with tab stops every %d cells was %d instead of %d", x.text, x.start_col, x.tab_width, w, x.width)
+		}
+	}
 	// Flags individually and together
 	wcwidth("\U0001f1ee\U0001f1f3", 2, 2)
 	wcswidth("\U0001f1ee\U0001f1f3", 2)
@@ -63,6 +82,46 @@ func TestWCSWidth(t *testing.T) {
 	truncate("a\x1b[31mb", 2, "a\x1b[31mb", 2)
 	truncate("a\x1b[7bb", 2, "a", 1)
 	truncate("a\x1b[3bbc", 5, "a\x1b[3bb", 5)
+	for _, x := range []struct {
+		text, expected                          string
+		length, start_col, tab_width, exp_width int
+	}{
+		{"a\tb", "a\tb", 9, 0, 8, 9},
+		{"a\tb", "a\t", 8, 0, 8, 8},
+		{"a\tb", "a", 7, 0, 8, 1},
+		{"a\tb", "a\tb", 4, 5, 8, 4},
+		{"a\tb", "a\t", 3, 5, 8, 3},
+		{"ab\tc", "ab\tc", 5, 0, 4, 5},
+		{"a\tb", "a\tb", 2, 3, 0, 2},
+	} {
+		actual, actual_width := TruncateToVisualLengthWithTabs(x.text, x.length, x.start_col, x.tab_width)
+		if actual != x.expected || actual_width != x.exp_width {
+			t.Fatalf("Failed to truncate %#v to %d at column %d with tab stops every %d cells\nExpected: %#v %d\nActual:   %#v %d",
+				x.text, x.length, x.start_col, x.tab_width, x.expected, x.exp_width, actual, actual_width)
+		}
+	}
+}
+
+func TestScrollWindow(t *testing.T) {
+	s := func(text string, offset, width int, expected string) {
+		if actual := ScrollWindow(text, offset, width); actual != expected {
+			t.Fatalf("ScrollWindow(%#v, %d, %d): %#v != %#v", text, offset, width, expected, actual)
+		}
+	}
+	s("abcdef", 0, 3, "abc")
+	s("abcdef", 2, 3, "cde")
+	s("abcdef", 4, 3, "ef ")
+	s("abc", 5, 3, "   ")
+	s("🌷ab", 0, 3, "🌷a")
+	s("🌷ab", 1, 3, " ab")
+	s("ab🌷", 0, 3, "ab ")
+	s("a\tb", 0, 10, "a       b ")
+	s("a\tb", 4, 5, "    b")
+	s("a\tb", 2, 3, "   ")
+	s("ab🌷", 1, 3, "b🌷")
+	s("a🌷b", 2, 2, " b")
+	s("a🌷\ufe0eb", 1, 2, "🌷\ufe0eb")
+	s("òne", 0, 2, "òn")
 }
 
 func TestCellIterator(t *testing.T) {